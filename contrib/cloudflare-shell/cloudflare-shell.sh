@@ -79,7 +79,8 @@ case "$STUNMESH_ACTION" in
             echo "$VALUE"
         else
             echo "Record not found: $RECORD_NAME" >&2
-            exit 1
+            # 3 tells stunmesh the record does not exist, not that this failed
+            exit 3
         fi
         ;;
 
@@ -158,7 +158,7 @@ echo "== 6. get on a key nobody set =="
 status=0
 printf 'STUNMESH_ACTION=get\nSTUNMESH_KEY=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n' |
 	bash "$PLUGIN" -zone example.com -token tok -subdomain stunmesh >/dev/null 2>/dev/null || status=$?
-check_nonzero "get on unset key exits non-zero" "$status"
+check "get on unset key exits 3 (not found)" "$status" "3"
 
 if [ "$fail" = "0" ]; then
 	echo "PASS"
@@ -41,9 +41,11 @@ EOF
 	exit 2
 }
 
+# die MESSAGE [STATUS] -- STATUS is one of the protocol's exit codes, e.g. 3
+# for a key with no record, when the failure is one of them.
 die() {
 	printf '%s\n' "$1" >&2
-	exit 1
+	exit "${2:-1}"
 }
 
 while [ $# -gt 0 ]; do
@@ -215,7 +217,7 @@ get)
 	done
 	IFS=$oldIFS
 
-	[ -n "$best" ] || die "no value found for key"
+	[ -n "$best" ] || die "no value found for key" 3
 
 	printf '%s\n' "$best"
 	;;
@@ -85,7 +85,7 @@ echo "== 2. get on a key nobody set =="
 missing_status=0
 printf 'STUNMESH_ACTION=get\nSTUNMESH_KEY=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n' |
 	sh "$PLUGIN" -endpoint "$ENDPOINT" >/dev/null 2>/dev/null || missing_status=$?
-check "get on unset key exits non-zero" "$([ "$missing_status" != 0 ] && echo yes || echo no)" "yes"
+check "get on unset key exits 3 (not found)" "$missing_status" "3"
 
 echo "== 3. malformed input =="
 noaction_status=0
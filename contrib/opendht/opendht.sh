@@ -82,8 +82,10 @@ respond_ok() {
 	exit 0
 }
 
+# respond_error MESSAGE [CODE] -- CODE is one of the protocol's error codes,
+# e.g. not_found, when the failure is one of them.
 respond_error() {
-	jq -cn --arg e "$1" '{success: false, error: $e}'
+	jq -cn --arg e "$1" --arg c "${2-}" '{success: false, error: $e} + (if $c == "" then {} else {code: $c} end)'
 	exit 0
 }
 
@@ -165,7 +167,7 @@ get)
 		| .data // empty
 	' 2>/dev/null) || respond_error "failed to parse proxy response"
 
-	[ -n "$found" ] || respond_error "no value found for key"
+	[ -n "$found" ] || respond_error "no value found for key" not_found
 
 	respond_ok "$found"
 	;;
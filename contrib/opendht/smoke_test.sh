@@ -95,6 +95,7 @@ echo "== 2. get on a key nobody set =="
 missing_out=$(printf '{"action":"get","key":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}' |
 	sh "$PLUGIN" -endpoint "$ENDPOINT")
 check "get on unset key fails" "$(printf '%s' "$missing_out" | jq -r .success)" "false"
+check "get on unset key is not_found" "$(printf '%s' "$missing_out" | jq -r .code)" "not_found"
 
 echo "== 3. malformed input =="
 malformed_out=$(printf '{not valid json' | sh "$PLUGIN" -endpoint "$ENDPOINT" || true)
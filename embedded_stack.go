@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/embedded"
+	"github.com/tjjh89017/stunmesh-go/internal/stun"
+	"github.com/tjjh89017/stunmesh-go/internal/wg"
+	"golang.org/x/crypto/curve25519"
+)
+
+// newWGClient builds the WireGuard client: the platform's, wrapped to run
+// the embedded interfaces in-process when any is configured; devices is
+// nil when none is. The embedded interfaces are brought up here, before
+// bootstrap reads them. Without a kernel interface left to manage the
+// platform client is not built at all, so an embedded-only config runs on
+// hosts without kernel WireGuard or wireguard-tools.
+func newWGClient(deviceConfig *config.DeviceConfig, goos string, logger *zerolog.Logger) (client wg.Client, devices *embedded.Manager, err error) {
+	var ifaces []embedded.Interface
+	for _, name := range deviceConfig.TunnelInterfaceNames() {
+		if e := deviceConfig.GetEmbedded(name); e != nil {
+			iface, err := embeddedInterface(name, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			ifaces = append(ifaces, iface)
+		}
+	}
+	if client, err = newPlatformClient(deviceConfig); err != nil {
+		return nil, nil, err
+	}
+	if len(ifaces) == 0 {
+		return client, nil, nil
+	}
+
+	devices = embedded.NewManager(logger, goos)
+	for _, iface := range ifaces {
+		if _, err := devices.Up(context.Background(), iface); err != nil {
+			_ = devices.Close()
+			if client != nil {
+				_ = client.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return wg.NewEmbeddedClient(client, devices), devices, nil
+}
+
+// newPlatformClient builds the platform's WireGuard client, or returns nil
+// when every configured interface is embedded.
+func newPlatformClient(deviceConfig *config.DeviceConfig) (wg.Client, error) {
+	names := deviceConfig.TunnelInterfaceNames()
+	if len(names) > 0 && !slices.ContainsFunc(names, func(name string) bool { return deviceConfig.GetEmbedded(name) == nil }) {
+		return nil, nil
+	}
+	return wg.New()
+}
+
+// newKeysClient builds the WireGuard client for commands that read devices
+// without running them, like gc.
+func newKeysClient(deviceConfig *config.DeviceConfig) (wg.Client, error) {
+	inner, err := newPlatformClient(deviceConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &embeddedKeysClient{inner: inner, deviceConfig: deviceConfig}, nil
+}
+
+// embeddedInterface converts a device's embedded block, which validation
+// has already checked.
+func embeddedInterface(name string, e *config.Embedded) (embedded.Interface, error) {
+	iface := embedded.Interface{Name: name, ListenPort: uint16(e.ListenPort), MTU: e.MTU}
+	var err error
+	if iface.PrivateKey, err = decodeKey(e.PrivateKey); err != nil {
+		return embedded.Interface{}, fmt.Errorf("embedded private_key of %s: %w", name, err)
+	}
+	if iface.Addresses, err = parsePrefixes(e.Address); err != nil {
+		return embedded.Interface{}, fmt.Errorf("embedded address of %s: %w", name, err)
+	}
+	for i, p := range e.Peers {
+		peer := embedded.Peer{PersistentKeepalive: uint16(p.PersistentKeepalive)}
+		if peer.PublicKey, err = decodeKey(p.PublicKey); err != nil {
+			return embedded.Interface{}, fmt.Errorf("embedded peer %d of %s: public_key: %w", i, name, err)
+		}
+		if p.PresharedKey != "" {
+			if peer.PresharedKey, err = decodeKey(p.PresharedKey); err != nil {
+				return embedded.Interface{}, fmt.Errorf("embedded peer %d of %s: preshared_key: %w", i, name, err)
+			}
+		}
+		if peer.AllowedIPs, err = parsePrefixes(p.AllowedIPs); err != nil {
+			return embedded.Interface{}, fmt.Errorf("embedded peer %d of %s: allowed_ips: %w", i, name, err)
+		}
+		if p.Endpoint != "" {
+			if peer.Endpoint, err = netip.ParseAddrPort(p.Endpoint); err != nil {
+				return embedded.Interface{}, fmt.Errorf("embedded peer %d of %s: endpoint: %w", i, name, err)
+			}
+		}
+		iface.Peers = append(iface.Peers, peer)
+	}
+	return iface, nil
+}
+
+func decodeKey(s string) (embedded.Key, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return embedded.Key{}, err
+	}
+	if len(b) != len(embedded.Key{}) {
+		return embedded.Key{}, fmt.Errorf("key is %d bytes, want 32", len(b))
+	}
+	return embedded.Key(b), nil
+}
+
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// newEmbeddedStunFactory routes each Resolve call for an embedded device to
+// a client exchanging on the device's own WireGuard socket, and every other
+// call to otherFactory.
+func newEmbeddedStunFactory(devices *embedded.Manager, otherFactory stun.ClientFactory, logger *zerolog.Logger) stun.ClientFactory {
+	lookup := func(deviceName string, _ uint16) (stun.StunTransport, error) {
+		d, err := devices.Get(deviceName)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	embeddedFactory := stun.NewProxyLookupFactory(lookup, logger)
+	return func(ctx context.Context, deviceName string, port uint16, protocol string, firewallMark int, listenInterfaces []string, listenDefaultRoute bool) (stun.StunClient, error) {
+		if _, err := devices.Get(deviceName); err == nil {
+			return embeddedFactory(ctx, deviceName, port, protocol, firewallMark, listenInterfaces, listenDefaultRoute)
+		}
+		return otherFactory(ctx, deviceName, port, protocol, firewallMark, listenInterfaces, listenDefaultRoute)
+	}
+}
+
+// embeddedKeysClient answers Device for embedded interfaces from their
+// config, for commands like gc that need a device's keys but do not run
+// it; other devices go to inner, nil when every device is embedded.
+type embeddedKeysClient struct {
+	inner        wg.Client
+	deviceConfig *config.DeviceConfig
+}
+
+func (c *embeddedKeysClient) Device(name string) (*wg.DeviceInfo, error) {
+	e := c.deviceConfig.GetEmbedded(name)
+	if e == nil {
+		if c.inner == nil {
+			return nil, fmt.Errorf("%w: %s", embedded.ErrNotEmbedded, name)
+		}
+		return c.inner.Device(name)
+	}
+	iface, err := embeddedInterface(name, e)
+	if err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(iface.PrivateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	info := &wg.DeviceInfo{Name: name, ListenPort: int(iface.ListenPort), PrivateKey: iface.PrivateKey, PublicKey: wg.Key(public)}
+	for _, p := range iface.Peers {
+		info.PeerKeys = append(info.PeerKeys, p.PublicKey)
+	}
+	return info, nil
+}
+
+func (c *embeddedKeysClient) UpdatePeerEndpoint(u wg.PeerEndpointUpdate) error {
+	if c.deviceConfig.GetEmbedded(u.DeviceName) != nil || c.inner == nil {
+		return errors.New("embedded devices are only configured by the daemon running them")
+	}
+	return c.inner.UpdatePeerEndpoint(u)
+}
+
+func (c *embeddedKeysClient) Close() error {
+	if c.inner == nil {
+		return nil
+	}
+	return c.inner.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/embedded"
+	"github.com/tjjh89017/stunmesh-go/internal/wg"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func testEmbeddedConfig() (*config.Embedded, embedded.Key, embedded.Key) {
+	private := embedded.Key{0: 0x08, 31: 0x40}
+	peer := embedded.Key{0x02}
+	return &config.Embedded{
+		PrivateKey: base64.StdEncoding.EncodeToString(private[:]),
+		ListenPort: 51820,
+		Address:    []string{"10.0.0.1/24"},
+		Peers: []config.EmbeddedPeer{{
+			PublicKey:           base64.StdEncoding.EncodeToString(peer[:]),
+			AllowedIPs:          []string{"10.0.0.2/32"},
+			Endpoint:            "192.0.2.1:51820",
+			PersistentKeepalive: 25,
+		}},
+	}, private, peer
+}
+
+func TestEmbeddedInterface(t *testing.T) {
+	e, private, peer := testEmbeddedConfig()
+	iface, err := embeddedInterface("wg0", e)
+	if err != nil {
+		t.Fatalf("embeddedInterface: %v", err)
+	}
+	if iface.Name != "wg0" || iface.PrivateKey != private || iface.ListenPort != 51820 || !slices.Equal(iface.Addresses, []netip.Prefix{netip.MustParsePrefix("10.0.0.1/24")}) {
+		t.Errorf("embeddedInterface = %+v, want the configured interface", iface)
+	}
+	if len(iface.Peers) != 1 {
+		t.Fatalf("Peers = %+v, want one", iface.Peers)
+	}
+	p := iface.Peers[0]
+	if p.PublicKey != peer || p.PresharedKey != (embedded.Key{}) || p.Endpoint != netip.MustParseAddrPort("192.0.2.1:51820") || p.PersistentKeepalive != 25 {
+		t.Errorf("Peers[0] = %+v, want the configured peer", p)
+	}
+
+	e.Peers[0].PublicKey = "short"
+	if _, err := embeddedInterface("wg0", e); err == nil {
+		t.Error("embeddedInterface with a bad peer key succeeded, want an error")
+	}
+}
+
+// gc reads an embedded device's keys from its config, without a platform
+// client when every device is embedded.
+func TestEmbeddedKeysClient(t *testing.T) {
+	e, private, peer := testEmbeddedConfig()
+	cfg := &config.Config{Interfaces: config.Interfaces{"wg0": {Embedded: e}}}
+	c, err := newKeysClient(config.NewDeviceConfig(cfg))
+	if err != nil {
+		t.Fatalf("newKeysClient: %v", err)
+	}
+	defer c.Close()
+
+	info, err := c.Device("wg0")
+	if err != nil {
+		t.Fatalf("Device(wg0): %v", err)
+	}
+	public, _ := curve25519.X25519(private[:], curve25519.Basepoint)
+	if info.PrivateKey != private || info.PublicKey != wg.Key(public) || info.ListenPort != 51820 || !slices.Equal(info.PeerKeys, []wg.Key{peer}) {
+		t.Errorf("Device(wg0) = %+v, want the configured keys", info)
+	}
+	if err := c.UpdatePeerEndpoint(wg.PeerEndpointUpdate{DeviceName: "wg0", PublicKey: peer, Host: "192.0.2.1", Port: 1}); err == nil {
+		t.Error("UpdatePeerEndpoint(wg0) succeeded, want an error")
+	}
+	if _, err := c.Device("wg1"); err == nil {
+		t.Error("Device(wg1) without a platform client succeeded, want an error")
+	}
+}
+
+func TestNewEmbeddedStunFactory_RoutesPerDevice(t *testing.T) {
+	logger := zerolog.Nop()
+	devices := embedded.NewManager(&logger, "linux",
+		embedded.WithRunner(func(context.Context, string, ...string) ([]byte, error) { return nil, nil }),
+		embedded.WithTUN(func(string, int) (tun.Device, error) { return tuntest.NewChannelTUN().TUN(), nil }),
+	)
+	defer devices.Close()
+	if _, err := devices.Up(context.Background(), embedded.Interface{Name: "wg0", PrivateKey: embedded.Key{0: 0x08, 31: 0x40}}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	var otherCalls []string
+	factory := newEmbeddedStunFactory(devices, stubFactory(&otherCalls), &logger)
+	client, err := factory(context.Background(), "wg0", 0, "ipv4", 0, nil, false)
+	if err != nil || client == nil {
+		t.Errorf("factory(wg0) = %v, %v; want a client on the embedded device", client, err)
+	}
+	if client != nil {
+		_ = client.Stop()
+	}
+	if _, err := factory(context.Background(), "wg1", 0, "ipv4", 0, nil, false); err != nil {
+		t.Fatalf("factory(wg1): %v", err)
+	}
+	if len(otherCalls) != 1 || otherCalls[0] != "wg1" {
+		t.Errorf("other factory calls = %v, want [wg1]", otherCalls)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/ctrl"
+	"github.com/tjjh89017/stunmesh-go/internal/logger"
+)
+
+// runGC is the gc subcommand: it prints the records no configured peer
+// accounts for, and deletes them with -delete. Run it without -delete first
+// when a store is shared with nodes outside this config, whose records it
+// would list too.
+func runGC(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	del := flags.Bool("delete", false, "delete the orphaned records instead of only listing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newKeysClient(config.NewDeviceConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	plugins, cleanup, err := providePluginManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	gc := ctrl.NewGarbageCollector(client, cfg, plugins, logger.NewLogger(cfg))
+	orphans, err := gc.Orphans(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(orphans))
+	for name := range orphans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, key := range orphans[name] {
+			fmt.Fprintf(os.Stdout, "%s\t%s\n", name, key)
+		}
+	}
+	if !*del {
+		return nil
+	}
+	return gc.Delete(ctx, orphans)
+}
@@ -1,12 +1,16 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -208,6 +212,7 @@ func load(configFile, configDir string, paths []string) (*Config, error) {
 		// hooks are required so quoted scalars and string list values still decode.
 		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				listenPortsHookFunc(),
 				mapstructure.StringToTimeDurationHookFunc(),
 				mapstructure.StringToSliceHookFunc(","),
 			),
@@ -286,9 +291,8 @@ func validateConfigForGOOS(cfg *Config, goos string) error {
 	}
 
 	for ifaceName, iface := range cfg.Interfaces {
-		// 0 means unset (ephemeral); reject anything outside the port range.
-		if iface.Proxy.Listen < 0 || iface.Proxy.Listen > 65535 {
-			return fmt.Errorf("invalid proxy listen port %d for interface '%s', must be between 0 and 65535", iface.Proxy.Listen, ifaceName)
+		if err := validateProxyListen(iface.Proxy.Listen, ifaceName); err != nil {
+			return err
 		}
 
 		// 0 means unset (escape off); the true kernel limit is net.fibs-1,
@@ -304,6 +308,33 @@ func validateConfigForGOOS(cfg *Config, goos string) error {
 			return fmt.Errorf("invalid proxy.enabled 'false' for interface '%s': Windows has no non-proxy mode", ifaceName)
 		}
 
+		if err := validateProxyRelay(iface.Proxy.Relay, ifaceName); err != nil {
+			return err
+		}
+
+		if c := iface.Proxy.Capture; c.MaxSize < 0 || c.MaxFiles < 0 || c.SnapLen < 0 {
+			return fmt.Errorf("invalid proxy capture for interface '%s': max_size, max_files and snaplen must not be negative", ifaceName)
+		}
+
+		if junk := iface.Proxy.Obfuscation.Junk; junk < 0 || junk > maxJunkPackets {
+			return fmt.Errorf("invalid proxy obfuscation junk %d for interface '%s', must be between 0 and %d", junk, ifaceName, maxJunkPackets)
+		}
+
+		if iface.Embedded != nil {
+			if err := validateEmbedded(iface.Embedded, ifaceName, iface.Proxy, goos); err != nil {
+				return err
+			}
+		}
+
+		if gw := iface.PortMapping.Gateway; gw != "" {
+			if addr, err := netip.ParseAddr(gw); err != nil || !addr.Is4() {
+				return fmt.Errorf("invalid port_mapping gateway '%s' for interface '%s', must be an IPv4 address", gw, ifaceName)
+			}
+		}
+		if iface.PortMapping.Lifetime < 0 {
+			return fmt.Errorf("invalid port_mapping lifetime %s for interface '%s', must not be negative", iface.PortMapping.Lifetime, ifaceName)
+		}
+
 		if iface.Protocol != "" {
 			switch iface.Protocol {
 			case "ipv4", "ipv6", "dualstack":
@@ -325,3 +356,162 @@ func validateConfigForGOOS(cfg *Config, goos string) error {
 
 	return nil
 }
+
+// validateProxyListen checks one interface's proxy.listen ports.
+func validateProxyListen(ports ListenPorts, ifaceName string) error {
+	// 0 means unset (ephemeral); reject anything outside the port range.
+	for _, port := range ports {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid proxy listen port %d for interface '%s', must be between 0 and 65535", port, ifaceName)
+		}
+	}
+	if len(ports) <= 1 {
+		return nil
+	}
+	// Several sockets are told apart by port, so none may be ephemeral.
+	if len(ports) > maxListenPorts {
+		return fmt.Errorf("invalid proxy listen for interface '%s': %d ports, at most %d allowed", ifaceName, len(ports), maxListenPorts)
+	}
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port == 0 {
+			return fmt.Errorf("invalid proxy listen for interface '%s': port 0 (ephemeral) cannot be one of several ports", ifaceName)
+		}
+		if seen[port] {
+			return fmt.Errorf("invalid proxy listen for interface '%s': port %d is listed twice", ifaceName, port)
+		}
+		seen[port] = true
+	}
+	return nil
+}
+
+// listenPortsHookFunc decodes proxy.listen's string and list forms into
+// ListenPorts; a plain integer is left to weak typing.
+func listenPortsHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		if to != reflect.TypeOf(ListenPorts(nil)) {
+			return data, nil
+		}
+		switch v := data.(type) {
+		case string:
+			return parseListenPorts(strings.Split(v, ","))
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			return parseListenPorts(items)
+		}
+		return data, nil
+	}
+}
+
+// parseListenPorts expands ports and "first-last" ranges, in order.
+func parseListenPorts(items []string) (ListenPorts, error) {
+	var ports ListenPorts
+	for _, item := range items {
+		first, last, isRange := strings.Cut(strings.TrimSpace(item), "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy listen port '%s'", item)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid proxy listen port range '%s'", item)
+			}
+		}
+		// Bounded before expanding, so a wide range cannot allocate much.
+		if len(ports)+hi-lo+1 > maxListenPorts {
+			return nil, fmt.Errorf("invalid proxy listen '%s': more than %d ports", item, maxListenPorts)
+		}
+		for port := lo; port <= hi; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// validateProxyRelay checks one interface's proxy.relay block.
+func validateProxyRelay(relay ProxyRelay, ifaceName string) error {
+	if relay.Listen < 0 || relay.Listen > 65535 {
+		return fmt.Errorf("invalid proxy relay listen port %d for interface '%s', must be between 0 and 65535", relay.Listen, ifaceName)
+	}
+	if (relay.Server == "") != (relay.ServerKey == "") {
+		return fmt.Errorf("invalid proxy relay for interface '%s': server and server_key must be set together", ifaceName)
+	}
+	if relay.Server == "" {
+		return nil
+	}
+	if server, err := netip.ParseAddrPort(relay.Server); err != nil || !server.Addr().Unmap().Is4() || server.Port() == 0 {
+		return fmt.Errorf("invalid proxy relay server '%s' for interface '%s', must be an IPv4 address and port", relay.Server, ifaceName)
+	}
+	if !isKey(relay.ServerKey) {
+		return fmt.Errorf("invalid proxy relay server_key for interface '%s', must be a base64 WireGuard public key", ifaceName)
+	}
+	return nil
+}
+
+// validateEmbedded checks one interface's embedded block.
+func validateEmbedded(e *Embedded, ifaceName string, proxy Proxy, goos string) error {
+	switch goos {
+	case "linux", "freebsd":
+	case "darwin":
+		// macOS names utun devices itself; utunN asks for a number.
+		if n := strings.TrimPrefix(ifaceName, "utun"); n == ifaceName || strings.Trim(n, "0123456789") != "" {
+			return fmt.Errorf("invalid embedded interface name '%s': macOS needs utun or utunN", ifaceName)
+		}
+	default:
+		return fmt.Errorf("invalid embedded for interface '%s': not supported on %s", ifaceName, goos)
+	}
+	if proxy.IsEnabled(goos) {
+		return fmt.Errorf("invalid embedded for interface '%s': cannot be combined with proxy mode", ifaceName)
+	}
+	if !isKey(e.PrivateKey) {
+		return fmt.Errorf("invalid embedded private_key for interface '%s', must be a base64 WireGuard key", ifaceName)
+	}
+	if e.ListenPort < 0 || e.ListenPort > 65535 {
+		return fmt.Errorf("invalid embedded listen_port %d for interface '%s', must be between 0 and 65535", e.ListenPort, ifaceName)
+	}
+	if e.MTU != 0 && (e.MTU < 576 || e.MTU > 65535) {
+		return fmt.Errorf("invalid embedded mtu %d for interface '%s', must be between 576 and 65535", e.MTU, ifaceName)
+	}
+	for _, addr := range e.Address {
+		if _, err := netip.ParsePrefix(strings.TrimSpace(addr)); err != nil {
+			return fmt.Errorf("invalid embedded address '%s' for interface '%s', must be in CIDR notation", addr, ifaceName)
+		}
+	}
+	for i, peer := range e.Peers {
+		if !isKey(peer.PublicKey) {
+			return fmt.Errorf("invalid public_key for embedded peer %d on interface '%s', must be a base64 WireGuard key", i, ifaceName)
+		}
+		if peer.PresharedKey != "" && !isKey(peer.PresharedKey) {
+			return fmt.Errorf("invalid preshared_key for embedded peer %d on interface '%s', must be a base64 WireGuard key", i, ifaceName)
+		}
+		for _, ip := range peer.AllowedIPs {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(ip))
+			if err != nil {
+				return fmt.Errorf("invalid allowed_ips '%s' for embedded peer %d on interface '%s', must be in CIDR notation", ip, i, ifaceName)
+			}
+			// A default route would carry the tunnel's own packets into it.
+			if prefix.Bits() == 0 {
+				return fmt.Errorf("invalid allowed_ips '%s' for embedded peer %d on interface '%s': a default route is not supported in embedded mode", ip, i, ifaceName)
+			}
+		}
+		if peer.Endpoint != "" {
+			if _, err := netip.ParseAddrPort(peer.Endpoint); err != nil {
+				return fmt.Errorf("invalid endpoint '%s' for embedded peer %d on interface '%s', must be an IP address and port", peer.Endpoint, i, ifaceName)
+			}
+		}
+		if peer.PersistentKeepalive < 0 || peer.PersistentKeepalive > 65535 {
+			return fmt.Errorf("invalid persistent_keepalive %d for embedded peer %d on interface '%s', must be between 0 and 65535", peer.PersistentKeepalive, i, ifaceName)
+		}
+	}
+	return nil
+}
+
+// isKey reports whether s is a base64 WireGuard key.
+func isKey(s string) bool {
+	key, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(key) == 32
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"encoding/base64"
+	"net/netip"
 	"time"
 
 	"github.com/tjjh89017/stunmesh-go/internal/entity"
@@ -38,10 +39,20 @@ func (p *Peer) GetProtocol() string {
 	return p.Protocol
 }
 
+// ListenPorts is proxy.listen: one port, or several given as a "first-last"
+// range, a comma-separated string or a list of ports and ranges.
+type ListenPorts []int
+
+// maxListenPorts bounds how many outer sockets per family proxy.listen may
+// ask for.
+const maxListenPorts = 64
+
 // Proxy configures the UDP proxy fronting a WireGuard interface.
 type Proxy struct {
 	// Listen pins the outer-socket port; 0 (the default) means ephemeral.
-	Listen int `mapstructure:"listen"`
+	// A "first-last" range or a list binds one outer socket per port, each
+	// peer pinned to one of them; every port must then be fixed.
+	Listen ListenPorts `mapstructure:"listen"`
 	// Enabled switches proxy mode on or off explicitly; nil means "absent"
 	// and resolves to the platform default via IsEnabled. Must stay *bool,
 	// not bool, so "absent" (use platform default) is distinguishable from
@@ -54,6 +65,57 @@ type Proxy struct {
 	// covering WireGuard default route already lives. Only fib > 0 activates
 	// the escape. Ignored on every other platform.
 	Fib int `mapstructure:"fib"`
+	// Roaming lets the proxy follow a peer whose NAT rebinds to a new source
+	// once WireGuard authenticates a handshake from it, instead of dropping
+	// its packets until the next publish/establish cycle. Off by default.
+	Roaming bool `mapstructure:"roaming"`
+	// Relay configures relaying through a stunmesh relay node; see
+	// ProxyRelay.
+	Relay ProxyRelay `mapstructure:"relay"`
+	// Capture writes the proxy's traffic to a pcapng file for debugging;
+	// see ProxyCapture.
+	Capture ProxyCapture `mapstructure:"capture"`
+	// Obfuscation masks the proxy's packets to peers that obfuscate too;
+	// see ProxyObfuscation.
+	Obfuscation ProxyObfuscation `mapstructure:"obfuscation"`
+}
+
+// maxJunkPackets bounds proxy.obfuscation.junk; wgproxy.MaxJunkPackets.
+const maxJunkPackets = 16
+
+// ProxyObfuscation configures masking the proxy's WireGuard packets so
+// networks that fingerprint WireGuard do not recognize them. Peers only
+// mask between each other when both enable it, which each advertises in its
+// endpoint record.
+type ProxyObfuscation struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Junk is how many junk packets precede each handshake initiation.
+	Junk int `mapstructure:"junk"`
+}
+
+// ProxyCapture configures the proxy's debug capture: off unless Path is
+// set. Zero limits take the wgproxy defaults.
+type ProxyCapture struct {
+	Path string `mapstructure:"path"`
+	// MaxSize rotates the file past this many bytes, keeping MaxFiles
+	// rotated files besides the current one.
+	MaxSize  int64 `mapstructure:"max_size"`
+	MaxFiles int   `mapstructure:"max_files"`
+	// SnapLen bounds how many payload bytes of each packet are kept.
+	SnapLen int `mapstructure:"snaplen"`
+}
+
+// ProxyRelay configures the relay node role and the relay node used for
+// fallback, both proxy-mode only.
+type ProxyRelay struct {
+	// Listen serves as a relay node for this interface's peers on that
+	// port; 0 (the default) is off. The node must be publicly reachable.
+	Listen int `mapstructure:"listen"`
+	// Server is the relay node's IPv4 address and port, and ServerKey its
+	// WireGuard public key (base64). When set, an allocation on it is
+	// published for every peer as a fallback endpoint.
+	Server    string `mapstructure:"server"`
+	ServerKey string `mapstructure:"server_key"`
 }
 
 // IsEnabled resolves proxy mode for goos (pass runtime.GOOS at call sites,
@@ -69,9 +131,53 @@ func (p *Proxy) IsEnabled(goos string) bool {
 	return goos == "windows"
 }
 
+// PortMapping asks the gateway to forward the interface's UDP port over
+// PCP, NAT-PMP or UPnP-IGD, and publishes the mapped address alongside the
+// STUN result.
+type PortMapping struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Gateway overrides the PCP/NAT-PMP server, by default the default
+	// route's next hop. UPnP always finds its gateway by discovery.
+	Gateway string `mapstructure:"gateway"`
+	// Lifetime is requested for the mapping; 0 means the portmap default.
+	// Mappings are renewed at half their lifetime.
+	Lifetime time.Duration `mapstructure:"lifetime"`
+}
+
+// Embedded runs the interface in-process instead of on kernel WireGuard,
+// configured like a wg-quick file: a TUN device driven by wireguard-go,
+// whose socket STUN shares. It needs no wireguard-tools, raw sockets or
+// proxy mode, and is not supported on Windows.
+type Embedded struct {
+	PrivateKey string `mapstructure:"private_key"`
+	// ListenPort 0 (the default) is ephemeral.
+	ListenPort int `mapstructure:"listen_port"`
+	// Address lists the interface's addresses in CIDR notation.
+	Address []string `mapstructure:"address"`
+	// MTU 0 means the wg-quick default, 1420.
+	MTU   int            `mapstructure:"mtu"`
+	Peers []EmbeddedPeer `mapstructure:"peers"`
+}
+
+// EmbeddedPeer is one WireGuard peer of an embedded interface, as a
+// wg-quick [Peer] section configures it.
+type EmbeddedPeer struct {
+	PublicKey    string   `mapstructure:"public_key"`
+	PresharedKey string   `mapstructure:"preshared_key"`
+	AllowedIPs   []string `mapstructure:"allowed_ips"`
+	// Endpoint is an optional initial ip:port; stunmesh replaces it with
+	// the endpoint it discovers.
+	Endpoint            string `mapstructure:"endpoint"`
+	PersistentKeepalive int    `mapstructure:"persistent_keepalive"`
+}
+
 type Interface struct {
-	Protocol string `mapstructure:"protocol"`
-	Proxy    Proxy  `mapstructure:"proxy"`
+	Protocol    string      `mapstructure:"protocol"`
+	Proxy       Proxy       `mapstructure:"proxy"`
+	PortMapping PortMapping `mapstructure:"port_mapping"`
+	// Embedded, when present, runs the interface in-process; nil leaves it
+	// to kernel or external WireGuard.
+	Embedded *Embedded `mapstructure:"embedded"`
 	// ListenInterfaces restricts which underlay interfaces STUN discovery
 	// listens on (darwin/bsd only; Linux uses a system-wide raw socket and
 	// ignores it). Empty means "all eligible interfaces" -- the default.
@@ -132,13 +238,27 @@ func (c *DeviceConfig) GetListenConfig(deviceName string) (interfaces []string,
 	return device.ListenInterfaces, device.ListenDefaultRoute
 }
 
-// GetProxyListenPort returns the proxy.listen override; 0 means ephemeral.
+// GetProxyListenPort returns the proxy.listen override's first port; 0
+// means ephemeral.
 func (c *DeviceConfig) GetProxyListenPort(deviceName string) uint16 {
+	if ports := c.GetProxyListenPorts(deviceName); len(ports) > 0 {
+		return ports[0]
+	}
+	return 0
+}
+
+// GetProxyListenPorts returns every proxy.listen port, in order; nil when
+// unset.
+func (c *DeviceConfig) GetProxyListenPorts(deviceName string) []uint16 {
 	device, ok := c.device(deviceName)
-	if !ok {
-		return 0
+	if !ok || len(device.Proxy.Listen) == 0 {
+		return nil
 	}
-	return uint16(device.Proxy.Listen)
+	ports := make([]uint16, len(device.Proxy.Listen))
+	for i, port := range device.Proxy.Listen {
+		ports[i] = uint16(port)
+	}
+	return ports
 }
 
 // GetProxyFib returns the proxy.fib override for deviceName; 0 means "not
@@ -151,6 +271,86 @@ func (c *DeviceConfig) GetProxyFib(deviceName string) int {
 	return device.Proxy.Fib
 }
 
+// GetProxyRoaming returns the proxy.roaming switch for deviceName; false for
+// an unknown device.
+func (c *DeviceConfig) GetProxyRoaming(deviceName string) bool {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return false
+	}
+	return device.Proxy.Roaming
+}
+
+// GetRelayListenPort returns the proxy.relay.listen port for deviceName; 0
+// (relay node off) for an unknown device.
+func (c *DeviceConfig) GetRelayListenPort(deviceName string) uint16 {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return 0
+	}
+	return uint16(device.Proxy.Relay.Listen)
+}
+
+// GetRelayServer returns the relay node deviceName falls back to and its
+// public key; ok is false when none is configured. Validation has already
+// rejected malformed values.
+func (c *DeviceConfig) GetRelayServer(deviceName string) (server netip.AddrPort, serverKey [32]byte, ok bool) {
+	device, found := c.device(deviceName)
+	if !found || device.Proxy.Relay.Server == "" {
+		return netip.AddrPort{}, serverKey, false
+	}
+	server, err := netip.ParseAddrPort(device.Proxy.Relay.Server)
+	if err != nil {
+		return netip.AddrPort{}, serverKey, false
+	}
+	key, err := base64.StdEncoding.DecodeString(device.Proxy.Relay.ServerKey)
+	if err != nil || len(key) != len(serverKey) {
+		return netip.AddrPort{}, serverKey, false
+	}
+	return server, [32]byte(key), true
+}
+
+// GetProxyCapture returns the proxy.capture block for deviceName; off for
+// an unknown device.
+func (c *DeviceConfig) GetProxyCapture(deviceName string) ProxyCapture {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return ProxyCapture{}
+	}
+	return device.Proxy.Capture
+}
+
+// GetProxyObfuscation returns whether deviceName obfuscates its proxy
+// traffic and how many junk packets precede each handshake initiation;
+// off for an unknown device.
+func (c *DeviceConfig) GetProxyObfuscation(deviceName string) (enabled bool, junk int) {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return false, 0
+	}
+	return device.Proxy.Obfuscation.Enabled, device.Proxy.Obfuscation.Junk
+}
+
+// GetPortMapping returns the port_mapping block for deviceName; disabled
+// for an unknown device.
+func (c *DeviceConfig) GetPortMapping(deviceName string) PortMapping {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return PortMapping{}
+	}
+	return device.PortMapping
+}
+
+// GetEmbedded returns deviceName's embedded block; nil when the device is
+// not embedded or unknown.
+func (c *DeviceConfig) GetEmbedded(deviceName string) *Embedded {
+	device, ok := c.device(deviceName)
+	if !ok {
+		return nil
+	}
+	return device.Embedded
+}
+
 // GetProxyEnabled resolves whether proxy mode is on for deviceName on goos
 // (pass runtime.GOOS at call sites); an unknown device resolves using the
 // platform default, same as a known device with proxy.enabled absent.
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+const (
+	testPrivateKey = "YAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testPublicKey  = "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="
+)
+
+func TestLoad_Embedded(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    embedded:
+      private_key: `+testPrivateKey+`
+      listen_port: 51820
+      address: [10.0.0.1/24, "fd00::1/64"]
+      mtu: 1380
+      peers:
+        - public_key: `+testPublicKey+`
+          allowed_ips: [10.0.0.2/32, 192.168.2.0/24]
+          endpoint: 192.0.2.1:51820
+          persistent_keepalive: 25
+    peers: {}
+  wg1:
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	e := dc.GetEmbedded("wg0")
+	if e == nil {
+		t.Fatal("GetEmbedded(wg0) = nil, want the embedded block")
+	}
+	if e.PrivateKey != testPrivateKey || e.ListenPort != 51820 || e.MTU != 1380 {
+		t.Errorf("GetEmbedded(wg0) = %+v, want its key, port and mtu", e)
+	}
+	if want := []string{"10.0.0.1/24", "fd00::1/64"}; !slices.Equal(e.Address, want) {
+		t.Errorf("Address = %q, want %q", e.Address, want)
+	}
+	if len(e.Peers) != 1 {
+		t.Fatalf("Peers = %+v, want one", e.Peers)
+	}
+	p := e.Peers[0]
+	if p.PublicKey != testPublicKey || p.Endpoint != "192.0.2.1:51820" || p.PersistentKeepalive != 25 || !slices.Equal(p.AllowedIPs, []string{"10.0.0.2/32", "192.168.2.0/24"}) {
+		t.Errorf("Peers[0] = %+v, want the configured peer", p)
+	}
+	if dc.GetEmbedded("wg1") != nil || dc.GetEmbedded("does-not-exist") != nil {
+		t.Error("GetEmbedded of a kernel or unknown device is not nil")
+	}
+}
+
+func TestValidateConfig_Embedded(t *testing.T) {
+	t.Parallel()
+	enabled := true
+	valid := func() *Embedded {
+		return &Embedded{
+			PrivateKey: testPrivateKey,
+			Address:    []string{"10.0.0.1/24"},
+			Peers:      []EmbeddedPeer{{PublicKey: testPublicKey, AllowedIPs: []string{"10.0.0.0/24"}}},
+		}
+	}
+	tests := []struct {
+		name    string
+		iface   string
+		goos    string
+		proxy   Proxy
+		modify  func(e *Embedded)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(*Embedded) {}},
+		{name: "freebsd", goos: "freebsd", modify: func(*Embedded) {}},
+		{name: "darwin utun", iface: "utun", goos: "darwin", modify: func(*Embedded) {}},
+		{name: "darwin utunN", iface: "utun9", goos: "darwin", modify: func(*Embedded) {}},
+		{name: "darwin other name", goos: "darwin", modify: func(*Embedded) {}, wantErr: true},
+		{name: "windows", goos: "windows", modify: func(*Embedded) {}, wantErr: true},
+		{name: "proxy mode", proxy: Proxy{Enabled: &enabled}, modify: func(*Embedded) {}, wantErr: true},
+		{name: "no private key", modify: func(e *Embedded) { e.PrivateKey = "" }, wantErr: true},
+		{name: "listen port", modify: func(e *Embedded) { e.ListenPort = 65536 }, wantErr: true},
+		{name: "small mtu", modify: func(e *Embedded) { e.MTU = 500 }, wantErr: true},
+		{name: "bare address", modify: func(e *Embedded) { e.Address = []string{"10.0.0.1"} }, wantErr: true},
+		{name: "peer key", modify: func(e *Embedded) { e.Peers[0].PublicKey = "short" }, wantErr: true},
+		{name: "preshared key", modify: func(e *Embedded) { e.Peers[0].PresharedKey = testPublicKey }},
+		{name: "bad preshared key", modify: func(e *Embedded) { e.Peers[0].PresharedKey = "short" }, wantErr: true},
+		{name: "default route", modify: func(e *Embedded) { e.Peers[0].AllowedIPs = []string{"0.0.0.0/0"} }, wantErr: true},
+		{name: "hostname endpoint", modify: func(e *Embedded) { e.Peers[0].Endpoint = "peer.example:51820" }, wantErr: true},
+		{name: "ipv6 endpoint", modify: func(e *Embedded) { e.Peers[0].Endpoint = "[2001:db8::1]:51820" }},
+		{name: "keepalive", modify: func(e *Embedded) { e.Peers[0].PersistentKeepalive = -1 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface, goos := tt.iface, tt.goos
+			if iface == "" {
+				iface = "wg0"
+			}
+			if goos == "" {
+				goos = "linux"
+			}
+			e := valid()
+			tt.modify(e)
+			cfg := &Config{Interfaces: Interfaces{iface: {Embedded: e, Proxy: tt.proxy}}}
+			if err := validateConfigForGOOS(cfg, goos); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigForGOOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
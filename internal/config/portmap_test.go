@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoad_PortMapping(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    port_mapping:
+      enabled: true
+      gateway: 192.168.1.1
+      lifetime: 30m
+    peers: {}
+  wg1:
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	want := PortMapping{Enabled: true, Gateway: "192.168.1.1", Lifetime: 30 * time.Minute}
+	if got := dc.GetPortMapping("wg0"); got != want {
+		t.Errorf("GetPortMapping(wg0) = %+v, want %+v", got, want)
+	}
+	if got := dc.GetPortMapping("wg1"); got.Enabled {
+		t.Error("GetPortMapping(wg1).Enabled = true, want false (off by default)")
+	}
+	if got := dc.GetPortMapping("does-not-exist"); got.Enabled {
+		t.Error("GetPortMapping(unknown).Enabled = true, want false")
+	}
+}
+
+func TestValidateConfig_PortMapping(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		mapping PortMapping
+		wantErr bool
+	}{
+		{"unset", PortMapping{}, false},
+		{"gateway", PortMapping{Enabled: true, Gateway: "10.0.0.1"}, false},
+		{"hostname gateway", PortMapping{Enabled: true, Gateway: "router.lan"}, true},
+		{"ipv6 gateway", PortMapping{Enabled: true, Gateway: "fe80::1"}, true},
+		{"negative lifetime", PortMapping{Enabled: true, Lifetime: -time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: Interfaces{"wg0": {PortMapping: tt.mapping}}}
+			if err := validateConfigForGOOS(cfg, "linux"); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigForGOOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"errors"
+	"net/netip"
 	"runtime"
+	"slices"
 	"testing"
 )
 
@@ -17,8 +19,8 @@ interfaces:
     peers: {}
 `)
 
-	if got := cfg.Interfaces["wg0"].Proxy.Listen; got != 51999 {
-		t.Errorf("Proxy.Listen = %d, want 51999", got)
+	if got := cfg.Interfaces["wg0"].Proxy.Listen; !slices.Equal(got, ListenPorts{51999}) {
+		t.Errorf("Proxy.Listen = %v, want [51999]", got)
 	}
 
 	dc := NewDeviceConfig(cfg)
@@ -62,14 +64,20 @@ func TestValidateConfig_ProxyListen(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
 		name    string
-		listen  int
+		listen  ListenPorts
 		wantErr bool
 	}{
-		{"unset (zero)", 0, false},
-		{"minimum port", 1, false},
-		{"maximum port", 65535, false},
-		{"negative", -1, true},
-		{"above range", 65536, true},
+		{"absent", nil, false},
+		{"unset (zero)", ListenPorts{0}, false},
+		{"minimum port", ListenPorts{1}, false},
+		{"maximum port", ListenPorts{65535}, false},
+		{"negative", ListenPorts{-1}, true},
+		{"above range", ListenPorts{65536}, true},
+		{"several ports", ListenPorts{51820, 51821, 51900}, false},
+		{"ephemeral among several", ListenPorts{51820, 0}, true},
+		{"duplicate", ListenPorts{51820, 51820}, true},
+		{"one above range among several", ListenPorts{51820, 65536}, true},
+		{"too many", make(ListenPorts, maxListenPorts+1), true},
 	}
 
 	for _, tt := range tests {
@@ -141,6 +149,59 @@ interfaces:
 	}
 }
 
+func TestLoad_ProxyListen_RangeAndList(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		listen string
+		want   []uint16
+	}{
+		{"range", `"51820-51823"`, []uint16{51820, 51821, 51822, 51823}},
+		{"comma-separated", `"51820, 51900"`, []uint16{51820, 51900}},
+		{"list", `[51820, "51900-51901"]`, []uint16{51820, 51900, 51901}},
+		{"single-port range", `"51820-51820"`, []uint16{51820}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    proxy:
+      listen: `+tt.listen+`
+    peers: {}
+`)
+
+			dc := NewDeviceConfig(cfg)
+			if got := dc.GetProxyListenPorts("wg0"); !slices.Equal(got, tt.want) {
+				t.Errorf("GetProxyListenPorts(wg0) = %v, want %v", got, tt.want)
+			}
+			if got := dc.GetProxyListenPort("wg0"); got != tt.want[0] {
+				t.Errorf("GetProxyListenPort(wg0) = %d, want %d", got, tt.want[0])
+			}
+		})
+	}
+}
+
+func TestLoad_ProxyListen_InvalidRange(t *testing.T) {
+	t.Parallel()
+	for _, listen := range []string{`"51830-51820"`, `"51820-"`, `"51820-52820"`, `"51820,51820"`, `"51820,0"`} {
+		t.Run(listen, func(t *testing.T) {
+			paths := writeWeakTypingConfig(t, `
+interfaces:
+  wg0:
+    proxy:
+      listen: `+listen+`
+    peers: {}
+`)
+
+			if _, err := load("", "", paths); err == nil {
+				t.Fatalf("Load() with proxy.listen %s should return an error", listen)
+			}
+		})
+	}
+}
+
 func TestLoad_ProxyFib_Present(t *testing.T) {
 	t.Parallel()
 	cfg := loadConfigFromYAML(t, `
@@ -162,6 +223,33 @@ interfaces:
 	}
 }
 
+func TestLoad_ProxyRoaming(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    proxy:
+      enabled: true
+      roaming: true
+    peers: {}
+  wg1:
+    proxy:
+      enabled: true
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	if !dc.GetProxyRoaming("wg0") {
+		t.Error("GetProxyRoaming(wg0) = false, want true")
+	}
+	if dc.GetProxyRoaming("wg1") {
+		t.Error("GetProxyRoaming(wg1) = true, want false (off by default)")
+	}
+	if dc.GetProxyRoaming("does-not-exist") {
+		t.Error("GetProxyRoaming(unknown) = true, want false")
+	}
+}
+
 // No proxy key (or no fib key) means the escape is off -- the zero-breaking
 // default, and also correct since FIB 0 is where the covering WireGuard
 // default route already lives.
@@ -295,7 +383,7 @@ func TestProxy_IsEnabled_Explicit(t *testing.T) {
 // A lone proxy.listen must not flip proxy mode on.
 func TestProxy_IsEnabled_ListenAloneDoesNotEnable(t *testing.T) {
 	t.Parallel()
-	p := Proxy{Listen: 51999}
+	p := Proxy{Listen: ListenPorts{51999}}
 	if got := p.IsEnabled("linux"); got != false {
 		t.Errorf("IsEnabled(linux) = %v, want false (listen alone must not enable proxy)", got)
 	}
@@ -446,3 +534,172 @@ func TestLoad_Testdata_InvalidProxyListen(t *testing.T) {
 		t.Fatal("Load() with invalid proxy.listen fixture should return an error")
 	}
 }
+
+func TestLoad_ProxyRelay(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    proxy:
+      enabled: true
+      relay:
+        listen: 3479
+    peers: {}
+  wg1:
+    proxy:
+      enabled: true
+      relay:
+        server: 198.51.100.1:3479
+        server_key: AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	if got := dc.GetRelayListenPort("wg0"); got != 3479 {
+		t.Errorf("GetRelayListenPort(wg0) = %d, want 3479", got)
+	}
+	if _, _, ok := dc.GetRelayServer("wg0"); ok {
+		t.Error("GetRelayServer(wg0) ok = true, want false")
+	}
+
+	server, key, ok := dc.GetRelayServer("wg1")
+	if !ok || server != netip.MustParseAddrPort("198.51.100.1:3479") {
+		t.Errorf("GetRelayServer(wg1) = %v, %v, want 198.51.100.1:3479", server, ok)
+	}
+	for i, b := range key {
+		if b != 1 {
+			t.Fatalf("GetRelayServer(wg1) key[%d] = %d, want 1", i, b)
+		}
+	}
+	if got := dc.GetRelayListenPort("wg1"); got != 0 {
+		t.Errorf("GetRelayListenPort(wg1) = %d, want 0 (off)", got)
+	}
+	if _, _, ok := dc.GetRelayServer("does-not-exist"); ok {
+		t.Error("GetRelayServer(unknown) ok = true, want false")
+	}
+}
+
+func TestValidateConfig_ProxyRelay(t *testing.T) {
+	t.Parallel()
+	const key = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	tests := []struct {
+		name    string
+		relay   ProxyRelay
+		wantErr bool
+	}{
+		{"unset", ProxyRelay{}, false},
+		{"listen", ProxyRelay{Listen: 3479}, false},
+		{"listen out of range", ProxyRelay{Listen: 70000}, true},
+		{"server", ProxyRelay{Server: "198.51.100.1:3479", ServerKey: key}, false},
+		{"server without key", ProxyRelay{Server: "198.51.100.1:3479"}, true},
+		{"key without server", ProxyRelay{ServerKey: key}, true},
+		{"server without port", ProxyRelay{Server: "198.51.100.1", ServerKey: key}, true},
+		{"ipv6 server", ProxyRelay{Server: "[2001:db8::1]:3479", ServerKey: key}, true},
+		{"hostname server", ProxyRelay{Server: "relay.example:3479", ServerKey: key}, true},
+		{"short key", ProxyRelay{Server: "198.51.100.1:3479", ServerKey: "AQEB"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: Interfaces{"wg0": {Proxy: Proxy{Relay: tt.relay}}}}
+			if err := validateConfigForGOOS(cfg, "linux"); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigForGOOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_ProxyCapture(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    proxy:
+      enabled: true
+      capture:
+        path: /var/tmp/wg0.pcapng
+        max_size: 1048576
+        max_files: 5
+        snaplen: 128
+    peers: {}
+  wg1:
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	want := ProxyCapture{Path: "/var/tmp/wg0.pcapng", MaxSize: 1 << 20, MaxFiles: 5, SnapLen: 128}
+	if got := dc.GetProxyCapture("wg0"); got != want {
+		t.Errorf("GetProxyCapture(wg0) = %+v, want %+v", got, want)
+	}
+	if got := dc.GetProxyCapture("wg1"); got != (ProxyCapture{}) {
+		t.Errorf("GetProxyCapture(wg1) = %+v, want off", got)
+	}
+}
+
+func TestValidateConfig_ProxyCapture(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		capture ProxyCapture
+		wantErr bool
+	}{
+		{"unset", ProxyCapture{}, false},
+		{"path only", ProxyCapture{Path: "wg0.pcapng"}, false},
+		{"negative max_size", ProxyCapture{Path: "wg0.pcapng", MaxSize: -1}, true},
+		{"negative max_files", ProxyCapture{Path: "wg0.pcapng", MaxFiles: -1}, true},
+		{"negative snaplen", ProxyCapture{Path: "wg0.pcapng", SnapLen: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: Interfaces{"wg0": {Proxy: Proxy{Capture: tt.capture}}}}
+			if err := validateConfigForGOOS(cfg, "linux"); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigForGOOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_ProxyObfuscation(t *testing.T) {
+	t.Parallel()
+	cfg := loadConfigFromYAML(t, `
+interfaces:
+  wg0:
+    proxy:
+      enabled: true
+      obfuscation:
+        enabled: true
+        junk: 3
+    peers: {}
+  wg1:
+    peers: {}
+`)
+
+	dc := NewDeviceConfig(cfg)
+	if enabled, junk := dc.GetProxyObfuscation("wg0"); !enabled || junk != 3 {
+		t.Errorf("GetProxyObfuscation(wg0) = %v, %d; want true, 3", enabled, junk)
+	}
+	if enabled, junk := dc.GetProxyObfuscation("wg1"); enabled || junk != 0 {
+		t.Errorf("GetProxyObfuscation(wg1) = %v, %d; want off", enabled, junk)
+	}
+}
+
+func TestValidateConfig_ProxyObfuscation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		junk    int
+		wantErr bool
+	}{
+		{"no junk", 0, false},
+		{"most junk", maxJunkPackets, false},
+		{"too much junk", maxJunkPackets + 1, true},
+		{"negative junk", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: Interfaces{"wg0": {Proxy: Proxy{Obfuscation: ProxyObfuscation{Enabled: true, Junk: tt.junk}}}}}
+			if err := validateConfigForGOOS(cfg, "linux"); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigForGOOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package ctrl
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// rateLimitBackoff is how long a controller leaves a plugin instance alone
+// after it reported being rate limited.
+const rateLimitBackoff = time.Minute
+
+// pluginBackoff tracks the plugin instances a controller leaves alone
+// because they reported being rate limited. The zero value is ready to use.
+type pluginBackoff struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// active reports whether plugin is still being left alone, and for how much
+// longer.
+func (b *pluginBackoff) active(plugin string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := time.Until(b.until[plugin])
+	if remaining <= 0 {
+		delete(b.until, plugin)
+		return 0, false
+	}
+	return remaining, true
+}
+
+// handle deals with the failures of a call to plugin that need more than
+// the caller's own log line: it alerts on rejected credentials, which only
+// the operator can fix, and backs off a rate limited plugin. It reports
+// whether err was one of those.
+func (b *pluginBackoff) handle(logger zerolog.Logger, plugin string, err error) bool {
+	switch {
+	case errors.Is(err, pluginapi.ErrUnauthorized):
+		logger.Error().Err(err).Str("plugin", plugin).Msg("plugin rejected its credentials, check the plugin's configuration")
+		return true
+	case errors.Is(err, pluginapi.ErrRateLimited):
+		b.mu.Lock()
+		if b.until == nil {
+			b.until = make(map[string]time.Time)
+		}
+		b.until[plugin] = time.Now().Add(rateLimitBackoff)
+		b.mu.Unlock()
+		logger.Warn().Err(err).Str("plugin", plugin).Dur("backoff", rateLimitBackoff).Msg("plugin is rate limited, backing off")
+		return true
+	}
+	return false
+}
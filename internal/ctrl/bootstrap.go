@@ -10,6 +10,16 @@ import (
 	"github.com/tjjh89017/stunmesh-go/internal/wg"
 )
 
+// Unpublisher is the slice of *PublishController that BootstrapController
+// needs to have a removed peer's record deleted.
+type Unpublisher interface {
+	Unpublish(peer *entity.Peer)
+}
+
+// pruneAfterMisses is how many refreshes in a row a registered peer has to
+// be missing from its device before Refresh drops it.
+const pruneAfterMisses = 3
+
 type BootstrapController struct {
 	wg            WireGuardClient
 	config        *config.Config
@@ -18,9 +28,14 @@ type BootstrapController struct {
 	peers         PeerRepository
 	logger        zerolog.Logger
 	filterService *entity.FilterPeerService
+	unpublisher   Unpublisher
+
+	// missing counts, per registered peer, the refreshes in a row that
+	// found it gone from its device. Only Refresh touches it.
+	missing map[entity.PeerId]int
 }
 
-func NewBootstrapController(wg WireGuardClient, config *config.Config, deviceConfig *config.DeviceConfig, devices DeviceRepository, peers PeerRepository, logger *zerolog.Logger, filterService *entity.FilterPeerService) *BootstrapController {
+func NewBootstrapController(wg WireGuardClient, config *config.Config, deviceConfig *config.DeviceConfig, devices DeviceRepository, peers PeerRepository, logger *zerolog.Logger, filterService *entity.FilterPeerService, unpublisher Unpublisher) *BootstrapController {
 	return &BootstrapController{
 		wg:            wg,
 		config:        config,
@@ -29,6 +44,8 @@ func NewBootstrapController(wg WireGuardClient, config *config.Config, deviceCon
 		peers:         peers,
 		logger:        logger.With().Str("controller", "bootstrap").Logger(),
 		filterService: filterService,
+		unpublisher:   unpublisher,
+		missing:       make(map[entity.PeerId]int),
 	}
 }
 
@@ -80,3 +97,78 @@ func (ctrl *BootstrapController) registerDevice(ctx context.Context, deviceName
 
 	return nil
 }
+
+// Refresh brings the registered peers in line with their WireGuard
+// devices: peers added to a device since the last refresh are registered,
+// and peers missing from it for pruneAfterMisses refreshes in a row are
+// dropped and have their records deleted. Waiting out a few refreshes keeps
+// a peer that only blinks out, e.g. across a wg syncconf, from losing its
+// record. A device that cannot be read is skipped: that says nothing about
+// its peers.
+func (ctrl *BootstrapController) Refresh(ctx context.Context) {
+	for deviceName := range ctrl.config.Interfaces {
+		if err := ctrl.refreshDevice(ctx, deviceName); err != nil {
+			ctrl.logger.Warn().Err(err).Str("device", deviceName).Msg("failed to refresh device peers")
+		}
+	}
+}
+
+func (ctrl *BootstrapController) refreshDevice(ctx context.Context, deviceName string) error {
+	registered, err := ctrl.peers.ListByDevice(ctx, entity.DeviceId(deviceName))
+	if err != nil {
+		return err
+	}
+
+	device, err := ctrl.wg.Device(deviceName)
+	if err != nil {
+		return err
+	}
+
+	allowPeers, err := ctrl.filterService.Execute(ctx, entity.DeviceId(deviceName), device.PublicKey[:])
+	if err != nil {
+		return err
+	}
+
+	known := make(map[entity.PeerId]bool, len(registered))
+	for _, peer := range registered {
+		known[peer.Id()] = true
+	}
+	allowed := make(map[entity.PeerId]bool, len(allowPeers))
+	for _, peer := range allowPeers {
+		allowed[peer.Id()] = true
+		delete(ctrl.missing, peer.Id())
+		if known[peer.Id()] {
+			continue
+		}
+		ctrl.logger.Info().
+			Str("device", deviceName).
+			Str("peer", peer.Id().PeerPublicKeyString()).
+			Msg("peer added to device, registering it")
+		ctrl.devices.Save(ctx, entity.NewDevice(
+			entity.DeviceId(device.Name),
+			device.ListenPort,
+			device.PrivateKey[:],
+			ctrl.deviceConfig.GetInterfaceProtocol(deviceName),
+			device.FirewallMark,
+		))
+		ctrl.peers.Save(ctx, peer)
+	}
+
+	for _, peer := range registered {
+		if allowed[peer.Id()] {
+			continue
+		}
+		ctrl.missing[peer.Id()]++
+		if ctrl.missing[peer.Id()] < pruneAfterMisses {
+			continue
+		}
+		delete(ctrl.missing, peer.Id())
+		ctrl.logger.Info().
+			Str("device", deviceName).
+			Str("peer", peer.Id().PeerPublicKeyString()).
+			Msg("peer removed from device, dropping it")
+		ctrl.peers.Delete(ctx, peer.Id())
+		ctrl.unpublisher.Unpublish(peer)
+	}
+	return nil
+}
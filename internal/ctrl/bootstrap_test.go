@@ -49,6 +49,7 @@ func TestBootstrap_WithError(t *testing.T) {
 		mockPeers,
 		&logger,
 		peerFilterService,
+		nil,
 	)
 
 	bootstrap.Execute(context.TODO())
@@ -133,7 +134,179 @@ func TestBootstrap_WithMultipleInterfaces(t *testing.T) {
 		mockPeers,
 		&logger,
 		peerFilterService,
+		nil,
 	)
 
 	bootstrap.Execute(context.TODO())
 }
+
+// fakeUnpublisher records the peers it was asked to unpublish.
+type fakeUnpublisher struct {
+	peers []*entity.Peer
+}
+
+func (f *fakeUnpublisher) Unpublish(peer *entity.Peer) {
+	f.peers = append(f.peers, peer)
+}
+
+// refreshFixture is a bootstrap controller over a wg0 with two configured
+// peers, whose device and peer lookups the test scripts per refresh.
+type refreshFixture struct {
+	wgClient    *mock.MockWireGuardClient
+	devices     *mock.MockDeviceRepository
+	peers       *mock.MockPeerRepository
+	checker     *mockEntity.MockDevicePeerChecker
+	unpublisher *fakeUnpublisher
+	bootstrap   *ctrl.BootstrapController
+
+	device             *wg.DeviceInfo
+	keptKey, goneKey   wg.Key
+	keptPeer, gonePeer *entity.Peer
+}
+
+func newRefreshFixture(t *testing.T) *refreshFixture {
+	mockCtrl := gomock.NewController(t)
+	logger := zerolog.Nop()
+	cfg := &config.Config{
+		Interfaces: map[string]config.Interface{
+			"wg0": {
+				Peers: map[string]config.Peer{
+					"test_peer1": {
+						PublicKey: "XgPRso34lnrSAx8nJtdj1/zlF7CoNj7B64LPElYdOGs=",
+						Plugin:    "exec",
+					},
+					"test_peer2": {
+						PublicKey: "FQ9/2l8t4xmQQbs6SB03+Lh2VijJX74rxRUOv7YT03k=",
+						Plugin:    "exec",
+					},
+				},
+			},
+		},
+	}
+	deviceConfig := config.NewDeviceConfig(cfg)
+
+	f := &refreshFixture{
+		wgClient:    mock.NewMockWireGuardClient(mockCtrl),
+		devices:     mock.NewMockDeviceRepository(mockCtrl),
+		peers:       mock.NewMockPeerRepository(mockCtrl),
+		checker:     mockEntity.NewMockDevicePeerChecker(mockCtrl),
+		unpublisher: &fakeUnpublisher{},
+		keptKey:     wg.Key{94, 3, 209, 178, 141, 248, 150, 122, 210, 3, 31, 39, 38, 215, 99, 215, 252, 229, 23, 176, 168, 54, 62, 193, 235, 130, 207, 18, 86, 29, 56, 107},
+		goneKey:     wg.Key{21, 15, 127, 218, 95, 45, 227, 25, 144, 65, 187, 58, 72, 29, 55, 248, 184, 118, 86, 40, 201, 95, 190, 43, 197, 21, 14, 191, 182, 19, 211, 121},
+	}
+	f.device = &wg.DeviceInfo{
+		Name:       "wg0",
+		ListenPort: 51820,
+		PublicKey:  wg.Key{1},
+	}
+	f.keptPeer = entity.NewPeer(entity.NewPeerId(f.device.PublicKey[:], f.keptKey[:]), "wg0", entity.PeerPublicKey(f.keptKey), "exec", "ipv4", entity.PeerPingConfig{})
+	f.gonePeer = entity.NewPeer(entity.NewPeerId(f.device.PublicKey[:], f.goneKey[:]), "wg0", entity.PeerPublicKey(f.goneKey), "exec", "ipv4", entity.PeerPingConfig{})
+	f.bootstrap = ctrl.NewBootstrapController(
+		f.wgClient,
+		cfg,
+		deviceConfig,
+		f.devices,
+		f.peers,
+		&logger,
+		entity.NewFilterPeerService(f.checker, deviceConfig),
+		f.unpublisher,
+	)
+	return f
+}
+
+// refresh runs one Refresh with registered as the repository's peers and
+// onDevice as the device's.
+func (f *refreshFixture) refresh(registered []*entity.Peer, onDevice ...wg.Key) {
+	peerMap := make(map[entity.PeerKey]bool, len(onDevice))
+	for _, key := range onDevice {
+		peerMap[entity.PeerKey(key)] = true
+	}
+	f.peers.EXPECT().ListByDevice(gomock.Any(), entity.DeviceId("wg0")).Return(registered, nil)
+	f.wgClient.EXPECT().Device("wg0").Return(f.device, nil)
+	f.checker.EXPECT().GetDevicePeerMap(gomock.Any(), "wg0").Return(peerMap, nil)
+	f.bootstrap.Refresh(context.TODO())
+}
+
+func TestBootstrap_Refresh_PrunesPeerMissingForSeveralRefreshes(t *testing.T) {
+	f := newRefreshFixture(t)
+	registered := []*entity.Peer{f.keptPeer, f.gonePeer}
+
+	// A peer only just gone may be in the middle of a wg syncconf.
+	f.refresh(registered, f.keptKey)
+	f.refresh(registered, f.keptKey)
+	if len(f.unpublisher.peers) != 0 {
+		t.Fatalf("unpublished %v before the peer stayed missing", f.unpublisher.peers)
+	}
+
+	f.peers.EXPECT().Delete(gomock.Any(), f.gonePeer.Id())
+	f.refresh(registered, f.keptKey)
+	if len(f.unpublisher.peers) != 1 || f.unpublisher.peers[0] != f.gonePeer {
+		t.Fatalf("unpublished %v, want only the removed peer", f.unpublisher.peers)
+	}
+}
+
+func TestBootstrap_Refresh_PeerRemovedThenReadded(t *testing.T) {
+	f := newRefreshFixture(t)
+
+	// Missing twice, then back: the count starts over.
+	registered := []*entity.Peer{f.keptPeer, f.gonePeer}
+	f.refresh(registered, f.keptKey)
+	f.refresh(registered, f.keptKey)
+	f.refresh(registered, f.keptKey, f.goneKey)
+	f.refresh(registered, f.keptKey)
+	f.refresh(registered, f.keptKey)
+	if len(f.unpublisher.peers) != 0 {
+		t.Fatalf("unpublished %v for a peer that came back", f.unpublisher.peers)
+	}
+
+	// Pruned, then added back to the device: registered again.
+	f.peers.EXPECT().Delete(gomock.Any(), f.gonePeer.Id())
+	f.refresh(registered, f.keptKey)
+
+	f.devices.EXPECT().Save(gomock.Any(), gomock.Any())
+	f.peers.EXPECT().Save(gomock.Any(), gomock.Cond(func(peer *entity.Peer) bool {
+		return peer.Id() == f.gonePeer.Id()
+	}))
+	f.refresh([]*entity.Peer{f.keptPeer}, f.keptKey, f.goneKey)
+}
+
+func TestBootstrap_Refresh_DeviceError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	logger := zerolog.Nop()
+	cfg := &config.Config{
+		Interfaces: map[string]config.Interface{
+			"wg0": {},
+		},
+	}
+	deviceConfig := config.NewDeviceConfig(cfg)
+	mockDevicePeerChecker := mockEntity.NewMockDevicePeerChecker(mockCtrl)
+	peerFilterService := entity.NewFilterPeerService(mockDevicePeerChecker, deviceConfig)
+
+	peer := entity.NewPeer(entity.NewPeerId([]byte{1}, []byte{2}), "wg0", entity.PeerPublicKey{2}, "exec", "ipv4", entity.PeerPingConfig{})
+	mockPeers.EXPECT().ListByDevice(gomock.Any(), entity.DeviceId("wg0")).Return([]*entity.Peer{peer}, nil)
+	mockWgClient.EXPECT().Device("wg0").Return(nil, errors.New("device not found"))
+
+	unpublisher := &fakeUnpublisher{}
+	bootstrap := ctrl.NewBootstrapController(
+		mockWgClient,
+		cfg,
+		deviceConfig,
+		mockDevices,
+		mockPeers,
+		&logger,
+		peerFilterService,
+		unpublisher,
+	)
+
+	// An unreadable device must not be taken as one without peers.
+	bootstrap.Refresh(context.TODO())
+
+	if len(unpublisher.peers) != 0 {
+		t.Fatalf("unpublished %v after a device error", unpublisher.peers)
+	}
+}
@@ -18,6 +18,21 @@ type EndpointData struct {
 	// IPv6 contains the encrypted IPv6 endpoint (format: "ip:port")
 	// Empty string means IPv6 endpoint is not available
 	IPv6 string `json:"ipv6,omitempty"`
+
+	// MappedIPv4 is the IPv4 endpoint the publisher's gateway forwards by
+	// port mapping (format: "ip:port"), on the same address as IPv4.
+	// Empty string means the publisher has no port mapping
+	MappedIPv4 string `json:"mapped_ipv4,omitempty"`
+
+	// Relay is the relay node allocation between the publisher and this
+	// peer (format: "ip:port"), the same one the peer allocates, for it to
+	// fall back to when the direct endpoints do not work.
+	// Empty string means the publisher has no relay
+	Relay string `json:"relay,omitempty"`
+
+	// Obfuscation says the publisher masks its proxy traffic to peers that
+	// obfuscate too, so the peer should as well
+	Obfuscation bool `json:"obfuscation,omitempty"`
 }
 
 type EndpointEncryptRequest struct {
@@ -10,9 +10,16 @@ import "fmt"
 // actually pass "" here; mobile's config leaves the field optional, so its
 // controller can).
 //
+// Where IPv4 is chosen, a MappedIPv4 endpoint wins over the STUN one: the
+// gateway forwards it to the publisher outright, where the STUN endpoint
+// may only admit traffic from addresses the publisher has sent to.
+//
 // Shared by EstablishController.Execute and the mobile controller so the
 // two callers can never drift on this rule again.
 func SelectEndpoint(data EndpointData, protocol string) (string, error) {
+	if data.MappedIPv4 != "" {
+		data.IPv4 = data.MappedIPv4
+	}
 	switch protocol {
 	case "", "ipv4":
 		if data.IPv4 == "" {
@@ -3,6 +3,7 @@ package ctrl
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"strconv"
 	"sync"
@@ -13,8 +14,24 @@ import (
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
 	"github.com/tjjh89017/stunmesh-go/internal/queue"
 	"github.com/tjjh89017/stunmesh-go/internal/wg"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
 
+// reapplyInterval is how often a peer's endpoint is applied again even
+// though its record did not change, in case the device lost it, e.g. to the
+// interface being recreated.
+const reapplyInterval = 10 * time.Minute
+
+// appliedEndpoint is what Execute last applied to a peer, and when. direct
+// is the record's own endpoint at the time, which endpoint differs from
+// while the peer is relayed.
+type appliedEndpoint struct {
+	record   string
+	endpoint string
+	direct   string
+	at       time.Time
+}
+
 type EstablishController struct {
 	wgCtrl        WireGuardClient
 	devices       DeviceRepository
@@ -22,12 +39,38 @@ type EstablishController struct {
 	pluginManager PluginProvider
 	decryptor     EndpointDecryptor
 	deviceConfig  DeviceConfigProvider
+	obfuscation   Obfuscation
 	logger        zerolog.Logger
 	mu            sync.Mutex
 	queue         *queue.Queue[entity.PeerId]
+
+	// appliedMu guards applied, touched only by Execute, revalidate and
+	// failed, which TriggerForPeer and TriggerForFailedPeer set from other
+	// goroutines, and prefetched, the records Trigger read ahead for Execute.
+	appliedMu  sync.Mutex
+	applied    map[entity.PeerId]appliedEndpoint
+	revalidate map[entity.PeerId]bool
+	failed     map[entity.PeerId]bool
+	prefetched map[entity.PeerId]prefetchedRecord
+
+	// watchMu guards watchCtx, Run's context while it runs, and watches,
+	// the subscriptions syncWatches keeps in line with the registered peers.
+	watchMu  sync.Mutex
+	watchCtx context.Context
+	watches  map[watchKey]*runningWatch
+	watchWG  sync.WaitGroup
+
+	backoff pluginBackoff
+}
+
+// prefetchedRecord is a peer's record as a GetMany returned it; found is
+// false when there was none.
+type prefetchedRecord struct {
+	value string
+	found bool
 }
 
-func NewEstablishController(ctrl WireGuardClient, devices DeviceRepository, peers PeerRepository, pluginManager PluginProvider, decryptor EndpointDecryptor, deviceConfig DeviceConfigProvider, logger *zerolog.Logger) *EstablishController {
+func NewEstablishController(ctrl WireGuardClient, devices DeviceRepository, peers PeerRepository, pluginManager PluginProvider, decryptor EndpointDecryptor, deviceConfig DeviceConfigProvider, obfuscation Obfuscation, logger *zerolog.Logger) *EstablishController {
 	return &EstablishController{
 		wgCtrl:        ctrl,
 		devices:       devices,
@@ -35,15 +78,30 @@ func NewEstablishController(ctrl WireGuardClient, devices DeviceRepository, peer
 		pluginManager: pluginManager,
 		decryptor:     decryptor,
 		deviceConfig:  deviceConfig,
+		obfuscation:   obfuscation,
 		logger:        logger.With().Str("controller", "establish").Logger(),
 		queue:         queue.NewBuffered[entity.PeerId](queue.PeerQueueSize),
+		applied:       make(map[entity.PeerId]appliedEndpoint),
+		revalidate:    make(map[entity.PeerId]bool),
+		failed:        make(map[entity.PeerId]bool),
+		prefetched:    make(map[entity.PeerId]prefetchedRecord),
+		watches:       make(map[watchKey]*runningWatch),
 	}
 }
 
+// Execute reads a peer's record and applies the endpoint it holds. A record
+// identical to the one applied last, or one that selects the same endpoint,
+// leaves the device alone until reapplyInterval has passed, unless the peer
+// was triggered with TriggerForPeer or TriggerForFailedPeer. A record with
+// a relay endpoint falls back to it as chooseEndpoint describes. Traffic to
+// the peer is masked when the record and the device both obfuscate.
 func (c *EstablishController) Execute(ctx context.Context, peerId entity.PeerId) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	last, fresh, failed := c.lastApplied(peerId)
+	record, prefetched := c.takePrefetched(peerId)
+
 	peer, err := c.peers.Find(ctx, peerId)
 	if err != nil {
 		c.logger.Error().Err(err).Msg("failed to find peer")
@@ -65,9 +123,33 @@ func (c *EstablishController) Execute(ctx context.Context, peerId entity.PeerId)
 	}
 
 	storeCtx := dialer.WithEscape(logger.WithContext(ctx), escapeFor(c.deviceConfig, device))
-	encryptedData, err := store.Get(storeCtx, peer.RemoteId())
-	if err != nil {
-		logger.Warn().Err(err).Msg("endpoint is unavailable or not ready")
+	if !fresh {
+		storeCtx = pluginapi.Revalidate(storeCtx)
+	}
+	encryptedData := record.value
+	if !prefetched {
+		if remaining, ok := c.backoff.active(peer.Plugin()); ok {
+			logger.Debug().Str("plugin", peer.Plugin()).Dur("remaining", remaining).Msg("plugin is rate limited, not reading the endpoint")
+			return
+		}
+		encryptedData, err = store.Get(storeCtx, peer.RemoteId())
+		switch {
+		case err == nil:
+		case errors.Is(err, pluginapi.ErrNotFound):
+			logger.Debug().Err(err).Msg("peer has not published its endpoint yet")
+			return
+		case c.backoff.handle(logger, peer.Plugin(), err):
+			return
+		default:
+			logger.Warn().Err(err).Msg("endpoint is unavailable or not ready")
+			return
+		}
+	} else if !record.found {
+		logger.Debug().Msg("peer has not published its endpoint yet")
+		return
+	}
+	if fresh && encryptedData == last.record {
+		logger.Debug().Msg("record unchanged, endpoint already applied")
 		return
 	}
 
@@ -92,15 +174,29 @@ func (c *EstablishController) Execute(ctx context.Context, peerId entity.PeerId)
 	// Log decrypted endpoint data for debugging
 	logger.Trace().Str("json", res.Content).Msg("decrypted endpoint data")
 
+	c.applyObfuscation(device, peer, endpointData.Obfuscation, logger)
+
 	// Select endpoint based on peer protocol
 	peerProtocol := peer.Protocol()
-	selectedEndpoint, err := SelectEndpoint(endpointData, peerProtocol)
-	if err != nil {
+	direct, err := SelectEndpoint(endpointData, peerProtocol)
+	if err != nil && endpointData.Relay == "" {
 		logger.Error().Err(err).Str("protocol", peerProtocol).Msg("failed to select endpoint")
 		return
 	}
+	selectedEndpoint := chooseEndpoint(direct, endpointData.Relay, last, failed)
+	if selectedEndpoint == endpointData.Relay {
+		logger.Info().Str("relay", selectedEndpoint).Str("direct", direct).Msg("using relay endpoint")
+	}
 	logger.Debug().Str("endpoint", selectedEndpoint).Str("protocol", peerProtocol).Msg("selected endpoint")
 
+	if fresh && selectedEndpoint == last.endpoint {
+		logger.Debug().Msg("endpoint unchanged, already applied")
+		last.record = encryptedData
+		last.direct = direct
+		c.setApplied(peerId, last)
+		return
+	}
+
 	// Parse host:port
 	host, portStr, err := net.SplitHostPort(selectedEndpoint)
 	if err != nil {
@@ -119,6 +215,76 @@ func (c *EstablishController) Execute(ctx context.Context, peerId entity.PeerId)
 		logger.Error().Err(err).Msg("failed to configure device")
 		return
 	}
+	c.setApplied(peerId, appliedEndpoint{record: encryptedData, endpoint: selectedEndpoint, direct: direct, at: time.Now()})
+}
+
+// applyObfuscation masks the device's traffic to the peer when both
+// obfuscate, and stops once either does not.
+func (c *EstablishController) applyObfuscation(device *entity.Device, peer *entity.Peer, advertised bool, logger zerolog.Logger) {
+	if c.obfuscation == nil {
+		return
+	}
+	enabled := advertised && c.obfuscation.Enabled(string(device.Name()))
+	if err := c.obfuscation.SetPeer(string(device.Name()), peer.PublicKey(), enabled); err != nil {
+		logger.Warn().Err(err).Msg("failed to set obfuscation for peer")
+	}
+}
+
+// chooseEndpoint picks between a record's direct endpoint and the relay
+// endpoint published alongside it. The relay is used when there is no
+// direct endpoint, or when the ping monitor reports the peer failing while
+// the direct one is applied; a failure while relayed tries the direct
+// endpoint again. Otherwise a relayed peer stays relayed for as long as the
+// direct endpoint it fell back from is unchanged. A record change alone
+// never moves a peer onto the relay.
+func chooseEndpoint(direct, relay string, last appliedEndpoint, failed bool) string {
+	switch {
+	case relay == "":
+		return direct
+	case direct == "":
+		return relay
+	case failed:
+		if last.endpoint == direct {
+			return relay
+		}
+		return direct
+	case last.endpoint == relay && last.direct == direct:
+		return relay
+	default:
+		return direct
+	}
+}
+
+// lastApplied returns what was last applied to the peer, with fresh set
+// when that can stand: it is recent, and the peer was not triggered since.
+// failed reports whether the trigger was the peer's path failing.
+func (c *EstablishController) lastApplied(peerId entity.PeerId) (last appliedEndpoint, fresh, failed bool) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	last, ok := c.applied[peerId]
+	forced := c.revalidate[peerId]
+	failed = c.failed[peerId]
+	delete(c.revalidate, peerId)
+	delete(c.failed, peerId)
+	return last, ok && !forced && time.Since(last.at) < reapplyInterval, failed
+}
+
+// takePrefetched returns the record Trigger read ahead for the peer, if any,
+// so it is used once.
+func (c *EstablishController) takePrefetched(peerId entity.PeerId) (prefetchedRecord, bool) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	record, ok := c.prefetched[peerId]
+	delete(c.prefetched, peerId)
+	return record, ok
+}
+
+func (c *EstablishController) setApplied(peerId entity.PeerId, applied appliedEndpoint) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+	c.applied[peerId] = applied
 }
 
 func (c *EstablishController) ConfigureDevice(ctx context.Context, peer *entity.Peer, host string, port int) error {
@@ -139,9 +305,28 @@ func (c *EstablishController) ConfigureDevice(ctx context.Context, peer *entity.
 	return nil
 }
 
-// Run starts the worker goroutine that processes establish triggers
+// Run starts the worker goroutine that processes establish triggers, and
+// the watches of stores that push record changes. Trigger starts and stops
+// watches as peers come and go while Run runs.
 func (c *EstablishController) Run(ctx context.Context) {
 	c.logger.Info().Msg("establish controller worker started")
+
+	c.watchMu.Lock()
+	c.watchCtx = ctx
+	c.watchMu.Unlock()
+	defer func() {
+		c.watchMu.Lock()
+		c.watchCtx = nil
+		clear(c.watches)
+		c.watchMu.Unlock()
+		c.watchWG.Wait()
+	}()
+	if peers, err := c.peers.List(ctx); err != nil {
+		c.logger.Error().Err(err).Msg("failed to list peers to watch")
+	} else {
+		c.syncWatches(ctx, peers)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -153,13 +338,18 @@ func (c *EstablishController) Run(ctx context.Context) {
 	}
 }
 
-// Trigger lists all peers and enqueues them for establishment
+// Trigger lists all peers and enqueues them for establishment. The records
+// of peers whose store is a pluginapi.BatchStore are read ahead, with one
+// GetMany per plugin instance and device, and the watches are brought in
+// line with the peers.
 func (c *EstablishController) Trigger(ctx context.Context) {
 	peers, err := c.peers.List(ctx)
 	if err != nil {
 		c.logger.Error().Err(err).Msg("failed to list peers")
 		return
 	}
+	c.syncWatches(ctx, peers)
+	c.prefetch(ctx, peers)
 
 	enqueued := 0
 	for _, peer := range peers {
@@ -174,8 +364,83 @@ func (c *EstablishController) Trigger(ctx context.Context) {
 	c.logger.Debug().Int("queue_len", c.queue.Len()).Msg("current queue length")
 }
 
-// TriggerForPeer enqueues a specific peer for establishment (non-blocking)
+// prefetch reads the records of peers in BatchStores ahead for Execute. A
+// group whose GetMany fails is left for Execute to read peer by peer.
+func (c *EstablishController) prefetch(ctx context.Context, peers []*entity.Peer) {
+	type groupKey struct {
+		plugin string
+		device entity.DeviceId
+	}
+	groups := make(map[groupKey][]*entity.Peer)
+	for _, peer := range peers {
+		key := groupKey{plugin: peer.Plugin(), device: peer.DeviceName()}
+		groups[key] = append(groups[key], peer)
+	}
+
+	for key, group := range groups {
+		store, err := c.pluginManager.GetPlugin(key.plugin)
+		if err != nil {
+			continue
+		}
+		many, ok := pluginapi.As[pluginapi.BatchStore](store)
+		if !ok {
+			continue
+		}
+		if _, ok := c.backoff.active(key.plugin); ok {
+			continue
+		}
+		device, err := c.devices.Find(ctx, key.device)
+		if err != nil {
+			continue
+		}
+
+		logger := c.logger.With().Str("plugin", key.plugin).Str("device", string(key.device)).Logger()
+		keys := make([]string, 0, len(group))
+		for _, peer := range group {
+			keys = append(keys, peer.RemoteId())
+		}
+		records, err := many.GetMany(dialer.WithEscape(logger.WithContext(ctx), escapeFor(c.deviceConfig, device)), keys)
+		if err != nil {
+			if !c.backoff.handle(logger, key.plugin, err) {
+				logger.Warn().Err(err).Msg("failed to read peer records at once, reading them one by one")
+			}
+			continue
+		}
+		logger.Debug().Int("peers", len(group)).Int("records", len(records)).Msg("read peer records at once")
+
+		c.appliedMu.Lock()
+		for _, peer := range group {
+			value, found := records[peer.RemoteId()]
+			c.prefetched[peer.Id()] = prefetchedRecord{value: value, found: found}
+		}
+		c.appliedMu.Unlock()
+	}
+}
+
+// TriggerForPeer enqueues a specific peer for establishment (non-blocking).
+// The peer's record is read past any cache and its endpoint applied even if
+// unchanged: the trigger comes from a changed record, which watches may
+// report spuriously, so it keeps a peer on the endpoint it is on.
 func (c *EstablishController) TriggerForPeer(peerId entity.PeerId) {
+	c.trigger(peerId, false)
+}
+
+// TriggerForFailedPeer is TriggerForPeer for a peer whose ping is failing:
+// it also switches the peer between its direct and relay endpoints, as
+// chooseEndpoint describes.
+func (c *EstablishController) TriggerForFailedPeer(peerId entity.PeerId) {
+	c.trigger(peerId, true)
+}
+
+func (c *EstablishController) trigger(peerId entity.PeerId, failed bool) {
+	c.appliedMu.Lock()
+	c.revalidate[peerId] = true
+	if failed {
+		c.failed[peerId] = true
+	}
+	delete(c.prefetched, peerId)
+	c.appliedMu.Unlock()
+
 	if c.queue.TryEnqueue(peerId) {
 		c.logger.Debug().Str("peer", peerId.PeerPublicKeyString()).Msg("establish triggered for peer")
 	} else {
@@ -4,8 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/ctrl"
@@ -13,6 +18,7 @@ import (
 	"github.com/tjjh89017/stunmesh-go/internal/entity"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	"github.com/tjjh89017/stunmesh-go/internal/wg"
 	"github.com/tjjh89017/stunmesh-go/pluginapi"
 	"go.uber.org/mock/gomock"
 )
@@ -87,6 +93,7 @@ func TestEstablishController_Execute_PeerNotFound(t *testing.T) {
 		pluginManager,
 		nil, // decryptor
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -123,6 +130,7 @@ func TestEstablishController_Execute_DeviceNotFound(t *testing.T) {
 		pluginManager,
 		nil,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -160,6 +168,7 @@ func TestEstablishController_Execute_PluginGetError(t *testing.T) {
 		pluginManager,
 		nil,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -207,6 +216,7 @@ func TestEstablishController_Execute_StorageGetError(t *testing.T) {
 		pluginManager,
 		nil,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -261,6 +271,7 @@ func TestEstablishController_Execute_DecryptionError(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -315,6 +326,7 @@ func TestEstablishController_Execute_InvalidJSON(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -379,6 +391,7 @@ func TestEstablishController_Execute_IPv4Selection(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -443,6 +456,7 @@ func TestEstablishController_Execute_IPv6Selection(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -507,6 +521,7 @@ func TestEstablishController_Execute_PreferIPv4_HasIPv4(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -571,6 +586,7 @@ func TestEstablishController_Execute_PreferIPv4_FallbackIPv6(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -635,6 +651,7 @@ func TestEstablishController_Execute_PreferIPv6_HasIPv6(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -699,6 +716,7 @@ func TestEstablishController_Execute_PreferIPv6_FallbackIPv4(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -760,6 +778,7 @@ func TestEstablishController_Execute_IPv4_NotAvailable(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -822,6 +841,7 @@ func TestEstablishController_Execute_WireGuardError(t *testing.T) {
 		pluginManager,
 		mockDecryptor,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -829,6 +849,396 @@ func TestEstablishController_Execute_WireGuardError(t *testing.T) {
 	controller.Execute(ctx, peerId)
 }
 
+// Test Execute - unchanged records and endpoints are not applied again
+func TestEstablishController_Execute_SkipsUnchanged(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+	store := newTestStore()
+	_ = store.Set(ctx, peer.RemoteId(), "encrypted_data")
+
+	jsonData, _ := json.Marshal(ctrl.EndpointData{IPv4: "1.2.3.4:51820"})
+
+	mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil).Times(4)
+	mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil).Times(4)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).Times(4)
+	// Read as a new record: the first time, after it is republished, and
+	// after the peer is triggered.
+	mockDecryptor.EXPECT().
+		Decrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil).
+		Times(3)
+	// Applied the first time and when triggered; the republished record
+	// holds the same endpoint.
+	mockWgClient.EXPECT().
+		UpdatePeerEndpoint(gomock.Any()).
+		Return(nil).
+		Times(2)
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockDecryptor,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	controller.Execute(ctx, peer.Id())
+	controller.Execute(ctx, peer.Id())
+
+	_ = store.Set(ctx, peer.RemoteId(), "republished_data")
+	controller.Execute(ctx, peer.Id())
+
+	controller.TriggerForPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+}
+
+// A record with a relay falls back to it when the peer's ping fails while
+// direct, stays on it while the direct endpoint is unchanged, and tries
+// direct again on the next failure.
+func TestEstablishController_Execute_RelayFallback(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+	store := newTestStore()
+	_ = store.Set(ctx, peer.RemoteId(), "encrypted_data")
+
+	jsonData, _ := json.Marshal(ctrl.EndpointData{IPv4: "1.2.3.4:51820", Relay: "198.51.100.1:40000"})
+
+	mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil).AnyTimes()
+	mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil).AnyTimes()
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+	mockDecryptor.EXPECT().
+		Decrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil).
+		AnyTimes()
+	var applied []string
+	mockWgClient.EXPECT().
+		UpdatePeerEndpoint(gomock.Any()).
+		DoAndReturn(func(u wg.PeerEndpointUpdate) error {
+			applied = append(applied, net.JoinHostPort(u.Host, strconv.Itoa(u.Port)))
+			return nil
+		}).
+		AnyTimes()
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockDecryptor,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	controller.Execute(ctx, peer.Id())
+	controller.TriggerForFailedPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+	// Republished with the same endpoints: the peer stays relayed, and a
+	// watch reporting the record applies the relay again.
+	_ = store.Set(ctx, peer.RemoteId(), "republished_data")
+	controller.Execute(ctx, peer.Id())
+	controller.TriggerForPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+	controller.TriggerForFailedPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+
+	want := []string{"1.2.3.4:51820", "198.51.100.1:40000", "198.51.100.1:40000", "1.2.3.4:51820"}
+	if !slices.Equal(applied, want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+}
+
+// A watch may report an unchanged record again; that must not move a peer
+// on a healthy direct endpoint onto the relay.
+func TestEstablishController_Execute_RenotifiedRecordKeepsDirect(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+	store := newTestStore()
+	_ = store.Set(ctx, peer.RemoteId(), "encrypted_data")
+
+	jsonData, _ := json.Marshal(ctrl.EndpointData{IPv4: "1.2.3.4:51820", Relay: "198.51.100.1:40000"})
+
+	mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil).AnyTimes()
+	mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil).AnyTimes()
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+	mockDecryptor.EXPECT().
+		Decrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil).
+		AnyTimes()
+	var applied []string
+	mockWgClient.EXPECT().
+		UpdatePeerEndpoint(gomock.Any()).
+		DoAndReturn(func(u wg.PeerEndpointUpdate) error {
+			applied = append(applied, net.JoinHostPort(u.Host, strconv.Itoa(u.Port)))
+			return nil
+		}).
+		AnyTimes()
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockDecryptor,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	controller.Execute(ctx, peer.Id())
+	controller.TriggerForPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+	controller.TriggerForPeer(peer.Id())
+	controller.Execute(ctx, peer.Id())
+
+	for i, endpoint := range applied {
+		if endpoint != "1.2.3.4:51820" {
+			t.Errorf("applied[%d] = %s, want the direct endpoint", i, endpoint)
+		}
+	}
+	if len(applied) == 0 {
+		t.Error("no endpoint applied")
+	}
+}
+
+// A record with only a relay uses it.
+func TestEstablishController_Execute_RelayOnly(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+	store := newTestStore()
+	_ = store.Set(ctx, peer.RemoteId(), "encrypted_data")
+
+	jsonData, _ := json.Marshal(ctrl.EndpointData{IPv6: "[2001:db8::1]:51820", Relay: "198.51.100.1:40000"})
+
+	mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil)
+	mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil)
+	mockDecryptor.EXPECT().
+		Decrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil)
+	mockWgClient.EXPECT().
+		UpdatePeerEndpoint(gomock.Any()).
+		DoAndReturn(func(u wg.PeerEndpointUpdate) error {
+			if u.Host != "198.51.100.1" || u.Port != 40000 {
+				t.Errorf("applied %s:%d, want the relay", u.Host, u.Port)
+			}
+			return nil
+		})
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockDecryptor,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+	controller.Execute(ctx, peer.Id())
+}
+
+// fakeObfuscation is a device's obfuscation setting, recording what was set
+// for each peer.
+type fakeObfuscation struct {
+	enabled bool
+	peers   map[entity.PeerPublicKey]bool
+}
+
+func (f *fakeObfuscation) Enabled(deviceName string) bool { return f.enabled }
+func (f *fakeObfuscation) SetPeer(deviceName string, peer entity.PeerPublicKey, enabled bool) error {
+	if f.peers == nil {
+		f.peers = make(map[entity.PeerPublicKey]bool)
+	}
+	f.peers[peer] = enabled
+	return nil
+}
+
+// A peer's traffic is masked only when its record and the device both
+// obfuscate.
+func TestEstablishController_Execute_Obfuscation(t *testing.T) {
+	tests := []struct {
+		name       string
+		device     bool
+		advertised bool
+		want       bool
+	}{
+		{"both", true, true, true},
+		{"device only", true, false, false},
+		{"peer only", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+			pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+			logger := zerolog.Nop()
+
+			ctx := context.Background()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			peer := createTestPeer("wg0", "test_plugin", "ipv4")
+			store := newTestStore()
+			_ = store.Set(ctx, peer.RemoteId(), "encrypted_data")
+
+			jsonData, _ := json.Marshal(ctrl.EndpointData{IPv4: "1.2.3.4:51820", Obfuscation: tt.advertised})
+
+			mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil)
+			mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil)
+			pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil)
+			mockDecryptor.EXPECT().
+				Decrypt(ctx, gomock.Any()).
+				Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil)
+			mockWgClient.EXPECT().UpdatePeerEndpoint(gomock.Any()).Return(nil)
+
+			obfuscation := &fakeObfuscation{enabled: tt.device}
+			controller := ctrl.NewEstablishController(
+				mockWgClient,
+				mockDevices,
+				mockPeers,
+				pluginProvider,
+				mockDecryptor,
+				nil, // deviceConfig
+				obfuscation,
+				&logger,
+			)
+			controller.Execute(ctx, peer.Id())
+
+			got, set := obfuscation.peers[peer.PublicKey()]
+			if !set || got != tt.want {
+				t.Errorf("SetPeer = %v (called: %v), want %v", got, set, tt.want)
+			}
+		})
+	}
+}
+
+// failingStore fails every Get with err.
+type failingStore struct {
+	err      error
+	getCalls int
+}
+
+func (s *failingStore) Get(ctx context.Context, key string) (string, error) {
+	s.getCalls++
+	return "", s.err
+}
+
+func (s *failingStore) Set(ctx context.Context, key string, value string) error {
+	return nil
+}
+
+func TestEstablishController_Execute_StoreErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantGetCalls int
+	}{
+		{name: "not found", err: fmt.Errorf("%w: key", pluginapi.ErrNotFound), wantGetCalls: 2},
+		{name: "unauthorized", err: fmt.Errorf("API error: 403 Forbidden: %w", pluginapi.ErrUnauthorized), wantGetCalls: 2},
+		// A rate limited plugin is left alone for the next Execute.
+		{name: "rate limited", err: fmt.Errorf("API error: 429 Too Many Requests: %w", pluginapi.ErrRateLimited), wantGetCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+			pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+			logger := zerolog.Nop()
+
+			ctx := context.Background()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			peer := createTestPeer("wg0", "test_plugin", "ipv4")
+			store := &failingStore{err: tt.err}
+
+			mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil).Times(2)
+			mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil).Times(2)
+			pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).Times(2)
+			// Nothing is decrypted or applied.
+
+			controller := ctrl.NewEstablishController(
+				mockWgClient,
+				mockDevices,
+				mockPeers,
+				pluginProvider,
+				mockDecryptor,
+				nil, // deviceConfig
+				nil, // obfuscation
+				&logger,
+			)
+
+			controller.Execute(ctx, peer.Id())
+			controller.Execute(ctx, peer.Id())
+
+			if store.getCalls != tt.wantGetCalls {
+				t.Errorf("store.Get call count = %d, want %d", store.getCalls, tt.wantGetCalls)
+			}
+		})
+	}
+}
+
 // Test Trigger - list peers and enqueue
 func TestEstablishController_Trigger(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -854,6 +1264,7 @@ func TestEstablishController_Trigger(t *testing.T) {
 		pluginManager,
 		nil,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
@@ -861,6 +1272,67 @@ func TestEstablishController_Trigger(t *testing.T) {
 	controller.Trigger(ctx)
 }
 
+// Test Trigger - records in a BatchStore are read at once
+func TestEstablishController_Trigger_BatchStore(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockDecryptor := mock.NewMockEndpointDecryptor(mockCtrl)
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+	store := &fakeManyStore{records: make(map[string]string)}
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	var peers []*entity.Peer
+	for _, b := range []byte{1, 2, 3} {
+		peerPublicKey := [32]byte{b}
+		peerId := entity.NewPeerId(make([]byte, 32), peerPublicKey[:])
+		peer := entity.NewPeer(peerId, "wg0", peerPublicKey, "test_plugin", "ipv4", entity.PeerPingConfig{})
+		peers = append(peers, peer)
+		mockPeers.EXPECT().Find(ctx, peer.Id()).Return(peer, nil)
+	}
+	// The last peer has not published yet.
+	for _, peer := range peers[:2] {
+		store.records[peer.RemoteId()] = "encrypted_data"
+	}
+
+	jsonData, _ := json.Marshal(ctrl.EndpointData{IPv4: "1.2.3.4:51820"})
+
+	mockPeers.EXPECT().List(ctx).Return(peers, nil)
+	mockDevices.EXPECT().Find(ctx, entity.DeviceId("wg0")).Return(device, nil).Times(len(peers) + 1)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).Times(len(peers) + 1)
+	mockDecryptor.EXPECT().
+		Decrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointDecryptResponse{Content: string(jsonData)}, nil).
+		Times(2)
+	mockWgClient.EXPECT().UpdatePeerEndpoint(gomock.Any()).Return(nil).Times(2)
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockDecryptor,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	controller.Trigger(ctx)
+	for _, peer := range peers {
+		controller.Execute(ctx, peer.Id())
+	}
+
+	if store.getManyCalls != 1 || store.getCalls != 0 {
+		t.Errorf("GetMany called %d times and Get %d, want 1 and 0", store.getManyCalls, store.getCalls)
+	}
+}
+
 // Test Trigger - list peers error
 func TestEstablishController_Trigger_ListError(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -883,9 +1355,174 @@ func TestEstablishController_Trigger_ListError(t *testing.T) {
 		pluginManager,
 		nil,
 		nil, // deviceConfig
+		nil, // obfuscation
 		&logger,
 	)
 
 	// Should not panic
 	controller.Trigger(ctx)
 }
+
+// watchStore is a pluginapi.Watcher that reports its keys changed once and
+// then holds the subscription, recording the keys it is asked to Get and,
+// when watched is set, the keys of each subscription and its end.
+type watchStore struct {
+	gets    chan string
+	watched chan []string
+	ended   chan struct{}
+}
+
+func (s *watchStore) Get(ctx context.Context, key string) (string, error) {
+	select {
+	case s.gets <- key:
+	default:
+	}
+	return "", errors.New("key not found")
+}
+
+func (s *watchStore) Set(ctx context.Context, key string, value string) error {
+	return nil
+}
+
+func (s *watchStore) Watch(ctx context.Context, keys []string, changed func(key string)) error {
+	if s.watched != nil {
+		s.watched <- keys
+		defer func() { s.ended <- struct{}{} }()
+	}
+	for _, key := range keys {
+		changed(key)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Test Run - a watched record change triggers an establish for its peer
+func TestEstablishController_Run_WatchTriggersPeer(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	logger := zerolog.Nop()
+
+	store := &watchStore{gets: make(chan string, 1)}
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+
+	mockPeers.EXPECT().List(gomock.Any()).Return([]*entity.Peer{peer}, nil)
+	mockPeers.EXPECT().Find(gomock.Any(), peer.Id()).Return(peer, nil).AnyTimes()
+	mockDevices.EXPECT().Find(gomock.Any(), entity.DeviceId("wg0")).Return(device, nil).AnyTimes()
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		nil,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		controller.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case key := <-store.gets:
+		if key != peer.RemoteId() {
+			t.Errorf("Get(%q), want the peer's remote key %q", key, peer.RemoteId())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watched change did not trigger an establish")
+	}
+}
+
+// Test Trigger - a peer registered after Run started is watched, and one
+// dropped is no longer
+func TestEstablishController_Trigger_SyncsWatches(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	logger := zerolog.Nop()
+
+	store := &watchStore{gets: make(chan string, 1), watched: make(chan []string, 1), ended: make(chan struct{}, 1)}
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+
+	started := make(chan struct{})
+	mockPeers.EXPECT().List(gomock.Any()).DoAndReturn(func(context.Context) ([]*entity.Peer, error) {
+		close(started)
+		return nil, nil
+	})
+	mockPeers.EXPECT().Find(gomock.Any(), peer.Id()).Return(peer, nil).AnyTimes()
+	mockDevices.EXPECT().Find(gomock.Any(), entity.DeviceId("wg0")).Return(device, nil).AnyTimes()
+
+	controller := ctrl.NewEstablishController(
+		mockWgClient,
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		nil,
+		nil, // deviceConfig
+		nil, // obfuscation
+		&logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		controller.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	<-started
+
+	mockPeers.EXPECT().List(gomock.Any()).Return([]*entity.Peer{peer}, nil)
+	controller.Trigger(ctx)
+	select {
+	case keys := <-store.watched:
+		if len(keys) != 1 || keys[0] != peer.RemoteId() {
+			t.Errorf("Watch(%v), want the peer's remote key %q", keys, peer.RemoteId())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer registered after Run was not watched")
+	}
+
+	// The same peers again leave the subscription alone.
+	mockPeers.EXPECT().List(gomock.Any()).Return([]*entity.Peer{peer}, nil)
+	controller.Trigger(ctx)
+	select {
+	case <-store.ended:
+		t.Fatal("unchanged peers ended the watch")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mockPeers.EXPECT().List(gomock.Any()).Return(nil, nil)
+	controller.Trigger(ctx)
+	select {
+	case <-store.ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch of a dropped peer did not end")
+	}
+}
@@ -0,0 +1,140 @@
+package ctrl
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/entity"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// GarbageCollector finds the records left in plugin stores for peers that no
+// longer exist, e.g. after a node was dropped from the mesh while stunmesh
+// was not running to unpublish it.
+//
+// A record's key is the hash of a pair of public keys, so it cannot be traced
+// back to a node; instead every record whose key no pair of the keys this
+// config knows (its interfaces' own and their peers') hashes to is an
+// orphan. A store shared with nodes outside this config holds their records
+// too, and they look the same.
+type GarbageCollector struct {
+	wg      WireGuardClient
+	config  *config.Config
+	plugins PluginProvider
+	logger  zerolog.Logger
+}
+
+func NewGarbageCollector(wg WireGuardClient, config *config.Config, plugins PluginProvider, logger *zerolog.Logger) *GarbageCollector {
+	return &GarbageCollector{
+		wg:      wg,
+		config:  config,
+		plugins: plugins,
+		logger:  logger.With().Str("controller", "gc").Logger(),
+	}
+}
+
+// Orphans returns the orphaned keys of each plugin instance whose store can
+// list its keys, sorted. Instances that cannot are skipped with a warning.
+func (gc *GarbageCollector) Orphans(ctx context.Context) (map[string][]string, error) {
+	live, err := gc.liveKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(gc.config.Plugins))
+	for name := range gc.config.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	orphans := make(map[string][]string)
+	for _, name := range names {
+		store, err := gc.plugins.GetPlugin(name)
+		if err != nil {
+			return nil, err
+		}
+		lister, ok := pluginapi.As[pluginapi.Lister](store)
+		if !ok {
+			gc.logger.Warn().Str("plugin", name).Msg("plugin cannot list its keys, skipping it")
+			continue
+		}
+
+		keys, err := lister.List(gc.logger.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys of plugin %s: %w", name, err)
+		}
+		for _, key := range keys {
+			if !live[key] {
+				orphans[name] = append(orphans[name], key)
+			}
+		}
+		sort.Strings(orphans[name])
+	}
+	return orphans, nil
+}
+
+// Delete removes the given orphans, as returned by Orphans, carrying on past
+// failures and returning the first.
+func (gc *GarbageCollector) Delete(ctx context.Context, orphans map[string][]string) error {
+	var firstErr error
+	for name, keys := range orphans {
+		store, err := gc.plugins.GetPlugin(name)
+		if err != nil {
+			return err
+		}
+		deleter, ok := pluginapi.As[pluginapi.Deleter](store)
+		if !ok {
+			gc.logger.Warn().Str("plugin", name).Msg("plugin cannot delete records, skipping it")
+			continue
+		}
+
+		for _, key := range keys {
+			if err := deleter.Delete(gc.logger.WithContext(ctx), key); err != nil {
+				gc.logger.Error().Err(err).Str("plugin", name).Str("key", key).Msg("failed to delete orphaned record")
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// liveKeys hashes every ordered pair of the known public keys: the pairs
+// this node publishes and reads, and those its peers publish for each other.
+// A device that cannot be read fails the whole run, since without its key
+// every record it owns would look orphaned.
+func (gc *GarbageCollector) liveKeys() (map[string]bool, error) {
+	known := make(map[entity.PeerKey]struct{})
+	for deviceName, iface := range gc.config.Interfaces {
+		device, err := gc.wg.Device(deviceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read device %s: %w", deviceName, err)
+		}
+		known[entity.PeerKey(device.PublicKey)] = struct{}{}
+
+		for peerName, peer := range iface.Peers {
+			publicKey, err := base64.StdEncoding.DecodeString(peer.PublicKey)
+			if err != nil || len(publicKey) != entity.PeerKeyLength {
+				return nil, fmt.Errorf("peer %s of device %s has an invalid public_key", peerName, deviceName)
+			}
+			known[entity.PeerKey(publicKey)] = struct{}{}
+		}
+	}
+
+	live := make(map[string]bool, len(known)*len(known))
+	for a := range known {
+		for b := range known {
+			if a == b {
+				continue
+			}
+			id := entity.NewPeerId(a[:], b[:])
+			live[id.EndpointKey()] = true
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,115 @@
+package ctrl_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/ctrl"
+	mock "github.com/tjjh89017/stunmesh-go/internal/ctrl/mock"
+	"github.com/tjjh89017/stunmesh-go/internal/entity"
+	"github.com/tjjh89017/stunmesh-go/internal/wg"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"go.uber.org/mock/gomock"
+)
+
+// fakeListStore is a fakeDedupStore that lists and deletes a fixed set of keys.
+type fakeListStore struct {
+	fakeDedupStore
+	keys    []string
+	deleted []string
+}
+
+func (f *fakeListStore) List(ctx context.Context) ([]string, error) {
+	return f.keys, nil
+}
+
+func (f *fakeListStore) Delete(ctx context.Context, key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+func endpointKey(a, b wg.Key) string {
+	id := entity.NewPeerId(a[:], b[:])
+	return id.EndpointKey()
+}
+
+func TestGarbageCollector(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	self, peerA, peerB, gone := wg.Key{1}, wg.Key{2}, wg.Key{3}, wg.Key{4}
+	cfg := &config.Config{
+		Interfaces: map[string]config.Interface{
+			"wg0": {
+				Peers: map[string]config.Peer{
+					"a": {PublicKey: base64.StdEncoding.EncodeToString(peerA[:]), Plugin: "listing"},
+					"b": {PublicKey: base64.StdEncoding.EncodeToString(peerB[:]), Plugin: "listing"},
+				},
+			},
+		},
+		Plugins: map[string]pluginapi.PluginDefinition{
+			"listing": {Type: "fake"},
+			"plain":   {Type: "fake"},
+		},
+	}
+
+	orphan := endpointKey(self, gone)
+	store := &fakeListStore{keys: []string{
+		endpointKey(self, peerA),
+		endpointKey(peerA, self),
+		// Records the peers publish for each other are live too.
+		endpointKey(peerA, peerB),
+		orphan,
+	}}
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockWgClient.EXPECT().Device("wg0").Return(&wg.DeviceInfo{Name: "wg0", PublicKey: self}, nil)
+	plugins := mock.NewMockPluginProvider(mockCtrl)
+	plugins.EXPECT().GetPlugin("listing").Return(store, nil).AnyTimes()
+	plugins.EXPECT().GetPlugin("plain").Return(&fakeDedupStore{}, nil).AnyTimes()
+	logger := zerolog.Nop()
+
+	gc := ctrl.NewGarbageCollector(mockWgClient, cfg, plugins, &logger)
+
+	orphans, err := gc.Orphans(context.Background())
+	if err != nil {
+		t.Fatalf("Orphans: %v", err)
+	}
+	want := map[string][]string{"listing": {orphan}}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Fatalf("Orphans = %v, want %v", orphans, want)
+	}
+
+	if err := gc.Delete(context.Background(), orphans); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !reflect.DeepEqual(store.deleted, []string{orphan}) {
+		t.Errorf("deleted %v, want %v", store.deleted, []string{orphan})
+	}
+}
+
+func TestGarbageCollector_DeviceError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	cfg := &config.Config{
+		Interfaces: map[string]config.Interface{"wg0": {}},
+		Plugins:    map[string]pluginapi.PluginDefinition{"listing": {Type: "fake"}},
+	}
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+	mockWgClient.EXPECT().Device("wg0").Return(nil, errors.New("device not found"))
+	plugins := mock.NewMockPluginProvider(mockCtrl)
+	logger := zerolog.Nop()
+
+	// Without the device's key every record it owns would look orphaned.
+	gc := ctrl.NewGarbageCollector(mockWgClient, cfg, plugins, &logger)
+	if _, err := gc.Orphans(context.Background()); err == nil {
+		t.Fatal("Orphans succeeded without reading the device")
+	}
+}
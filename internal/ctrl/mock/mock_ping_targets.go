@@ -76,14 +76,14 @@ func (m *MockEstablisher) EXPECT() *MockEstablisherMockRecorder {
 	return m.recorder
 }
 
-// TriggerForPeer mocks base method.
-func (m *MockEstablisher) TriggerForPeer(peerId entity.PeerId) {
+// TriggerForFailedPeer mocks base method.
+func (m *MockEstablisher) TriggerForFailedPeer(peerId entity.PeerId) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "TriggerForPeer", peerId)
+	m.ctrl.Call(m, "TriggerForFailedPeer", peerId)
 }
 
-// TriggerForPeer indicates an expected call of TriggerForPeer.
-func (mr *MockEstablisherMockRecorder) TriggerForPeer(peerId any) *gomock.Call {
+// TriggerForFailedPeer indicates an expected call of TriggerForFailedPeer.
+func (mr *MockEstablisherMockRecorder) TriggerForFailedPeer(peerId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TriggerForPeer", reflect.TypeOf((*MockEstablisher)(nil).TriggerForPeer), peerId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TriggerForFailedPeer", reflect.TypeOf((*MockEstablisher)(nil).TriggerForFailedPeer), peerId)
 }
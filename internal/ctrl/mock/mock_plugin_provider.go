@@ -11,6 +11,7 @@ package mock_ctrl
 
 import (
 	reflect "reflect"
+	time "time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 	gomock "go.uber.org/mock/gomock"
@@ -68,3 +69,17 @@ func (mr *MockPluginProviderMockRecorder) IsDedup(name any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsDedup", reflect.TypeOf((*MockPluginProvider)(nil).IsDedup), name)
 }
+
+// RecordTTL mocks base method.
+func (m *MockPluginProvider) RecordTTL(name string) time.Duration {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordTTL", name)
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// RecordTTL indicates an expected call of RecordTTL.
+func (mr *MockPluginProviderMockRecorder) RecordTTL(name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordTTL", reflect.TypeOf((*MockPluginProvider)(nil).RecordTTL), name)
+}
@@ -107,6 +107,18 @@ func (m *MockPeerRepository) EXPECT() *MockPeerRepositoryMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method.
+func (m *MockPeerRepository) Delete(ctx context.Context, id entity.PeerId) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Delete", ctx, id)
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockPeerRepositoryMockRecorder) Delete(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockPeerRepository)(nil).Delete), ctx, id)
+}
+
 // Find mocks base method.
 func (m *MockPeerRepository) Find(ctx context.Context, id entity.PeerId) (*entity.Peer, error) {
 	m.ctrl.T.Helper()
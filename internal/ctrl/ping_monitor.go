@@ -532,7 +532,7 @@ func (m *DevicePingMonitor) handlePingResult(state *PeerPingState, success bool)
 		if m.shouldRetryPublishEstablish(state, now) {
 			// Always run publish to update our endpoint, then establish the specific peer
 			m.controller.publishCtrl.TriggerForPeer(state.peerId)
-			m.controller.establishCtrl.TriggerForPeer(state.peerId)
+			m.controller.establishCtrl.TriggerForFailedPeer(state.peerId)
 
 			if state.retryCount < PeerSpecificRetryThreshold {
 				logger.Info().Msg("triggered publish and establish for specific peer (early retry)")
@@ -15,7 +15,7 @@ import (
 // unexported PeerPingState fields directly instead of guessing at
 // randomly generated values such as the ICMP ID.
 
-// fakePublisher/fakeEstablisher record TriggerForPeer calls without
+// fakePublisher/fakeEstablisher record trigger calls without
 // requiring gomock, avoiding an internal_test -> mock -> ctrl import cycle.
 type fakePublisher struct {
 	triggered []entity.PeerId
@@ -29,7 +29,7 @@ type fakeEstablisher struct {
 	triggered []entity.PeerId
 }
 
-func (f *fakeEstablisher) TriggerForPeer(peerId entity.PeerId) {
+func (f *fakeEstablisher) TriggerForFailedPeer(peerId entity.PeerId) {
 	f.triggered = append(f.triggered, peerId)
 }
 
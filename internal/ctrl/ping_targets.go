@@ -11,5 +11,5 @@ type Publisher interface {
 
 // Establisher is the narrow slice of *EstablishController that PingMonitorController needs.
 type Establisher interface {
-	TriggerForPeer(peerId entity.PeerId)
+	TriggerForFailedPeer(peerId entity.PeerId)
 }
@@ -2,12 +2,17 @@
 
 package ctrl
 
-import pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+import (
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
 
 // PluginProvider is the slice of *plugin.Manager that PublishController and
 // EstablishController need: looking up a named plugin instance's Store, and
-// checking whether that instance has dedup enabled.
+// reading that instance's dedup and record_ttl settings.
 type PluginProvider interface {
 	GetPlugin(name string) (pluginapi.Store, error)
 	IsDedup(name string) bool
+	RecordTTL(name string) time.Duration
 }
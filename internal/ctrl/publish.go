@@ -6,13 +6,17 @@ import (
 	"context"
 	"encoding/json"
 	"net"
+	"net/netip"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/entity"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
 	"github.com/tjjh89017/stunmesh-go/internal/queue"
 	"github.com/tjjh89017/stunmesh-go/internal/routeprobe"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
 
 type DeviceConfigProvider interface {
@@ -23,6 +27,34 @@ type DeviceConfigProvider interface {
 	TunnelInterfaceNames() []string
 }
 
+// PortMapper asks the gateway to forward a device's UDP port and returns the
+// mapped external address; zero, with no error, when the device does not
+// use port mapping.
+type PortMapper interface {
+	Map(ctx context.Context, deviceName string, port uint16) (netip.AddrPort, error)
+}
+
+// RelayAllocator allocates a device's relay node endpoint for one of its
+// peers; zero, with no error, when the device has no relay node.
+type RelayAllocator interface {
+	Allocate(ctx context.Context, deviceName string, privateKey entity.PrivateKey, peer entity.PeerPublicKey) (netip.AddrPort, error)
+}
+
+// PeerPorts reports the local port a device's traffic to a peer leaves
+// from, when the device sends from several (proxy mode's extra outer
+// ports); 0 means the device's default one.
+type PeerPorts interface {
+	PeerPort(deviceName string, peer entity.PeerPublicKey) uint16
+}
+
+// Obfuscation reports whether a device obfuscates its traffic (proxy
+// mode's packet masking), which its records advertise, and turns masking
+// on for a peer whose record advertises it too.
+type Obfuscation interface {
+	Enabled(deviceName string) bool
+	SetPeer(deviceName string, peer entity.PeerPublicKey, enabled bool) error
+}
+
 type PublishController struct {
 	devices       DeviceRepository
 	peers         PeerRepository
@@ -30,18 +62,25 @@ type PublishController struct {
 	resolver      StunResolver
 	encryptor     EndpointEncryptor
 	deviceConfig  DeviceConfigProvider
+	portMapper    PortMapper
+	relays        RelayAllocator
+	peerPorts     PeerPorts
+	obfuscation   Obfuscation
 	logger        zerolog.Logger
 	triggerQueue  *queue.Queue[struct{}]      // Trigger queue for full publish
 	peerQueue     *queue.Queue[entity.PeerId] // Trigger queue for specific peer
+	removeQueue   *queue.Queue[*entity.Peer]  // Queue of removed peers whose record to delete
 
 	// lastPublished remembers the plaintext endpoint JSON last successfully
 	// published for each peer, keyed by peer.LocalId(). It is only read and
-	// written from Execute/ExecuteForPeer, both of which are driven
+	// written from Execute/ExecuteForPeer/unpublish, all of which are driven
 	// sequentially from the single Run() goroutine, so no mutex is needed.
 	lastPublished map[string]string
+
+	backoff pluginBackoff
 }
 
-func NewPublishController(devices DeviceRepository, peers PeerRepository, pluginManager PluginProvider, resolver StunResolver, encryptor EndpointEncryptor, deviceConfig DeviceConfigProvider, logger *zerolog.Logger) *PublishController {
+func NewPublishController(devices DeviceRepository, peers PeerRepository, pluginManager PluginProvider, resolver StunResolver, encryptor EndpointEncryptor, deviceConfig DeviceConfigProvider, portMapper PortMapper, relays RelayAllocator, peerPorts PeerPorts, obfuscation Obfuscation, logger *zerolog.Logger) *PublishController {
 	return &PublishController{
 		devices:       devices,
 		peers:         peers,
@@ -49,19 +88,29 @@ func NewPublishController(devices DeviceRepository, peers PeerRepository, plugin
 		resolver:      resolver,
 		encryptor:     encryptor,
 		deviceConfig:  deviceConfig,
+		portMapper:    portMapper,
+		relays:        relays,
+		peerPorts:     peerPorts,
+		obfuscation:   obfuscation,
 		logger:        logger.With().Str("controller", "publish").Logger(),
 		triggerQueue:  queue.NewBuffered[struct{}](queue.TriggerQueueSize),   // Buffered trigger queue
 		peerQueue:     queue.NewBuffered[entity.PeerId](queue.PeerQueueSize), // Buffered peer queue
+		removeQueue:   queue.NewBuffered[*entity.Peer](queue.PeerQueueSize),  // Buffered removal queue
 		lastPublished: make(map[string]string),
 	}
 }
 
-// discoverEndpoints performs STUN discovery based on device protocol.
-// Returns IPv4 and IPv6 endpoints, or error if discovery failed. The
-// resolution and dualstack partial-failure policy live in the shared
-// DiscoverEndpoints (internal/ctrl/discover.go); this wraps it with the
-// raw-socket StunResolver and this controller's logging.
-func (c *PublishController) discoverEndpoints(ctx context.Context, device *entity.Device, logger zerolog.Logger) (ipv4Endpoint, ipv6Endpoint string, err error) {
+// discoverEndpoints performs STUN discovery based on device protocol from
+// the local port, 0 meaning the device's listen port, and adds the port's
+// mapping when it has one worth publishing (see mappedEndpoint). Returns an
+// error if discovery failed. The resolution and dualstack partial-failure
+// policy live in the shared DiscoverEndpoints (internal/ctrl/discover.go);
+// this wraps it with the raw-socket StunResolver and this controller's
+// logging.
+func (c *PublishController) discoverEndpoints(ctx context.Context, device *entity.Device, port uint16, logger zerolog.Logger) (EndpointData, error) {
+	if port == 0 {
+		port = uint16(device.ListenPort())
+	}
 	// The escape rides into discovery for the STUN server's name lookup: the
 	// probe socket escapes by itself (fwmark/pcap), but the lookup is a socket
 	// too, and the only one in the discovery path nothing else covers.
@@ -69,11 +118,11 @@ func (c *PublishController) discoverEndpoints(ctx context.Context, device *entit
 
 	resolveFamily := func(family string) FamilyResolver {
 		return func(ctx context.Context) (string, error) {
-			host, port, err := c.resolver.Resolve(ctx, string(device.Name()), uint16(device.ListenPort()), family, device.FirewallMark())
+			host, mappedPort, err := c.resolver.Resolve(ctx, string(device.Name()), port, family, device.FirewallMark())
 			if err != nil {
 				return "", err
 			}
-			return net.JoinHostPort(host, strconv.Itoa(port)), nil
+			return net.JoinHostPort(host, strconv.Itoa(mappedPort)), nil
 		}
 	}
 
@@ -81,10 +130,10 @@ func (c *PublishController) discoverEndpoints(ctx context.Context, device *entit
 		logger.Warn().Err(ferr).Msg("failed to resolve " + family + " address in dualstack mode")
 	}
 
-	ipv4Endpoint, ipv6Endpoint, err = DiscoverEndpoints(ctx, device.Protocol(), warn, resolveFamily("ipv4"), resolveFamily("ipv6"))
+	ipv4Endpoint, ipv6Endpoint, err := DiscoverEndpoints(ctx, device.Protocol(), warn, resolveFamily("ipv4"), resolveFamily("ipv6"))
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to discover endpoints")
-		return "", "", err
+		return EndpointData{}, err
 	}
 
 	if ipv4Endpoint != "" {
@@ -94,7 +143,200 @@ func (c *PublishController) discoverEndpoints(ctx context.Context, device *entit
 		logger.Info().Str("ipv6", ipv6Endpoint).Msg("discovered IPv6 endpoint")
 	}
 
-	return ipv4Endpoint, ipv6Endpoint, nil
+	return EndpointData{
+		IPv4:        ipv4Endpoint,
+		IPv6:        ipv6Endpoint,
+		MappedIPv4:  c.mappedEndpoint(ctx, device, port, ipv4Endpoint, logger),
+		Obfuscation: c.obfuscation != nil && c.obfuscation.Enabled(string(device.Name())),
+	}, nil
+}
+
+// mappedEndpoint returns the device's port mapping as "ip:port" when it adds
+// to the STUN result: on the same public address (a mapping made by the
+// inner NAT of a double NAT is unreachable from outside) but a different
+// port. "" otherwise; a failed mapping only costs the record its companion
+// endpoint, never the STUN one.
+func (c *PublishController) mappedEndpoint(ctx context.Context, device *entity.Device, port uint16, ipv4Endpoint string, logger zerolog.Logger) string {
+	if c.portMapper == nil || device.Protocol() == "ipv6" {
+		return ""
+	}
+	external, err := c.portMapper.Map(ctx, string(device.Name()), port)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to map port")
+		return ""
+	}
+	if !external.IsValid() {
+		return ""
+	}
+
+	if ipv4Endpoint == "" {
+		if !isPublicIPv4(external.Addr()) {
+			logger.Warn().Str("mapped", external.String()).Msg("port mapping is not on a public address, not publishing it")
+			return ""
+		}
+	} else if stun, err := netip.ParseAddrPort(ipv4Endpoint); err != nil || stun.Addr() != external.Addr() {
+		logger.Warn().Str("mapped", external.String()).Str("ipv4", ipv4Endpoint).Msg("port mapping is not on the STUN address, not publishing it")
+		return ""
+	} else if stun == external {
+		return ""
+	}
+
+	logger.Info().Str("mapped_ipv4", external.String()).Msg("publishing mapped endpoint")
+	return external.String()
+}
+
+// peerPort returns the local port the device's traffic to peer leaves
+// from; 0 means the device's default one.
+func (c *PublishController) peerPort(device *entity.Device, peer *entity.Peer) uint16 {
+	if c.peerPorts == nil {
+		return 0
+	}
+	return c.peerPorts.PeerPort(string(device.Name()), peer.PublicKey())
+}
+
+// discoveredPort is one local port's discovery result within a round.
+type discoveredPort struct {
+	endpointData EndpointData
+	err          error
+}
+
+// peerEndpoints returns the endpoints discovered from the port peer's
+// traffic leaves from, discovering each port once per round in byPort.
+func (c *PublishController) peerEndpoints(ctx context.Context, device *entity.Device, peer *entity.Peer, byPort map[uint16]discoveredPort, logger zerolog.Logger) (EndpointData, error) {
+	port := c.peerPort(device, peer)
+	if found, ok := byPort[port]; ok {
+		return found.endpointData, found.err
+	}
+	endpointData, err := c.discoverEndpoints(ctx, device, port, logger)
+	byPort[port] = discoveredPort{endpointData: endpointData, err: err}
+	if err == nil {
+		logger.Info().
+			Int("port", int(port)).
+			Str("ipv4", endpointData.IPv4).
+			Str("ipv6", endpointData.IPv6).
+			Str("mapped_ipv4", endpointData.MappedIPv4).
+			Msg("discovered endpoints for port")
+	}
+	return endpointData, err
+}
+
+// withRelay returns endpointData with the device's relay allocation for
+// peer added, when it has one. A failed allocation only costs the record
+// its fallback.
+func (c *PublishController) withRelay(ctx context.Context, device *entity.Device, peer *entity.Peer, endpointData EndpointData, logger zerolog.Logger) EndpointData {
+	if c.relays == nil {
+		return endpointData
+	}
+	relayed, err := c.relays.Allocate(ctx, string(device.Name()), device.PrivateKey(), peer.PublicKey())
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to allocate relay endpoint")
+		return endpointData
+	}
+	if relayed.IsValid() {
+		endpointData.Relay = relayed.String()
+	}
+	return endpointData
+}
+
+// cgnat is RFC 6598 shared address space, as unreachable from outside as
+// RFC 1918's.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublicIPv4(addr netip.Addr) bool {
+	return addr.Is4() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnat.Contains(addr)
+}
+
+// publishBatch collects one device round's records for stores that
+// implement pluginapi.Batcher or pluginapi.BatchStore, so each such plugin
+// instance is written once per round rather than once per peer. Records
+// only count as published, for dedup, once their batch has been flushed.
+// Neither a Batch nor SetMany carries a TTL, so a store with a record_ttl
+// to apply is written record by record instead.
+type publishBatch struct {
+	batches map[string]pluginapi.Batch
+	// pending maps plugin instance name to peer.LocalId() to the plaintext
+	// endpoint JSON set in that instance's batch.
+	pending map[string]map[string]string
+}
+
+func newPublishBatch() *publishBatch {
+	return &publishBatch{
+		batches: make(map[string]pluginapi.Batch),
+		pending: make(map[string]map[string]string),
+	}
+}
+
+// batcherFor returns how a round's records can be written to store
+// together: through its own batches, or one SetMany of a pluginapi.BatchStore.
+func batcherFor(store pluginapi.Store) (pluginapi.Batcher, bool) {
+	if batcher, ok := pluginapi.As[pluginapi.Batcher](store); ok {
+		return batcher, true
+	}
+	if many, ok := pluginapi.As[pluginapi.BatchStore](store); ok {
+		return setManyBatcher{store: many}, true
+	}
+	return nil, false
+}
+
+// setManyBatcher batches Sets into a single SetMany.
+type setManyBatcher struct {
+	store pluginapi.BatchStore
+}
+
+func (b setManyBatcher) NewBatch() pluginapi.Batch {
+	return &setManyBatch{store: b.store, records: make(map[string]string)}
+}
+
+type setManyBatch struct {
+	store   pluginapi.BatchStore
+	records map[string]string
+}
+
+func (b *setManyBatch) Set(ctx context.Context, key string, value string) error {
+	b.records[key] = value
+	return nil
+}
+
+func (b *setManyBatch) Flush(ctx context.Context) error {
+	return b.store.SetMany(ctx, b.records)
+}
+
+func (b *publishBatch) set(ctx context.Context, plugin string, batcher pluginapi.Batcher, key, value, plain string) error {
+	batch, ok := b.batches[plugin]
+	if !ok {
+		batch = batcher.NewBatch()
+		b.batches[plugin] = batch
+		b.pending[plugin] = make(map[string]string)
+	}
+	if err := batch.Set(ctx, key, value); err != nil {
+		return err
+	}
+	b.pending[plugin][key] = plain
+	return nil
+}
+
+// flush writes out every batch of the round, recording the records of each
+// one that succeeds in lastPublished.
+func (c *PublishController) flush(ctx context.Context, b *publishBatch, logger zerolog.Logger) {
+	plugins := make([]string, 0, len(b.batches))
+	for plugin := range b.batches {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+
+	for _, plugin := range plugins {
+		logger := logger.With().Str("plugin", plugin).Int("records", len(b.pending[plugin])).Logger()
+		if err := b.batches[plugin].Flush(logger.WithContext(ctx)); err != nil {
+			if !c.backoff.handle(logger, plugin, err) {
+				logger.Error().Err(err).Msg("failed to flush batched endpoints")
+			}
+			continue
+		}
+		logger.Info().Msg("flushed batched endpoints")
+		for key, plain := range b.pending[plugin] {
+			c.lastPublished[key] = plain
+		}
+	}
 }
 
 // publishToPeer builds the endpoint JSON, applies dedup, encrypts and
@@ -102,14 +344,12 @@ func (c *PublishController) discoverEndpoints(ctx context.Context, device *entit
 // storeCtx is the context used for the store.Set call (after applying the
 // dialer escape); callers pass different bases (Execute keeps the
 // peer-scoped logger attached, ExecuteForPeer detaches from cancellation)
-// while ctx is used unchanged for encryption.
-func (c *PublishController) publishToPeer(ctx, storeCtx context.Context, device *entity.Device, peer *entity.Peer, ipv4Endpoint, ipv6Endpoint string, logger zerolog.Logger) error {
+// while ctx is used unchanged for encryption. With a non-nil batch, a store
+// that can write records together (see batcherFor) and has no record_ttl
+// to apply is written through the batch instead, and lastPublished is left
+// for the flush to update.
+func (c *PublishController) publishToPeer(ctx, storeCtx context.Context, device *entity.Device, peer *entity.Peer, endpointData EndpointData, batch *publishBatch, logger zerolog.Logger) error {
 	// Build endpoint data in plain JSON
-	endpointData := EndpointData{
-		IPv4: ipv4Endpoint,
-		IPv6: ipv6Endpoint,
-	}
-
 	jsonPlain, err := json.Marshal(endpointData)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to marshal endpoint data")
@@ -141,10 +381,30 @@ func (c *PublishController) publishToPeer(ctx, storeCtx context.Context, device
 		return err
 	}
 
+	if remaining, ok := c.backoff.active(peer.Plugin()); ok {
+		logger.Debug().Str("plugin", peer.Plugin()).Dur("remaining", remaining).Msg("plugin is rate limited, not publishing the endpoint")
+		return nil
+	}
+
+	storeCtx = dialer.WithEscape(storeCtx, escapeFor(c.deviceConfig, device))
+
+	if batcher, ok := batcherFor(store); ok && batch != nil && c.recordTTL(store, peer) == 0 {
+		logger.Info().Str("plugin", peer.Plugin()).Msg("batch endpoint")
+		if err := batch.set(storeCtx, peer.Plugin(), batcher, peer.LocalId(), res.Data, string(jsonPlain)); err != nil {
+			if !c.backoff.handle(logger, peer.Plugin(), err) {
+				logger.Error().Err(err).Msg("failed to batch endpoint")
+			}
+			return err
+		}
+		return nil
+	}
+
 	logger.Info().Str("plugin", peer.Plugin()).Msg("store endpoint")
-	err = store.Set(dialer.WithEscape(storeCtx, escapeFor(c.deviceConfig, device)), peer.LocalId(), res.Data)
+	err = c.set(storeCtx, store, peer, res.Data)
 	if err != nil {
-		logger.Error().Err(err).Msg("failed to store endpoint")
+		if !c.backoff.handle(logger, peer.Plugin(), err) {
+			logger.Error().Err(err).Msg("failed to store endpoint")
+		}
 		return err
 	}
 
@@ -152,6 +412,25 @@ func (c *PublishController) publishToPeer(ctx, storeCtx context.Context, device
 	return nil
 }
 
+// recordTTL returns the lifetime a peer's record is stored with: the
+// plugin's record_ttl when the store can expire records, otherwise zero.
+func (c *PublishController) recordTTL(store pluginapi.Store, peer *entity.Peer) time.Duration {
+	if _, ok := pluginapi.As[pluginapi.TTLSetter](store); !ok {
+		return 0
+	}
+	return c.pluginManager.RecordTTL(peer.Plugin())
+}
+
+// set stores a peer's record, with the plugin's record_ttl when one is
+// configured and the store can expire records.
+func (c *PublishController) set(ctx context.Context, store pluginapi.Store, peer *entity.Peer, value string) error {
+	if ttl := c.recordTTL(store, peer); ttl > 0 {
+		setter, _ := pluginapi.As[pluginapi.TTLSetter](store)
+		return setter.SetWithTTL(ctx, peer.LocalId(), value, ttl)
+	}
+	return store.Set(ctx, peer.LocalId(), value)
+}
+
 func (c *PublishController) Execute(ctx context.Context) {
 	devices, err := c.devices.List(ctx)
 	if err != nil {
@@ -163,7 +442,7 @@ func (c *PublishController) Execute(ctx context.Context) {
 		logger := c.logger.With().Str("device", string(device.Name())).Logger()
 
 		// Perform STUN discovery based on device protocol
-		ipv4Endpoint, ipv6Endpoint, err := c.discoverEndpoints(ctx, device, logger)
+		endpointData, err := c.discoverEndpoints(ctx, device, 0, logger)
 		if err != nil {
 			logger.Error().Err(err).Msg("failed to discover endpoints")
 			continue
@@ -171,8 +450,9 @@ func (c *PublishController) Execute(ctx context.Context) {
 
 		// Log discovered endpoints
 		logger.Info().
-			Str("ipv4", ipv4Endpoint).
-			Str("ipv6", ipv6Endpoint).
+			Str("ipv4", endpointData.IPv4).
+			Str("ipv6", endpointData.IPv6).
+			Str("mapped_ipv4", endpointData.MappedIPv4).
 			Msg("discovered endpoints for device")
 
 		peers, err := c.peers.ListByDevice(ctx, device.Name())
@@ -181,11 +461,22 @@ func (c *PublishController) Execute(ctx context.Context) {
 			continue
 		}
 
+		// One batch per device: the flush has to go out through the
+		// device's dialer escape, as each Set would have.
+		batch := newPublishBatch()
+		// Peers pinned to another port publish what that port discovers.
+		byPort := map[uint16]discoveredPort{0: {endpointData: endpointData}}
 		for _, peer := range peers {
 			logger := logger.With().Str("peer", peer.LocalId()).Logger()
 
-			_ = c.publishToPeer(ctx, logger.WithContext(ctx), device, peer, ipv4Endpoint, ipv6Endpoint, logger)
+			peerData, err := c.peerEndpoints(ctx, device, peer, byPort, logger)
+			if err != nil {
+				continue
+			}
+			peerData = c.withRelay(ctx, device, peer, peerData, logger)
+			_ = c.publishToPeer(ctx, logger.WithContext(ctx), device, peer, peerData, batch, logger)
 		}
+		c.flush(dialer.WithEscape(ctx, escapeFor(c.deviceConfig, device)), batch, logger)
 	}
 }
 
@@ -209,8 +500,9 @@ func (c *PublishController) ExecuteForPeer(ctx context.Context, peerId entity.Pe
 		Str("peer", peer.LocalId()).
 		Logger()
 
-	// Perform STUN discovery based on device protocol
-	ipv4Endpoint, ipv6Endpoint, err := c.discoverEndpoints(ctx, device, logger)
+	// Perform STUN discovery based on device protocol, from the port the
+	// peer's traffic leaves from
+	endpointData, err := c.discoverEndpoints(ctx, device, c.peerPort(device, peer), logger)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to discover endpoints for specific peer")
 		return
@@ -218,11 +510,13 @@ func (c *PublishController) ExecuteForPeer(ctx context.Context, peerId entity.Pe
 
 	// Log discovered endpoints
 	logger.Info().
-		Str("ipv4", ipv4Endpoint).
-		Str("ipv6", ipv6Endpoint).
+		Str("ipv4", endpointData.IPv4).
+		Str("ipv6", endpointData.IPv6).
+		Str("mapped_ipv4", endpointData.MappedIPv4).
 		Msg("discovered endpoints for peer")
 
-	if err := c.publishToPeer(ctx, context.WithoutCancel(ctx), device, peer, ipv4Endpoint, ipv6Endpoint, logger); err != nil {
+	endpointData = c.withRelay(ctx, device, peer, endpointData, logger)
+	if err := c.publishToPeer(ctx, context.WithoutCancel(ctx), device, peer, endpointData, nil, logger); err != nil {
 		return
 	}
 
@@ -241,6 +535,8 @@ func (c *PublishController) Run(ctx context.Context) {
 			c.Execute(ctx)
 		case peerId := <-c.peerQueue.Dequeue():
 			c.ExecuteForPeer(ctx, peerId)
+		case peer := <-c.removeQueue.Dequeue():
+			c.unpublish(ctx, peer)
 		}
 	}
 }
@@ -263,6 +559,49 @@ func (c *PublishController) TriggerForPeer(peerId entity.PeerId) {
 	}
 }
 
+// Unpublish requests that a removed peer's record be deleted from its
+// plugin (non-blocking). It runs on the worker like a publish, so it cannot
+// race one for the same peer.
+func (c *PublishController) Unpublish(peer *entity.Peer) {
+	if c.removeQueue.TryEnqueue(peer) {
+		c.logger.Debug().Str("peer", peer.LocalId()).Msg("unpublish triggered for peer")
+	} else {
+		c.logger.Warn().Str("peer", peer.LocalId()).Msg("unpublish queue full, leaving the record for stunmesh gc")
+	}
+}
+
+// unpublish deletes peer's record, if its plugin can, and forgets what was
+// last published for it, so the peer publishes afresh should it come back.
+func (c *PublishController) unpublish(ctx context.Context, peer *entity.Peer) {
+	logger := c.logger.With().Str("peer", peer.LocalId()).Str("plugin", peer.Plugin()).Logger()
+	delete(c.lastPublished, peer.LocalId())
+
+	store, err := c.pluginManager.GetPlugin(peer.Plugin())
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to get plugin")
+		return
+	}
+	deleter, ok := pluginapi.As[pluginapi.Deleter](store)
+	if !ok {
+		logger.Info().Msg("plugin cannot delete records, leaving the removed peer's record")
+		return
+	}
+
+	// The device may be gone along with the peer; its escape goes with it.
+	var escape dialer.Escape
+	if device, err := c.devices.Find(ctx, peer.DeviceName()); err == nil {
+		escape = escapeFor(c.deviceConfig, device)
+	}
+
+	if err := deleter.Delete(dialer.WithEscape(logger.WithContext(ctx), escape), peer.LocalId()); err != nil {
+		if !c.backoff.handle(logger, peer.Plugin(), err) {
+			logger.Error().Err(err).Msg("failed to delete the removed peer's record")
+		}
+		return
+	}
+	logger.Info().Msg("deleted the removed peer's record")
+}
+
 // escapeFor describes how this device's plugin traffic should leave the host.
 func escapeFor(deviceConfig DeviceConfigProvider, device *entity.Device) dialer.Escape {
 	escape := dialer.Escape{FirewallMark: device.FirewallMark()}
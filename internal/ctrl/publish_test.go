@@ -5,8 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/ctrl"
@@ -14,6 +18,7 @@ import (
 	"github.com/tjjh89017/stunmesh-go/internal/entity"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 	"go.uber.org/mock/gomock"
 )
 
@@ -94,6 +99,10 @@ func TestPublishController_Execute_DeviceListError(t *testing.T) {
 		nil, // resolver not needed
 		nil, // encryptor not needed
 		nil, // deviceConfig not needed
+		nil, // portMapper not needed
+		nil, // relays not needed
+		nil, // peerPorts not needed
+		nil, // obfuscation not needed
 		&logger,
 	)
 
@@ -128,6 +137,10 @@ func TestPublishController_Execute_STUNError(t *testing.T) {
 		mockResolver,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -166,6 +179,10 @@ func TestPublishController_Execute_ForwardsDeviceFirewallMark(t *testing.T) {
 		mockResolver,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -206,6 +223,10 @@ func TestPublishController_Execute_CarriesEscapeIntoDiscovery(t *testing.T) {
 		mockResolver,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -246,6 +267,10 @@ func TestPublishController_Execute_PeerListError(t *testing.T) {
 		mockResolver,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -289,6 +314,10 @@ func TestPublishController_Execute_EncryptionError(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -347,6 +376,10 @@ func TestPublishController_Execute_SuccessfulEncryption(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -404,6 +437,10 @@ func TestPublishController_Execute_IPv6(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -463,6 +500,10 @@ func TestPublishController_Execute_Dualstack(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -501,6 +542,10 @@ func TestPublishController_Execute_Dualstack_BothFail(t *testing.T) {
 		mockResolver,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -572,6 +617,10 @@ func TestPublishController_Execute_Dualstack_PartialFail(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -636,6 +685,10 @@ func TestPublishController_Execute_MultipleDevices(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -668,6 +721,10 @@ func TestPublishController_ExecuteForPeer_PeerNotFound(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -703,6 +760,10 @@ func TestPublishController_ExecuteForPeer_DeviceNotFound(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -763,6 +824,10 @@ func TestPublishController_ExecuteForPeer_Success(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -811,6 +876,10 @@ func TestPublishController_Execute_Dedup_ChangedEndpoint_Publishes(t *testing.T)
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -861,6 +930,10 @@ func TestPublishController_Execute_Dedup_UnchangedEndpoint_Skips(t *testing.T) {
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -910,6 +983,10 @@ func TestPublishController_Execute_Dedup_OffByDefault_AlwaysPublishes(t *testing
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -965,6 +1042,10 @@ func TestPublishController_ExecuteForPeer_Dedup_UnchangedEndpoint_Skips(t *testi
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -1021,6 +1102,10 @@ func TestPublishController_ExecuteForPeer_Dedup_ChangedEndpoint_Publishes(t *tes
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -1077,6 +1162,10 @@ func TestPublishController_Execute_Dedup_FailedStore_DoesNotCacheAndRetries(t *t
 		mockResolver,
 		mockEncryptor,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -1088,6 +1177,56 @@ func TestPublishController_Execute_Dedup_FailedStore_DoesNotCacheAndRetries(t *t
 	}
 }
 
+func TestPublishController_Execute_RateLimited_BacksOff(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockResolver := mock.NewMockStunResolver(mockCtrl)
+	mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+	pluginProvider, store := newDedupTestPluginProvider(mockCtrl, false)
+	store.setErr = fmt.Errorf("API error: 429 Too Many Requests: %w", pluginapi.ErrRateLimited)
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+
+	mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil).Times(2)
+	mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return([]*entity.Peer{peer}, nil).Times(2)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 51820, nil).
+		Times(2)
+	mockEncryptor.EXPECT().
+		Encrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil).
+		Times(2)
+
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockResolver,
+		mockEncryptor,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		&logger,
+	)
+
+	controller.Execute(ctx)
+	controller.Execute(ctx)
+
+	if store.setCalls != 1 {
+		t.Errorf("store.Set call count = %d, want 1 (a rate limited plugin is left alone)", store.setCalls)
+	}
+}
+
 // Test Trigger - should not panic
 func TestPublishController_Trigger(t *testing.T) {
 	logger := zerolog.Nop()
@@ -1099,6 +1238,10 @@ func TestPublishController_Trigger(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -1118,6 +1261,10 @@ func TestPublishController_TriggerForPeer(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		&logger,
 	)
 
@@ -1129,3 +1276,687 @@ func TestPublishController_TriggerForPeer(t *testing.T) {
 	controller.TriggerForPeer(peerId)
 	controller.TriggerForPeer(peerId)
 }
+
+// fakeBatchStore is a fakeDedupStore that is also a pluginapi.Batcher,
+// counting the records batched and the flushes.
+type fakeBatchStore struct {
+	fakeDedupStore
+	batched    map[string]string
+	flushCalls int
+
+	// flushErr, if non-nil, is returned by the next Flush and then cleared.
+	flushErr error
+}
+
+func (f *fakeBatchStore) NewBatch() pluginapi.Batch {
+	return &fakeBatch{store: f, records: make(map[string]string)}
+}
+
+type fakeBatch struct {
+	store   *fakeBatchStore
+	records map[string]string
+}
+
+func (b *fakeBatch) Set(ctx context.Context, key string, value string) error {
+	b.records[key] = value
+	return nil
+}
+
+func (b *fakeBatch) Flush(ctx context.Context) error {
+	b.store.flushCalls++
+	if b.store.flushErr != nil {
+		err := b.store.flushErr
+		b.store.flushErr = nil
+		return err
+	}
+	for key, value := range b.records {
+		b.store.batched[key] = value
+	}
+	return nil
+}
+
+func TestPublishController_Execute_Batcher_OneFlushPerRound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockResolver := mock.NewMockStunResolver(mockCtrl)
+	mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+	store := &fakeBatchStore{batched: make(map[string]string)}
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	pluginProvider.EXPECT().IsDedup("test_plugin").Return(true).AnyTimes()
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	var peers []*entity.Peer
+	for _, b := range []byte{1, 2, 3} {
+		peerPublicKey := [32]byte{b}
+		peerId := entity.NewPeerId(make([]byte, 32), peerPublicKey[:])
+		peers = append(peers, entity.NewPeer(peerId, "wg0", peerPublicKey, "test_plugin", "ipv4", entity.PeerPingConfig{}))
+	}
+
+	mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil).Times(3)
+	mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return(peers, nil).Times(3)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 51820, nil).
+		Times(3)
+	// The first round's flush fails, so the second round encrypts and
+	// batches every peer again; the third is deduped entirely.
+	mockEncryptor.EXPECT().
+		Encrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil).
+		Times(6)
+
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockResolver,
+		mockEncryptor,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		&logger,
+	)
+
+	store.flushErr = errors.New("store unavailable")
+	controller.Execute(ctx)
+	controller.Execute(ctx)
+	controller.Execute(ctx)
+
+	if store.setCalls != 0 {
+		t.Errorf("store.Set call count = %d, want 0 (records go through the batch)", store.setCalls)
+	}
+	if store.flushCalls != 2 {
+		t.Errorf("Flush call count = %d, want 2 (one per round with records to write)", store.flushCalls)
+	}
+	if len(store.batched) != len(peers) {
+		t.Errorf("flushed %d records, want %d", len(store.batched), len(peers))
+	}
+}
+
+// fakeManyStore is a fakeDedupStore that is also a pluginapi.BatchStore,
+// holding the records written with SetMany and counting both calls.
+type fakeManyStore struct {
+	fakeDedupStore
+	records      map[string]string
+	getManyCalls int
+	setManyCalls int
+}
+
+func (f *fakeManyStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	f.getManyCalls++
+	found := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := f.records[key]; ok {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeManyStore) SetMany(ctx context.Context, records map[string]string) error {
+	f.setManyCalls++
+	for key, value := range records {
+		f.records[key] = value
+	}
+	return nil
+}
+
+func TestPublishController_Execute_BatchStore_OneSetManyPerRound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockResolver := mock.NewMockStunResolver(mockCtrl)
+	mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+	logger := zerolog.Nop()
+
+	ctx := context.Background()
+	store := &fakeManyStore{records: make(map[string]string)}
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	pluginProvider.EXPECT().IsDedup("test_plugin").Return(false).AnyTimes()
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	var peers []*entity.Peer
+	for _, b := range []byte{1, 2, 3} {
+		peerPublicKey := [32]byte{b}
+		peerId := entity.NewPeerId(make([]byte, 32), peerPublicKey[:])
+		peers = append(peers, entity.NewPeer(peerId, "wg0", peerPublicKey, "test_plugin", "ipv4", entity.PeerPingConfig{}))
+	}
+
+	mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+	mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return(peers, nil)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 51820, nil)
+	mockEncryptor.EXPECT().
+		Encrypt(ctx, gomock.Any()).
+		Return(&ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil).
+		Times(len(peers))
+
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		mockPeers,
+		pluginProvider,
+		mockResolver,
+		mockEncryptor,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		&logger,
+	)
+
+	controller.Execute(ctx)
+
+	if store.setCalls != 0 || store.setManyCalls != 1 {
+		t.Errorf("Set called %d times and SetMany %d, want 0 and 1", store.setCalls, store.setManyCalls)
+	}
+	if len(store.records) != len(peers) {
+		t.Errorf("SetMany wrote %d records, want %d", len(store.records), len(peers))
+	}
+}
+
+// fakeCleanupStore is a fakeDedupStore that can also expire and delete
+// records, recording the TTLs it was given and the keys it deleted.
+type fakeCleanupStore struct {
+	fakeDedupStore
+	ttls    []time.Duration
+	deleted chan string
+}
+
+func (f *fakeCleanupStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	f.ttls = append(f.ttls, ttl)
+	return nil
+}
+
+func (f *fakeCleanupStore) Delete(ctx context.Context, key string) error {
+	f.deleted <- key
+	return nil
+}
+
+func TestPublishController_Execute_RecordTTL(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		ttl      time.Duration
+		wantTTLs int
+		wantSets int
+	}{
+		{name: "configured", ttl: 10 * time.Minute, wantTTLs: 1},
+		{name: "unset", ttl: 0, wantSets: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockResolver := mock.NewMockStunResolver(mockCtrl)
+			mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+			logger := zerolog.Nop()
+
+			ctx := context.Background()
+			store := &fakeCleanupStore{}
+			pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+			pluginProvider.EXPECT().IsDedup("test_plugin").Return(false).AnyTimes()
+			pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+			pluginProvider.EXPECT().RecordTTL("test_plugin").Return(tt.ttl).AnyTimes()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			peer := createTestPeer("wg0", "test_plugin", "ipv4")
+
+			mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+			mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return([]*entity.Peer{peer}, nil)
+			mockResolver.EXPECT().
+				Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+				Return("1.2.3.4", 51820, nil)
+			mockEncryptor.EXPECT().
+				Encrypt(ctx, gomock.Any()).
+				Return(&ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil)
+
+			controller := ctrl.NewPublishController(
+				mockDevices,
+				mockPeers,
+				pluginProvider,
+				mockResolver,
+				mockEncryptor,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				&logger,
+			)
+
+			controller.Execute(ctx)
+
+			if len(store.ttls) != tt.wantTTLs || store.setCalls != tt.wantSets {
+				t.Fatalf("SetWithTTL calls = %d, Set calls = %d, want %d and %d", len(store.ttls), store.setCalls, tt.wantTTLs, tt.wantSets)
+			}
+			if tt.wantTTLs > 0 && store.ttls[0] != tt.ttl {
+				t.Errorf("ttl = %s, want %s", store.ttls[0], tt.ttl)
+			}
+		})
+	}
+}
+
+// fakeManyTTLStore is a fakeManyStore that can also expire records,
+// recording the TTLs it was given.
+type fakeManyTTLStore struct {
+	fakeManyStore
+	ttls []time.Duration
+}
+
+func (f *fakeManyTTLStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	f.ttls = append(f.ttls, ttl)
+	return nil
+}
+
+// SetMany has no TTL to give, so a record_ttl takes the store out of the
+// round's batch.
+func TestPublishController_Execute_BatchStore_RecordTTL(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		ttl          time.Duration
+		wantTTLs     int
+		wantSetManys int
+	}{
+		{name: "configured", ttl: 10 * time.Minute, wantTTLs: 3},
+		{name: "unset", ttl: 0, wantSetManys: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockResolver := mock.NewMockStunResolver(mockCtrl)
+			mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+			logger := zerolog.Nop()
+
+			ctx := context.Background()
+			store := &fakeManyTTLStore{fakeManyStore: fakeManyStore{records: make(map[string]string)}}
+			pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+			pluginProvider.EXPECT().IsDedup("test_plugin").Return(false).AnyTimes()
+			pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil).AnyTimes()
+			pluginProvider.EXPECT().RecordTTL("test_plugin").Return(tt.ttl).AnyTimes()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			var peers []*entity.Peer
+			for _, b := range []byte{1, 2, 3} {
+				peerPublicKey := [32]byte{b}
+				peerId := entity.NewPeerId(make([]byte, 32), peerPublicKey[:])
+				peers = append(peers, entity.NewPeer(peerId, "wg0", peerPublicKey, "test_plugin", "ipv4", entity.PeerPingConfig{}))
+			}
+
+			mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+			mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return(peers, nil)
+			mockResolver.EXPECT().
+				Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+				Return("1.2.3.4", 51820, nil)
+			mockEncryptor.EXPECT().
+				Encrypt(ctx, gomock.Any()).
+				Return(&ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil).
+				Times(len(peers))
+
+			controller := ctrl.NewPublishController(
+				mockDevices,
+				mockPeers,
+				pluginProvider,
+				mockResolver,
+				mockEncryptor,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				&logger,
+			)
+
+			controller.Execute(ctx)
+
+			if len(store.ttls) != tt.wantTTLs || store.setManyCalls != tt.wantSetManys {
+				t.Fatalf("SetWithTTL calls = %d, SetMany calls = %d, want %d and %d", len(store.ttls), store.setManyCalls, tt.wantTTLs, tt.wantSetManys)
+			}
+			for _, ttl := range store.ttls {
+				if ttl != tt.ttl {
+					t.Errorf("ttl = %s, want %s", ttl, tt.ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishController_Unpublish_DeletesRecord(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	logger := zerolog.Nop()
+
+	store := &fakeCleanupStore{deleted: make(chan string, 1)}
+	pluginProvider := mock.NewMockPluginProvider(mockCtrl)
+	pluginProvider.EXPECT().GetPlugin("test_plugin").Return(store, nil)
+
+	peer := createTestPeer("wg0", "test_plugin", "ipv4")
+	// The device went away with the peer; the record is still deleted.
+	mockDevices.EXPECT().Find(gomock.Any(), entity.DeviceId("wg0")).Return(nil, errors.New("device not found"))
+
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		nil,
+		pluginProvider,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		&logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		controller.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	controller.Unpublish(peer)
+
+	select {
+	case key := <-store.deleted:
+		if key != peer.LocalId() {
+			t.Errorf("deleted key %q, want %q", key, peer.LocalId())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("removed peer's record was not deleted")
+	}
+}
+
+// fakePortMapper returns a fixed mapping for every device.
+type fakePortMapper struct {
+	external netip.AddrPort
+	err      error
+	ports    []uint16
+}
+
+func (f *fakePortMapper) Map(ctx context.Context, deviceName string, port uint16) (netip.AddrPort, error) {
+	f.ports = append(f.ports, port)
+	return f.external, f.err
+}
+
+func TestPublishController_Execute_PortMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		external   netip.AddrPort
+		err        error
+		wantMapped string
+	}{
+		{"mapping on the STUN address is published", netip.MustParseAddrPort("1.2.3.4:40000"), nil, "1.2.3.4:40000"},
+		{"mapping on another address is not", netip.MustParseAddrPort("192.168.0.2:40000"), nil, ""},
+		{"mapping equal to the STUN endpoint is not", netip.MustParseAddrPort("1.2.3.4:51820"), nil, ""},
+		{"no mapping", netip.AddrPort{}, nil, ""},
+		{"failed mapping still publishes STUN", netip.AddrPort{}, errors.New("no gateway"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockResolver := mock.NewMockStunResolver(mockCtrl)
+			mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+			logger := zerolog.Nop()
+			ctx := context.Background()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			peer := createTestPeer("wg0", "test_plugin", "ipv4")
+			mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+			mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return([]*entity.Peer{peer}, nil)
+			mockResolver.EXPECT().
+				Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+				Return("1.2.3.4", 51820, nil)
+
+			var got ctrl.EndpointData
+			mockEncryptor.EXPECT().
+				Encrypt(ctx, gomock.Any()).
+				DoAndReturn(func(ctx context.Context, req *ctrl.EndpointEncryptRequest) (*ctrl.EndpointEncryptResponse, error) {
+					if err := json.Unmarshal([]byte(req.Content), &got); err != nil {
+						t.Errorf("Invalid JSON content: %v", err)
+					}
+					return &ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil
+				})
+
+			mapper := &fakePortMapper{external: tt.external, err: tt.err}
+			controller := ctrl.NewPublishController(
+				mockDevices,
+				mockPeers,
+				plugin.NewManager(),
+				mockResolver,
+				mockEncryptor,
+				nil,
+				mapper,
+				nil,
+				nil,
+				nil,
+				&logger,
+			)
+			controller.Execute(ctx)
+
+			if len(mapper.ports) != 1 || mapper.ports[0] != 51820 {
+				t.Errorf("Map called with ports %v, want the listen port once", mapper.ports)
+			}
+			if got.IPv4 != "1.2.3.4:51820" {
+				t.Errorf("IPv4 = %q, want the STUN endpoint", got.IPv4)
+			}
+			if got.MappedIPv4 != tt.wantMapped {
+				t.Errorf("MappedIPv4 = %q, want %q", got.MappedIPv4, tt.wantMapped)
+			}
+		})
+	}
+}
+
+// fakeRelayAllocator hands out one relay endpoint per peer.
+type fakeRelayAllocator struct {
+	relays map[entity.PeerPublicKey]netip.AddrPort
+	err    error
+}
+
+func (f *fakeRelayAllocator) Allocate(ctx context.Context, deviceName string, privateKey entity.PrivateKey, peer entity.PeerPublicKey) (netip.AddrPort, error) {
+	return f.relays[peer], f.err
+}
+
+func TestPublishController_Execute_Relay(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockResolver := mock.NewMockStunResolver(mockCtrl)
+	mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	relayed := createTestPeer("wg0", "test_plugin", "ipv4")
+	otherKey := [32]byte{}
+	copy(otherKey[:], "other_peer_key_1234567890123456")
+	direct := entity.NewPeer(entity.NewPeerId(make([]byte, 32), otherKey[:]), "wg0", otherKey, "test_plugin", "ipv4", entity.PeerPingConfig{})
+	mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+	mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return([]*entity.Peer{relayed, direct}, nil)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 51820, nil)
+
+	got := make(map[entity.PeerPublicKey]ctrl.EndpointData)
+	mockEncryptor.EXPECT().
+		Encrypt(ctx, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, req *ctrl.EndpointEncryptRequest) (*ctrl.EndpointEncryptResponse, error) {
+			var data ctrl.EndpointData
+			if err := json.Unmarshal([]byte(req.Content), &data); err != nil {
+				t.Errorf("Invalid JSON content: %v", err)
+			}
+			got[req.PeerPublicKey] = data
+			return &ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil
+		}).Times(2)
+
+	relays := &fakeRelayAllocator{relays: map[entity.PeerPublicKey]netip.AddrPort{
+		relayed.PublicKey(): netip.MustParseAddrPort("198.51.100.1:40000"),
+	}}
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		mockPeers,
+		plugin.NewManager(),
+		mockResolver,
+		mockEncryptor,
+		nil,
+		nil,
+		relays,
+		nil,
+		nil,
+		&logger,
+	)
+	controller.Execute(ctx)
+
+	if data := got[relayed.PublicKey()]; data.Relay != "198.51.100.1:40000" || data.IPv4 != "1.2.3.4:51820" {
+		t.Errorf("relayed peer's record = %+v, want its relay alongside the STUN endpoint", data)
+	}
+	if data := got[direct.PublicKey()]; data.Relay != "" {
+		t.Errorf("direct peer's record has relay %q, want none", data.Relay)
+	}
+}
+
+// fakePeerPorts pins peers to local ports; unlisted peers use the default.
+type fakePeerPorts map[entity.PeerPublicKey]uint16
+
+func (f fakePeerPorts) PeerPort(deviceName string, peer entity.PeerPublicKey) uint16 {
+	return f[peer]
+}
+
+func TestPublishController_Execute_PeerPorts(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+	mockPeers := mock.NewMockPeerRepository(mockCtrl)
+	mockResolver := mock.NewMockStunResolver(mockCtrl)
+	mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	device := createTestDevice("wg0", 51820, "ipv4")
+	peers := []*entity.Peer{createTestPeer("wg0", "test_plugin", "ipv4")}
+	for _, name := range []string{"pinned_peer_key_a_4567890123456", "pinned_peer_key_b_4567890123456"} {
+		key := [32]byte{}
+		copy(key[:], name)
+		peers = append(peers, entity.NewPeer(entity.NewPeerId(make([]byte, 32), key[:]), "wg0", key, "test_plugin", "ipv4", entity.PeerPingConfig{}))
+	}
+	mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+	mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return(peers, nil)
+	// Each port is discovered once, however many peers are pinned to it.
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 40001, nil)
+	mockResolver.EXPECT().
+		Resolve(gomock.Any(), "wg0", uint16(51821), "ipv4", gomock.Any()).
+		Return("1.2.3.4", 40002, nil)
+
+	got := make(map[entity.PeerPublicKey]string)
+	mockEncryptor.EXPECT().
+		Encrypt(ctx, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, req *ctrl.EndpointEncryptRequest) (*ctrl.EndpointEncryptResponse, error) {
+			var data ctrl.EndpointData
+			if err := json.Unmarshal([]byte(req.Content), &data); err != nil {
+				t.Errorf("Invalid JSON content: %v", err)
+			}
+			got[req.PeerPublicKey] = data.IPv4
+			return &ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil
+		}).Times(3)
+
+	controller := ctrl.NewPublishController(
+		mockDevices,
+		mockPeers,
+		plugin.NewManager(),
+		mockResolver,
+		mockEncryptor,
+		nil,
+		nil,
+		nil,
+		fakePeerPorts{peers[1].PublicKey(): 51821, peers[2].PublicKey(): 51821},
+		nil,
+		&logger,
+	)
+	controller.Execute(ctx)
+
+	want := map[entity.PeerPublicKey]string{
+		peers[0].PublicKey(): "1.2.3.4:40001",
+		peers[1].PublicKey(): "1.2.3.4:40002",
+		peers[2].PublicKey(): "1.2.3.4:40002",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("published IPv4 endpoints = %v, want %v", got, want)
+	}
+}
+
+// A device that obfuscates says so in every record it publishes.
+func TestPublishController_Execute_AdvertisesObfuscation(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			mockDevices := mock.NewMockDeviceRepository(mockCtrl)
+			mockPeers := mock.NewMockPeerRepository(mockCtrl)
+			mockResolver := mock.NewMockStunResolver(mockCtrl)
+			mockEncryptor := mock.NewMockEndpointEncryptor(mockCtrl)
+			logger := zerolog.Nop()
+			ctx := context.Background()
+
+			device := createTestDevice("wg0", 51820, "ipv4")
+			peer := createTestPeer("wg0", "test_plugin", "ipv4")
+			mockDevices.EXPECT().List(ctx).Return([]*entity.Device{device}, nil)
+			mockPeers.EXPECT().ListByDevice(ctx, entity.DeviceId("wg0")).Return([]*entity.Peer{peer}, nil)
+			mockResolver.EXPECT().
+				Resolve(gomock.Any(), "wg0", uint16(51820), "ipv4", gomock.Any()).
+				Return("1.2.3.4", 40001, nil)
+			mockEncryptor.EXPECT().
+				Encrypt(ctx, gomock.Any()).
+				DoAndReturn(func(ctx context.Context, req *ctrl.EndpointEncryptRequest) (*ctrl.EndpointEncryptResponse, error) {
+					var data ctrl.EndpointData
+					if err := json.Unmarshal([]byte(req.Content), &data); err != nil {
+						t.Errorf("Invalid JSON content: %v", err)
+					}
+					if data.Obfuscation != enabled {
+						t.Errorf("record obfuscation = %v, want %v", data.Obfuscation, enabled)
+					}
+					return &ctrl.EndpointEncryptResponse{Data: "encrypted_data"}, nil
+				})
+
+			controller := ctrl.NewPublishController(
+				mockDevices,
+				mockPeers,
+				plugin.NewManager(),
+				mockResolver,
+				mockEncryptor,
+				nil,
+				nil,
+				nil,
+				nil,
+				&fakeObfuscation{enabled: enabled},
+				&logger,
+			)
+			controller.Execute(ctx)
+		})
+	}
+}
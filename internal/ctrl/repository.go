@@ -19,4 +19,5 @@ type PeerRepository interface {
 	ListByDevice(ctx context.Context, deviceName entity.DeviceId) ([]*entity.Peer, error)
 	Find(ctx context.Context, id entity.PeerId) (*entity.Peer, error)
 	Save(ctx context.Context, peer *entity.Peer)
+	Delete(ctx context.Context, id entity.PeerId)
 }
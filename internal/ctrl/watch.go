@@ -0,0 +1,157 @@
+package ctrl
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/entity"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+const (
+	watchRetryMin = time.Second
+	watchRetryMax = 5 * time.Minute
+)
+
+// watchKey identifies a watchGroup.
+type watchKey struct {
+	plugin string
+	device entity.DeviceId
+}
+
+// watchGroup is the peers whose records one Watch call follows: those of a
+// device that share a plugin instance, as the watch has to leave the host
+// through that device's escape.
+type watchGroup struct {
+	plugin  string
+	watcher pluginapi.Watcher
+	device  *entity.Device
+	peers   map[string]entity.PeerId
+}
+
+// runningWatch is a watchGroup's subscription as syncWatches started it.
+type runningWatch struct {
+	peers  map[string]entity.PeerId
+	cancel context.CancelFunc
+}
+
+// syncWatches subscribes to the remote records of every peer whose store
+// is a pluginapi.Watcher, triggering an establish for a peer as soon as its
+// record changes. A group whose peers changed since the last sync is
+// subscribed again with the new keys, and one left without peers is
+// dropped. The periodic Trigger keeps running alongside, covering whatever
+// a watch misses. Outside Run there is nothing to sync.
+func (c *EstablishController) syncWatches(ctx context.Context, peers []*entity.Peer) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if c.watchCtx == nil {
+		return
+	}
+
+	groups := c.watchGroups(ctx, peers)
+
+	for key, running := range c.watches {
+		if group, ok := groups[key]; ok && maps.Equal(group.peers, running.peers) {
+			delete(groups, key)
+			continue
+		}
+		running.cancel()
+		delete(c.watches, key)
+	}
+
+	for key, group := range groups {
+		ctx, cancel := context.WithCancel(c.watchCtx)
+		c.watches[key] = &runningWatch{peers: group.peers, cancel: cancel}
+		c.watchWG.Add(1)
+		go func() {
+			defer c.watchWG.Done()
+			c.watch(ctx, group)
+		}()
+	}
+}
+
+// watchGroups groups peers for watching, leaving out those whose store is
+// not a pluginapi.Watcher.
+func (c *EstablishController) watchGroups(ctx context.Context, peers []*entity.Peer) map[watchKey]*watchGroup {
+	groups := make(map[watchKey]*watchGroup)
+	for _, peer := range peers {
+		key := watchKey{plugin: peer.Plugin(), device: peer.DeviceName()}
+		if group, ok := groups[key]; ok {
+			if group != nil {
+				group.peers[peer.RemoteId()] = peer.Id()
+			}
+			continue
+		}
+
+		// A nil group remembers a pair that cannot be watched.
+		groups[key] = nil
+		store, err := c.pluginManager.GetPlugin(peer.Plugin())
+		if err != nil {
+			continue
+		}
+		watcher, ok := pluginapi.As[pluginapi.Watcher](store)
+		if !ok {
+			continue
+		}
+		device, err := c.devices.Find(ctx, peer.DeviceName())
+		if err != nil {
+			continue
+		}
+		groups[key] = &watchGroup{
+			plugin:  peer.Plugin(),
+			watcher: watcher,
+			device:  device,
+			peers:   map[string]entity.PeerId{peer.RemoteId(): peer.Id()},
+		}
+	}
+	maps.DeleteFunc(groups, func(_ watchKey, group *watchGroup) bool { return group == nil })
+	return groups
+}
+
+// watch keeps group's subscription up until ctx is done, subscribing again
+// with a growing backoff whenever it breaks. Each resubscription triggers
+// the group's peers once, for the changes the gap may have hidden.
+func (c *EstablishController) watch(ctx context.Context, group *watchGroup) {
+	logger := c.logger.With().Str("plugin", group.plugin).Str("device", string(group.device.Name())).Logger()
+	storeCtx := dialer.WithEscape(logger.WithContext(ctx), escapeFor(c.deviceConfig, group.device))
+
+	keys := make([]string, 0, len(group.peers))
+	for key := range group.peers {
+		keys = append(keys, key)
+	}
+	changed := func(key string) {
+		if peerId, ok := group.peers[key]; ok {
+			c.TriggerForPeer(peerId)
+		}
+	}
+
+	logger.Info().Int("peers", len(keys)).Msg("watching peer records")
+	retry := watchRetryMin
+	for {
+		started := time.Now()
+		err := group.watcher.Watch(storeCtx, keys, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		// A subscription that held for a while was healthy; start over.
+		if time.Since(started) > watchRetryMax {
+			retry = watchRetryMin
+		}
+		logger.Warn().Err(err).Dur("retry_in", retry).Msg("watch ended, subscribing again")
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		retry = min(retry*2, watchRetryMax)
+
+		for _, key := range keys {
+			changed(key)
+		}
+	}
+}
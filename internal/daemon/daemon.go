@@ -15,6 +15,7 @@ import (
 // BootstrapExecutor is the subset of ctrl.BootstrapController that Daemon calls.
 type BootstrapExecutor interface {
 	Execute(ctx context.Context)
+	Refresh(ctx context.Context)
 }
 
 // PublishRunner is the subset of ctrl.PublishController that Daemon calls.
@@ -128,6 +129,7 @@ func (d *Daemon) Run(ctx context.Context) {
 			return
 		case <-ticker.C:
 			d.logger.Info().Msg("refreshing peers")
+			d.bootCtrl.Refresh(daemonCtx)
 			d.publishCtrl.Trigger()
 			d.establishCtrl.Trigger(daemonCtx)
 		}
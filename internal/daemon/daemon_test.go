@@ -10,10 +10,11 @@ import (
 	"github.com/tjjh89017/stunmesh-go/internal/config"
 )
 
-// fakeBootstrap counts Execute calls.
+// fakeBootstrap counts Execute and Refresh calls.
 type fakeBootstrap struct {
-	mu    sync.Mutex
-	calls int
+	mu           sync.Mutex
+	calls        int
+	refreshCalls int
 }
 
 func (f *fakeBootstrap) Execute(ctx context.Context) {
@@ -28,6 +29,18 @@ func (f *fakeBootstrap) Calls() int {
 	return f.calls
 }
 
+func (f *fakeBootstrap) Refresh(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshCalls++
+}
+
+func (f *fakeBootstrap) RefreshCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshCalls
+}
+
 // fakePublish counts Execute/Trigger calls and blocks Run until ctx is done,
 // mirroring the real PublishController's worker-loop shape.
 type fakePublish struct {
@@ -195,6 +208,34 @@ func TestRun_ShouldTriggerPublishAndEstablishOnTick(t *testing.T) {
 	}
 }
 
+func TestRun_ShouldRefreshPeersOnTick(t *testing.T) {
+	d, boot, _, _ := newTestDaemon(t, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(2 * time.Second)
+	for boot.RefreshCalls() < 1 {
+		select {
+		case <-deadline:
+			t.Fatal("ticker did not refresh peers in time")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+}
+
 func TestRun_ShouldExecuteBootstrapOnStart(t *testing.T) {
 	d, boot, _, _ := newTestDaemon(t, time.Hour)
 
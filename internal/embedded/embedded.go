@@ -0,0 +1,271 @@
+// Package embedded runs WireGuard interfaces in-process: a TUN device driven
+// by wireguard-go on a mobilebind.Bind, configured the way a wg-quick file
+// would configure a kernel interface. The bind shares the WireGuard socket
+// with STUN, so an embedded interface needs neither a kernel module and
+// wireguard-tools, nor raw sockets, pcap or the wgproxy loopback proxy.
+package embedded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/mobilebind"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// DefaultMTU is wg-quick's default, used when Interface.MTU is 0.
+const DefaultMTU = 1420
+
+// ErrManagerClosed is returned by Up after the manager has been closed.
+var ErrManagerClosed = errors.New("embedded: manager closed")
+
+// ErrNotEmbedded is returned by Get for a device that is not embedded.
+var ErrNotEmbedded = errors.New("embedded: not an embedded device")
+
+// Key is a 32-byte WireGuard key (public, private or preshared).
+type Key = [32]byte
+
+// Interface configures one embedded interface, as the [Interface] section
+// of a wg-quick file does.
+type Interface struct {
+	Name       string
+	PrivateKey Key
+	// ListenPort 0 binds an ephemeral port.
+	ListenPort uint16
+	// Addresses are assigned to the TUN device, and routes added for the
+	// peers' allowed IPs they do not already cover.
+	Addresses []netip.Prefix
+	// MTU 0 means DefaultMTU.
+	MTU   int
+	Peers []Peer
+}
+
+// Peer configures one peer of an embedded interface, as a wg-quick [Peer]
+// section does.
+type Peer struct {
+	PublicKey Key
+	// PresharedKey is unset when zero.
+	PresharedKey Key
+	AllowedIPs   []netip.Prefix
+	// Endpoint is an optional static initial endpoint; stunmesh replaces it
+	// with the discovered one.
+	Endpoint netip.AddrPort
+	// PersistentKeepalive is in seconds; 0 is off.
+	PersistentKeepalive uint16
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithRunner runs the address and route commands with run instead of
+// executing them.
+func WithRunner(run Runner) Option {
+	return func(m *Manager) { m.run = run }
+}
+
+// WithTUN creates TUN devices with newTUN instead of tun.CreateTUN, such as
+// in-memory ones for tests.
+func WithTUN(newTUN func(name string, mtu int) (tun.Device, error)) Option {
+	return func(m *Manager) { m.newTUN = newTUN }
+}
+
+// Manager owns the process's embedded interfaces.
+type Manager struct {
+	logger zerolog.Logger
+	goos   string
+	run    Runner
+	newTUN func(name string, mtu int) (tun.Device, error)
+
+	mu      sync.Mutex
+	devices map[string]*Device
+	// starting holds the names Up is bringing up, outside mu.
+	starting map[string]bool
+	closed   bool
+}
+
+// NewManager creates an empty Manager configuring interfaces for goos (pass
+// runtime.GOOS).
+func NewManager(logger *zerolog.Logger, goos string, opts ...Option) *Manager {
+	m := &Manager{
+		logger:   logger.With().Str("component", "embedded").Logger(),
+		goos:     goos,
+		run:      defaultRunner,
+		newTUN:   tun.CreateTUN,
+		devices:  make(map[string]*Device),
+		starting: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Up creates the interface: its TUN device, the wireguard-go device on a
+// fresh bind, its keys and peers, then its addresses and routes. A route
+// that cannot be added costs only a warning; anything else fails Up and
+// tears down what it created. Up is once per interface. The manager is not
+// locked while Up runs the address and route commands, so a slow one does
+// not hold up Get for the interfaces already up.
+func (m *Manager) Up(ctx context.Context, iface Interface) (*Device, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrManagerClosed
+	}
+	if _, ok := m.devices[iface.Name]; ok || m.starting[iface.Name] {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("embedded: %s is already up", iface.Name)
+	}
+	if !supported(m.goos) {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("embedded: not supported on %s", m.goos)
+	}
+	m.starting[iface.Name] = true
+	m.mu.Unlock()
+
+	d, err := m.up(ctx, iface)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.starting, iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	if m.closed {
+		d.Close()
+		return nil, ErrManagerClosed
+	}
+	m.devices[iface.Name] = d
+	return d, nil
+}
+
+// up is Up without the manager's bookkeeping.
+func (m *Manager) up(ctx context.Context, iface Interface) (*Device, error) {
+	mtu := iface.MTU
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	tunDev, err := m.newTUN(iface.Name, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("embedded: create TUN %s: %w", iface.Name, err)
+	}
+	// The TUN's name can differ from the configured one: darwin numbers
+	// utun devices itself.
+	tunName, err := tunDev.Name()
+	if err != nil {
+		_ = tunDev.Close()
+		return nil, fmt.Errorf("embedded: TUN name: %w", err)
+	}
+	logger := m.logger.With().Str("device", iface.Name).Str("tun", tunName).Logger()
+
+	bind := mobilebind.New(nil)
+	dev := device.NewDevice(tunDev, bind, deviceLogger(logger))
+	d := &Device{name: iface.Name, tunName: tunName, dev: dev, bind: bind}
+	if err := dev.IpcSet(buildUAPI(iface)); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("embedded: configure %s: %w", iface.Name, err)
+	}
+	if err := dev.Up(); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("embedded: bring up %s: %w", iface.Name, err)
+	}
+
+	addrs, routes, err := setupCommands(m.goos, tunName, iface)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	for _, cmd := range addrs {
+		if _, err := m.run(ctx, cmd[0], cmd[1:]...); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("embedded: configure %s: %w", iface.Name, err)
+		}
+	}
+	for _, cmd := range routes {
+		if _, err := m.run(ctx, cmd[0], cmd[1:]...); err != nil {
+			logger.Warn().Err(err).Msg("failed to add route for allowed IPs")
+		}
+	}
+
+	logger.Info().Msg("embedded WireGuard interface up")
+	return d, nil
+}
+
+// Get returns the embedded device named deviceName, or ErrNotEmbedded.
+func (m *Manager) Get(deviceName string) (*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.devices[deviceName]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotEmbedded, deviceName)
+}
+
+// Close tears down every interface; process-shutdown only, idempotent.
+// Addresses and routes go with the TUN devices.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	for name, d := range m.devices {
+		d.Close()
+		delete(m.devices, name)
+	}
+	return nil
+}
+
+// Device is one running embedded interface.
+type Device struct {
+	name    string
+	tunName string
+	dev     *device.Device
+	bind    *mobilebind.Bind
+}
+
+// TUNName is the name the TUN device got from the system.
+func (d *Device) TUNName() string { return d.tunName }
+
+// State reads the device's configuration back from wireguard-go.
+func (d *Device) State() (State, error) {
+	uapi, err := d.dev.IpcGet()
+	if err != nil {
+		return State{}, fmt.Errorf("embedded: read %s: %w", d.name, err)
+	}
+	return parseState(uapi)
+}
+
+// SetPeerEndpoint points the peer at endpoint; unknown peers are left alone.
+func (d *Device) SetPeerEndpoint(peer Key, endpoint netip.AddrPort) error {
+	if err := d.dev.IpcSet(peerEndpointUAPI(peer, endpoint)); err != nil {
+		return fmt.Errorf("embedded: set endpoint on %s: %w", d.name, err)
+	}
+	return nil
+}
+
+// Exchange sends a STUN request from the device's WireGuard socket, so the
+// mapping STUN reports is the one WireGuard traffic uses; it satisfies
+// stun.StunTransport.
+func (d *Device) Exchange(ctx context.Context, server netip.AddrPort, txnID [12]byte, packet []byte) ([]byte, error) {
+	return d.bind.Exchange(ctx, server, txnID, packet)
+}
+
+// Close closes the wireguard-go device, which closes its TUN and bind.
+func (d *Device) Close() {
+	d.dev.Close()
+}
+
+// deviceLogger routes wireguard-go's log lines to logger.
+func deviceLogger(logger zerolog.Logger) *device.Logger {
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) { logger.Debug().Msgf(format, args...) },
+		Errorf:   func(format string, args ...any) { logger.Error().Msgf(format, args...) },
+	}
+}
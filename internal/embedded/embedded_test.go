@@ -0,0 +1,254 @@
+package embedded
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// testKeyPair draws a private key, clamped as wireguard-go stores it, and
+// its public key.
+func testKeyPair(t *testing.T) (private, public Key) {
+	t.Helper()
+	if _, err := rand.Read(private[:]); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	private[0] &= 248
+	private[31] = private[31]&127 | 64
+	pub, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatalf("X25519: %v", err)
+	}
+	copy(public[:], pub)
+	return private, public
+}
+
+// commandRecorder is a Runner that records commands and fails those
+// starting with fail.
+type commandRecorder struct {
+	mu   sync.Mutex
+	cmds []string
+	fail string
+}
+
+func (r *commandRecorder) run(_ context.Context, name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmds = append(r.cmds, cmd)
+	if r.fail != "" && strings.HasPrefix(cmd, r.fail) {
+		return nil, errors.New("command failed")
+	}
+	return nil, nil
+}
+
+// newTestManager returns a manager whose devices run on in-memory TUNs,
+// handed out in order from tuns.
+func newTestManager(t *testing.T, runner *commandRecorder, tuns ...*tuntest.ChannelTUN) *Manager {
+	t.Helper()
+	logger := zerolog.Nop()
+	var mu sync.Mutex
+	newTUN := func(string, int) (tun.Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(tuns) == 0 {
+			return nil, errors.New("no TUN left")
+		}
+		next := tuns[0]
+		tuns = tuns[1:]
+		return next.TUN(), nil
+	}
+	m := NewManager(&logger, "linux", WithRunner(runner.run), WithTUN(newTUN))
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func TestManager_Up_RelaysBetweenDevices(t *testing.T) {
+	privA, pubA := testKeyPair(t)
+	privB, pubB := testKeyPair(t)
+	addrA, addrB := netip.MustParseAddr("10.99.0.1"), netip.MustParseAddr("10.99.0.2")
+	tunA, tunB := tuntest.NewChannelTUN(), tuntest.NewChannelTUN()
+	runner := &commandRecorder{}
+	m := newTestManager(t, runner, tunA, tunB)
+
+	a, err := m.Up(context.Background(), Interface{
+		Name:       "wg0",
+		PrivateKey: privA,
+		Addresses:  []netip.Prefix{netip.PrefixFrom(addrA, 24)},
+		Peers:      []Peer{{PublicKey: pubB, AllowedIPs: []netip.Prefix{netip.PrefixFrom(addrB, 32)}}},
+	})
+	if err != nil {
+		t.Fatalf("Up(wg0): %v", err)
+	}
+	b, err := m.Up(context.Background(), Interface{
+		Name:       "wg1",
+		PrivateKey: privB,
+		Peers:      []Peer{{PublicKey: pubA, AllowedIPs: []netip.Prefix{netip.PrefixFrom(addrA, 32)}}},
+	})
+	if err != nil {
+		t.Fatalf("Up(wg1): %v", err)
+	}
+
+	stateA, err := a.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if stateA.PrivateKey != privA || stateA.PublicKey != pubA || !slices.Equal(stateA.Peers, []Key{pubB}) {
+		t.Errorf("State = %+v, want wg0's keys and wg1 as its one peer", stateA)
+	}
+	if stateA.ListenPort == 0 {
+		t.Error("State.ListenPort = 0, want the port the bind opened")
+	}
+	stateB, err := b.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+
+	// Endpoints set after Up, the way establish sets discovered ones.
+	loopback := netip.MustParseAddr("127.0.0.1")
+	if err := a.SetPeerEndpoint(pubB, netip.AddrPortFrom(loopback, stateB.ListenPort)); err != nil {
+		t.Fatalf("SetPeerEndpoint: %v", err)
+	}
+	if err := b.SetPeerEndpoint(pubA, netip.AddrPortFrom(loopback, stateA.ListenPort)); err != nil {
+		t.Fatalf("SetPeerEndpoint: %v", err)
+	}
+
+	ping := tuntest.Ping(addrB, addrA)
+	select {
+	case tunA.Outbound <- ping:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wg0 did not read the packet from its TUN")
+	}
+	select {
+	case got := <-tunB.Inbound:
+		if !bytes.Equal(got, ping) {
+			t.Errorf("wg1 delivered %x, want %x", got, ping)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet did not come out of wg1's TUN")
+	}
+
+	// wg0's /32 route to wg1 is inside its /24, which routes it already.
+	want := []string{
+		"ip -4 address add 10.99.0.1/24 dev loopbackTun1",
+		"ip link set dev loopbackTun1 up",
+		"ip link set dev loopbackTun1 up",
+		"ip -4 route add 10.99.0.1/32 dev loopbackTun1",
+	}
+	if !slices.Equal(runner.cmds, want) {
+		t.Errorf("commands = %q, want %q", runner.cmds, want)
+	}
+}
+
+func TestManager_Up_FailsAndCleansUp(t *testing.T) {
+	priv, _ := testKeyPair(t)
+	_, peer := testKeyPair(t)
+	tun0, tun1 := tuntest.NewChannelTUN(), tuntest.NewChannelTUN()
+	runner := &commandRecorder{fail: "ip -4 address"}
+	m := newTestManager(t, runner, tun0, tun1)
+
+	iface := Interface{
+		Name:       "wg0",
+		PrivateKey: priv,
+		Addresses:  []netip.Prefix{netip.MustParsePrefix("10.99.0.1/24")},
+		Peers:      []Peer{{PublicKey: peer, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.98.0.0/16")}}},
+	}
+	if _, err := m.Up(context.Background(), iface); err == nil {
+		t.Fatal("Up succeeded although the address command failed")
+	}
+	if _, err := m.Get("wg0"); !errors.Is(err, ErrNotEmbedded) {
+		t.Errorf("Get after a failed Up = %v, want ErrNotEmbedded", err)
+	}
+	// A failing route costs only a warning.
+	runner.fail = "ip -4 route"
+	if _, err := m.Up(context.Background(), iface); err != nil {
+		t.Fatalf("Up with a failing route: %v", err)
+	}
+	if _, err := m.Up(context.Background(), iface); err == nil {
+		t.Error("second Up of wg0 succeeded, want an error")
+	}
+}
+
+// A slow address or route command must not hold up Get for the devices
+// already up.
+func TestManager_Up_CommandsDoNotBlockGet(t *testing.T) {
+	priv0, _ := testKeyPair(t)
+	priv1, _ := testKeyPair(t)
+	logger := zerolog.Nop()
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	block := false
+	runner := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if block {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		return nil, nil
+	}
+	m := NewManager(&logger, "linux", WithRunner(runner),
+		WithTUN(func(string, int) (tun.Device, error) { return tuntest.NewChannelTUN().TUN(), nil }))
+	defer m.Close()
+
+	addr := []netip.Prefix{netip.MustParsePrefix("10.99.0.1/24")}
+	if _, err := m.Up(context.Background(), Interface{Name: "wg0", PrivateKey: priv0, Addresses: addr}); err != nil {
+		t.Fatalf("Up(wg0): %v", err)
+	}
+	block = true
+	upped := make(chan error, 1)
+	go func() {
+		_, err := m.Up(context.Background(), Interface{Name: "wg1", PrivateKey: priv1, Addresses: addr})
+		upped <- err
+	}()
+	<-entered
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := m.Get("wg0")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Get(wg0) = %v, want the device", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get(wg0) blocked behind another interface's commands")
+	}
+	if _, err := m.Get("wg1"); !errors.Is(err, ErrNotEmbedded) {
+		t.Errorf("Get(wg1) while it is coming up = %v, want ErrNotEmbedded", err)
+	}
+	if _, err := m.Up(context.Background(), Interface{Name: "wg1", PrivateKey: priv1}); err == nil {
+		t.Error("Up(wg1) while it is coming up succeeded, want an error")
+	}
+
+	close(release)
+	if err := <-upped; err != nil {
+		t.Fatalf("Up(wg1): %v", err)
+	}
+	if _, err := m.Get("wg1"); err != nil {
+		t.Errorf("Get(wg1) = %v, want the device", err)
+	}
+}
+
+func TestManager_Up_UnsupportedPlatform(t *testing.T) {
+	logger := zerolog.Nop()
+	m := NewManager(&logger, "windows")
+	defer m.Close()
+	if _, err := m.Up(context.Background(), Interface{Name: "wg0"}); err == nil {
+		t.Error("Up on windows succeeded, want an error")
+	}
+}
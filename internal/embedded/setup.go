@@ -0,0 +1,110 @@
+package embedded
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Runner runs one command, returning its output or an error carrying its
+// stderr.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func defaultRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// supported reports whether interfaces can be embedded on goos.
+func supported(goos string) bool {
+	switch goos {
+	case "linux", "darwin", "freebsd":
+		return true
+	}
+	return false
+}
+
+// setupCommands returns the commands that configure the TUN device named
+// tunName for iface on goos, as wg-quick does: addrs assign the addresses
+// and bring the device up, routes send each peer's allowed IPs into it.
+// Linux skips allowed IPs an address's own prefix already routes; the BSDs'
+// point-to-point TUN devices route none of them.
+func setupCommands(goos, tunName string, iface Interface) (addrs, routes [][]string, err error) {
+	switch goos {
+	case "linux":
+		for _, addr := range iface.Addresses {
+			addrs = append(addrs, []string{"ip", familyFlag(addr), "address", "add", addr.String(), "dev", tunName})
+		}
+		addrs = append(addrs, []string{"ip", "link", "set", "dev", tunName, "up"})
+		for _, prefix := range allowedIPs(iface, true) {
+			routes = append(routes, []string{"ip", familyFlag(prefix), "route", "add", prefix.String(), "dev", tunName})
+		}
+	case "darwin", "freebsd":
+		for _, addr := range iface.Addresses {
+			switch {
+			case addr.Addr().Is6():
+				addrs = append(addrs, []string{"ifconfig", tunName, "inet6", addr.String(), "alias"})
+			case goos == "darwin":
+				// utun is point-to-point and needs a destination; its own
+				// address will do.
+				addrs = append(addrs, []string{"ifconfig", tunName, "inet", addr.String(), addr.Addr().String(), "alias"})
+			default:
+				addrs = append(addrs, []string{"ifconfig", tunName, "inet", addr.String(), "alias"})
+			}
+		}
+		addrs = append(addrs, []string{"ifconfig", tunName, "up"})
+		for _, prefix := range allowedIPs(iface, false) {
+			family := "-inet"
+			if prefix.Addr().Is6() {
+				family = "-inet6"
+			}
+			routes = append(routes, []string{"route", "-q", "-n", "add", family, prefix.String(), "-interface", tunName})
+		}
+	default:
+		return nil, nil, fmt.Errorf("embedded: not supported on %s", goos)
+	}
+	return addrs, routes, nil
+}
+
+// familyFlag is ip(8)'s family flag for p.
+func familyFlag(p netip.Prefix) string {
+	if p.Addr().Is6() {
+		return "-6"
+	}
+	return "-4"
+}
+
+// allowedIPs collects the peers' allowed IPs once each, masked, leaving out
+// those inside an address's prefix when connected says that prefix is
+// routed already.
+func allowedIPs(iface Interface, connected bool) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, p := range iface.Peers {
+		for _, prefix := range p.AllowedIPs {
+			prefix = prefix.Masked()
+			if slices.Contains(prefixes, prefix) {
+				continue
+			}
+			if connected && slices.ContainsFunc(iface.Addresses, func(addr netip.Prefix) bool {
+				return addr.Bits() <= prefix.Bits() && addr.Contains(prefix.Addr())
+			}) {
+				continue
+			}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,134 @@
+package embedded
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetupCommands(t *testing.T) {
+	iface := Interface{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/24"), netip.MustParsePrefix("fd00::1/64")},
+		Peers: []Peer{
+			{AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32"), netip.MustParsePrefix("192.168.1.7/24")}},
+			{AllowedIPs: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("fd01::/64")}},
+		},
+	}
+	tests := []struct {
+		goos          string
+		tunName       string
+		addrs, routes []string
+	}{
+		{
+			goos:    "linux",
+			tunName: "wg0",
+			addrs: []string{
+				"ip -4 address add 10.0.0.1/24 dev wg0",
+				"ip -6 address add fd00::1/64 dev wg0",
+				"ip link set dev wg0 up",
+			},
+			routes: []string{
+				"ip -4 route add 192.168.1.0/24 dev wg0",
+				"ip -6 route add fd01::/64 dev wg0",
+			},
+		},
+		{
+			goos:    "darwin",
+			tunName: "utun7",
+			addrs: []string{
+				"ifconfig utun7 inet 10.0.0.1/24 10.0.0.1 alias",
+				"ifconfig utun7 inet6 fd00::1/64 alias",
+				"ifconfig utun7 up",
+			},
+			routes: []string{
+				"route -q -n add -inet 10.0.0.2/32 -interface utun7",
+				"route -q -n add -inet 192.168.1.0/24 -interface utun7",
+				"route -q -n add -inet6 fd01::/64 -interface utun7",
+			},
+		},
+		{
+			goos:    "freebsd",
+			tunName: "tun0",
+			addrs: []string{
+				"ifconfig tun0 inet 10.0.0.1/24 alias",
+				"ifconfig tun0 inet6 fd00::1/64 alias",
+				"ifconfig tun0 up",
+			},
+			routes: []string{
+				"route -q -n add -inet 10.0.0.2/32 -interface tun0",
+				"route -q -n add -inet 192.168.1.0/24 -interface tun0",
+				"route -q -n add -inet6 fd01::/64 -interface tun0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			addrs, routes, err := setupCommands(tt.goos, tt.tunName, iface)
+			if err != nil {
+				t.Fatalf("setupCommands: %v", err)
+			}
+			if got := joinCommands(addrs); !slices.Equal(got, tt.addrs) {
+				t.Errorf("address commands = %q, want %q", got, tt.addrs)
+			}
+			if got := joinCommands(routes); !slices.Equal(got, tt.routes) {
+				t.Errorf("route commands = %q, want %q", got, tt.routes)
+			}
+		})
+	}
+
+	if _, _, err := setupCommands("windows", "wg0", iface); err == nil {
+		t.Error("setupCommands(windows) succeeded, want an error")
+	}
+}
+
+func joinCommands(cmds [][]string) []string {
+	var out []string
+	for _, cmd := range cmds {
+		out = append(out, strings.Join(cmd, " "))
+	}
+	return out
+}
+
+func TestBuildUAPI(t *testing.T) {
+	iface := Interface{
+		PrivateKey: Key{0x01},
+		ListenPort: 51820,
+		Peers: []Peer{
+			{
+				PublicKey:           Key{0x02},
+				PresharedKey:        Key{0x03},
+				AllowedIPs:          []netip.Prefix{netip.MustParsePrefix("10.0.0.2/24")},
+				Endpoint:            netip.MustParseAddrPort("[2001:db8::1]:51820"),
+				PersistentKeepalive: 25,
+			},
+			{PublicKey: Key{0x04}},
+		},
+	}
+	want := "private_key=01" + strings.Repeat("00", 31) + "\n" +
+		"listen_port=51820\n" +
+		"replace_peers=true\n" +
+		"public_key=02" + strings.Repeat("00", 31) + "\n" +
+		"preshared_key=03" + strings.Repeat("00", 31) + "\n" +
+		"endpoint=[2001:db8::1]:51820\n" +
+		"persistent_keepalive_interval=25\n" +
+		"replace_allowed_ips=true\n" +
+		"allowed_ip=10.0.0.0/24\n" +
+		"public_key=04" + strings.Repeat("00", 31) + "\n" +
+		"replace_allowed_ips=true\n"
+	if got := buildUAPI(iface); got != want {
+		t.Errorf("buildUAPI =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseState_RejectsBadValues(t *testing.T) {
+	for _, uapi := range []string{
+		"private_key=zz\n",
+		"listen_port=70000\n",
+		"public_key=0102\n",
+	} {
+		if _, err := parseState(uapi); err == nil {
+			t.Errorf("parseState(%q) succeeded, want an error", uapi)
+		}
+	}
+}
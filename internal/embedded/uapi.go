@@ -0,0 +1,97 @@
+package embedded
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// State is an embedded device's configuration as wireguard-go reports it.
+type State struct {
+	PrivateKey Key
+	PublicKey  Key
+	// ListenPort is the port the bind actually opened.
+	ListenPort uint16
+	Peers      []Key
+}
+
+// buildUAPI renders iface as a wireguard-go IpcSet string, replacing every
+// peer.
+func buildUAPI(iface Interface) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(iface.PrivateKey[:]))
+	fmt.Fprintf(&b, "listen_port=%d\n", iface.ListenPort)
+	b.WriteString("replace_peers=true\n")
+	for _, p := range iface.Peers {
+		fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(p.PublicKey[:]))
+		if p.PresharedKey != (Key{}) {
+			fmt.Fprintf(&b, "preshared_key=%s\n", hex.EncodeToString(p.PresharedKey[:]))
+		}
+		if p.Endpoint.IsValid() {
+			fmt.Fprintf(&b, "endpoint=%s\n", p.Endpoint)
+		}
+		if p.PersistentKeepalive > 0 {
+			fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", p.PersistentKeepalive)
+		}
+		b.WriteString("replace_allowed_ips=true\n")
+		for _, prefix := range p.AllowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", prefix.Masked())
+		}
+	}
+	return b.String()
+}
+
+// peerEndpointUAPI renders the run-time endpoint update for one peer;
+// update_only keeps it from creating a peer the config does not have.
+func peerEndpointUAPI(peer Key, endpoint netip.AddrPort) string {
+	return fmt.Sprintf("public_key=%s\nupdate_only=true\nendpoint=%s\n", hex.EncodeToString(peer[:]), endpoint)
+}
+
+// parseState reads the IpcGet output keys State needs, skipping the rest.
+func parseState(uapi string) (State, error) {
+	var s State
+	for line := range strings.Lines(uapi) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "private_key":
+			if err := decodeKey(value, &s.PrivateKey); err != nil {
+				return State{}, err
+			}
+			public, err := curve25519.X25519(s.PrivateKey[:], curve25519.Basepoint)
+			if err != nil {
+				return State{}, fmt.Errorf("embedded: derive public key: %w", err)
+			}
+			copy(s.PublicKey[:], public)
+		case "listen_port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return State{}, fmt.Errorf("embedded: invalid listen_port %q", value)
+			}
+			s.ListenPort = uint16(port)
+		case "public_key":
+			var peer Key
+			if err := decodeKey(value, &peer); err != nil {
+				return State{}, err
+			}
+			s.Peers = append(s.Peers, peer)
+		}
+	}
+	return s, nil
+}
+
+// decodeKey decodes a hex UAPI key into k.
+func decodeKey(value string, k *Key) error {
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) != len(k) {
+		return fmt.Errorf("embedded: invalid key %q", value)
+	}
+	copy(k[:], b)
+	return nil
+}
@@ -1,5 +1,3 @@
-//go:build mobile
-
 package mobilebind
 
 import (
@@ -50,27 +48,39 @@ func (b *Bind) Discover(ctx context.Context, dst netip.AddrPort) (netip.AddrPort
 	if err != nil {
 		return netip.AddrPort{}, err
 	}
-	ch := b.registry.Register(txn)
-	defer b.registry.Unregister(txn)
+	resp, err := b.Exchange(ctx, dst, txn, req)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return parseBindingResponse(resp, txn)
+}
+
+// Exchange sends a caller-built STUN request to dst from the shared WG
+// socket, retransmitting on the RFC 8489 schedule, and returns the raw
+// response demuxed for txnID. It lets the desktop STUN client (see
+// stun.StunTransport) ride the bind the way Discover does.
+func (b *Bind) Exchange(ctx context.Context, dst netip.AddrPort, txnID [12]byte, packet []byte) ([]byte, error) {
+	ch := b.registry.Register(txnID)
+	defer b.registry.Unregister(txnID)
 
 	rto := stunInitialRTO
 	for attempt := 0; attempt < stunMaxRetries; attempt++ {
-		if err := b.SendTo(dst, req); err != nil {
-			return netip.AddrPort{}, fmt.Errorf("stun: send: %w", err)
+		if err := b.SendTo(dst, packet); err != nil {
+			return nil, fmt.Errorf("stun: send: %w", err)
 		}
 		timer := time.NewTimer(rto)
 		select {
 		case resp := <-ch:
 			timer.Stop()
-			return parseBindingResponse(resp, txn)
+			return resp, nil
 		case <-ctx.Done():
 			timer.Stop()
-			return netip.AddrPort{}, ctx.Err()
+			return nil, ctx.Err()
 		case <-timer.C:
 			rto *= 2
 		}
 	}
-	return netip.AddrPort{}, ErrStunTimeout
+	return nil, ErrStunTimeout
 }
 
 func buildBindingRequest() ([]byte, TxnID, error) {
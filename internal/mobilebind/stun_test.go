@@ -1,11 +1,15 @@
-//go:build mobile
-
 package mobilebind
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"net"
 	"net/netip"
 	"testing"
+	"time"
+
+	wgconn "golang.zx2c4.com/wireguard/conn"
 )
 
 func xorMappedV4(t *testing.T, addr netip.AddrPort) []byte {
@@ -105,3 +109,49 @@ func TestParseBindingResponseRejects(t *testing.T) {
 		t.Error("accepted response without mapped address")
 	}
 }
+
+func TestBindExchange(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	txn := TxnID{7}
+	resp := stunPacket(t, stunBindingSuccess, txn, xorMappedV4(t, netip.MustParseAddrPort("198.51.100.9:4242")))
+	go func() {
+		buf := make([]byte, 1500)
+		n, from, err := server.ReadFromUDPAddrPort(buf)
+		if err != nil || !IsSTUN(buf[:n]) {
+			return
+		}
+		// A WireGuard-shaped packet first: the demux must not hand it over.
+		_, _ = server.WriteToUDPAddrPort([]byte{4, 0, 0, 0}, from)
+		_, _ = server.WriteToUDPAddrPort(resp, from)
+	}()
+
+	b := New(nil)
+	fns, _, err := b.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	go func() {
+		bufs, sizes, eps := [][]byte{make([]byte, 1500)}, make([]int, 1), make([]wgconn.Endpoint, 1)
+		for {
+			if _, err := fns[0](bufs, sizes, eps); err != nil {
+				return
+			}
+		}
+	}()
+
+	req := stunPacket(t, stunBindingRequest, txn, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.Exchange(ctx, server.LocalAddr().(*net.UDPAddr).AddrPort(), txn, req)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if !bytes.Equal(got, resp) {
+		t.Errorf("Exchange = %x, want %x", got, resp)
+	}
+}
@@ -3,20 +3,17 @@
 package cloudflare
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"sync"
-
+	"net/url"
+	"sort"
+	"strconv"
 	"time"
 
-	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
-	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
-
 	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
@@ -28,27 +25,39 @@ func init() {
 const (
 	cfAPI = "https://api.cloudflare.com/client/v4"
 
+	// The TTLs Cloudflare accepts on any plan, in seconds. Records written
+	// without one get "automatic", which Cloudflare spells 1.
+	cfMinTTL = 60
+	cfMaxTTL = 86400
+
+	// cfListPageSize is how many records one list request asks for.
+	cfListPageSize = 1000
+
 	// Configuration keys
 	configKeyZoneName  = "zone"
 	configKeyAPIToken  = "token"
 	configKeySubdomain = "subdomain"
 )
 
-// CloudflarePlugin implements the Store interface
+// CloudflarePlugin is the Cloudflare DNS API as a builtin.RecordProvider
 type CloudflarePlugin struct {
-	token     string
-	zoneID    string
-	zoneOnce  sync.Mutex
-	zoneName  string
-	subdomain string
-	client    *http.Client
+	api      *builtin.JSONAPI
+	zoneName string
+	zoneID   *builtin.Lazy[string]
 }
 
-// Minimal JSON response structures (only fields we need)
+// Minimal JSON response structures (only fields we need). Result is a list
+// for lookups and a single object for writes, so it is decoded by the caller.
 type cfResponse struct {
-	Success bool              `json:"success"`
-	Result  []json.RawMessage `json:"result,omitempty"`
-	Errors  []cfError         `json:"errors,omitempty"`
+	Success    bool            `json:"success"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	ResultInfo *cfResultInfo   `json:"result_info,omitempty"`
+	Errors     []cfError       `json:"errors,omitempty"`
+}
+
+type cfResultInfo struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
 }
 
 type cfError struct {
@@ -56,6 +65,22 @@ type cfError struct {
 	Message string `json:"message"`
 }
 
+type cfRecord struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	TTL        int    `json:"ttl"`
+	ModifiedOn string `json:"modified_on"`
+}
+
+// cloudflareStore is the shared TXTStore plus what Cloudflare's API also
+// offers: deleting records, listing them, giving them a TTL, telling
+// whether one changed, and reading or writing them all at once.
+type cloudflareStore struct {
+	*builtin.TXTStore
+	api *CloudflarePlugin
+}
+
 // NewCloudflarePlugin creates a new Cloudflare plugin instance
 func NewCloudflarePlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
 	cfg := builtin.NewConfig(config)
@@ -72,204 +97,380 @@ func NewCloudflarePlugin(config pluginapi.PluginConfig) (pluginapi.Store, error)
 
 	subdomain, _ := cfg.GetString(configKeySubdomain)
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		// Through the shared dialer so the request escapes a covering tunnel
-		// route instead of being carried into the tunnel it is meant to bring
-		// up. See internal/plugin/dialer.
-		Transport: dialer.Transport(),
+	p := newCloudflarePlugin(cfAPI, token, zoneName)
+	return newCloudflareStore(p, zoneName, subdomain), nil
+}
+
+func newCloudflareStore(p *CloudflarePlugin, zoneName, subdomain string) *cloudflareStore {
+	return &cloudflareStore{
+		TXTStore: builtin.NewRecordStore("cloudflare", p, zoneName, subdomain),
+		api:      p,
 	}
+}
 
+func newCloudflarePlugin(baseURL, token, zoneName string) *CloudflarePlugin {
 	p := &CloudflarePlugin{
-		token:     token,
-		zoneName:  zoneName,
-		subdomain: subdomain,
-		client:    client,
-	}
-
-	// The zone lookup is deferred to the first Get or Set rather than done
-	// here. Plugins are constructed before the device is read, so a lookup
-	// here has no fwmark to escape a covering tunnel with, and it would also
-	// make stunmesh refuse to start whenever Cloudflare is briefly
-	// unreachable.
-	return p, nil
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Authorization": {"Bearer " + token}},
+			Client:  builtin.NewHTTPClient(10 * time.Second),
+		},
+		zoneName: zoneName,
+	}
+	p.zoneID = builtin.NewLazy(p.getZoneID)
+	return p
 }
 
-// ensureZoneID resolves the zone once, with the caller's context so the
-// request carries whatever escape that context asks for.
-func (p *CloudflarePlugin) ensureZoneID(ctx context.Context) error {
-	p.zoneOnce.Lock()
-	defer p.zoneOnce.Unlock()
-	if p.zoneID != "" {
+// do sends a request and unwraps Cloudflare's response envelope. A delete
+// answers with the bare result and no success flag, so only a reported
+// error counts as failure.
+func (p *CloudflarePlugin) do(ctx context.Context, method, path string, body interface{}) (*cfResponse, error) {
+	var resp cfResponse
+	if err := p.api.Do(ctx, method, path, body, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success && len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("API error: %v", resp.Errors)
+	}
+	return &resp, nil
+}
+
+// decodeResult decodes a lookup's result list, treating an absent one as
+// empty.
+func decodeResult(resp *cfResponse, out interface{}) error {
+	if len(resp.Result) == 0 {
 		return nil
 	}
-	zoneID, err := p.getZoneID(ctx)
+	return json.Unmarshal(resp.Result, out)
+}
+
+func (p *CloudflarePlugin) getZoneID(ctx context.Context) (string, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(p.zoneName), nil)
 	if err != nil {
-		return fmt.Errorf("failed to get zone ID: %w", err)
+		return "", fmt.Errorf("failed to get zone ID: %w", err)
+	}
+
+	var zones []struct {
+		ID string `json:"id"`
+	}
+	if err := decodeResult(resp, &zones); err != nil {
+		return "", err
 	}
-	p.zoneID = zoneID
-	return nil
-}
 
-func (p *CloudflarePlugin) doRequest(ctx context.Context, method, path string, body []byte) ([]byte, error) {
-	url := cfAPI + path
+	if len(zones) == 0 {
+		return "", fmt.Errorf("failed to get zone ID: zone not found: %s", p.zoneName)
+	}
 
-	var bodyReader io.Reader
-	if body != nil {
-		bodyReader = bytes.NewReader(body)
+	return zones[0].ID, nil
+}
+
+// listRecords returns the TXT records at name, with their content unquoted
+func (p *CloudflarePlugin) listRecords(ctx context.Context, name string) ([]cfRecord, error) {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	query := url.Values{"type": {"TXT"}, "name": {name}}
+	resp, err := p.do(ctx, http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?%s", zoneID, query.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+p.token)
-	req.Header.Set("Content-Type", "application/json")
+	var found []cfRecord
+	if err := decodeResult(resp, &found); err != nil {
+		return nil, err
+	}
+
+	for i := range found {
+		// Cloudflare may report the content in presentation form.
+		found[i].Content = builtin.UnquoteTXT(found[i].Content)
+	}
+	return found, nil
+}
 
-	resp, err := p.client.Do(req)
+// ListTXT returns the TXT records at name
+func (p *CloudflarePlugin) ListTXT(ctx context.Context, name string) ([]builtin.TXTRecord, error) {
+	found, err := p.listRecords(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	data, err := io.ReadAll(resp.Body)
+	records := make([]builtin.TXTRecord, 0, len(found))
+	for _, record := range found {
+		records = append(records, builtin.TXTRecord{ID: record.ID, Value: record.Content})
+	}
+	return records, nil
+}
+
+// listRecordsUnder returns every TXT record under domain, with their content
+// unquoted, a page at a time.
+func (p *CloudflarePlugin) listRecordsUnder(ctx context.Context, domain string) ([]cfRecord, error) {
+	zoneID, err := p.zoneID.Get(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(data))
+	var records []cfRecord
+	for page := 1; ; page++ {
+		query := url.Values{
+			"type":          {"TXT"},
+			"name.endswith": {"." + domain},
+			"per_page":      {strconv.Itoa(cfListPageSize)},
+			"page":          {strconv.Itoa(page)},
+		}
+		resp, err := p.do(ctx, http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?%s", zoneID, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []cfRecord
+		if err := decodeResult(resp, &found); err != nil {
+			return nil, err
+		}
+		for _, record := range found {
+			record.Content = builtin.UnquoteTXT(record.Content)
+			records = append(records, record)
+		}
+
+		if resp.ResultInfo == nil || page >= resp.ResultInfo.TotalPages || len(found) == 0 {
+			return records, nil
+		}
 	}
+}
 
-	return data, nil
+// CreateTXT adds a TXT record at name
+func (p *CloudflarePlugin) CreateTXT(ctx context.Context, name, value string) error {
+	return p.createRecord(ctx, name, value, 0)
 }
 
-func (p *CloudflarePlugin) getZoneID(ctx context.Context) (string, error) {
-	data, err := p.doRequest(ctx, "GET", "/zones?name="+p.zoneName, nil)
+// createRecord adds a TXT record at name; a zero ttl leaves it automatic.
+func (p *CloudflarePlugin) createRecord(ctx context.Context, name, value string, ttl int) error {
+	zoneID, err := p.zoneID.Get(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	var resp cfResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return "", err
-	}
+	body := struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Content string `json:"content"`
+		TTL     int    `json:"ttl,omitempty"`
+		Comment string `json:"comment"`
+	}{Type: "TXT", Name: name, Content: value, TTL: ttl, Comment: "Stunmesh"}
+	_, err = p.do(ctx, http.MethodPost, fmt.Sprintf("/zones/%s/dns_records", zoneID), body)
+	return err
+}
+
+// UpdateTXT replaces the content of an existing record
+func (p *CloudflarePlugin) UpdateTXT(ctx context.Context, _ string, record builtin.TXTRecord, value string) error {
+	return p.updateRecord(ctx, record.ID, value, 0)
+}
 
-	if !resp.Success || len(resp.Result) == 0 {
-		return "", fmt.Errorf("zone not found: %s", p.zoneName)
+// updateRecord replaces the content of an existing record, and its TTL
+// unless ttl is zero.
+func (p *CloudflarePlugin) updateRecord(ctx context.Context, id, value string, ttl int) error {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Extract ID from first result
-	var result struct {
-		ID string `json:"id"`
+	body := struct {
+		Content string `json:"content"`
+		TTL     int    `json:"ttl,omitempty"`
+	}{Content: value, TTL: ttl}
+	_, err = p.do(ctx, http.MethodPatch, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, id), body)
+	return err
+}
+
+// DeleteTXT removes an existing record
+func (p *CloudflarePlugin) DeleteTXT(ctx context.Context, _ string, record builtin.TXTRecord) error {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return err
 	}
-	if err := json.Unmarshal(resp.Result[0], &result); err != nil {
-		return "", err
+
+	_, err = p.do(ctx, http.MethodDelete, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, record.ID), nil)
+	return err
+}
+
+// SetWithTTL stores a value like Set, giving the record ttl, rounded up to
+// whole seconds and kept within what Cloudflare accepts.
+func (s *cloudflareStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to builtin cloudflare plugin")
+
+	if err := builtin.CheckKey(key); err != nil {
+		return err
 	}
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	seconds = min(max(seconds, cfMinTTL), cfMaxTTL)
 
-	return result.ID, nil
+	name := s.RecordName(key)
+	existing, err := s.api.listRecords(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, name, existing, value, seconds)
 }
 
-func (p *CloudflarePlugin) getRecordName(key string) string {
-	// Key is already SHA1 hex from stunmesh
-	if p.subdomain != "" {
-		return fmt.Sprintf("%s.%s.%s", key, p.subdomain, p.zoneName)
+// write leaves value as the only record at name, given the records there
+// now. As Set does, the first record is kept and updated, the rest deleted.
+// A zero ttl leaves the record's TTL as it is.
+func (s *cloudflareStore) write(ctx context.Context, name string, existing []cfRecord, value string, ttl int) error {
+	if len(existing) == 0 {
+		return s.api.createRecord(ctx, name, value, ttl)
+	}
+
+	if existing[0].Content != value || (ttl != 0 && existing[0].TTL != ttl) {
+		if err := s.api.updateRecord(ctx, existing[0].ID, value, ttl); err != nil {
+			return err
+		}
 	}
-	return fmt.Sprintf("%s.%s", key, p.zoneName)
+	for _, record := range existing[1:] {
+		if err := s.api.DeleteTXT(ctx, name, builtin.TXTRecord{ID: record.ID}); err != nil {
+			return fmt.Errorf("failed to delete duplicate record: %w", err)
+		}
+	}
+	return nil
 }
 
-func (p *CloudflarePlugin) findRecord(ctx context.Context, name string) (string, string, error) {
-	path := fmt.Sprintf("/zones/%s/dns_records?type=TXT&name=%s", p.zoneID, name)
-	data, err := p.doRequest(ctx, "GET", path, nil)
+// recordsByKey lists every record under the store's domain in one go,
+// grouped by the key they are the record of.
+func (s *cloudflareStore) recordsByKey(ctx context.Context) (map[string][]cfRecord, error) {
+	records, err := s.api.listRecordsUnder(ctx, s.Domain())
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	var resp cfResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return "", "", err
+	byKey := make(map[string][]cfRecord)
+	for _, record := range records {
+		if key, ok := s.KeyOf(record.Name); ok {
+			byKey[key] = append(byKey[key], record)
+		}
+	}
+	return byKey, nil
+}
+
+// GetMany reads the records of keys with one listing of the store's domain,
+// rather than a lookup per key.
+func (s *cloudflareStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(keys)).Msg("get many data from builtin cloudflare plugin")
+
+	byKey, err := s.recordsByKey(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if !resp.Success || len(resp.Result) == 0 {
-		return "", "", nil // Not found
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		// As Get does, the first of several records is used.
+		if records := byKey[key]; len(records) > 0 && records[0].Content != "" {
+			values[key] = records[0].Content
+		}
 	}
+	return values, nil
+}
 
-	var record struct {
-		ID      string `json:"id"`
-		Content string `json:"content"`
+// SetMany writes records after one listing of the store's domain, so only
+// the records that change cost a call each.
+func (s *cloudflareStore) SetMany(ctx context.Context, records map[string]string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(records)).Msg("set many data to builtin cloudflare plugin")
+
+	for key := range records {
+		if err := builtin.CheckKey(key); err != nil {
+			return err
+		}
 	}
-	if err := json.Unmarshal(resp.Result[0], &record); err != nil {
-		return "", "", err
+	byKey, err := s.recordsByKey(ctx)
+	if err != nil {
+		return err
 	}
 
-	return record.ID, record.Content, nil
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := s.write(ctx, s.RecordName(key), byKey[key], records[key], 0); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Get retrieves a value from Cloudflare DNS
-func (p *CloudflarePlugin) Get(ctx context.Context, key string) (string, error) {
+// GetIfModified reads key's record like Get. Its revision is the record's
+// ID and modification time, and sets no expiry: the record's TTL is for
+// resolvers, and far longer than peers should wait for a new endpoint, so
+// a cache in front of the store holds it only as long as its max_age.
+func (s *cloudflareStore) GetIfModified(ctx context.Context, key string, rev pluginapi.Revision) (string, pluginapi.Revision, error) {
 	logger := zerolog.Ctx(ctx)
 	logger.Info().Str("key", key).Msg("get data from builtin cloudflare plugin")
 
-	if err := p.ensureZoneID(ctx); err != nil {
-		return "", err
+	if err := builtin.CheckKey(key); err != nil {
+		return "", pluginapi.Revision{}, err
 	}
-
-	name := p.getRecordName(key)
-	_, content, err := p.findRecord(ctx, name)
+	name := s.RecordName(key)
+	records, err := s.api.listRecords(ctx, name)
 	if err != nil {
-		return "", err
+		return "", pluginapi.Revision{}, err
 	}
-	if content == "" {
-		return "", fmt.Errorf("record not found: %s", name)
+	txt := make([]builtin.TXTRecord, 0, len(records))
+	modifiedOn := make(map[string]string, len(records))
+	for _, record := range records {
+		txt = append(txt, builtin.TXTRecord{ID: record.ID, Value: record.Content})
+		modifiedOn[record.ID] = record.ModifiedOn
 	}
-	return content, nil
+	record, err := builtin.FirstRecord(ctx, name, txt)
+	if err != nil {
+		return "", pluginapi.Revision{}, err
+	}
+
+	current := pluginapi.Revision{Tag: record.ID + "@" + modifiedOn[record.ID]}
+	if rev.Tag == current.Tag {
+		return "", current, pluginapi.ErrNotModified
+	}
+	return record.Value, current, nil
 }
 
-// Set stores a value in Cloudflare DNS
-func (p *CloudflarePlugin) Set(ctx context.Context, key string, value string) error {
+// Delete removes every TXT record for key
+func (s *cloudflareStore) Delete(ctx context.Context, key string) error {
 	logger := zerolog.Ctx(ctx)
-	logger.Info().Str("key", key).Msg("set data to builtin cloudflare plugin")
+	logger.Info().Str("key", key).Msg("delete data from builtin cloudflare plugin")
 
-	if err := p.ensureZoneID(ctx); err != nil {
+	if err := builtin.CheckKey(key); err != nil {
 		return err
 	}
-
-	name := p.getRecordName(key)
-	recordID, existingContent, err := p.findRecord(ctx, name)
+	name := s.RecordName(key)
+	records, err := s.api.ListTXT(ctx, name)
 	if err != nil {
 		return err
 	}
-
-	// Skip if content unchanged
-	if existingContent == value {
-		return nil
-	}
-
-	if recordID != "" {
-		// Update existing record
-		body, err := json.Marshal(struct {
-			Content string `json:"content"`
-		}{Content: value})
-		if err != nil {
+	for _, record := range records {
+		if err := s.api.DeleteTXT(ctx, name, record); err != nil {
 			return err
 		}
-		path := fmt.Sprintf("/zones/%s/dns_records/%s", p.zoneID, recordID)
-		_, err = p.doRequest(ctx, "PATCH", path, body)
-		return err
 	}
+	return nil
+}
 
-	// Create new record
-	body, err := json.Marshal(struct {
-		Type    string `json:"type"`
-		Name    string `json:"name"`
-		Content string `json:"content"`
-		Comment string `json:"comment"`
-	}{Type: "TXT", Name: name, Content: value, Comment: "Stunmesh"})
+// List returns the key of every record under the store's domain
+func (s *cloudflareStore) List(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("list keys from builtin cloudflare plugin")
+
+	byKey, err := s.recordsByKey(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	path := fmt.Sprintf("/zones/%s/dns_records", p.zoneID)
-	_, err = p.doRequest(ctx, "POST", path, body)
-	return err
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
@@ -3,9 +3,22 @@
 package cloudflare
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
 )
 
 func TestNewCloudflarePlugin_MissingZone(t *testing.T) {
@@ -42,104 +55,404 @@ func TestNewCloudflarePlugin_MissingToken(t *testing.T) {
 	}
 }
 
-func TestGetRecordName_WithSubdomain(t *testing.T) {
-	p := &CloudflarePlugin{
-		zoneName:  "example.com",
-		subdomain: "stunmesh",
+// fakeCloudflare serves the slice of the v4 API the plugin uses, for one
+// zone, keeping records in memory.
+type fakeCloudflare struct {
+	mu        sync.Mutex
+	records   map[string]cfRecordEntry
+	nextID    int
+	zoneCalls int
+	lists     int
+	writes    int
+}
+
+type cfRecordEntry struct {
+	Name     string
+	Content  string
+	TTL      int
+	Modified int
+}
+
+func newFakeCloudflare(t *testing.T) (*fakeCloudflare, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeCloudflare{records: make(map[string]cfRecordEntry)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeCloudflare) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	reply := func(result interface{}) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
 	}
 
-	key := "abc123"
-	expected := "abc123.stunmesh.example.com"
-	got := p.getRecordName(key)
+	switch {
+	case r.URL.Path == "/zones":
+		f.zoneCalls++
+		if r.URL.Query().Get("name") != "example.com" {
+			reply([]interface{}{})
+			return
+		}
+		reply([]map[string]string{{"id": "zone1"}})
+
+	case r.URL.Path == "/zones/zone1/dns_records" && r.Method == http.MethodGet:
+		f.lists++
+		query := r.URL.Query()
+		ids := make([]string, 0, len(f.records))
+		for id := range f.records {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
 
-	if got != expected {
-		t.Errorf("getRecordName() = %q, want %q", got, expected)
+		var found []cfRecord
+		for _, id := range ids {
+			record := f.records[id]
+			if name := query.Get("name"); name != "" && record.Name != name {
+				continue
+			}
+			if suffix := query.Get("name.endswith"); suffix != "" && !strings.HasSuffix(record.Name, suffix) {
+				continue
+			}
+			found = append(found, cfRecord{ID: id, Name: record.Name, Content: record.Content, TTL: record.TTL, ModifiedOn: strconv.Itoa(record.Modified)})
+		}
+
+		// Page the way the real API does when asked to.
+		perPage, _ := strconv.Atoi(query.Get("per_page"))
+		if perPage == 0 {
+			reply(found)
+			return
+		}
+		page, _ := strconv.Atoi(query.Get("page"))
+		totalPages := (len(found) + perPage - 1) / perPage
+		start := min((page-1)*perPage, len(found))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"result":      found[start:min(start+perPage, len(found))],
+			"result_info": cfResultInfo{Page: page, TotalPages: totalPages},
+		})
+
+	case r.URL.Path == "/zones/zone1/dns_records" && r.Method == http.MethodPost:
+		var body struct {
+			Name    string `json:"name"`
+			Content string `json:"content"`
+			TTL     int    `json:"ttl"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.TTL == 0 {
+			body.TTL = 1
+		}
+		f.nextID++
+		id := fmt.Sprintf("rec%d", f.nextID)
+		f.records[id] = cfRecordEntry{Name: body.Name, Content: body.Content, TTL: body.TTL}
+		f.writes++
+		reply(map[string]string{"id": id})
+
+	case strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/"):
+		id := strings.TrimPrefix(r.URL.Path, "/zones/zone1/dns_records/")
+		record, ok := f.records[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.writes++
+		switch r.Method {
+		case http.MethodPatch:
+			var body struct {
+				Content string `json:"content"`
+				TTL     int    `json:"ttl"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			record.Content = body.Content
+			record.Modified++
+			if body.TTL != 0 {
+				record.TTL = body.TTL
+			}
+			f.records[id] = record
+		case http.MethodDelete:
+			delete(f.records, id)
+			// The real API answers a delete without a success flag.
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]string{"id": id}})
+			return
+		}
+		reply(map[string]string{"id": id})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-func TestGetRecordName_WithoutSubdomain(t *testing.T) {
-	p := &CloudflarePlugin{
-		zoneName:  "example.com",
-		subdomain: "",
+func newTestStore(url string) *cloudflareStore {
+	return newCloudflareStore(newCloudflarePlugin(url, "test-token", "example.com"), "example.com", "wg")
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		_, server := newFakeCloudflare(t)
+		return newTestStore(server.URL)
+	})
+}
+
+func TestSetCreatesThenUpdates(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	if err := store.Set(ctx, "abc123", "first"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := store.Set(ctx, "abc123", "second"); err != nil {
+		t.Fatalf("Set() error = %v", err)
 	}
 
-	key := "abc123"
-	expected := "abc123.example.com"
-	got := p.getRecordName(key)
+	got, err := store.Get(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != "second" {
+		t.Errorf("Get() = %q, want %q", got, "second")
+	}
+	if len(fake.records) != 1 {
+		t.Errorf("fake holds %d records, want 1", len(fake.records))
+	}
+	if fake.records["rec1"].Name != "abc123.wg.example.com" {
+		t.Errorf("record name = %q, want abc123.wg.example.com", fake.records["rec1"].Name)
+	}
 
-	if got != expected {
-		t.Errorf("getRecordName() = %q, want %q", got, expected)
+	// The zone is resolved once and then reused.
+	if fake.zoneCalls != 1 {
+		t.Errorf("zone looked up %d times, want 1", fake.zoneCalls)
 	}
 }
 
-func TestGetRecordName_SpecialCharacters(t *testing.T) {
-	p := &CloudflarePlugin{
-		zoneName:  "example.com",
-		subdomain: "test",
+func TestSetUnchangedSkipsWrite(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := store.Set(ctx, "abc123", "same"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
 	}
 
-	tests := []struct {
-		name     string
-		key      string
-		expected string
-	}{
-		{"hex key", "a1b2c3d4", "a1b2c3d4.test.example.com"},
-		{"sha1 hex", "3061b8fcbdb6972059518f1adc3590dca6a5f352", "3061b8fcbdb6972059518f1adc3590dca6a5f352.test.example.com"},
+	if fake.writes != 1 {
+		t.Errorf("API saw %d writes, want 1", fake.writes)
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := p.getRecordName(tt.key)
-			if got != tt.expected {
-				t.Errorf("getRecordName(%q) = %q, want %q", tt.key, got, tt.expected)
-			}
-		})
+func TestSetRemovesDuplicates(t *testing.T) {
+	// Two nodes racing to create the same name leave two records behind.
+	fake, server := newFakeCloudflare(t)
+	fake.records["dup1"] = cfRecordEntry{Name: "abc123.wg.example.com", Content: "old"}
+	fake.records["dup2"] = cfRecordEntry{Name: "abc123.wg.example.com", Content: "older"}
+
+	if err := newTestStore(server.URL).Set(context.Background(), "abc123", "new"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if len(fake.records) != 1 {
+		t.Fatalf("fake holds %d records, want 1", len(fake.records))
+	}
+	for _, record := range fake.records {
+		if record.Content != "new" {
+			t.Errorf("remaining record = %q, want new", record.Content)
+		}
 	}
 }
 
-func TestCloudflarePlugin_StructFields(t *testing.T) {
-	// Test that CloudflarePlugin struct has expected fields
-	p := &CloudflarePlugin{
-		token:     "test-token",
-		zoneID:    "test-zone-id",
-		zoneName:  "example.com",
-		subdomain: "test",
-		client:    nil, // Will be set in real usage
+func TestGetUnquotesPresentationForm(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	fake.records["q1"] = cfRecordEntry{Name: "abc123.wg.example.com", Content: `"dead" "beef"`}
+
+	got, err := newTestStore(server.URL).Get(context.Background(), "abc123")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != "deadbeef" {
+		t.Errorf("Get() = %q, want deadbeef", got)
 	}
+}
 
-	if p.token != "test-token" {
-		t.Errorf("CloudflarePlugin.token = %q, want %q", p.token, "test-token")
+func TestGetMissingRecord(t *testing.T) {
+	_, server := newFakeCloudflare(t)
+
+	_, err := newTestStore(server.URL).Get(context.Background(), "abc123")
+	if err == nil || !strings.Contains(err.Error(), "record not found") {
+		t.Fatalf("Get() error = %v, want record not found", err)
 	}
+}
+
+func TestGetIfModified(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
 
-	if p.zoneID != "test-zone-id" {
-		t.Errorf("CloudflarePlugin.zoneID = %q, want %q", p.zoneID, "test-zone-id")
+	if err := store.SetWithTTL(ctx, "abc123", "first", 2*time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
 	}
 
-	if p.zoneName != "example.com" {
-		t.Errorf("CloudflarePlugin.zoneName = %q, want %q", p.zoneName, "example.com")
+	got, rev, err := store.GetIfModified(ctx, "abc123", pluginapi.Revision{})
+	if err != nil || got != "first" {
+		t.Fatalf("GetIfModified() = (%q, %v), want (first, nil)", got, err)
+	}
+	// The record's TTL does not hold peers to a stale endpoint.
+	if !rev.Expires.IsZero() {
+		t.Errorf("revision expires at %s, want no expiry", rev.Expires)
 	}
 
-	if p.subdomain != "test" {
-		t.Errorf("CloudflarePlugin.subdomain = %q, want %q", p.subdomain, "test")
+	if _, _, err := store.GetIfModified(ctx, "abc123", rev); !errors.Is(err, pluginapi.ErrNotModified) {
+		t.Errorf("GetIfModified() of the same revision error = %v, want ErrNotModified", err)
+	}
+
+	if err := store.Set(ctx, "abc123", "second"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, _, err = store.GetIfModified(ctx, "abc123", rev)
+	if err != nil || got != "second" {
+		t.Errorf("GetIfModified() after an update = (%q, %v), want (second, nil)", got, err)
+	}
+
+	// As Get does, an empty record is not found and duplicates are one.
+	fake.records["empty"] = cfRecordEntry{Name: "def456.wg.example.com", Content: ""}
+	if _, _, err := store.GetIfModified(ctx, "def456", pluginapi.Revision{}); !errors.Is(err, pluginapi.ErrNotFound) {
+		t.Errorf("GetIfModified() of an empty record error = %v, want ErrNotFound", err)
+	}
+	delete(fake.records, "empty")
+	fake.records["dup1"] = cfRecordEntry{Name: "def456.wg.example.com", Content: "v"}
+	fake.records["dup2"] = cfRecordEntry{Name: "def456.wg.example.com", Content: "v"}
+	if got, _, err := store.GetIfModified(ctx, "def456", pluginapi.Revision{}); err != nil || got != "v" {
+		t.Errorf("GetIfModified() of duplicates = (%q, %v), want (v, nil)", got, err)
 	}
 }
 
-// Note: Full integration tests for Get/Set/doRequest/getZoneID/findRecord
-// require either:
-// 1. Refactoring CloudflarePlugin to accept injected HTTP client and API URL
-// 2. Setting up actual Cloudflare API credentials (not suitable for unit tests)
-// 3. Using HTTP interceptors (complex setup)
-//
-// The current tests cover:
-// - Configuration validation
-// - Record name generation logic
-//
-// builtin.Config's own helper methods are tested in
-// internal/plugin/builtin/config_test.go.
-//
-// For full coverage, consider refactoring to use dependency injection:
-//   type CloudflarePlugin struct {
-//       client HTTPClient // interface
-//       apiURL string     // configurable
-//       ...
-//   }
+func TestGetManySetMany(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	keys := make([]string, 5)
+	records := make(map[string]string, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%040x", i)
+		records[keys[i]] = fmt.Sprintf("value%d", i)
+	}
+	fake.records["stale"] = cfRecordEntry{Name: keys[0] + ".wg.example.com", Content: "old"}
+	fake.records["same"] = cfRecordEntry{Name: keys[1] + ".wg.example.com", Content: "value1"}
+
+	if err := store.SetMany(ctx, records); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+	if fake.lists != 1 {
+		t.Errorf("SetMany() listed %d times, want 1", fake.lists)
+	}
+	// One update and three creates; the unchanged record is left alone.
+	if fake.writes != 4 {
+		t.Errorf("SetMany() wrote %d times, want 4", fake.writes)
+	}
+
+	fake.lists = 0
+	got, err := store.GetMany(ctx, append(keys, fmt.Sprintf("%040x", 99)))
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if fake.lists != 1 {
+		t.Errorf("GetMany() listed %d times, want 1", fake.lists)
+	}
+	if len(got) != len(records) {
+		t.Errorf("GetMany() = %v, want only the %d keys that have records", got, len(records))
+	}
+	for key, want := range records {
+		if got[key] != want {
+			t.Errorf("GetMany()[%s] = %q, want %q", key, got[key], want)
+		}
+	}
+}
+
+func TestUnknownZone(t *testing.T) {
+	_, server := newFakeCloudflare(t)
+	store := builtin.NewRecordStore("cloudflare", newCloudflarePlugin(server.URL, "test-token", "other.org"), "other.org", "")
+
+	err := store.Set(context.Background(), "abc123", "value")
+	if err == nil || !strings.Contains(err.Error(), "zone not found") {
+		t.Fatalf("Set() error = %v, want zone not found", err)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	if err := store.SetWithTTL(ctx, "abc123", "value", 5*time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if got := fake.records["rec1"].TTL; got != 300 {
+		t.Errorf("record TTL = %d, want 300", got)
+	}
+
+	// Unchanged value and TTL: no write. A new TTL alone: an update.
+	if err := store.SetWithTTL(ctx, "abc123", "value", 5*time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if fake.writes != 1 {
+		t.Errorf("API saw %d writes, want 1", fake.writes)
+	}
+	if err := store.SetWithTTL(ctx, "abc123", "value", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if got := fake.records["rec1"].TTL; got != cfMinTTL {
+		t.Errorf("record TTL = %d, want it raised to %d", got, cfMinTTL)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+	fake.records["dup1"] = cfRecordEntry{Name: "abc123.wg.example.com", Content: "old"}
+	fake.records["dup2"] = cfRecordEntry{Name: "abc123.wg.example.com", Content: "older"}
+	fake.records["other"] = cfRecordEntry{Name: "def456.wg.example.com", Content: "keep"}
+
+	store := newTestStore(server.URL)
+	if err := store.Delete(context.Background(), "abc123"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if len(fake.records) != 1 || fake.records["other"].Content != "keep" {
+		t.Errorf("fake holds %v, want only the other key's record", fake.records)
+	}
+
+	// Deleting what is already gone is fine.
+	if err := store.Delete(context.Background(), "abc123"); err != nil {
+		t.Errorf("Delete() of a missing record error = %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	fake, server := newFakeCloudflare(t)
+
+	var want []string
+	for i := 0; i < cfListPageSize+5; i++ {
+		key := fmt.Sprintf("%040x", i)
+		want = append(want, key)
+		fake.records[fmt.Sprintf("r%05d", i)] = cfRecordEntry{Name: key + ".wg.example.com", Content: "v"}
+	}
+	// Neither of these is a record of the store.
+	fake.records["x1"] = cfRecordEntry{Name: "_acme-challenge.wg.example.com", Content: "v"}
+	fake.records["x2"] = cfRecordEntry{Name: want[0] + ".other.example.com", Content: "v"}
+
+	keys, err := newTestStore(server.URL).List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("List() returned %d keys, want the %d under wg.example.com", len(keys), len(want))
+	}
+}
@@ -1,14 +1,19 @@
 // Package builtin holds helpers shared across built-in plugin
 // implementations (internal/plugin/builtin/<name>).
 //
-// This file carries no build tag: unlike each built-in's own
+// Its files carry no build tag: unlike each built-in's own
 // implementation, which is compiled in only under its own tag or
-// builtin_all, the config helper must be reachable regardless of which
-// combination of built-ins is compiled in, so it is always compiled.
+// builtin_all, the config helper, the HTTP plumbing and the TXT record
+// store must be reachable regardless of which combination of built-ins is
+// compiled in, so they are always compiled.
 package builtin
 
 import (
 	"fmt"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
@@ -95,3 +100,54 @@ func (c *Config) GetDuration(key string) (time.Duration, bool, error) {
 		return 0, false, fmt.Errorf("%s must be a duration or a number of seconds", key)
 	}
 }
+
+// GetTTL reads a record TTL the way GetDuration reads a timeout, falling
+// back to def when absent, and returns it in whole seconds, the unit every
+// DNS API takes.
+func (c *Config) GetTTL(key string, def time.Duration) (uint32, error) {
+	ttl, ok, err := c.GetDuration(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		ttl = def
+	}
+	if ttl < 0 || ttl%time.Second != 0 || ttl/time.Second > math.MaxUint32 {
+		return 0, fmt.Errorf("%s must be a whole, non-negative number of seconds", key)
+	}
+	return uint32(ttl / time.Second), nil
+}
+
+// GetFileMode reads a permission mode, falling back to def when absent. The
+// string form is octal, as chmod takes it. A YAML integer written with a
+// leading zero arrives already converted, but one written without it
+// arrives as decimal, so an integer above 0777 is refused rather than
+// misread.
+func (c *Config) GetFileMode(key string, def fs.FileMode) (fs.FileMode, error) {
+	val, ok := c.values[key]
+	if !ok {
+		return def, nil
+	}
+
+	var mode uint64
+	switch v := val.(type) {
+	case string:
+		parsed, err := strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an octal mode such as \"0644\": %q", key, v)
+		}
+		mode = parsed
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("%s must be an octal mode such as \"0644\"", key)
+		}
+		mode = uint64(v)
+	default:
+		return 0, fmt.Errorf("%s must be an octal mode such as \"0644\"", key)
+	}
+
+	if mode > 0777 {
+		return 0, fmt.Errorf("%s must be a permission mode no higher than 0777; quote it as \"0%d\" if it was meant as octal", key, mode)
+	}
+	return fs.FileMode(mode), nil
+}
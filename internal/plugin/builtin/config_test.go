@@ -1,7 +1,9 @@
 package builtin
 
 import (
+	"io/fs"
 	"testing"
+	"time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
@@ -239,3 +241,59 @@ func TestConfig_GetDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_GetTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		want    uint32
+		wantErr bool
+	}{
+		{name: "absent uses default", config: pluginapi.PluginConfig{}, want: 300},
+		{name: "duration string", config: pluginapi.PluginConfig{"ttl": "1m"}, want: 60},
+		{name: "plain seconds", config: pluginapi.PluginConfig{"ttl": 3600}, want: 3600},
+		{name: "fractional seconds", config: pluginapi.PluginConfig{"ttl": "1.5s"}, wantErr: true},
+		{name: "negative", config: pluginapi.PluginConfig{"ttl": -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewConfig(tt.config).GetTTL("ttl", 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("GetTTL() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_GetFileMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		want    fs.FileMode
+		wantErr bool
+	}{
+		{name: "absent uses default", config: pluginapi.PluginConfig{}, want: 0644},
+		{name: "octal string", config: pluginapi.PluginConfig{"mode": "0600"}, want: 0600},
+		{name: "0o prefix", config: pluginapi.PluginConfig{"mode": "0o640"}, want: 0640},
+		{name: "yaml octal integer", config: pluginapi.PluginConfig{"mode": 0600}, want: 0600},
+		{name: "decimal integer meant as octal", config: pluginapi.PluginConfig{"mode": 644}, wantErr: true},
+		{name: "not octal", config: pluginapi.PluginConfig{"mode": "0688"}, wantErr: true},
+		{name: "wrong type", config: pluginapi.PluginConfig{"mode": true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewConfig(tt.config).GetFileMode("mode", 0644)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFileMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("GetFileMode() = %o, want %o", got, tt.want)
+			}
+		})
+	}
+}
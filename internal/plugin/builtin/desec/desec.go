@@ -0,0 +1,136 @@
+//go:build builtin_desec || builtin_all
+
+package desec
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("desec", NewDeSECPlugin)
+}
+
+const (
+	desecAPI = "https://desec.io/api/v1"
+
+	// deSEC's minimum TTL for most accounts.
+	defaultTTL = 3600 * time.Second
+
+	// Configuration keys
+	configKeyZoneName  = "zone"
+	configKeyAPIToken  = "token"
+	configKeySubdomain = "subdomain"
+	configKeyTTL       = "ttl"
+)
+
+// DeSECPlugin is the deSEC API as a builtin.RRSetProvider
+type DeSECPlugin struct {
+	api      *builtin.JSONAPI
+	zoneName string
+	ttl      uint32
+}
+
+type desecRRSet struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     uint32   `json:"ttl,omitempty"`
+	Records []string `json:"records"`
+}
+
+// NewDeSECPlugin creates a new deSEC plugin instance
+func NewDeSECPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	zoneName, err := cfg.GetStringRequired(configKeyZoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.GetStringRequired(configKeyAPIToken)
+	if err != nil {
+		return nil, err
+	}
+
+	subdomain, _ := cfg.GetString(configKeySubdomain)
+
+	ttl, err := cfg.GetTTL(configKeyTTL, defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newDeSECPlugin(desecAPI, token, zoneName, ttl)
+	return builtin.NewRRSetStore("desec", p, zoneName, subdomain), nil
+}
+
+func newDeSECPlugin(baseURL, token, zoneName string, ttl uint32) *DeSECPlugin {
+	return &DeSECPlugin{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Authorization": {"Token " + token}},
+			Client:  builtin.NewHTTPClient(10 * time.Second),
+		},
+		zoneName: strings.TrimSuffix(zoneName, "."),
+		ttl:      ttl,
+	}
+}
+
+func (p *DeSECPlugin) rrsetsPath() string {
+	return "/domains/" + url.PathEscape(p.zoneName) + "/rrsets/"
+}
+
+// subname is name relative to the zone, which deSEC spells as empty rather
+// than "@" at the apex.
+func (p *DeSECPlugin) subname(name string) string {
+	subname := builtin.RelativeName(name, p.zoneName)
+	if subname == "@" {
+		return ""
+	}
+	return subname
+}
+
+// ListTXT returns the records of the TXT RRset at name
+func (p *DeSECPlugin) ListTXT(ctx context.Context, name string) ([]builtin.TXTRecord, error) {
+	subname := p.subname(name)
+	if subname == "" {
+		subname = "@"
+	}
+
+	var rrset desecRRSet
+	err := p.api.Do(ctx, http.MethodGet, p.rrsetsPath()+url.PathEscape(subname)+"/TXT/", nil, &rrset)
+	if err != nil {
+		// A missing RRset is a 404 rather than an empty list.
+		var apiErr *builtin.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	records := make([]builtin.TXTRecord, 0, len(rrset.Records))
+	for _, record := range rrset.Records {
+		records = append(records, builtin.TXTRecord{Value: builtin.UnquoteTXT(record)})
+	}
+	return records, nil
+}
+
+// ReplaceTXT makes value the only TXT record at name
+func (p *DeSECPlugin) ReplaceTXT(ctx context.Context, name, value string) error {
+	// The bulk endpoint, because a PUT to a single RRset only replaces one
+	// that already exists, while a bulk PUT creates it too.
+	body := []desecRRSet{{
+		Subname: p.subname(name),
+		Type:    "TXT",
+		TTL:     p.ttl,
+		Records: []string{builtin.QuoteTXT(value)},
+	}}
+	return p.api.Do(ctx, http.MethodPut, p.rrsetsPath(), body, nil)
+}
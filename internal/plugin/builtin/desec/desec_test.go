@@ -0,0 +1,148 @@
+//go:build builtin_desec || builtin_all
+
+package desec
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+func TestNewDeSECPlugin_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		wantErr string
+	}{
+		{"missing zone", pluginapi.PluginConfig{"token": "t"}, "zone is required"},
+		{"missing token", pluginapi.PluginConfig{"zone": "example.com"}, "token is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDeSECPlugin(tt.config)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewDeSECPlugin() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeDeSEC serves the slice of the deSEC API the plugin uses, for one
+// domain, keeping TXT RRsets in memory by subname.
+type fakeDeSEC struct {
+	mu     sync.Mutex
+	rrsets map[string]desecRRSet
+	writes int
+}
+
+func newFakeDeSEC(t *testing.T) (*fakeDeSEC, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeDeSEC{rrsets: make(map[string]desecRRSet)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeDeSEC) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Token test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const base = "/domains/example.com/rrsets/"
+	switch {
+	case r.URL.Path == base && r.Method == http.MethodPut:
+		var rrsets []desecRRSet
+		if err := json.NewDecoder(r.Body).Decode(&rrsets); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, rrset := range rrsets {
+			f.rrsets[rrset.Subname] = rrset
+		}
+		f.writes++
+		_ = json.NewEncoder(w).Encode(rrsets)
+
+	case strings.HasPrefix(r.URL.Path, base) && strings.HasSuffix(r.URL.Path, "/TXT/") && r.Method == http.MethodGet:
+		subname := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, base), "/TXT/")
+		if subname == "@" {
+			subname = ""
+		}
+		rrset, ok := f.rrsets[subname]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"detail":"Not found."}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(rrset)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestStore(url, subdomain string) *builtin.TXTStore {
+	return builtin.NewRRSetStore("desec", newDeSECPlugin(url, "test-token", "example.com", 3600), "example.com", subdomain)
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		_, server := newFakeDeSEC(t)
+		return newTestStore(server.URL, "wg")
+	})
+}
+
+func TestSetThenGet(t *testing.T) {
+	fake, server := newFakeDeSEC(t)
+	store := newTestStore(server.URL, "wg")
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second", "second"} {
+		if err := store.Set(ctx, "abc123", value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	got, err := store.Get(ctx, "abc123")
+	if err != nil || got != "second" {
+		t.Errorf("Get() = (%q, %v), want (second, nil)", got, err)
+	}
+	if rrset := fake.rrsets["abc123.wg"]; len(rrset.Records) != 1 || rrset.Records[0] != `"second"` || rrset.TTL != 3600 {
+		t.Errorf("RRset = %+v, want the quoted value alone with TTL 3600", rrset)
+	}
+	if fake.writes != 2 {
+		t.Errorf("API saw %d writes, want 2", fake.writes)
+	}
+}
+
+func TestGetMissingRRset(t *testing.T) {
+	_, server := newFakeDeSEC(t)
+
+	_, err := newTestStore(server.URL, "wg").Get(context.Background(), "abc123")
+	if err == nil || !strings.Contains(err.Error(), "record not found") {
+		t.Fatalf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestSubnameAtApex(t *testing.T) {
+	p := newDeSECPlugin("", "test-token", "example.com", 3600)
+	if got := p.subname("example.com"); got != "" {
+		t.Errorf("subname(apex) = %q, want empty", got)
+	}
+	if got := p.subname("abc.wg.example.com"); got != "abc.wg" {
+		t.Errorf("subname() = %q, want abc.wg", got)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_desec && !builtin_all
+
+package desec
+
+// This file exists to provide an empty package when builtin_desec tag is not set
+// This prevents import errors when the build tag is disabled
@@ -0,0 +1,134 @@
+//go:build builtin_digitalocean || builtin_all
+
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("digitalocean", NewDigitalOceanPlugin)
+}
+
+const (
+	doAPI = "https://api.digitalocean.com/v2"
+
+	// DigitalOcean rejects TTLs below 30 seconds.
+	defaultTTL = 60 * time.Second
+
+	// Configuration keys
+	configKeyZoneName  = "zone"
+	configKeyAPIToken  = "token"
+	configKeySubdomain = "subdomain"
+	configKeyTTL       = "ttl"
+)
+
+// DigitalOceanPlugin is the DigitalOcean domains API as a
+// builtin.RecordProvider
+type DigitalOceanPlugin struct {
+	api      *builtin.JSONAPI
+	zoneName string
+	ttl      uint32
+}
+
+type doRecord struct {
+	ID   int    `json:"id"`
+	Data string `json:"data"`
+}
+
+// NewDigitalOceanPlugin creates a new DigitalOcean plugin instance
+func NewDigitalOceanPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	zoneName, err := cfg.GetStringRequired(configKeyZoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.GetStringRequired(configKeyAPIToken)
+	if err != nil {
+		return nil, err
+	}
+
+	subdomain, _ := cfg.GetString(configKeySubdomain)
+
+	ttl, err := cfg.GetTTL(configKeyTTL, defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newDigitalOceanPlugin(doAPI, token, zoneName, ttl)
+	return builtin.NewRecordStore("digitalocean", p, zoneName, subdomain), nil
+}
+
+func newDigitalOceanPlugin(baseURL, token, zoneName string, ttl uint32) *DigitalOceanPlugin {
+	return &DigitalOceanPlugin{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Authorization": {"Bearer " + token}},
+			Client:  builtin.NewHTTPClient(10 * time.Second),
+		},
+		// The domain is addressed by name, so there is no zone ID to look up.
+		zoneName: strings.TrimSuffix(zoneName, "."),
+		ttl:      ttl,
+	}
+}
+
+func (p *DigitalOceanPlugin) recordsPath() string {
+	return "/domains/" + url.PathEscape(p.zoneName) + "/records"
+}
+
+// ListTXT returns the TXT records at name
+func (p *DigitalOceanPlugin) ListTXT(ctx context.Context, name string) ([]builtin.TXTRecord, error) {
+	// The name filter takes the fully qualified name; only the record
+	// bodies use the relative one.
+	query := url.Values{"type": {"TXT"}, "name": {name}, "per_page": {"200"}}
+
+	var resp struct {
+		Records []doRecord `json:"domain_records"`
+	}
+	if err := p.api.Do(ctx, http.MethodGet, p.recordsPath()+"?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	records := make([]builtin.TXTRecord, 0, len(resp.Records))
+	for _, record := range resp.Records {
+		records = append(records, builtin.TXTRecord{ID: strconv.Itoa(record.ID), Value: record.Data})
+	}
+	return records, nil
+}
+
+// CreateTXT adds a TXT record at name
+func (p *DigitalOceanPlugin) CreateTXT(ctx context.Context, name, value string) error {
+	body := struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		Data string `json:"data"`
+		TTL  uint32 `json:"ttl"`
+	}{Type: "TXT", Name: builtin.RelativeName(name, p.zoneName), Data: value, TTL: p.ttl}
+	return p.api.Do(ctx, http.MethodPost, p.recordsPath(), body, nil)
+}
+
+// UpdateTXT replaces the data of an existing record
+func (p *DigitalOceanPlugin) UpdateTXT(ctx context.Context, _ string, record builtin.TXTRecord, value string) error {
+	body := struct {
+		Data string `json:"data"`
+		TTL  uint32 `json:"ttl"`
+	}{Data: value, TTL: p.ttl}
+	return p.api.Do(ctx, http.MethodPatch, fmt.Sprintf("%s/%s", p.recordsPath(), record.ID), body, nil)
+}
+
+// DeleteTXT removes an existing record
+func (p *DigitalOceanPlugin) DeleteTXT(ctx context.Context, _ string, record builtin.TXTRecord) error {
+	return p.api.Do(ctx, http.MethodDelete, fmt.Sprintf("%s/%s", p.recordsPath(), record.ID), nil, nil)
+}
@@ -0,0 +1,194 @@
+//go:build builtin_digitalocean || builtin_all
+
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+func TestNewDigitalOceanPlugin_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		wantErr string
+	}{
+		{"missing zone", pluginapi.PluginConfig{"token": "t"}, "zone is required"},
+		{"missing token", pluginapi.PluginConfig{"zone": "example.com"}, "token is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDigitalOceanPlugin(tt.config)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewDigitalOceanPlugin() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeDigitalOcean serves the slice of the v2 domains API the plugin uses,
+// for one domain, keeping records in memory by relative name.
+type fakeDigitalOcean struct {
+	mu      sync.Mutex
+	records map[int]doEntry
+	nextID  int
+	writes  int
+}
+
+type doEntry struct {
+	Name string
+	Data string
+	TTL  uint32
+}
+
+func newFakeDigitalOcean(t *testing.T) (*fakeDigitalOcean, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeDigitalOcean{records: make(map[int]doEntry)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeDigitalOcean) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const base = "/domains/example.com/records"
+	switch {
+	case r.URL.Path == base && r.Method == http.MethodGet:
+		// Like the real API, filter on the fully qualified name.
+		name := strings.TrimSuffix(r.URL.Query().Get("name"), ".example.com")
+		found := []doRecord{}
+		for id, record := range f.records {
+			if record.Name == name {
+				found = append(found, doRecord{ID: id, Data: record.Data})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"domain_records": found})
+
+	case r.URL.Path == base && r.Method == http.MethodPost:
+		var body doEntry
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.nextID++
+		f.records[f.nextID] = body
+		f.writes++
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"domain_record": map[string]int{"id": f.nextID}})
+
+	case strings.HasPrefix(r.URL.Path, base+"/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, base+"/"))
+		record, ok := f.records[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.writes++
+		switch r.Method {
+		case http.MethodPatch:
+			var body doEntry
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			record.Data = body.Data
+			f.records[id] = record
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"domain_record": map[string]int{"id": id}})
+		case http.MethodDelete:
+			delete(f.records, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestStore(url string) *builtin.TXTStore {
+	return builtin.NewRecordStore("digitalocean", newDigitalOceanPlugin(url, "test-token", "example.com", 60), "example.com", "wg")
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		_, server := newFakeDigitalOcean(t)
+		return newTestStore(server.URL)
+	})
+}
+
+func TestSetCreatesThenUpdates(t *testing.T) {
+	fake, server := newFakeDigitalOcean(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second", "second"} {
+		if err := store.Set(ctx, "abc123", value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	got, err := store.Get(ctx, "abc123")
+	if err != nil || got != "second" {
+		t.Errorf("Get() = (%q, %v), want (second, nil)", got, err)
+	}
+	if record := fake.records[1]; record.Name != "abc123.wg" || record.TTL != 60 {
+		t.Errorf("record = %+v, want relative name abc123.wg with TTL 60", record)
+	}
+	if fake.writes != 2 {
+		t.Errorf("API saw %d writes, want 2", fake.writes)
+	}
+}
+
+func TestSetRemovesDuplicates(t *testing.T) {
+	fake, server := newFakeDigitalOcean(t)
+	fake.records[7] = doEntry{Name: "abc123.wg", Data: "old"}
+	fake.records[8] = doEntry{Name: "abc123.wg", Data: "older"}
+	fake.nextID = 8
+
+	if err := newTestStore(server.URL).Set(context.Background(), "abc123", "new"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if len(fake.records) != 1 {
+		t.Fatalf("fake holds %d records, want 1", len(fake.records))
+	}
+	for _, record := range fake.records {
+		if record.Data != "new" {
+			t.Errorf("remaining record = %q, want new", record.Data)
+		}
+	}
+}
+
+func TestGetMissingRecord(t *testing.T) {
+	_, server := newFakeDigitalOcean(t)
+
+	_, err := newTestStore(server.URL).Get(context.Background(), "abc123")
+	if err == nil || !strings.Contains(err.Error(), "record not found") {
+		t.Fatalf("Get() error = %v, want record not found", err)
+	}
+}
+
+// A 404 for the domain is a misconfiguration, not a peer without a record.
+func TestGetWrongZone(t *testing.T) {
+	_, server := newFakeDigitalOcean(t)
+	store := builtin.NewRecordStore("digitalocean", newDigitalOceanPlugin(server.URL, "test-token", "example.org", 60), "example.org", "wg")
+
+	_, err := store.Get(context.Background(), "abc123")
+	var apiErr *builtin.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || errors.Is(err, pluginapi.ErrNotFound) {
+		t.Fatalf("Get() error = %v, want the API's 404 rather than ErrNotFound", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_digitalocean && !builtin_all
+
+package digitalocean
+
+// This file exists to provide an empty package when builtin_digitalocean tag is not set
+// This prevents import errors when the build tag is disabled
@@ -0,0 +1,215 @@
+//go:build builtin_file || builtin_all
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("file", NewFilePlugin)
+}
+
+const (
+	defaultFileMode fs.FileMode = 0644
+	defaultDirMode  fs.FileMode = 0755
+
+	// tempPrefix marks files Set has not renamed into place yet. Keys may
+	// not start with a dot, so a temp file never shadows a record.
+	tempPrefix = "."
+
+	// Configuration keys
+	configKeyDir      = "dir"
+	configKeyFileMode = "file_mode"
+	configKeyDirMode  = "dir_mode"
+)
+
+// FilePlugin implements the Store interface with one file per key in a
+// directory that something else (NFS, Syncthing, a git-synced share)
+// keeps in sync between nodes.
+//
+// A record is the hex payload followed by a newline. The newline is what
+// tells a complete file from one a sync tool is still writing: a truncated
+// file lacks it, and a truncated payload is usually still valid hex.
+type FilePlugin struct {
+	dir      string
+	fileMode fs.FileMode
+	dirMode  fs.FileMode
+}
+
+// NewFilePlugin creates a new file plugin instance
+func NewFilePlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	dir, err := cfg.GetStringRequired(configKeyDir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("%s is required", configKeyDir)
+	}
+
+	fileMode, err := cfg.GetFileMode(configKeyFileMode, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	dirMode, err := cfg.GetFileMode(configKeyDirMode, defaultDirMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory is created on first Set rather than here: it may live
+	// on a share that is not mounted yet when stunmesh starts.
+	return &FilePlugin{
+		dir:      filepath.Clean(dir),
+		fileMode: fileMode,
+		dirMode:  dirMode,
+	}, nil
+}
+
+func (p *FilePlugin) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, tempPrefix) || strings.ContainsAny(key, `/\`) || filepath.Base(key) != key {
+		return "", fmt.Errorf("key is not a valid file name: %q", key)
+	}
+	return filepath.Join(p.dir, key), nil
+}
+
+// Get reads the record for key, refusing one that is not completely synced
+func (p *FilePlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from builtin file plugin")
+
+	path, err := p.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, path)
+		}
+		return "", err
+	}
+
+	value, ok := parseRecord(data)
+	if !ok {
+		return "", fmt.Errorf("record is incomplete or corrupt, possibly still syncing: %s", path)
+	}
+	return value, nil
+}
+
+// GetIfModified reads the record for key like Get, unless the file still
+// has the size and modification time it had at rev, which a stat tells
+// without reading it.
+func (p *FilePlugin) GetIfModified(ctx context.Context, key string, rev pluginapi.Revision) (string, pluginapi.Revision, error) {
+	path, err := p.path(key)
+	if err != nil {
+		return "", pluginapi.Revision{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		current := pluginapi.Revision{Tag: fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())}
+		if rev.Tag == current.Tag {
+			return "", current, pluginapi.ErrNotModified
+		}
+		value, err := p.Get(ctx, key)
+		if err != nil {
+			return "", pluginapi.Revision{}, err
+		}
+		return value, current, nil
+	}
+
+	// Let Get tell a missing record from other failures.
+	value, err := p.Get(ctx, key)
+	return value, pluginapi.Revision{}, err
+}
+
+// parseRecord accepts exactly a non-empty, even-length hex payload and its
+// terminating newline (CRLF too, for a file that passed through Windows).
+func parseRecord(data []byte) (string, bool) {
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return "", false
+	}
+	payload := bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+	if len(payload) == 0 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(string(payload)); err != nil {
+		return "", false
+	}
+	return string(payload), true
+}
+
+// Set writes the record for key atomically, so a reader on this host sees
+// either the previous file or the new one
+func (p *FilePlugin) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to builtin file plugin")
+
+	path, err := p.path(key)
+	if err != nil {
+		return err
+	}
+
+	// Every value stunmesh stores is hex; anything else could never be read
+	// back, so fail here rather than on every peer's Get.
+	if _, ok := parseRecord([]byte(value + "\n")); !ok {
+		return fmt.Errorf("value for %s is not hex", key)
+	}
+
+	record := []byte(value + "\n")
+
+	// An unchanged record is left alone, so the sync tool has nothing to
+	// propagate every refresh cycle.
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, record) {
+		return nil
+	}
+
+	if err := os.MkdirAll(p.dir, p.dirMode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(p.dir, tempPrefix+key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing a file that has been renamed away fails harmlessly.
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(record); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// CreateTemp always creates 0600; chmod sets the configured mode
+	// exactly, without the umask getting a say.
+	if err := tmp.Chmod(p.fileMode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
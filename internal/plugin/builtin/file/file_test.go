@@ -0,0 +1,227 @@
+//go:build builtin_file || builtin_all
+
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+const testKey = "3061b8fcbdb6972059518f1adc3590dca6a5f352"
+
+func newTestPlugin(t *testing.T, config pluginapi.PluginConfig) (*FilePlugin, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "records")
+	if config == nil {
+		config = pluginapi.PluginConfig{}
+	}
+	config["dir"] = dir
+
+	store, err := NewFilePlugin(config)
+	if err != nil {
+		t.Fatalf("NewFilePlugin() error = %v", err)
+	}
+	return store.(*FilePlugin), dir
+}
+
+func TestSetThenGet(t *testing.T) {
+	p, dir := newTestPlugin(t, nil)
+	ctx := context.Background()
+
+	for _, value := range []string{"deadbeef", "cafef00d"} {
+		if err := p.Set(ctx, testKey, value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	got, err := p.Get(ctx, testKey)
+	if err != nil || got != "cafef00d" {
+		t.Errorf("Get() = (%q, %v), want (cafef00d, nil)", got, err)
+	}
+
+	// Nothing but the record is left behind in the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != testKey {
+		t.Errorf("directory holds %v, want only %s", entries, testKey)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		store, _ := newTestPlugin(t, nil)
+		return store
+	})
+}
+
+func TestSetAppliesFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has no permission bits")
+	}
+
+	p, dir := newTestPlugin(t, pluginapi.PluginConfig{"file_mode": "0640", "dir_mode": "0750"})
+	if err := p.Set(context.Background(), testKey, "deadbeef"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("file mode = %o, want 640", info.Mode().Perm())
+	}
+
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The umask may clear bits from a new directory, but never add them.
+	if dirInfo.Mode().Perm()&^0750 != 0 {
+		t.Errorf("dir mode = %o, want within 750", dirInfo.Mode().Perm())
+	}
+}
+
+func TestSetUnchangedLeavesFile(t *testing.T) {
+	p, dir := newTestPlugin(t, nil)
+	ctx := context.Background()
+
+	if err := p.Set(ctx, testKey, "deadbeef"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	path := filepath.Join(dir, testKey)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Set(ctx, testKey, "deadbeef"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Set() replaced the file for an unchanged value")
+	}
+}
+
+func TestGetRejectsPartialFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "complete", content: "deadbeef\n", want: "deadbeef"},
+		{name: "crlf", content: "deadbeef\r\n", want: "deadbeef"},
+		{name: "truncated before newline", content: "deadbe", wantErr: true},
+		{name: "empty", content: "", wantErr: true},
+		{name: "odd length", content: "deadbee\n", wantErr: true},
+		{name: "not hex", content: "hello world\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, dir := newTestPlugin(t, nil)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, testKey), []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.Get(context.Background(), testKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMissingRecord(t *testing.T) {
+	p, _ := newTestPlugin(t, nil)
+
+	_, err := p.Get(context.Background(), testKey)
+	if err == nil || !strings.Contains(err.Error(), "record not found") {
+		t.Errorf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestGetIfModified(t *testing.T) {
+	p, _ := newTestPlugin(t, nil)
+	ctx := context.Background()
+
+	if err := p.Set(ctx, testKey, "deadbeef"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, rev, err := p.GetIfModified(ctx, testKey, pluginapi.Revision{})
+	if err != nil || got != "deadbeef" {
+		t.Fatalf("GetIfModified() = (%q, %v), want (deadbeef, nil)", got, err)
+	}
+
+	if _, _, err := p.GetIfModified(ctx, testKey, rev); !errors.Is(err, pluginapi.ErrNotModified) {
+		t.Errorf("GetIfModified() of the same file error = %v, want ErrNotModified", err)
+	}
+
+	if err := p.Set(ctx, testKey, "cafebabe00"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, _, err = p.GetIfModified(ctx, testKey, rev)
+	if err != nil || got != "cafebabe00" {
+		t.Errorf("GetIfModified() after a write = (%q, %v), want (cafebabe00, nil)", got, err)
+	}
+}
+
+func TestInvalidKeysAndValues(t *testing.T) {
+	p, _ := newTestPlugin(t, nil)
+	ctx := context.Background()
+
+	for _, key := range []string{"", ".hidden", "../escape", `a\b`, ".."} {
+		if err := p.Set(ctx, key, "deadbeef"); err == nil {
+			t.Errorf("Set(%q) should reject the key", key)
+		}
+		if _, err := p.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q) should reject the key", key)
+		}
+	}
+
+	if err := p.Set(ctx, testKey, "not hex"); err == nil {
+		t.Error("Set() should reject a value that is not hex")
+	}
+}
+
+func TestNewFilePlugin_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config pluginapi.PluginConfig
+	}{
+		{"missing dir", pluginapi.PluginConfig{}},
+		{"empty dir", pluginapi.PluginConfig{"dir": ""}},
+		{"bad file mode", pluginapi.PluginConfig{"dir": "/tmp", "file_mode": "rw-r--r--"}},
+		{"bad dir mode", pluginapi.PluginConfig{"dir": "/tmp", "dir_mode": 755}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFilePlugin(tt.config); err == nil {
+				t.Error("NewFilePlugin() should reject the config")
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_file && !builtin_all
+
+package file
+
+// This file exists to provide an empty package when builtin_file tag is not set
+// This prevents import errors when the build tag is disabled
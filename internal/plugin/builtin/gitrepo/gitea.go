@@ -0,0 +1,72 @@
+//go:build builtin_git || builtin_all
+
+package gitrepo
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+)
+
+// giteaRepo writes through Gitea's (and Forgejo's) multi-file contents
+// endpoint, which commits several files at once on the branch head. Each
+// update names the blob it replaces, and Gitea refuses the commit when
+// that blob is no longer the file's -- or when a file it is told to create
+// already exists -- which is the conflict the store retries.
+type giteaRepo struct {
+	api    *builtin.JSONAPI
+	repo   string
+	branch string
+}
+
+func newGiteaAPI(baseURL, token, repo, branch string, client *http.Client) *giteaRepo {
+	return &giteaRepo{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Authorization": {"token " + token}},
+			Client:  client,
+		},
+		repo:   repo,
+		branch: branch,
+	}
+}
+
+func (g *giteaRepo) readFile(ctx context.Context, filePath string) (repoFile, bool, error) {
+	return readContents(ctx, g.api, "/repos/"+g.repo, g.branch, filePath)
+}
+
+func (g *giteaRepo) commit(ctx context.Context, changes []fileChange, message string) error {
+	type fileOperation struct {
+		Operation string `json:"operation"`
+		Path      string `json:"path"`
+		Content   string `json:"content"`
+		SHA       string `json:"sha,omitempty"`
+	}
+
+	files := make([]fileOperation, 0, len(changes))
+	for _, change := range changes {
+		op := fileOperation{
+			Operation: "create",
+			Path:      change.path,
+			Content:   base64.StdEncoding.EncodeToString([]byte(change.content)),
+		}
+		if change.existing != nil {
+			op.Operation = "update"
+			op.SHA = change.existing.sha
+		}
+		files = append(files, op)
+	}
+
+	err := g.api.Do(ctx, http.MethodPost, "/repos/"+g.repo+"/contents", struct {
+		Branch  string          `json:"branch"`
+		Message string          `json:"message"`
+		Files   []fileOperation `json:"files"`
+	}{Branch: g.branch, Message: message, Files: files}, nil)
+	if isStatus(err, http.StatusConflict, http.StatusUnprocessableEntity) {
+		return fmt.Errorf("%w: %v", errConflict, err)
+	}
+	return err
+}
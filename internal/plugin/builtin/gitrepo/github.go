@@ -0,0 +1,139 @@
+//go:build builtin_git || builtin_all
+
+package gitrepo
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+)
+
+// githubRepo writes through GitHub's git data API rather than its contents
+// API: the contents API makes one commit per file, while a tree built on
+// the branch head carries any number of files in one commit. Moving the
+// branch to that commit is a fast-forward-only ref update, which GitHub
+// refuses when another push moved the branch first -- the conflict the
+// store retries.
+type githubRepo struct {
+	api    *builtin.JSONAPI
+	repo   string
+	branch string
+}
+
+type githubContent struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+	SHA      string `json:"sha"`
+}
+
+func newGitHubAPI(baseURL, token, repo, branch string, client *http.Client) *githubRepo {
+	return &githubRepo{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header: http.Header{
+				"Authorization": {"Bearer " + token},
+				"Accept":        {"application/vnd.github+json"},
+			},
+			Client: client,
+		},
+		repo:   repo,
+		branch: branch,
+	}
+}
+
+func (g *githubRepo) readFile(ctx context.Context, filePath string) (repoFile, bool, error) {
+	return readContents(ctx, g.api, "/repos/"+g.repo, g.branch, filePath)
+}
+
+// readContents is the contents API read GitHub and Gitea share.
+func readContents(ctx context.Context, api *builtin.JSONAPI, repoPath, branch, filePath string) (repoFile, bool, error) {
+	var resp githubContent
+	err := api.Do(ctx, http.MethodGet, repoPath+"/contents/"+escapePath(filePath)+"?ref="+url.QueryEscape(branch), nil, &resp)
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			return repoFile{}, false, nil
+		}
+		return repoFile{}, false, err
+	}
+
+	if resp.Encoding != "base64" {
+		return repoFile{}, false, fmt.Errorf("unexpected encoding %q for %s", resp.Encoding, filePath)
+	}
+	// The content is wrapped at 60 columns.
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(resp.Content, "\n", ""))
+	if err != nil {
+		return repoFile{}, false, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	return repoFile{content: string(content), sha: resp.SHA}, true, nil
+}
+
+func (g *githubRepo) commit(ctx context.Context, changes []fileChange, message string) error {
+	refPath := "/repos/" + g.repo + "/git/refs/heads/" + escapePath(g.branch)
+
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := g.api.Do(ctx, http.MethodGet, "/repos/"+g.repo+"/git/ref/heads/"+escapePath(g.branch), nil, &ref); err != nil {
+		return fmt.Errorf("failed to read branch %s: %w", g.branch, err)
+	}
+	head := ref.Object.SHA
+
+	var headCommit struct {
+		Tree struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	}
+	if err := g.api.Do(ctx, http.MethodGet, "/repos/"+g.repo+"/git/commits/"+head, nil, &headCommit); err != nil {
+		return err
+	}
+
+	type treeEntry struct {
+		Path    string `json:"path"`
+		Mode    string `json:"mode"`
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	}
+	entries := make([]treeEntry, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, treeEntry{Path: change.path, Mode: "100644", Type: "blob", Content: change.content})
+	}
+
+	var tree struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.api.Do(ctx, http.MethodPost, "/repos/"+g.repo+"/git/trees", struct {
+		BaseTree string      `json:"base_tree"`
+		Tree     []treeEntry `json:"tree"`
+	}{BaseTree: headCommit.Tree.SHA, Tree: entries}, &tree); err != nil {
+		return err
+	}
+
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.api.Do(ctx, http.MethodPost, "/repos/"+g.repo+"/git/commits", struct {
+		Message string   `json:"message"`
+		Tree    string   `json:"tree"`
+		Parents []string `json:"parents"`
+	}{Message: message, Tree: tree.SHA, Parents: []string{head}}, &commit); err != nil {
+		return err
+	}
+
+	err := g.api.Do(ctx, http.MethodPatch, refPath, struct {
+		SHA   string `json:"sha"`
+		Force bool   `json:"force"`
+	}{SHA: commit.SHA, Force: false}, nil)
+	if isStatus(err, http.StatusUnprocessableEntity, http.StatusConflict) {
+		// "Update is not a fast forward": the commit built on head is left
+		// dangling, and GitHub collects it.
+		return fmt.Errorf("%w: %v", errConflict, err)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+//go:build builtin_git || builtin_all
+
+package gitrepo
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+)
+
+// gitlabRepo writes through GitLab's commits API, whose actions commit any
+// number of files at once on the branch head. Each update carries the
+// commit that last touched the file when it was read, and GitLab refuses
+// the commit when the file has changed since -- or when a file it is told
+// to create already exists -- which is the conflict the store retries.
+type gitlabRepo struct {
+	api *builtin.JSONAPI
+	// project is the escaped project path ("group%2Fname") or numeric ID.
+	project string
+	branch  string
+}
+
+func newGitLabAPI(baseURL, token, project, branch string, client *http.Client) *gitlabRepo {
+	return &gitlabRepo{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Private-Token": {token}},
+			Client:  client,
+		},
+		project: url.PathEscape(project),
+		branch:  branch,
+	}
+}
+
+func (g *gitlabRepo) readFile(ctx context.Context, filePath string) (repoFile, bool, error) {
+	var resp struct {
+		Content      string `json:"content"`
+		Encoding     string `json:"encoding"`
+		LastCommitID string `json:"last_commit_id"`
+	}
+	// The file path is a single, fully escaped segment here.
+	err := g.api.Do(ctx, http.MethodGet, "/projects/"+g.project+"/repository/files/"+url.PathEscape(filePath)+"?ref="+url.QueryEscape(g.branch), nil, &resp)
+	if err != nil {
+		if isStatus(err, http.StatusNotFound) {
+			return repoFile{}, false, nil
+		}
+		return repoFile{}, false, err
+	}
+
+	if resp.Encoding != "base64" {
+		return repoFile{}, false, fmt.Errorf("unexpected encoding %q for %s", resp.Encoding, filePath)
+	}
+	content, err := base64.StdEncoding.DecodeString(resp.Content)
+	if err != nil {
+		return repoFile{}, false, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	return repoFile{content: string(content), sha: resp.LastCommitID}, true, nil
+}
+
+func (g *gitlabRepo) commit(ctx context.Context, changes []fileChange, message string) error {
+	type action struct {
+		Action       string `json:"action"`
+		FilePath     string `json:"file_path"`
+		Content      string `json:"content"`
+		LastCommitID string `json:"last_commit_id,omitempty"`
+	}
+
+	actions := make([]action, 0, len(changes))
+	for _, change := range changes {
+		a := action{Action: "create", FilePath: change.path, Content: change.content}
+		if change.existing != nil {
+			a.Action = "update"
+			a.LastCommitID = change.existing.sha
+		}
+		actions = append(actions, a)
+	}
+
+	err := g.api.Do(ctx, http.MethodPost, "/projects/"+g.project+"/repository/commits", struct {
+		Branch        string   `json:"branch"`
+		CommitMessage string   `json:"commit_message"`
+		Actions       []action `json:"actions"`
+	}{Branch: g.branch, CommitMessage: message, Actions: actions}, nil)
+	// GitLab answers a stale last_commit_id or an existing file with 400.
+	// Any other 400 is a request it will refuse again, so the retries run
+	// out quickly and the error surfaces.
+	if isStatus(err, http.StatusBadRequest, http.StatusConflict) {
+		return fmt.Errorf("%w: %v", errConflict, err)
+	}
+	return err
+}
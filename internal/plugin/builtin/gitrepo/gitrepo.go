@@ -0,0 +1,331 @@
+//go:build builtin_git || builtin_all
+
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("git", NewGitPlugin)
+}
+
+const (
+	githubAPI = "https://api.github.com"
+
+	defaultBranch     = "main"
+	defaultDir        = "stunmesh"
+	defaultMaxRetries = 5
+	defaultTimeout    = 30 * time.Second
+
+	// Configuration keys
+	configKeyProvider   = "provider"
+	configKeyURL        = "url"
+	configKeyRepo       = "repo"
+	configKeyBranch     = "branch"
+	configKeyDir        = "dir"
+	configKeyToken      = "token"
+	configKeyMaxRetries = "max_retries"
+	configKeyTimeout    = "timeout"
+)
+
+// errConflict is what a repoAPI returns when a commit lost a race with a
+// push from another node. The commit is rebuilt on the new branch head and
+// tried again: every node only writes its own keys, so there is never
+// anything to merge.
+var errConflict = errors.New("branch moved while committing")
+
+// repoFile is a record file as the branch currently holds it.
+type repoFile struct {
+	content string
+	// sha identifies the blob for providers that want it back when the file
+	// is updated; empty where they do not.
+	sha string
+}
+
+// fileChange is one record file a commit writes.
+type fileChange struct {
+	path    string
+	content string
+	// existing is the file being replaced, nil when it is created.
+	existing *repoFile
+}
+
+// repoAPI is one provider's contents API, reduced to what the store needs.
+type repoAPI interface {
+	// readFile returns the file at path on the branch, with found false
+	// when there is none.
+	readFile(ctx context.Context, path string) (file repoFile, found bool, err error)
+	// commit writes every change in one commit on the branch, returning
+	// errConflict when another push got there first.
+	commit(ctx context.Context, changes []fileChange, message string) error
+}
+
+// GitPlugin implements the Store interface with one file per key in a
+// directory of a git branch, written through the provider's HTTP API so no
+// git binary or checkout is needed. The branch history is an audit trail of
+// every endpoint change.
+//
+// It is also a pluginapi.Batcher, so one publish round over many peers
+// lands as a single commit.
+type GitPlugin struct {
+	api        repoAPI
+	dir        string
+	maxRetries int
+	// mu serialises flushes, so two of this node's own commits never race
+	// each other.
+	mu sync.Mutex
+}
+
+// NewGitPlugin creates a new git repository plugin instance
+func NewGitPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	provider, err := cfg.GetStringRequired(configKeyProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := cfg.GetStringRequired(configKeyRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.GetStringRequired(configKeyToken)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, _ := cfg.GetString(configKeyURL)
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	branch, ok := cfg.GetString(configKeyBranch)
+	if !ok || branch == "" {
+		branch = defaultBranch
+	}
+
+	dir, ok := cfg.GetString(configKeyDir)
+	if !ok {
+		dir = defaultDir
+	}
+	dir = strings.Trim(dir, "/")
+
+	maxRetries := defaultMaxRetries
+	if val, ok := config[configKeyMaxRetries]; ok {
+		n, ok := val.(int)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer", configKeyMaxRetries)
+		}
+		maxRetries = n
+	}
+
+	timeout, ok, err := cfg.GetDuration(configKeyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		timeout = defaultTimeout
+	}
+	client := builtin.NewHTTPClient(timeout)
+
+	var api repoAPI
+	switch provider {
+	case "github":
+		if baseURL == "" {
+			baseURL = githubAPI
+		}
+		api = newGitHubAPI(baseURL, token, repo, branch, client)
+	case "gitea":
+		if baseURL == "" {
+			return nil, fmt.Errorf("%s is required for gitea", configKeyURL)
+		}
+		api = newGiteaAPI(baseURL, token, repo, branch, client)
+	case "gitlab":
+		if baseURL == "" {
+			return nil, fmt.Errorf("%s is required for gitlab", configKeyURL)
+		}
+		api = newGitLabAPI(baseURL, token, repo, branch, client)
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q (want github, gitea or gitlab)", configKeyProvider, provider)
+	}
+
+	return &GitPlugin{api: api, dir: dir, maxRetries: maxRetries}, nil
+}
+
+func (p *GitPlugin) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("key is not a valid file name: %q", key)
+	}
+	return path.Join(p.dir, key), nil
+}
+
+// Get reads the record file for key from the branch
+func (p *GitPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from builtin git plugin")
+
+	filePath, err := p.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	file, found, err := p.api.readFile(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(file.content)
+	if !found || value == "" {
+		return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, filePath)
+	}
+	return value, nil
+}
+
+// Set commits the record for key on its own. Publish rounds go through
+// NewBatch instead.
+func (p *GitPlugin) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to builtin git plugin")
+
+	batch := p.NewBatch()
+	if err := batch.Set(ctx, key, value); err != nil {
+		return err
+	}
+	return batch.Flush(ctx)
+}
+
+// NewBatch starts a set of records to be committed together
+func (p *GitPlugin) NewBatch() pluginapi.Batch {
+	return &gitBatch{plugin: p, records: make(map[string]string)}
+}
+
+type gitBatch struct {
+	plugin *GitPlugin
+	// records maps file path to value.
+	records map[string]string
+}
+
+func (b *gitBatch) Set(ctx context.Context, key string, value string) error {
+	filePath, err := b.plugin.path(key)
+	if err != nil {
+		return err
+	}
+	b.records[filePath] = value
+	return nil
+}
+
+// Flush commits every record of the batch that differs from the branch, in
+// one commit, retrying on a new branch head as long as other nodes' pushes
+// keep winning the race.
+func (b *gitBatch) Flush(ctx context.Context) error {
+	if len(b.records) == 0 {
+		return nil
+	}
+
+	p := b.plugin
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	logger := zerolog.Ctx(ctx)
+	for attempt := 0; ; attempt++ {
+		changes, err := b.changes(ctx)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			b.records = make(map[string]string)
+			return nil
+		}
+
+		err = p.api.commit(ctx, changes, commitMessage(changes))
+		if err == nil {
+			b.records = make(map[string]string)
+			return nil
+		}
+		if !errors.Is(err, errConflict) || attempt >= p.maxRetries {
+			return fmt.Errorf("failed to commit %d records: %w", len(changes), err)
+		}
+
+		logger.Debug().Int("attempt", attempt+1).Msg("git commit conflicted with another push, retrying")
+		// A short, growing pause so nodes that collided do not collide
+		// again in lockstep.
+		select {
+		case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// changes reads the current file for each record, leaving out the ones
+// already on the branch, so an unchanged round makes no commit at all.
+func (b *gitBatch) changes(ctx context.Context) ([]fileChange, error) {
+	paths := make([]string, 0, len(b.records))
+	for filePath := range b.records {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	var changes []fileChange
+	for _, filePath := range paths {
+		content := b.records[filePath] + "\n"
+
+		file, found, err := b.plugin.api.readFile(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if found && file.content == content {
+			continue
+		}
+
+		change := fileChange{path: filePath, content: content}
+		if found {
+			change.existing = &file
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func commitMessage(changes []fileChange) string {
+	if len(changes) == 1 {
+		return "Update stunmesh endpoint " + path.Base(changes[0].path)
+	}
+	return fmt.Sprintf("Update %d stunmesh endpoints", len(changes))
+}
+
+// isStatus reports whether err is an API answer with one of the statuses.
+func isStatus(err error, statuses ...int) bool {
+	var apiErr *builtin.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, status := range statuses {
+		if apiErr.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePath escapes each segment of a repository path for a URL.
+func escapePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
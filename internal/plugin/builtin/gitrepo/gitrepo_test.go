@@ -0,0 +1,554 @@
+//go:build builtin_git || builtin_all
+
+package gitrepo
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+func blobSHA(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestPlugin(t *testing.T, provider, url string) *GitPlugin {
+	t.Helper()
+
+	store, err := NewGitPlugin(pluginapi.PluginConfig{
+		"provider": provider,
+		"url":      url,
+		"repo":     "mesh/endpoints",
+		"token":    "test-token",
+	})
+	if err != nil {
+		t.Fatalf("NewGitPlugin() error = %v", err)
+	}
+	return store.(*GitPlugin)
+}
+
+// setBatch sets every record through one batch and flushes it.
+func setBatch(t *testing.T, p *GitPlugin, records map[string]string) {
+	t.Helper()
+
+	ctx := context.Background()
+	batch := p.NewBatch()
+	for key, value := range records {
+		if err := batch.Set(ctx, key, value); err != nil {
+			t.Fatalf("batch.Set(%q) error = %v", key, err)
+		}
+	}
+	if err := batch.Flush(ctx); err != nil {
+		t.Fatalf("batch.Flush() error = %v", err)
+	}
+}
+
+func checkRecords(t *testing.T, p *GitPlugin, want map[string]string) {
+	t.Helper()
+
+	for key, value := range want {
+		got, err := p.Get(context.Background(), key)
+		if err != nil || got != value {
+			t.Errorf("Get(%q) = (%q, %v), want (%q, nil)", key, got, err, value)
+		}
+	}
+}
+
+// fakeGitHub serves the contents read and the git data API for one branch,
+// keeping every commit's files in memory.
+type fakeGitHub struct {
+	mu      sync.Mutex
+	head    string
+	commits map[string]githubFakeCommit
+	trees   map[string]map[string]string
+	// refUpdates counts successful branch moves, i.e. commits that landed.
+	refUpdates int
+	// beforeRefUpdate, if set, runs once before the next ref update, to
+	// stand in for another node's push.
+	beforeRefUpdate func()
+}
+
+type githubFakeCommit struct {
+	tree   string
+	parent string
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeGitHub{
+		commits: make(map[string]githubFakeCommit),
+		trees:   map[string]map[string]string{"tree0": {"README.md": "endpoints\n"}},
+	}
+	f.commits["c0"] = githubFakeCommit{tree: "tree0"}
+	f.head = "c0"
+
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// push records a commit on top of head writing files, as another node would.
+func (f *fakeGitHub) push(files map[string]string) {
+	tree := make(map[string]string)
+	for path, content := range f.trees[f.commits[f.head].tree] {
+		tree[path] = content
+	}
+	for path, content := range files {
+		tree[path] = content
+	}
+	treeSHA := fmt.Sprintf("tree%d", len(f.trees))
+	f.trees[treeSHA] = tree
+	commitSHA := fmt.Sprintf("c%d", len(f.commits))
+	f.commits[commitSHA] = githubFakeCommit{tree: treeSHA, parent: f.head}
+	f.head = commitSHA
+}
+
+func (f *fakeGitHub) headFiles() map[string]string {
+	return f.trees[f.commits[f.head].tree]
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const repo = "/repos/mesh/endpoints"
+	switch {
+	case strings.HasPrefix(r.URL.Path, repo+"/contents/") && r.Method == http.MethodGet:
+		if r.URL.Query().Get("ref") != "main" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, ok := f.headFiles()[strings.TrimPrefix(r.URL.Path, repo+"/contents/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Wrapped the way GitHub wraps it.
+		encoded := base64.StdEncoding.EncodeToString([]byte(content))
+		var wrapped strings.Builder
+		for len(encoded) > 60 {
+			wrapped.WriteString(encoded[:60] + "\n")
+			encoded = encoded[60:]
+		}
+		wrapped.WriteString(encoded)
+		_ = json.NewEncoder(w).Encode(githubContent{Content: wrapped.String(), Encoding: "base64", SHA: blobSHA(content)})
+
+	case r.URL.Path == repo+"/git/ref/heads/main" && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"object": map[string]string{"sha": f.head}})
+
+	case strings.HasPrefix(r.URL.Path, repo+"/git/commits/") && r.Method == http.MethodGet:
+		commit, ok := f.commits[strings.TrimPrefix(r.URL.Path, repo+"/git/commits/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"tree": map[string]string{"sha": commit.tree}})
+
+	case r.URL.Path == repo+"/git/trees" && r.Method == http.MethodPost:
+		var body struct {
+			BaseTree string `json:"base_tree"`
+			Tree     []struct {
+				Path    string `json:"path"`
+				Content string `json:"content"`
+			} `json:"tree"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		tree := make(map[string]string)
+		for path, content := range f.trees[body.BaseTree] {
+			tree[path] = content
+		}
+		for _, entry := range body.Tree {
+			tree[entry.Path] = entry.Content
+		}
+		sha := fmt.Sprintf("tree%d", len(f.trees))
+		f.trees[sha] = tree
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+
+	case r.URL.Path == repo+"/git/commits" && r.Method == http.MethodPost:
+		var body struct {
+			Tree    string   `json:"tree"`
+			Parents []string `json:"parents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		sha := fmt.Sprintf("c%d", len(f.commits))
+		f.commits[sha] = githubFakeCommit{tree: body.Tree, parent: body.Parents[0]}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+
+	case r.URL.Path == repo+"/git/refs/heads/main" && r.Method == http.MethodPatch:
+		if hook := f.beforeRefUpdate; hook != nil {
+			f.beforeRefUpdate = nil
+			hook()
+		}
+		var body struct {
+			SHA   string `json:"sha"`
+			Force bool   `json:"force"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Force || f.commits[body.SHA].parent != f.head {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Update is not a fast forward"}`))
+			return
+		}
+		f.head = body.SHA
+		f.refUpdates++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"object": map[string]string{"sha": f.head}})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGitHub_BatchIsOneCommit(t *testing.T) {
+	fake, server := newFakeGitHub(t)
+	p := newTestPlugin(t, "github", server.URL)
+
+	records := map[string]string{"aaaa": "deadbeef", "bbbb": "cafef00d", "cccc": strings.Repeat("ab", 100)}
+	setBatch(t, p, records)
+
+	if fake.refUpdates != 1 {
+		t.Errorf("branch moved %d times, want 1 commit for the whole batch", fake.refUpdates)
+	}
+	if got := fake.headFiles()["stunmesh/aaaa"]; got != "deadbeef\n" {
+		t.Errorf("stunmesh/aaaa = %q, want the value and a newline", got)
+	}
+	if _, ok := fake.headFiles()["README.md"]; !ok {
+		t.Error("commit dropped a file it did not touch")
+	}
+	checkRecords(t, p, records)
+
+	// An unchanged round commits nothing; a changed record commits alone.
+	setBatch(t, p, records)
+	records["bbbb"] = "0123"
+	setBatch(t, p, records)
+	if fake.refUpdates != 2 {
+		t.Errorf("branch moved %d times, want 2", fake.refUpdates)
+	}
+	checkRecords(t, p, records)
+}
+
+func TestGitHub_ConflictRetriesOnNewHead(t *testing.T) {
+	fake, server := newFakeGitHub(t)
+	p := newTestPlugin(t, "github", server.URL)
+
+	// Another node pushes its own record while this one is committing.
+	fake.beforeRefUpdate = func() {
+		fake.push(map[string]string{"stunmesh/other": "0a0b\n"})
+	}
+
+	if err := p.Set(context.Background(), "mine", "0c0d"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	files := fake.headFiles()
+	if files["stunmesh/mine"] != "0c0d\n" || files["stunmesh/other"] != "0a0b\n" {
+		t.Errorf("head files = %v, want both nodes' records", files)
+	}
+}
+
+func TestGitHub_GivesUpAfterMaxRetries(t *testing.T) {
+	fake, server := newFakeGitHub(t)
+	store, err := NewGitPlugin(pluginapi.PluginConfig{
+		"provider": "github", "url": server.URL, "repo": "mesh/endpoints", "token": "test-token", "max_retries": 0,
+	})
+	if err != nil {
+		t.Fatalf("NewGitPlugin() error = %v", err)
+	}
+	fake.beforeRefUpdate = func() {
+		fake.push(map[string]string{"stunmesh/other": "0a0b\n"})
+	}
+
+	err = store.Set(context.Background(), "mine", "0c0d")
+	if err == nil || !strings.Contains(err.Error(), "not a fast forward") {
+		t.Errorf("Set() error = %v, want the conflict reported", err)
+	}
+}
+
+func TestGitHub_GetMissingRecord(t *testing.T) {
+	_, server := newFakeGitHub(t)
+	p := newTestPlugin(t, "github", server.URL)
+
+	_, err := p.Get(context.Background(), "abc123")
+	if err == nil || !strings.Contains(err.Error(), "record not found: stunmesh/abc123") {
+		t.Errorf("Get() error = %v, want record not found", err)
+	}
+}
+
+// fakeFiles is the branch of a Gitea or GitLab fake: file contents, and the
+// version each update has to name.
+type fakeFiles struct {
+	mu      sync.Mutex
+	files   map[string]string
+	version map[string]string
+	commits int
+	// beforeCommit, if set, runs once before the next commit.
+	beforeCommit func()
+}
+
+func newFakeFiles() *fakeFiles {
+	return &fakeFiles{files: make(map[string]string), version: make(map[string]string)}
+}
+
+func (f *fakeFiles) write(path, content string) {
+	f.files[path] = content
+	f.version[path] = blobSHA(content)
+}
+
+func (f *fakeFiles) runHook() {
+	if hook := f.beforeCommit; hook != nil {
+		f.beforeCommit = nil
+		hook()
+	}
+}
+
+func newFakeGitea(t *testing.T) (*fakeFiles, *httptest.Server) {
+	t.Helper()
+
+	f := newFakeFiles()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if r.Header.Get("Authorization") != "token test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		const repo = "/repos/mesh/endpoints"
+		switch {
+		case strings.HasPrefix(r.URL.Path, repo+"/contents/") && r.Method == http.MethodGet:
+			path := strings.TrimPrefix(r.URL.Path, repo+"/contents/")
+			content, ok := f.files[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(githubContent{
+				Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+				Encoding: "base64",
+				SHA:      f.version[path],
+			})
+
+		case r.URL.Path == repo+"/contents" && r.Method == http.MethodPost:
+			f.runHook()
+			var body struct {
+				Branch string `json:"branch"`
+				Files  []struct {
+					Operation string `json:"operation"`
+					Path      string `json:"path"`
+					Content   string `json:"content"`
+					SHA       string `json:"sha"`
+				} `json:"files"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			for _, file := range body.Files {
+				_, exists := f.files[file.Path]
+				if file.Operation == "create" && exists {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					return
+				}
+				if file.Operation == "update" && file.SHA != f.version[file.Path] {
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"message":"sha does not match"}`))
+					return
+				}
+			}
+			for _, file := range body.Files {
+				content, _ := base64.StdEncoding.DecodeString(file.Content)
+				f.write(file.Path, string(content))
+			}
+			f.commits++
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func TestGitea_BatchAndConflictRetry(t *testing.T) {
+	fake, server := newFakeGitea(t)
+	p := newTestPlugin(t, "gitea", server.URL)
+
+	records := map[string]string{"aaaa": "deadbeef", "bbbb": "cafef00d"}
+	setBatch(t, p, records)
+	if fake.commits != 1 {
+		t.Fatalf("made %d commits, want 1", fake.commits)
+	}
+
+	// The file changes under the update, so the sha it names goes stale.
+	fake.beforeCommit = func() {
+		fake.write("stunmesh/aaaa", "ffff\n")
+	}
+	records["aaaa"] = "0123"
+	setBatch(t, p, records)
+
+	if fake.commits != 2 {
+		t.Errorf("made %d commits, want 2", fake.commits)
+	}
+	checkRecords(t, p, records)
+}
+
+func newFakeGitLab(t *testing.T) (*fakeFiles, *httptest.Server) {
+	t.Helper()
+
+	f := newFakeFiles()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if r.Header.Get("Private-Token") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// The project and file path arrive as single escaped segments.
+		const project = "/projects/mesh%2Fendpoints"
+		path := r.URL.EscapedPath()
+		switch {
+		case strings.HasPrefix(path, project+"/repository/files/") && r.Method == http.MethodGet:
+			file := strings.TrimPrefix(r.URL.Path, "/projects/mesh/endpoints/repository/files/")
+			content, ok := f.files[file]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"content":        base64.StdEncoding.EncodeToString([]byte(content)),
+				"encoding":       "base64",
+				"last_commit_id": f.version[file],
+			})
+
+		case path == project+"/repository/commits" && r.Method == http.MethodPost:
+			f.runHook()
+			var body struct {
+				Branch  string `json:"branch"`
+				Actions []struct {
+					Action       string `json:"action"`
+					FilePath     string `json:"file_path"`
+					Content      string `json:"content"`
+					LastCommitID string `json:"last_commit_id"`
+				} `json:"actions"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			for _, action := range body.Actions {
+				_, exists := f.files[action.FilePath]
+				if (action.Action == "create" && exists) || (action.Action == "update" && action.LastCommitID != f.version[action.FilePath]) {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"message":"A file with this name already exists"}`))
+					return
+				}
+			}
+			for _, action := range body.Actions {
+				f.write(action.FilePath, action.Content)
+			}
+			f.commits++
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func TestGitLab_BatchAndConflictRetry(t *testing.T) {
+	fake, server := newFakeGitLab(t)
+	p := newTestPlugin(t, "gitlab", server.URL)
+
+	// Another node creates the same file first, so the create is refused
+	// and the retry turns it into an update.
+	fake.beforeCommit = func() {
+		fake.write("stunmesh/aaaa", "ffff\n")
+	}
+	records := map[string]string{"aaaa": "deadbeef", "bbbb": "cafef00d"}
+	setBatch(t, p, records)
+
+	if fake.commits != 1 {
+		t.Errorf("made %d commits, want 1", fake.commits)
+	}
+	checkRecords(t, p, records)
+}
+
+func TestConformance(t *testing.T) {
+	fakes := []struct {
+		provider string
+		serve    func(t *testing.T) *httptest.Server
+	}{
+		{"github", func(t *testing.T) *httptest.Server { _, server := newFakeGitHub(t); return server }},
+		{"gitea", func(t *testing.T) *httptest.Server { _, server := newFakeGitea(t); return server }},
+		{"gitlab", func(t *testing.T) *httptest.Server { _, server := newFakeGitLab(t); return server }},
+	}
+	for _, fake := range fakes {
+		t.Run(fake.provider, func(t *testing.T) {
+			plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+				return newTestPlugin(t, fake.provider, fake.serve(t).URL)
+			})
+		})
+	}
+}
+
+func TestNewGitPlugin_Validation(t *testing.T) {
+	base := func(extra pluginapi.PluginConfig) pluginapi.PluginConfig {
+		config := pluginapi.PluginConfig{"provider": "github", "repo": "mesh/endpoints", "token": "t"}
+		for k, v := range extra {
+			config[k] = v
+		}
+		return config
+	}
+
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		wantErr string
+	}{
+		{"missing provider", pluginapi.PluginConfig{"repo": "a/b", "token": "t"}, "provider is required"},
+		{"unknown provider", base(pluginapi.PluginConfig{"provider": "svn"}), `unsupported provider: "svn" (want github, gitea or gitlab)`},
+		{"gitea without url", base(pluginapi.PluginConfig{"provider": "gitea"}), "url is required for gitea"},
+		{"gitlab without url", base(pluginapi.PluginConfig{"provider": "gitlab"}), "url is required for gitlab"},
+		{"negative retries", base(pluginapi.PluginConfig{"max_retries": -1}), "max_retries must be a non-negative integer"},
+		{"missing token", pluginapi.PluginConfig{"provider": "github", "repo": "a/b"}, "token is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGitPlugin(tt.config)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewGitPlugin() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	_, server := newFakeGitHub(t)
+	p := newTestPlugin(t, "github", server.URL)
+
+	for _, key := range []string{"", ".git", "a/b", `a\b`} {
+		if err := p.Set(context.Background(), key, "00"); err == nil {
+			t.Errorf("Set(%q) should reject the key", key)
+		}
+	}
+}
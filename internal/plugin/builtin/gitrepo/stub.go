@@ -0,0 +1,6 @@
+//go:build !builtin_git && !builtin_all
+
+package gitrepo
+
+// This file exists to provide an empty package when builtin_git tag is not set
+// This prevents import errors when the build tag is disabled
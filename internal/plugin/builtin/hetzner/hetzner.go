@@ -0,0 +1,172 @@
+//go:build builtin_hetzner || builtin_all
+
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("hetzner", NewHetznerPlugin)
+}
+
+const (
+	hetznerAPI = "https://dns.hetzner.com/api/v1"
+
+	defaultTTL = 60 * time.Second
+
+	// Configuration keys
+	configKeyZoneName  = "zone"
+	configKeyAPIToken  = "token"
+	configKeySubdomain = "subdomain"
+	configKeyTTL       = "ttl"
+)
+
+// HetznerPlugin is the Hetzner DNS API as a builtin.RecordProvider
+type HetznerPlugin struct {
+	api      *builtin.JSONAPI
+	zoneName string
+	ttl      uint32
+	zoneID   *builtin.Lazy[string]
+}
+
+type hetznerRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    uint32 `json:"ttl,omitempty"`
+}
+
+// NewHetznerPlugin creates a new Hetzner DNS plugin instance
+func NewHetznerPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	zoneName, err := cfg.GetStringRequired(configKeyZoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.GetStringRequired(configKeyAPIToken)
+	if err != nil {
+		return nil, err
+	}
+
+	subdomain, _ := cfg.GetString(configKeySubdomain)
+
+	ttl, err := cfg.GetTTL(configKeyTTL, defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newHetznerPlugin(hetznerAPI, token, zoneName, ttl)
+	return builtin.NewRecordStore("hetzner", p, zoneName, subdomain), nil
+}
+
+func newHetznerPlugin(baseURL, token, zoneName string, ttl uint32) *HetznerPlugin {
+	p := &HetznerPlugin{
+		api: &builtin.JSONAPI{
+			BaseURL: baseURL,
+			Header:  http.Header{"Auth-API-Token": {token}},
+			Client:  builtin.NewHTTPClient(10 * time.Second),
+		},
+		zoneName: strings.TrimSuffix(zoneName, "."),
+		ttl:      ttl,
+	}
+	p.zoneID = builtin.NewLazy(p.getZoneID)
+	return p
+}
+
+func (p *HetznerPlugin) getZoneID(ctx context.Context) (string, error) {
+	var resp struct {
+		Zones []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"zones"`
+	}
+	if err := p.api.Do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(p.zoneName), nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to get zone ID: %w", err)
+	}
+
+	for _, zone := range resp.Zones {
+		if zone.Name == p.zoneName {
+			return zone.ID, nil
+		}
+	}
+	return "", fmt.Errorf("failed to get zone ID: zone not found: %s", p.zoneName)
+}
+
+// ListTXT returns the TXT records at name
+func (p *HetznerPlugin) ListTXT(ctx context.Context, name string) ([]builtin.TXTRecord, error) {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The API cannot filter by name or type, so the zone's records are
+	// fetched and filtered here.
+	var resp struct {
+		Records []hetznerRecord `json:"records"`
+	}
+	query := url.Values{"zone_id": {zoneID}, "per_page": {"1000"}}
+	if err := p.api.Do(ctx, http.MethodGet, "/records?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	relative := builtin.RelativeName(name, p.zoneName)
+	var records []builtin.TXTRecord
+	for _, record := range resp.Records {
+		if record.Type == "TXT" && record.Name == relative {
+			records = append(records, builtin.TXTRecord{ID: record.ID, Value: builtin.UnquoteTXT(record.Value)})
+		}
+	}
+	return records, nil
+}
+
+// record builds the body Hetzner expects for both creates and updates.
+func (p *HetznerPlugin) record(zoneID, name, value string) hetznerRecord {
+	return hetznerRecord{
+		ZoneID: zoneID,
+		Type:   "TXT",
+		Name:   builtin.RelativeName(name, p.zoneName),
+		// Quoted, because Hetzner stores the value as zone-file text and
+		// rejects a bare string longer than 255 bytes.
+		Value: builtin.QuoteTXT(value),
+		TTL:   p.ttl,
+	}
+}
+
+// CreateTXT adds a TXT record at name
+func (p *HetznerPlugin) CreateTXT(ctx context.Context, name, value string) error {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	return p.api.Do(ctx, http.MethodPost, "/records", p.record(zoneID, name, value), nil)
+}
+
+// UpdateTXT replaces an existing record, which Hetzner only does whole
+func (p *HetznerPlugin) UpdateTXT(ctx context.Context, name string, record builtin.TXTRecord, value string) error {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	return p.api.Do(ctx, http.MethodPut, "/records/"+url.PathEscape(record.ID), p.record(zoneID, name, value), nil)
+}
+
+// DeleteTXT removes an existing record
+func (p *HetznerPlugin) DeleteTXT(ctx context.Context, _ string, record builtin.TXTRecord) error {
+	return p.api.Do(ctx, http.MethodDelete, "/records/"+url.PathEscape(record.ID), nil, nil)
+}
@@ -0,0 +1,181 @@
+//go:build builtin_hetzner || builtin_all
+
+package hetzner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+func TestNewHetznerPlugin_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		wantErr string
+	}{
+		{"missing zone", pluginapi.PluginConfig{"token": "t"}, "zone is required"},
+		{"missing token", pluginapi.PluginConfig{"zone": "example.com"}, "token is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHetznerPlugin(tt.config)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewHetznerPlugin() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeHetzner serves the slice of the Hetzner DNS API the plugin uses, for
+// one zone, keeping records in memory.
+type fakeHetzner struct {
+	mu        sync.Mutex
+	records   map[string]hetznerRecord
+	nextID    int
+	zoneCalls int
+	writes    int
+}
+
+func newFakeHetzner(t *testing.T) (*fakeHetzner, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeHetzner{records: make(map[string]hetznerRecord)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeHetzner) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Auth-API-Token") != "test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/zones":
+		f.zoneCalls++
+		zones := []map[string]string{}
+		if r.URL.Query().Get("name") == "example.com" {
+			zones = append(zones, map[string]string{"id": "zone1", "name": "example.com"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"zones": zones})
+
+	case r.URL.Path == "/records" && r.Method == http.MethodGet:
+		if r.URL.Query().Get("zone_id") != "zone1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		found := []hetznerRecord{}
+		for _, record := range f.records {
+			found = append(found, record)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"records": found})
+
+	case r.URL.Path == "/records" && r.Method == http.MethodPost:
+		var record hetznerRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		f.nextID++
+		record.ID = fmt.Sprintf("rec%d", f.nextID)
+		f.records[record.ID] = record
+		f.writes++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"record": record})
+
+	case strings.HasPrefix(r.URL.Path, "/records/"):
+		id := strings.TrimPrefix(r.URL.Path, "/records/")
+		if _, ok := f.records[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.writes++
+		switch r.Method {
+		case http.MethodPut:
+			var record hetznerRecord
+			_ = json.NewDecoder(r.Body).Decode(&record)
+			if record.ZoneID == "" || record.Type == "" || record.Name == "" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			record.ID = id
+			f.records[id] = record
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"record": record})
+		case http.MethodDelete:
+			delete(f.records, id)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestStore(url string) *builtin.TXTStore {
+	return builtin.NewRecordStore("hetzner", newHetznerPlugin(url, "test-token", "example.com", 60), "example.com", "wg")
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		_, server := newFakeHetzner(t)
+		return newTestStore(server.URL)
+	})
+}
+
+func TestSetCreatesThenUpdates(t *testing.T) {
+	fake, server := newFakeHetzner(t)
+	store := newTestStore(server.URL)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second", "second"} {
+		if err := store.Set(ctx, "abc123", value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	got, err := store.Get(ctx, "abc123")
+	if err != nil || got != "second" {
+		t.Errorf("Get() = (%q, %v), want (second, nil)", got, err)
+	}
+	if record := fake.records["rec1"]; record.Name != "abc123.wg" || record.Value != `"second"` {
+		t.Errorf("record = %+v, want relative name abc123.wg with a quoted value", record)
+	}
+	if fake.writes != 2 {
+		t.Errorf("API saw %d writes, want 2", fake.writes)
+	}
+	if fake.zoneCalls != 1 {
+		t.Errorf("zone looked up %d times, want 1", fake.zoneCalls)
+	}
+}
+
+func TestGetFiltersOtherRecords(t *testing.T) {
+	fake, server := newFakeHetzner(t)
+	fake.records["a"] = hetznerRecord{ID: "a", ZoneID: "zone1", Type: "A", Name: "abc123.wg", Value: "192.0.2.1"}
+	fake.records["b"] = hetznerRecord{ID: "b", ZoneID: "zone1", Type: "TXT", Name: "other.wg", Value: `"other"`}
+	fake.records["c"] = hetznerRecord{ID: "c", ZoneID: "zone1", Type: "TXT", Name: "abc123.wg", Value: `"dead" "beef"`}
+
+	got, err := newTestStore(server.URL).Get(context.Background(), "abc123")
+	if err != nil || got != "deadbeef" {
+		t.Errorf("Get() = (%q, %v), want (deadbeef, nil)", got, err)
+	}
+}
+
+func TestUnknownZone(t *testing.T) {
+	_, server := newFakeHetzner(t)
+	store := builtin.NewRecordStore("hetzner", newHetznerPlugin(server.URL, "test-token", "other.org", 60), "other.org", "")
+
+	err := store.Set(context.Background(), "abc123", "value")
+	if err == nil || !strings.Contains(err.Error(), "zone not found") {
+		t.Fatalf("Set() error = %v, want zone not found", err)
+	}
+}
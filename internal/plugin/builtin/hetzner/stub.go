@@ -0,0 +1,6 @@
+//go:build !builtin_hetzner && !builtin_all
+
+package hetzner
+
+// This file exists to provide an empty package when builtin_hetzner tag is not set
+// This prevents import errors when the build tag is disabled
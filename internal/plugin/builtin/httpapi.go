@@ -0,0 +1,156 @@
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// NewHTTPClient returns the client every HTTP-based built-in uses.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		// Through the shared dialer so the request escapes a covering tunnel
+		// route instead of being carried into the tunnel it is meant to bring
+		// up. See internal/plugin/dialer.
+		Transport: dialer.Transport(),
+	}
+}
+
+// APIError is a provider API's answer with a status of 400 or above.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error: %s - %s", e.Status, e.Body)
+}
+
+// Unwrap classifies the status as one of the pluginapi errors, nil for a
+// status that is none of them, so every HTTP-based built-in reports a
+// revoked token or a rate limit the same way. A 404 is not ErrNotFound
+// here: a wrong zone or endpoint URL answers with one too, and only the
+// adapter knows whether the request was for the record itself.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden:
+		return pluginapi.ErrUnauthorized
+	case e.StatusCode == http.StatusTooManyRequests:
+		return pluginapi.ErrRateLimited
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode >= 500:
+		return pluginapi.ErrTransient
+	}
+	return nil
+}
+
+// JSONAPI is a JSON-over-HTTP provider API: a base URL and the headers that
+// authenticate to it.
+type JSONAPI struct {
+	BaseURL string
+	Header  http.Header
+	Client  *http.Client
+}
+
+// Do sends body (if non-nil) as JSON to BaseURL+path and decodes the answer
+// into out (if non-nil).
+func (a *JSONAPI) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var bodyReader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.BaseURL+path, bodyReader)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range a.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	data, err := DoRequest(a.Client, req)
+	if err != nil {
+		return err
+	}
+
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// DoRequest sends req and returns the response body, turning a status of
+// 400 or above into an *APIError.
+func DoRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(data)}
+	}
+
+	return data, nil
+}
+
+// Lazy resolves a value on first use, with the caller's context so the
+// request carries whatever escape that context asks for. Zone IDs are
+// resolved this way rather than at construction: plugins are constructed
+// before the device is read, so a lookup there has no fwmark to escape a
+// covering tunnel with, and it would also make stunmesh refuse to start
+// whenever the provider is briefly unreachable. A failed resolution is
+// retried on the next call.
+type Lazy[T any] struct {
+	mu      sync.Mutex
+	done    bool
+	value   T
+	resolve func(ctx context.Context) (T, error)
+}
+
+// NewLazy wraps resolve.
+func NewLazy[T any](resolve func(ctx context.Context) (T, error)) *Lazy[T] {
+	return &Lazy[T]{resolve: resolve}
+}
+
+// NewResolved returns a Lazy that already holds value, for when the config
+// supplies it outright.
+func NewResolved[T any](value T) *Lazy[T] {
+	return &Lazy[T]{done: true, value: value}
+}
+
+// Get returns the value, resolving it first if no call has succeeded yet.
+func (l *Lazy[T]) Get(ctx context.Context) (T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return l.value, nil
+	}
+	value, err := l.resolve(ctx)
+	if err != nil {
+		return value, err
+	}
+	l.value, l.done = value, true
+	return value, nil
+}
@@ -0,0 +1,33 @@
+package builtin
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func TestAPIError_Unwrap(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{status: http.StatusNotFound, want: nil},
+		{status: http.StatusUnauthorized, want: pluginapi.ErrUnauthorized},
+		{status: http.StatusForbidden, want: pluginapi.ErrUnauthorized},
+		{status: http.StatusTooManyRequests, want: pluginapi.ErrRateLimited},
+		{status: http.StatusRequestTimeout, want: pluginapi.ErrTransient},
+		{status: http.StatusBadGateway, want: pluginapi.ErrTransient},
+		{status: http.StatusBadRequest, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var err error = &APIError{StatusCode: tt.status, Status: http.StatusText(tt.status)}
+			if got := errors.Unwrap(err); got != tt.want {
+				t.Errorf("Unwrap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+//go:build builtin_lan || builtin_all
+
+package lan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("lan", NewLANPlugin)
+}
+
+const (
+	defaultGroup            = "239.255.77.77:7797"
+	defaultMagic            = "stunmesh-lan-v1"
+	defaultAnnounceInterval = 30 * time.Second
+	defaultQueryTimeout     = time.Second
+
+	// maxMessage bounds a datagram. A record is an encrypted endpoint of a
+	// few hundred bytes, so this leaves ample room while staying under the
+	// smallest IPv6 MTU.
+	maxMessage = 1200
+
+	opAnnounce = "announce"
+	opQuery    = "query"
+
+	// Configuration keys
+	configKeyGroup            = "group"
+	configKeyInterfaces       = "interfaces"
+	configKeyMagic            = "magic"
+	configKeyAnnounceInterval = "announce_interval"
+	configKeyExpiry           = "expiry"
+	configKeyQueryTimeout     = "query_timeout"
+)
+
+// message is one datagram of the protocol. An announce carries a record and
+// how long it may be cached; a query asks whoever owns key to announce it
+// now rather than at its next interval.
+//
+// From is a random per-instance ID: multicast loopback has to stay on for
+// two instances on one host to hear each other, so every instance also
+// hears itself.
+type message struct {
+	Magic string `json:"magic"`
+	From  string `json:"from"`
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	TTL   uint32 `json:"ttl,omitempty"`
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+// LANPlugin implements the Store interface over link-local multicast.
+//
+// There is no server and nothing to write to: Set keeps the record and
+// announces it on the configured interfaces, again every announce_interval,
+// and Get answers from what it set or has heard. The records are the sealed
+// endpoints stunmesh stores everywhere else, so sharing them on the LAN
+// discloses no more than a DNS TXT record does.
+type LANPlugin struct {
+	group            *net.UDPAddr
+	interfaces       []string
+	magic            string
+	announceInterval time.Duration
+	expiry           time.Duration
+	queryTimeout     time.Duration
+	id               string
+
+	// node is started on first use rather than at construction, the same
+	// deferral zone IDs get: the interfaces may not be up yet when plugins
+	// are constructed, and a failed start is retried on the next call.
+	node *builtin.Lazy[*node]
+
+	mu sync.Mutex
+	// started is the node once start has succeeded, for Close.
+	started *node
+	own     map[string]string
+	heard   map[string]cached
+	// changed is closed and replaced whenever heard gains a record, waking
+	// Gets waiting on a query.
+	changed chan struct{}
+}
+
+// NewLANPlugin creates a new LAN plugin instance
+func NewLANPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	group, ok := cfg.GetString(configKeyGroup)
+	if !ok || group == "" {
+		group = defaultGroup
+	}
+	groupAddr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil || !groupAddr.IP.IsMulticast() || groupAddr.Port == 0 {
+		return nil, fmt.Errorf("%s must be a multicast address with a port: %q", configKeyGroup, group)
+	}
+
+	interfaces, err := cfg.GetStringSlice(configKeyInterfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	magic, ok := cfg.GetString(configKeyMagic)
+	if !ok || magic == "" {
+		magic = defaultMagic
+	}
+
+	announceInterval, err := getDuration(cfg, configKeyAnnounceInterval, defaultAnnounceInterval)
+	if err != nil {
+		return nil, err
+	}
+	// Three missed announcements before a record is dropped.
+	expiry, err := getDuration(cfg, configKeyExpiry, 3*announceInterval)
+	if err != nil {
+		return nil, err
+	}
+	if expiry < announceInterval {
+		return nil, fmt.Errorf("%s must not be shorter than %s", configKeyExpiry, configKeyAnnounceInterval)
+	}
+	// Announcements carry it in whole seconds.
+	if expiry < time.Second {
+		return nil, fmt.Errorf("%s must be at least 1s", configKeyExpiry)
+	}
+	queryTimeout, err := getDuration(cfg, configKeyQueryTimeout, defaultQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	p := &LANPlugin{
+		group:            groupAddr,
+		interfaces:       interfaces,
+		magic:            magic,
+		announceInterval: announceInterval,
+		expiry:           expiry,
+		queryTimeout:     queryTimeout,
+		id:               hex.EncodeToString(id[:]),
+		own:              make(map[string]string),
+		heard:            make(map[string]cached),
+		changed:          make(chan struct{}),
+	}
+	p.node = builtin.NewLazy(p.start)
+	return p, nil
+}
+
+func getDuration(cfg *builtin.Config, key string, def time.Duration) (time.Duration, error) {
+	d, ok, err := cfg.GetDuration(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return def, nil
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return d, nil
+}
+
+// start opens the multicast socket and runs the receive and announce loops
+// for the life of the process.
+func (p *LANPlugin) start(ctx context.Context) (*node, error) {
+	n, err := listen(p.group, p.interfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.started = n
+	p.mu.Unlock()
+
+	logger := zerolog.Ctx(ctx).With().Str("plugin", "lan").Logger()
+	go p.receive(n, logger)
+	go p.announceLoop(n, logger)
+	return n, nil
+}
+
+// Close stops the plugin's socket and loops, if they were started.
+func (p *LANPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.node = builtin.NewResolved[*node](nil)
+	if p.started == nil {
+		return nil
+	}
+	return p.started.close()
+}
+
+// Get returns the record set or heard for key, asking for it on a miss
+func (p *LANPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from builtin lan plugin")
+
+	n, err := p.getNode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok, changed := p.lookup(key)
+	if ok {
+		return value, nil
+	}
+
+	// Nothing heard yet, most likely because this node started after the
+	// owner's last announcement. Ask, and give the owner query_timeout to
+	// answer before reporting the record missing.
+	if err := n.send(p.encode(message{Op: opQuery, Key: key})); err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(p.queryTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-timer.C:
+			return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, key)
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		value, ok, changed = p.lookup(key)
+		if ok {
+			return value, nil
+		}
+	}
+}
+
+// Set keeps value as this node's record for key and announces it
+func (p *LANPlugin) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to builtin lan plugin")
+
+	n, err := p.getNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := p.encode(message{Op: opAnnounce, Key: key, Value: value, TTL: p.ttl()})
+	if len(data) > maxMessage {
+		return fmt.Errorf("record for %s is %d bytes, more than the %d a datagram may carry", key, len(data), maxMessage)
+	}
+
+	p.mu.Lock()
+	p.own[key] = value
+	p.mu.Unlock()
+
+	return n.send(data)
+}
+
+// Watch reports keys whose heard record changes, including ones first heard
+// while watching. Announcements are pushed anyway; this only spares waiting
+// for the next refresh to read them.
+func (p *LANPlugin) Watch(ctx context.Context, keys []string, changed func(key string)) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(keys)).Msg("watch data in builtin lan plugin")
+
+	n, err := p.getNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]string, len(keys))
+	for {
+		p.mu.Lock()
+		wake := p.changed
+		var updated []string
+		for _, key := range keys {
+			if record, ok := p.heard[key]; ok && record.value != seen[key] {
+				seen[key] = record.value
+				updated = append(updated, key)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, key := range updated {
+			changed(key)
+		}
+
+		select {
+		case <-wake:
+		case <-n.done:
+			return errors.New("lan plugin is closed")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *LANPlugin) getNode(ctx context.Context) (*node, error) {
+	p.mu.Lock()
+	lazy := p.node
+	p.mu.Unlock()
+
+	n, err := lazy.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start lan plugin: %w", err)
+	}
+	if n == nil {
+		return nil, errors.New("lan plugin is closed")
+	}
+	return n, nil
+}
+
+// lookup returns the record this node set for key, else the unexpired one
+// heard for it, or the channel that is closed once something new is heard.
+func (p *LANPlugin) lookup(key string) (string, bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if value, ok := p.own[key]; ok {
+		return value, true, nil
+	}
+	if record, ok := p.heard[key]; ok {
+		if time.Now().Before(record.expires) {
+			return record.value, true, nil
+		}
+		delete(p.heard, key)
+	}
+	return "", false, p.changed
+}
+
+func (p *LANPlugin) ttl() uint32 {
+	return uint32(p.expiry / time.Second)
+}
+
+func (p *LANPlugin) encode(msg message) []byte {
+	msg.Magic = p.magic
+	msg.From = p.id
+	// A struct of strings always marshals.
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+func (p *LANPlugin) receive(n *node, logger zerolog.Logger) {
+	buf := make([]byte, 64*1024)
+	for {
+		size, _, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Debug().Err(err).Msg("lan receive failed")
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil || msg.Magic != p.magic || msg.From == p.id || msg.Key == "" {
+			continue
+		}
+
+		switch msg.Op {
+		case opAnnounce:
+			if msg.TTL == 0 {
+				continue
+			}
+			p.mu.Lock()
+			p.heard[msg.Key] = cached{value: msg.Value, expires: time.Now().Add(time.Duration(msg.TTL) * time.Second)}
+			close(p.changed)
+			p.changed = make(chan struct{})
+			p.mu.Unlock()
+
+		case opQuery:
+			// Only records this node owns are answered. Repeating what it
+			// heard could hand out a record its owner has since replaced.
+			p.mu.Lock()
+			value, ok := p.own[msg.Key]
+			p.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if err := n.send(p.encode(message{Op: opAnnounce, Key: msg.Key, Value: value, TTL: p.ttl()})); err != nil {
+				logger.Debug().Err(err).Str("key", msg.Key).Msg("lan answer failed")
+			}
+		}
+	}
+}
+
+func (p *LANPlugin) announceLoop(n *node, logger zerolog.Logger) {
+	ticker := time.NewTicker(p.announceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		announcements := make([][]byte, 0, len(p.own))
+		for key, value := range p.own {
+			announcements = append(announcements, p.encode(message{Op: opAnnounce, Key: key, Value: value, TTL: p.ttl()}))
+		}
+		p.mu.Unlock()
+
+		for _, data := range announcements {
+			if err := n.send(data); err != nil {
+				logger.Warn().Err(err).Msg("lan announce failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+//go:build builtin_lan || builtin_all
+
+package lan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+// newLoopbackPair returns two instances sharing a group on the loopback
+// interface, as two nodes on one LAN would.
+func newLoopbackPair(t *testing.T, magicB string) (*LANPlugin, *LANPlugin) {
+	t.Helper()
+
+	// Borrow a free port for the group.
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	_ = probe.Close()
+
+	newInstance := func(magic string) *LANPlugin {
+		config := pluginapi.PluginConfig{
+			"group":         fmt.Sprintf("239.255.77.77:%d", port),
+			"interfaces":    []interface{}{loopbackName(t)},
+			"query_timeout": "500ms",
+		}
+		if magic != "" {
+			config["magic"] = magic
+		}
+		store, err := NewLANPlugin(config)
+		if err != nil {
+			t.Fatalf("NewLANPlugin() error = %v", err)
+		}
+		p := store.(*LANPlugin)
+		t.Cleanup(func() { _ = p.Close() })
+		return p
+	}
+
+	a := newInstance("")
+	b := newInstance(magicB)
+
+	// Start both sockets up front; a failure here means the host cannot do
+	// multicast on loopback at all.
+	ctx := context.Background()
+	for _, p := range []*LANPlugin{a, b} {
+		if _, err := p.getNode(ctx); err != nil {
+			t.Skipf("multicast on loopback unavailable: %v", err)
+		}
+	}
+	return a, b
+}
+
+func loopbackName(t *testing.T) string {
+	t.Helper()
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		a, _ := newLoopbackPair(t, "")
+		return a
+	})
+}
+
+func TestSetThenGetAcrossInstances(t *testing.T) {
+	a, b := newLoopbackPair(t, "")
+	ctx := context.Background()
+
+	if err := a.Set(ctx, "abc123", "first"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, err := b.Get(ctx, "abc123")
+	if err != nil || got != "first" {
+		t.Fatalf("Get() = (%q, %v), want (first, nil)", got, err)
+	}
+
+	// A replaced record reaches the cache through the announcement alone.
+	if err := a.Set(ctx, "abc123", "second"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _, _ := b.lookup("abc123")
+		if got == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cached record = %q, want second", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	a, b := newLoopbackPair(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes := make(chan string, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Watch(ctx, []string{"abc123"}, func(key string) { changes <- key })
+	}()
+
+	set := func(key, value string) {
+		t.Helper()
+		if err := a.Set(ctx, key, value); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case key := <-changes:
+			if key != want {
+				t.Fatalf("changed(%q), want %q", key, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change reported for %q", want)
+		}
+	}
+
+	set("abc123", "first")
+	expect("abc123")
+
+	// Neither an unwatched key nor a repeated announcement is a change.
+	set("def456", "other")
+	set("abc123", "first")
+	select {
+	case key := <-changes:
+		t.Fatalf("unexpected change reported for %q", key)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	set("abc123", "second")
+	expect("abc123")
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Watch() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestGetQueriesOwner(t *testing.T) {
+	a, b := newLoopbackPair(t, "")
+	ctx := context.Background()
+
+	// Recorded before b listened to anything, as if b started late.
+	a.mu.Lock()
+	a.own["abc123"] = "value"
+	a.mu.Unlock()
+
+	got, err := b.Get(ctx, "abc123")
+	if err != nil || got != "value" {
+		t.Errorf("Get() = (%q, %v), want (value, nil) from the owner's answer", got, err)
+	}
+}
+
+func TestGetMissingRecord(t *testing.T) {
+	_, b := newLoopbackPair(t, "")
+
+	_, err := b.Get(context.Background(), "abc123")
+	if err == nil || !strings.Contains(err.Error(), "record not found: abc123") {
+		t.Errorf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestOtherMagicIgnored(t *testing.T) {
+	a, b := newLoopbackPair(t, "other-mesh")
+
+	if err := a.Set(context.Background(), "abc123", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := b.Get(context.Background(), "abc123"); err == nil {
+		t.Error("Get() succeeded across different magic values")
+	}
+}
+
+func TestExpiredRecordDropped(t *testing.T) {
+	store, err := NewLANPlugin(pluginapi.PluginConfig{})
+	if err != nil {
+		t.Fatalf("NewLANPlugin() error = %v", err)
+	}
+	p := store.(*LANPlugin)
+	p.heard["abc123"] = cached{value: "stale", expires: time.Now().Add(-time.Second)}
+
+	if _, ok, _ := p.lookup("abc123"); ok {
+		t.Error("lookup() returned an expired record")
+	}
+}
+
+func TestNewLANPlugin_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config pluginapi.PluginConfig
+	}{
+		{"unicast group", pluginapi.PluginConfig{"group": "192.0.2.1:7797"}},
+		{"group without port", pluginapi.PluginConfig{"group": "239.255.77.77"}},
+		{"interfaces not a list", pluginapi.PluginConfig{"interfaces": 3}},
+		{"expiry shorter than interval", pluginapi.PluginConfig{"announce_interval": "30s", "expiry": "10s"}},
+		{"expiry under a second", pluginapi.PluginConfig{"announce_interval": "100ms", "expiry": "500ms"}},
+		{"negative interval", pluginapi.PluginConfig{"announce_interval": "-1s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLANPlugin(tt.config); err == nil {
+				t.Error("NewLANPlugin() should reject the config")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+//go:build builtin_lan || builtin_all
+
+package lan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// node is the multicast socket, joined to the group on every interface the
+// plugin announces on.
+//
+// It is the one socket in the built-ins that does not go through
+// internal/plugin/dialer, and need not: the group is link-local, and each
+// datagram leaves on an interface chosen here with IP_MULTICAST_IF rather
+// than by a route lookup, so a covering tunnel route never sees it.
+type node struct {
+	conn       *net.UDPConn
+	group      *net.UDPAddr
+	interfaces []net.Interface
+
+	// One of these is set, by the group's family.
+	p4 *ipv4.PacketConn
+	p6 *ipv6.PacketConn
+
+	// sendMu serialises choosing the outgoing interface with writing on it.
+	sendMu    sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// listen binds the group's port, joins the group on the named interfaces,
+// or on every interface that is up and multicast-capable when none are
+// named, and turns on multicast loopback so instances on one host hear
+// each other.
+func listen(group *net.UDPAddr, names []string) (*node, error) {
+	interfaces, err := selectInterfaces(names)
+	if err != nil {
+		return nil, err
+	}
+
+	network := "udp4"
+	if group.IP.To4() == nil {
+		network = "udp6"
+	}
+
+	// ListenMulticastUDP sets SO_REUSEADDR, so several instances on one
+	// host can share the port.
+	conn, err := net.ListenMulticastUDP(network, &interfaces[0], group)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{conn: conn, group: group, done: make(chan struct{})}
+	if network == "udp4" {
+		n.p4 = ipv4.NewPacketConn(conn)
+		err = n.p4.SetMulticastLoopback(true)
+	} else {
+		n.p6 = ipv6.NewPacketConn(conn)
+		err = n.p6.SetMulticastLoopback(true)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// ListenMulticastUDP joined the first interface; join the rest. An
+	// interface that refuses (no address of the group's family, say) is
+	// left out rather than failing the whole plugin.
+	n.interfaces = interfaces[:1]
+	var joinErrs []error
+	for i := range interfaces[1:] {
+		ifi := &interfaces[i+1]
+		if n.p4 != nil {
+			err = n.p4.JoinGroup(ifi, group)
+		} else {
+			err = n.p6.JoinGroup(ifi, group)
+		}
+		if err != nil {
+			joinErrs = append(joinErrs, fmt.Errorf("%s: %w", ifi.Name, err))
+			continue
+		}
+		n.interfaces = append(n.interfaces, *ifi)
+	}
+	if len(names) > 0 && len(joinErrs) > 0 {
+		// Interfaces the config names explicitly have to work.
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to join multicast group: %w", errors.Join(joinErrs...))
+	}
+
+	return n, nil
+}
+
+func selectInterfaces(names []string) ([]net.Interface, error) {
+	if len(names) > 0 {
+		interfaces := make([]net.Interface, 0, len(names))
+		for _, name := range names {
+			ifi, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("interface %s: %w", name, err)
+			}
+			interfaces = append(interfaces, *ifi)
+		}
+		return interfaces, nil
+	}
+
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// WireGuard interfaces are point-to-point without the multicast flag,
+	// so the tunnels themselves are never picked.
+	var interfaces []net.Interface
+	for _, ifi := range all {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0 {
+			interfaces = append(interfaces, ifi)
+		}
+	}
+	if len(interfaces) == 0 {
+		return nil, errors.New("no multicast-capable interface is up")
+	}
+	return interfaces, nil
+}
+
+// send multicasts data on every joined interface, failing only when it
+// could be sent on none.
+func (n *node) send(data []byte) error {
+	n.sendMu.Lock()
+	defer n.sendMu.Unlock()
+
+	var errs []error
+	for i := range n.interfaces {
+		ifi := &n.interfaces[i]
+
+		var err error
+		if n.p4 != nil {
+			err = n.p4.SetMulticastInterface(ifi)
+		} else {
+			err = n.p6.SetMulticastInterface(ifi)
+		}
+		if err == nil {
+			_, err = n.conn.WriteTo(data, n.group)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ifi.Name, err))
+		}
+	}
+
+	if len(errs) == len(n.interfaces) {
+		return fmt.Errorf("failed to send to multicast group: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (n *node) close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_lan && !builtin_all
+
+package lan
+
+// This file exists to provide an empty package when builtin_lan tag is not set
+// This prevents import errors when the build tag is disabled
@@ -3,6 +3,7 @@
 package opendht
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
@@ -15,6 +16,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
@@ -50,6 +52,14 @@ type OpenDHTPlugin struct {
 	endpoints []string
 	magic     string
 	client    *http.Client
+	// listenClient has no timeout: a listen stays open for as long as it is
+	// watched.
+	listenClient *http.Client
+
+	mu sync.Mutex
+	// lastTs is the Ts last set under each key, which the next Set for the
+	// key has to exceed.
+	lastTs map[string]int64
 }
 
 // envelope wraps the value stored under a key.
@@ -162,9 +172,11 @@ func NewOpenDHTPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
 	}
 
 	return &OpenDHTPlugin{
-		endpoints: endpoints,
-		magic:     magic,
-		client:    client,
+		endpoints:    endpoints,
+		magic:        magic,
+		client:       client,
+		listenClient: &http.Client{Transport: dialer.Transport()},
+		lastTs:       make(map[string]int64),
 	}, nil
 }
 
@@ -204,22 +216,7 @@ func (p *OpenDHTPlugin) doRequestTo(ctx context.Context, endpoint, method, key s
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(data))
-	}
-
-	return data, nil
+	return builtin.DoRequest(p.client, req)
 }
 
 // Get retrieves a value from OpenDHT
@@ -241,40 +238,49 @@ func (p *OpenDHTPlugin) Get(ctx context.Context, key string) (string, error) {
 	// also absorbs whatever a third party publishes under the same key.
 	var newest *envelope
 	for _, line := range bytes.Split(data, []byte("\n")) {
-		if len(bytes.TrimSpace(line)) == 0 {
+		e, ok := p.decode(line)
+		if !ok {
 			continue
 		}
 
-		var v value
-		if err := json.Unmarshal(line, &v); err != nil {
-			continue
+		if newest == nil || e.Ts > newest.Ts {
+			newest = e
 		}
+	}
 
-		raw, err := base64.StdEncoding.DecodeString(v.Data)
-		if err != nil {
-			continue
-		}
+	if newest == nil {
+		return "", fmt.Errorf("%w: no value found for key %s", pluginapi.ErrNotFound, key)
+	}
 
-		var e envelope
-		if err := json.Unmarshal(raw, &e); err != nil {
-			continue
-		}
+	return newest.Data, nil
+}
 
-		if e.Magic != p.magic {
-			continue
-		}
+// decode unwraps one value line, reporting false for anything that is not
+// an envelope with our magic.
+func (p *OpenDHTPlugin) decode(line []byte) (*envelope, bool) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, false
+	}
 
-		if newest == nil || e.Ts > newest.Ts {
-			found := e
-			newest = &found
-		}
+	var v value
+	if err := json.Unmarshal(line, &v); err != nil {
+		return nil, false
 	}
 
-	if newest == nil {
-		return "", fmt.Errorf("no value found for key: %s", key)
+	raw, err := base64.StdEncoding.DecodeString(v.Data)
+	if err != nil {
+		return nil, false
 	}
 
-	return newest.Data, nil
+	var e envelope
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, false
+	}
+
+	if e.Magic != p.magic {
+		return nil, false
+	}
+	return &e, true
 }
 
 // Set stores a value in OpenDHT
@@ -288,7 +294,7 @@ func (p *OpenDHTPlugin) Set(ctx context.Context, key string, value string) error
 
 	payload, err := json.Marshal(&envelope{
 		Magic: p.magic,
-		Ts:    time.Now().Unix(),
+		Ts:    p.nextTs(key),
 		Data:  value,
 	})
 	if err != nil {
@@ -305,3 +311,108 @@ func (p *OpenDHTPlugin) Set(ctx context.Context, key string, value string) error
 	_, err = p.doRequest(ctx, http.MethodPost, key, body)
 	return err
 }
+
+// nextTs returns the Ts for a new value under key: the current time, unless
+// a value was set under key within the same second. Ts has a resolution of
+// a second, and two values sharing one would leave Get to pick either.
+func (p *OpenDHTPlugin) nextTs(key string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ts := max(time.Now().Unix(), p.lastTs[key]+1)
+	p.lastTs[key] = ts
+	return ts
+}
+
+// Watch listens on every key through the proxy's LISTEN method, which
+// streams the values under a key as they are published. A value reports its
+// key changed when it is newer than the newest seen and carries different
+// data, so the republishing every refresh cycle does is not a change.
+func (p *OpenDHTPlugin) Watch(ctx context.Context, keys []string, changed func(key string)) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(keys)).Msg("watch data in builtin opendht plugin")
+
+	for _, key := range keys {
+		if !keyPattern.MatchString(key) {
+			return fmt.Errorf("key must be 40 hex characters: %s", key)
+		}
+	}
+
+	// One listen ending ends the watch; the caller subscribes again.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(keys))
+	for _, key := range keys {
+		go func(key string) {
+			errs <- p.listen(ctx, key, changed)
+		}(key)
+	}
+
+	if len(keys) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := <-errs
+	cancel()
+	for range keys[1:] {
+		<-errs
+	}
+	return err
+}
+
+// listen follows key on the first endpoint that accepts the listen, until
+// the stream ends.
+func (p *OpenDHTPlugin) listen(ctx context.Context, key string, changed func(key string)) error {
+	logger := zerolog.Ctx(ctx)
+
+	var body io.ReadCloser
+	var errs []error
+	for _, endpoint := range p.endpoints {
+		req, err := http.NewRequestWithContext(ctx, "LISTEN", fmt.Sprintf("%s/key/%s", endpoint, key), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := p.listenClient.Do(req)
+		if err == nil && resp.StatusCode >= 400 {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("API error: %s", resp.Status)
+		}
+		if err == nil {
+			body = resp.Body
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logger.Warn().Err(err).Str("endpoint", endpoint).Msg("opendht endpoint failed, trying next")
+		errs = append(errs, fmt.Errorf("%s: %w", endpoint, err))
+	}
+	if body == nil {
+		return errors.Join(errs...)
+	}
+	defer func() { _ = body.Close() }()
+
+	var newest *envelope
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		e, ok := p.decode(scanner.Bytes())
+		if !ok || (newest != nil && e.Ts <= newest.Ts) {
+			continue
+		}
+		if newest == nil || e.Data != newest.Data {
+			changed(key)
+		}
+		newest = e
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("listen on key %s ended", key)
+}
@@ -12,10 +12,12 @@ import (
 	"net/http/httptest"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
 )
 
 const testKey = "3061b8fcbdb6972059518f1adc3590dca6a5f352"
@@ -273,6 +275,41 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+// newFakeProxy returns a proxy that keeps every value published under a
+// key, as the DHT does until they expire.
+func newFakeProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	values := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/key/")
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			values[key] = append(values[key], string(body))
+			return
+		}
+		for _, v := range values[key] {
+			_, _ = fmt.Fprintln(w, v)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		return newTestPlugin(t, newFakeProxy(t).URL)
+	})
+}
+
 func TestKeyMustBeInfoHash(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Error("a malformed key must not reach the proxy")
@@ -639,3 +676,50 @@ func TestAllEndpointsFailingReportsEach(t *testing.T) {
 		}
 	}
 }
+
+func TestWatchReportsChangedValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "LISTEN" || r.URL.Path != "/key/"+testKey {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		// The current value, its republication, someone else's value, and
+		// then a new endpoint; the stream then ends as a dropped listen does.
+		_, _ = fmt.Fprintln(w, line(t, defaultMagic, 100, "first"))
+		_, _ = fmt.Fprintln(w, line(t, defaultMagic, 200, "first"))
+		_, _ = fmt.Fprintln(w, rawLine(t, "not ours"))
+		_, _ = fmt.Fprintln(w, line(t, defaultMagic, 150, "stale"))
+		_, _ = fmt.Fprintln(w, line(t, defaultMagic, 300, "second"))
+		w.(http.Flusher).Flush()
+	}))
+	defer server.Close()
+
+	p := newTestPlugin(t, server.URL).(pluginapi.Watcher)
+
+	var changes []string
+	err := p.Watch(context.Background(), []string{testKey}, func(key string) {
+		changes = append(changes, key)
+	})
+	if err == nil || !strings.Contains(err.Error(), "ended") {
+		t.Errorf("Watch() error = %v, want the listen to have ended", err)
+	}
+	if want := []string{testKey, testKey}; !slices.Equal(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestWatchStopsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	p := newTestPlugin(t, server.URL).(pluginapi.Watcher)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := p.Watch(ctx, []string{testKey}, func(string) {}); err != context.DeadlineExceeded {
+		t.Errorf("Watch() error = %v, want context.DeadlineExceeded", err)
+	}
+}
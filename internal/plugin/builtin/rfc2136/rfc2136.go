@@ -0,0 +1,316 @@
+//go:build builtin_rfc2136 || builtin_all
+
+package rfc2136
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/dialer"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("rfc2136", NewRFC2136Plugin)
+}
+
+const (
+	defaultTTL       = 60 * time.Second
+	defaultTimeout   = 10 * time.Second
+	defaultAlgorithm = "hmac-sha256"
+
+	// opcodeUpdate is the DNS UPDATE opcode (RFC 2136 section 1.3).
+	opcodeUpdate = 5
+
+	// Configuration keys
+	configKeyServer        = "server"
+	configKeyZone          = "zone"
+	configKeySubdomain     = "subdomain"
+	configKeyTSIGKey       = "tsig_key"
+	configKeyTSIGSecret    = "tsig_secret"
+	configKeyTSIGAlgorithm = "tsig_algorithm"
+	configKeyTTL           = "ttl"
+	configKeyTimeout       = "timeout"
+)
+
+// updateRCodes names the response codes RFC 2136 adds, which dnsmessage
+// does not know by name.
+var updateRCodes = map[dnsmessage.RCode]string{
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+// RFC2136Plugin implements the Store interface
+type RFC2136Plugin struct {
+	server    string
+	zone      string
+	subdomain string
+	ttl       uint32
+	timeout   time.Duration
+	// key is nil when no tsig_key is configured, for servers that authorize
+	// updates by source address instead.
+	key *tsigKey
+}
+
+// NewRFC2136Plugin creates a new RFC 2136 plugin instance
+func NewRFC2136Plugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	server, err := cfg.GetStringRequired(configKeyServer)
+	if err != nil {
+		return nil, err
+	}
+	// The server is also the nameserver lookups are pinned to, and a
+	// nameserver has to be an address: resolving its name would need a
+	// nameserver of its own.
+	normalized, ok := dialer.NormalizeNameserver(server)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an IP address, optionally with a port: %q", configKeyServer, server)
+	}
+
+	zone, err := cfg.GetStringRequired(configKeyZone)
+	if err != nil {
+		return nil, err
+	}
+
+	subdomain, _ := cfg.GetString(configKeySubdomain)
+
+	ttl, err := cfg.GetTTL(configKeyTTL, defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, ok, err := cfg.GetDuration(configKeyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		timeout = defaultTimeout
+	}
+
+	p := &RFC2136Plugin{
+		server:    normalized,
+		zone:      fqdn(zone),
+		subdomain: subdomain,
+		ttl:       ttl,
+		timeout:   timeout,
+	}
+
+	keyName, _ := cfg.GetString(configKeyTSIGKey)
+	secret, _ := cfg.GetString(configKeyTSIGSecret)
+	if keyName != "" || secret != "" {
+		if keyName == "" || secret == "" {
+			return nil, fmt.Errorf("%s and %s must be set together", configKeyTSIGKey, configKeyTSIGSecret)
+		}
+
+		algorithm, ok := cfg.GetString(configKeyTSIGAlgorithm)
+		if !ok || algorithm == "" {
+			algorithm = defaultAlgorithm
+		}
+
+		p.key, err = newTSIGKey(keyName, secret, algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *RFC2136Plugin) getRecordName(key string) string {
+	// Key is already SHA1 hex from stunmesh
+	if p.subdomain != "" {
+		return fmt.Sprintf("%s.%s.%s", key, p.subdomain, p.zone)
+	}
+	return fmt.Sprintf("%s.%s", key, p.zone)
+}
+
+// Get retrieves a value with a plain TXT query to the configured server
+func (p *RFC2136Plugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from builtin rfc2136 plugin")
+
+	if err := builtin.CheckKey(key); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// Through the dialer's resolver, pinned to the server being updated, so
+	// the query escapes a covering tunnel the same way the update does and
+	// reads the record back from its source rather than a cache that may
+	// still hold the previous one.
+	name := p.getRecordName(key)
+	records, err := dialer.Resolver().LookupTXT(dialer.WithNameserver(ctx, p.server), name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, name)
+		}
+		return "", err
+	}
+
+	// LookupTXT already joins the strings of each record. Set replaces the
+	// whole RRset, so there is normally exactly one.
+	if len(records) == 0 || records[0] == "" {
+		return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, name)
+	}
+	return records[0], nil
+}
+
+// Set replaces the TXT record with a DNS UPDATE message
+func (p *RFC2136Plugin) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to builtin rfc2136 plugin")
+
+	if err := builtin.CheckKey(key); err != nil {
+		return err
+	}
+	msg, err := p.buildUpdate(p.getRecordName(key), value)
+	if err != nil {
+		return fmt.Errorf("failed to build update: %w", err)
+	}
+
+	if p.key != nil {
+		msg, err = p.key.sign(msg, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sign update: %w", err)
+		}
+	}
+
+	header, err := p.exchange(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("update rejected: %s", rcodeName(header.RCode))
+	}
+	return nil
+}
+
+// buildUpdate replaces the whole TXT RRset at name in one message: delete
+// whatever is there, then add the new record. The server applies both or
+// neither, so a reader never sees the name without a record.
+func (p *RFC2136Plugin) buildUpdate(name, value string) ([]byte, error) {
+	recordName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneName, err := dnsmessage.NewName(p.zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:     binary.BigEndian.Uint16(id[:]),
+		OpCode: opcodeUpdate,
+	})
+
+	// An update's question section names the zone (RFC 2136 section 2.3).
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  zoneName,
+		Type:  dnsmessage.TypeSOA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+
+	// And its authority section carries the updates (section 2.5).
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	if err := b.UnknownResource(dnsmessage.ResourceHeader{
+		Name:  recordName,
+		Class: dnsmessage.ClassANY,
+	}, dnsmessage.UnknownResource{Type: dnsmessage.TypeTXT}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(dnsmessage.ResourceHeader{
+		Name:  recordName,
+		Class: dnsmessage.ClassINET,
+		TTL:   p.ttl,
+	}, dnsmessage.TXTResource{TXT: builtin.SplitTXT(value)}); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// exchange sends msg over TCP and returns the header of the reply. TCP
+// rather than UDP because a signed update carrying a dualstack record comes
+// close to the 512 bytes a UDP message without EDNS may hold.
+func (p *RFC2136Plugin) exchange(ctx context.Context, msg []byte) (dnsmessage.Header, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// Through the shared dialer so the update escapes a covering tunnel
+	// route instead of being carried into the tunnel it is meant to bring
+	// up. See internal/plugin/dialer.
+	conn, err := dialer.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return dnsmessage.Header{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Closing the connection is what unblocks a read in progress when the
+	// context ends.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	if _, err := conn.Write(append(framed, msg...)); err != nil {
+		return dnsmessage.Header{}, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return dnsmessage.Header{}, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return dnsmessage.Header{}, err
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(reply)
+	if err != nil {
+		return dnsmessage.Header{}, fmt.Errorf("failed to parse reply: %w", err)
+	}
+	if !header.Response || header.ID != binary.BigEndian.Uint16(msg[0:2]) {
+		return dnsmessage.Header{}, errors.New("reply does not match the update")
+	}
+
+	return header, nil
+}
+
+func rcodeName(rcode dnsmessage.RCode) string {
+	if name, ok := updateRCodes[rcode]; ok {
+		return name
+	}
+	return rcode.String()
+}
@@ -0,0 +1,450 @@
+//go:build builtin_rfc2136 || builtin_all
+
+package rfc2136
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+const (
+	testKey    = "3061b8fcbdb6972059518f1adc3590dca6a5f352"
+	testZone   = "example.com."
+	testSecret = "c3R1bm1lc2gtdGVzdC1zZWNyZXQ="
+)
+
+// testServer is an authoritative server for testZone: it answers TXT
+// queries over UDP and applies TSIG-verified updates over TCP, on the same
+// port, the way the plugin expects one server address to serve both.
+type testServer struct {
+	t      *testing.T
+	addr   string
+	secret []byte
+
+	mu      sync.Mutex
+	records map[string][]string
+	updates int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	secret, err := base64.StdEncoding.DecodeString(testSecret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+
+	s := &testServer{t: t, secret: secret, records: make(map[string][]string)}
+
+	// Bind UDP first on an ephemeral port, then TCP on the same one;
+	// another process may hold the TCP side, so retry a few ports.
+	for attempt := 0; attempt < 10; attempt++ {
+		udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen udp: %v", err)
+		}
+		tcp, err := net.Listen("tcp4", udp.LocalAddr().String())
+		if err != nil {
+			_ = udp.Close()
+			continue
+		}
+
+		s.addr = udp.LocalAddr().String()
+		t.Cleanup(func() {
+			_ = udp.Close()
+			_ = tcp.Close()
+		})
+		go s.serveUDP(udp)
+		go s.serveTCP(tcp)
+		return s
+	}
+
+	t.Fatal("failed to bind udp and tcp on the same port")
+	return nil
+}
+
+func (s *testServer) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.answer(buf[:n]); reply != nil {
+			_, _ = conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (s *testServer) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, msg); err != nil {
+				return
+			}
+
+			reply := s.update(msg)
+			_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+		}()
+	}
+}
+
+// answer serves a TXT query from the record map.
+func (s *testServer) answer(msg []byte) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	txt, ok := s.records[strings.ToLower(q.Name.String())]
+	s.mu.Unlock()
+
+	rcode := dnsmessage.RCodeSuccess
+	if !ok {
+		rcode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:            header.ID,
+		Response:      true,
+		Authoritative: true,
+		RCode:         rcode,
+	})
+	_ = b.StartQuestions()
+	_ = b.Question(q)
+	if ok && q.Type == dnsmessage.TypeTXT {
+		_ = b.StartAnswers()
+		_ = b.TXTResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.TXTResource{TXT: txt})
+	}
+	reply, _ := b.Finish()
+	return reply
+}
+
+// update verifies the TSIG on msg and applies its delete-then-add.
+func (s *testServer) update(msg []byte) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		s.t.Errorf("server: failed to parse update: %v", err)
+		return nil
+	}
+
+	reply := func(rcode dnsmessage.RCode) []byte {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, OpCode: opcodeUpdate, RCode: rcode})
+		built, _ := b.Finish()
+		return built
+	}
+
+	if header.OpCode != opcodeUpdate {
+		return reply(dnsmessage.RCodeNotImplemented)
+	}
+	if !s.verify(msg) {
+		return reply(9) // NOTAUTH
+	}
+
+	zone, err := p.Question()
+	if err != nil || zone.Name.String() != testZone || zone.Type != dnsmessage.TypeSOA {
+		s.t.Errorf("server: zone section = %v, %v", zone, err)
+		return reply(dnsmessage.RCodeFormatError)
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		h, err := p.AuthorityHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return reply(dnsmessage.RCodeFormatError)
+		}
+		name := strings.ToLower(h.Name.String())
+		switch h.Class {
+		case dnsmessage.ClassANY:
+			_ = p.SkipAuthority()
+			delete(s.records, name)
+		case dnsmessage.ClassINET:
+			r, err := p.TXTResource()
+			if err != nil {
+				return reply(dnsmessage.RCodeFormatError)
+			}
+			s.records[name] = r.TXT
+		default:
+			return reply(dnsmessage.RCodeFormatError)
+		}
+	}
+	s.updates++
+
+	return reply(dnsmessage.RCodeSuccess)
+}
+
+// verify recomputes the HMAC-SHA256 TSIG on msg with the server's copy of
+// the secret. The TSIG record is the last one in the message, and its
+// owner name and algorithm name are written uncompressed.
+func (s *testServer) verify(msg []byte) bool {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return false
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+	_ = p.SkipAllAuthorities()
+
+	var tsig *dnsmessage.Resource
+	for {
+		r, err := p.Additional()
+		if err != nil {
+			break
+		}
+		if r.Header.Type == typeTSIG {
+			found := r
+			tsig = &found
+		}
+	}
+	if tsig == nil {
+		return false
+	}
+
+	rdata := tsig.Body.(*dnsmessage.UnknownResource).Data
+	keyName, _ := appendName(nil, tsig.Header.Name.String())
+	rrLen := len(keyName) + 10 + len(rdata)
+	unsigned := append([]byte(nil), msg[:len(msg)-rrLen]...)
+	binary.BigEndian.PutUint16(unsigned[10:12], binary.BigEndian.Uint16(unsigned[10:12])-1)
+
+	algLen := len("\x0bhmac-sha256\x00")
+	fixed := rdata[algLen : algLen+8] // time signed + fudge
+	macLen := int(binary.BigEndian.Uint16(rdata[algLen+8:]))
+	got := rdata[algLen+10 : algLen+10+macLen]
+
+	vars := append([]byte(nil), keyName...)
+	vars = binary.BigEndian.AppendUint16(vars, classANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars = append(vars, rdata[:algLen]...)
+	vars = append(vars, fixed...)
+	vars = append(vars, 0, 0, 0, 0)
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(unsigned)
+	mac.Write(vars)
+	return hmac.Equal(mac.Sum(nil), got)
+}
+
+func (s *testServer) record(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[name]
+}
+
+func newTestPlugin(t *testing.T, server string, extra pluginapi.PluginConfig) pluginapi.Store {
+	t.Helper()
+
+	config := pluginapi.PluginConfig{
+		configKeyServer:     server,
+		configKeyZone:       testZone,
+		configKeySubdomain:  "wg",
+		configKeyTSIGKey:    "stunmesh-key",
+		configKeyTSIGSecret: testSecret,
+	}
+	for k, v := range extra {
+		config[k] = v
+	}
+
+	p, err := NewRFC2136Plugin(config)
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		return newTestPlugin(t, newTestServer(t).addr, nil)
+	})
+}
+
+func TestSetThenGet(t *testing.T) {
+	server := newTestServer(t)
+	p := newTestPlugin(t, server.addr, nil)
+	ctx := context.Background()
+
+	if err := p.Set(ctx, testKey, "deadbeef"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, err := p.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != "deadbeef" {
+		t.Errorf("Get() = %q, want %q", got, "deadbeef")
+	}
+}
+
+func TestSetReplacesRecord(t *testing.T) {
+	// Every publish deletes the RRset before adding, so records never pile
+	// up under a name the way repeated plain adds would.
+	server := newTestServer(t)
+	p := newTestPlugin(t, server.addr, nil)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		if err := p.Set(ctx, testKey, value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	name := testKey + ".wg." + testZone
+	if got := server.record(name); len(got) != 1 || got[0] != "second" {
+		t.Errorf("record = %v, want [second]", got)
+	}
+}
+
+func TestSetSplitsLongValues(t *testing.T) {
+	// A TXT character-string holds at most 255 bytes; a sealed dualstack
+	// record is longer, so it goes out as several strings of one record and
+	// the lookup joins them back.
+	server := newTestServer(t)
+	p := newTestPlugin(t, server.addr, nil)
+	ctx := context.Background()
+
+	value := strings.Repeat("0123456789abcdef", 40)
+	if err := p.Set(ctx, testKey, value); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	name := testKey + ".wg." + testZone
+	strs := server.record(name)
+	if len(strs) != 3 || len(strs[0]) != builtin.MaxTXTString {
+		t.Errorf("record strings = %d (first %d bytes), want 3 (first %d)", len(strs), len(strs[0]), builtin.MaxTXTString)
+	}
+
+	got, err := p.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != value {
+		t.Errorf("Get() returned %d bytes, want the %d written", len(got), len(value))
+	}
+}
+
+func TestSetRejectedWithWrongSecret(t *testing.T) {
+	server := newTestServer(t)
+	p := newTestPlugin(t, server.addr, pluginapi.PluginConfig{
+		configKeyTSIGSecret: base64.StdEncoding.EncodeToString([]byte("not the secret")),
+	})
+
+	err := p.Set(context.Background(), testKey, "deadbeef")
+	if err == nil || !strings.Contains(err.Error(), "NOTAUTH") {
+		t.Fatalf("Set() error = %v, want NOTAUTH", err)
+	}
+	if server.updates != 0 {
+		t.Errorf("server applied %d updates, want 0", server.updates)
+	}
+}
+
+func TestGetMissingRecord(t *testing.T) {
+	server := newTestServer(t)
+	p := newTestPlugin(t, server.addr, nil)
+
+	_, err := p.Get(context.Background(), testKey)
+	if err == nil || !strings.Contains(err.Error(), "record not found") {
+		t.Fatalf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestNewRFC2136Plugin_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config pluginapi.PluginConfig
+		want   string
+	}{
+		{
+			name:   "missing server",
+			config: pluginapi.PluginConfig{configKeyZone: testZone},
+			want:   "server is required",
+		},
+		{
+			name:   "hostname server",
+			config: pluginapi.PluginConfig{configKeyServer: "ns1.example.com", configKeyZone: testZone},
+			want:   "server must be an IP address",
+		},
+		{
+			name:   "missing zone",
+			config: pluginapi.PluginConfig{configKeyServer: "192.0.2.53"},
+			want:   "zone is required",
+		},
+		{
+			name:   "key without secret",
+			config: pluginapi.PluginConfig{configKeyServer: "192.0.2.53", configKeyZone: testZone, configKeyTSIGKey: "k"},
+			want:   "must be set together",
+		},
+		{
+			name: "unknown algorithm",
+			config: pluginapi.PluginConfig{
+				configKeyServer: "192.0.2.53", configKeyZone: testZone,
+				configKeyTSIGKey: "k", configKeyTSIGSecret: testSecret, configKeyTSIGAlgorithm: "hmac-md5",
+			},
+			want: "unsupported tsig algorithm",
+		},
+		{
+			name:   "fractional ttl",
+			config: pluginapi.PluginConfig{configKeyServer: "192.0.2.53", configKeyZone: testZone, configKeyTTL: "1.5s"},
+			want:   "whole, non-negative number of seconds",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRFC2136Plugin(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewRFC2136Plugin() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRecordName(t *testing.T) {
+	p := &RFC2136Plugin{zone: testZone, subdomain: "wg"}
+	if got := p.getRecordName("abc123"); got != "abc123.wg.example.com." {
+		t.Errorf("getRecordName() = %q, want %q", got, "abc123.wg.example.com.")
+	}
+
+	p.subdomain = ""
+	if got := p.getRecordName("abc123"); got != "abc123.example.com." {
+		t.Errorf("getRecordName() = %q, want %q", got, "abc123.example.com.")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_rfc2136 && !builtin_all
+
+package rfc2136
+
+// This file exists to provide an empty package when builtin_rfc2136 tag is not set
+// This prevents import errors when the build tag is disabled
@@ -0,0 +1,154 @@
+//go:build builtin_rfc2136 || builtin_all
+
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+const (
+	// typeTSIG and classANY are the TSIG RR's fixed type and class
+	// (RFC 8945 section 4.2).
+	typeTSIG = 250
+	classANY = 255
+
+	// tsigFudge is the clock skew the server is asked to tolerate, the value
+	// RFC 8945 recommends.
+	tsigFudge = 300
+)
+
+// tsigAlgorithms maps the names accepted in tsig_algorithm to the algorithm
+// name that goes on the wire. hmac-md5 is left out on purpose: it is
+// deprecated, and BIND, Knot and PowerDNS all accept the SHA-2 family.
+var tsigAlgorithms = map[string]struct {
+	wire string
+	hash func() hash.Hash
+}{
+	"hmac-sha1":   {"hmac-sha1.", sha1.New},
+	"hmac-sha224": {"hmac-sha224.", sha256.New224},
+	"hmac-sha256": {"hmac-sha256.", sha256.New},
+	"hmac-sha384": {"hmac-sha384.", sha512.New384},
+	"hmac-sha512": {"hmac-sha512.", sha512.New},
+}
+
+// tsigKey signs outgoing messages with a shared secret (RFC 8945).
+type tsigKey struct {
+	name      string
+	algorithm string
+	hash      func() hash.Hash
+	secret    []byte
+}
+
+func newTSIGKey(name, secret, algorithm string) (*tsigKey, error) {
+	alg, ok := tsigAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tsig algorithm: %s", algorithm)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("tsig secret is not valid base64: %w", err)
+	}
+
+	return &tsigKey{
+		name:      fqdn(name),
+		algorithm: alg.wire,
+		hash:      alg.hash,
+		secret:    raw,
+	}, nil
+}
+
+// sign appends a TSIG record to msg, a fully built message with no TSIG of
+// its own, and bumps its additional count to match.
+func (k *tsigKey) sign(msg []byte, now time.Time) ([]byte, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("message too short to sign")
+	}
+
+	keyName, err := appendName(nil, k.name)
+	if err != nil {
+		return nil, fmt.Errorf("tsig key name: %w", err)
+	}
+	algName, err := appendName(nil, k.algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("tsig algorithm name: %w", err)
+	}
+	timeSigned := uint64(now.Unix())
+
+	// The MAC covers the message followed by the TSIG variables: the
+	// record's name, class and TTL, then the parts of its RDATA that are
+	// not the MAC itself (RFC 8945 section 4.3.3).
+	vars := append([]byte(nil), keyName...)
+	vars = binary.BigEndian.AppendUint16(vars, classANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars = append(vars, algName...)
+	vars = appendUint48(vars, timeSigned)
+	vars = binary.BigEndian.AppendUint16(vars, tsigFudge)
+	vars = binary.BigEndian.AppendUint16(vars, 0) // error
+	vars = binary.BigEndian.AppendUint16(vars, 0) // other len
+
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte(nil), algName...)
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0:2]...)              // original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // other len
+
+	signed := append([]byte(nil), msg...)
+	signed = append(signed, keyName...)
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classANY)
+	signed = binary.BigEndian.AppendUint32(signed, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+
+	arcount := binary.BigEndian.Uint16(signed[10:12])
+	binary.BigEndian.PutUint16(signed[10:12], arcount+1)
+
+	return signed, nil
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendName writes name in the uncompressed, lowercased wire form TSIG
+// requires for both the key and the algorithm name.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid label in %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// fqdn adds the trailing dot that makes name absolute.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
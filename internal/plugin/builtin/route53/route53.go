@@ -0,0 +1,244 @@
+//go:build builtin_route53 || builtin_all
+
+package route53
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/registry"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+func init() {
+	registry.Register("route53", NewRoute53Plugin)
+}
+
+const (
+	route53API = "https://route53.amazonaws.com/2013-04-01"
+	route53NS  = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+	// Route53 is a global service whose requests are always signed for
+	// us-east-1.
+	signingRegion  = "us-east-1"
+	signingService = "route53"
+
+	defaultTTL = 60 * time.Second
+
+	// Configuration keys
+	configKeyZoneName        = "zone"
+	configKeySubdomain       = "subdomain"
+	configKeyHostedZoneID    = "hosted_zone_id"
+	configKeyAccessKeyID     = "access_key_id"
+	configKeySecretAccessKey = "secret_access_key"
+	configKeySessionToken    = "session_token"
+	configKeyTTL             = "ttl"
+)
+
+// Route53Plugin is the Route53 API as a builtin.RRSetProvider
+type Route53Plugin struct {
+	baseURL  string
+	creds    credentials
+	zoneName string
+	ttl      uint32
+	client   *http.Client
+	zoneID   *builtin.Lazy[string]
+	// now is the signing clock, replaced in tests.
+	now func() time.Time
+}
+
+type resourceRecordSet struct {
+	Name   string           `xml:"Name"`
+	Type   string           `xml:"Type"`
+	TTL    uint32           `xml:"TTL"`
+	Values []resourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type resourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type listRecordSetsResponse struct {
+	RecordSets []resourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type listHostedZonesResponse struct {
+	HostedZones []struct {
+		ID   string `xml:"Id"`
+		Name string `xml:"Name"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+type changeRequest struct {
+	XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Changes []change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type change struct {
+	Action    string            `xml:"Action"`
+	RecordSet resourceRecordSet `xml:"ResourceRecordSet"`
+}
+
+// NewRoute53Plugin creates a new Route53 plugin instance
+func NewRoute53Plugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg := builtin.NewConfig(config)
+
+	zoneName, err := cfg.GetStringRequired(configKeyZoneName)
+	if err != nil {
+		return nil, err
+	}
+
+	accessKeyID, err := cfg.GetStringRequired(configKeyAccessKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	secretAccessKey, err := cfg.GetStringRequired(configKeySecretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionToken, _ := cfg.GetString(configKeySessionToken)
+	subdomain, _ := cfg.GetString(configKeySubdomain)
+	hostedZoneID, _ := cfg.GetString(configKeyHostedZoneID)
+
+	ttl, err := cfg.GetTTL(configKeyTTL, defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newRoute53Plugin(route53API, credentials{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+	}, zoneName, hostedZoneID, ttl)
+	return builtin.NewRRSetStore("route53", p, zoneName, subdomain), nil
+}
+
+func newRoute53Plugin(baseURL string, creds credentials, zoneName, hostedZoneID string, ttl uint32) *Route53Plugin {
+	p := &Route53Plugin{
+		baseURL:  baseURL,
+		creds:    creds,
+		zoneName: strings.TrimSuffix(zoneName, "."),
+		ttl:      ttl,
+		client:   builtin.NewHTTPClient(10 * time.Second),
+		now:      time.Now,
+	}
+	// An explicit hosted zone ID wins; it is the only way to pick between a
+	// public and a private zone of the same name.
+	if hostedZoneID != "" {
+		p.zoneID = builtin.NewResolved(strings.TrimPrefix(hostedZoneID, "/hostedzone/"))
+	} else {
+		p.zoneID = builtin.NewLazy(p.getZoneID)
+	}
+	return p
+}
+
+func (p *Route53Plugin) doRequest(ctx context.Context, method, path string, query url.Values, body []byte) ([]byte, error) {
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	signV4(req, body, p.creds, signingRegion, signingService, p.now())
+
+	return builtin.DoRequest(p.client, req)
+}
+
+func (p *Route53Plugin) getZoneID(ctx context.Context) (string, error) {
+	query := url.Values{"dnsname": {p.zoneName + "."}, "maxitems": {"1"}}
+	data, err := p.doRequest(ctx, http.MethodGet, "/hostedzonesbyname", query, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get zone ID: %w", err)
+	}
+
+	var resp listHostedZonesResponse
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return "", err
+	}
+
+	// The listing starts at dnsname but continues past it, so the first
+	// zone is only ours if its name matches.
+	if len(resp.HostedZones) == 0 || !strings.EqualFold(strings.TrimSuffix(resp.HostedZones[0].Name, "."), p.zoneName) {
+		return "", fmt.Errorf("failed to get zone ID: zone not found: %s", p.zoneName)
+	}
+
+	return strings.TrimPrefix(resp.HostedZones[0].ID, "/hostedzone/"), nil
+}
+
+// ListTXT returns the values of the TXT RRset at name
+func (p *Route53Plugin) ListTXT(ctx context.Context, name string) ([]builtin.TXTRecord, error) {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"name": {name + "."}, "type": {"TXT"}, "maxitems": {"1"}}
+	data, err := p.doRequest(ctx, http.MethodGet, "/hostedzone/"+zoneID+"/rrset", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp listRecordSetsResponse
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	// As with zones, the listing starts at name and may return the next
+	// RRset in the zone when there is none at name.
+	if len(resp.RecordSets) == 0 {
+		return nil, nil
+	}
+	set := resp.RecordSets[0]
+	if set.Type != "TXT" || !strings.EqualFold(strings.TrimSuffix(set.Name, "."), name) {
+		return nil, nil
+	}
+
+	records := make([]builtin.TXTRecord, 0, len(set.Values))
+	for _, value := range set.Values {
+		records = append(records, builtin.TXTRecord{Value: builtin.UnquoteTXT(value.Value)})
+	}
+	return records, nil
+}
+
+// ReplaceTXT makes value the only TXT record at name
+func (p *Route53Plugin) ReplaceTXT(ctx context.Context, name, value string) error {
+	zoneID, err := p.zoneID.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(changeRequest{
+		XMLNS: route53NS,
+		Changes: []change{{
+			Action: "UPSERT",
+			RecordSet: resourceRecordSet{
+				Name:   name + ".",
+				Type:   "TXT",
+				TTL:    p.ttl,
+				Values: []resourceRecord{{Value: builtin.QuoteTXT(value)}},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.doRequest(ctx, http.MethodPost, "/hostedzone/"+zoneID+"/rrset/", nil, append([]byte(xml.Header), body...))
+	return err
+}
@@ -0,0 +1,250 @@
+//go:build builtin_route53 || builtin_all
+
+package route53
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+// TestSignV4_Vanilla is the get-vanilla case of the AWS SigV4 test suite.
+func TestSignV4_Vanilla(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds := credentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, nil, creds, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	query := map[string][]string{
+		"name":   {"a b.example.com."},
+		"type":   {"TXT"},
+		"Param":  {"2", "1"},
+		"Param2": {"x"},
+	}
+	want := "Param=1&Param=2&Param2=x&name=a%20b.example.com.&type=TXT"
+	if got := canonicalQuery(query); got != want {
+		t.Errorf("canonicalQuery() = %q, want %q", got, want)
+	}
+}
+
+func TestNewRoute53Plugin_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  pluginapi.PluginConfig
+		wantErr string
+	}{
+		{
+			name:    "missing zone",
+			config:  pluginapi.PluginConfig{"access_key_id": "id", "secret_access_key": "secret"},
+			wantErr: "zone is required",
+		},
+		{
+			name:    "missing access key",
+			config:  pluginapi.PluginConfig{"zone": "example.com", "secret_access_key": "secret"},
+			wantErr: "access_key_id is required",
+		},
+		{
+			name:    "missing secret",
+			config:  pluginapi.PluginConfig{"zone": "example.com", "access_key_id": "id"},
+			wantErr: "secret_access_key is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRoute53Plugin(tt.config)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewRoute53Plugin() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeRoute53 serves the slice of the Route53 API the plugin uses, for one
+// hosted zone, keeping TXT RRsets in memory.
+type fakeRoute53 struct {
+	mu        sync.Mutex
+	zoneName  string
+	rrsets    map[string][]string
+	zoneCalls int
+	changes   int
+}
+
+func newFakeRoute53(t *testing.T, zoneName string) (*fakeRoute53, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeRoute53{zoneName: zoneName, rrsets: make(map[string][]string)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeRoute53) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") {
+		http.Error(w, "<ErrorResponse><Error><Code>MissingAuthenticationToken</Code></Error></ErrorResponse>", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/hostedzonesbyname":
+		f.zoneCalls++
+		// Like Route53, list from dnsname onwards, so an unknown name still
+		// returns whatever zone sorts after it.
+		fmt.Fprintf(w, `<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z123</Id><Name>%s.</Name></HostedZone></HostedZones></ListHostedZonesByNameResponse>`, f.zoneName)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/hostedzone/Z123/rrset":
+		name := r.URL.Query().Get("name")
+		names := make([]string, 0, len(f.rrsets))
+		for n := range f.rrsets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		var set resourceRecordSet
+		for _, n := range names {
+			if n >= name {
+				set = resourceRecordSet{Name: n, Type: "TXT", TTL: 60}
+				for _, v := range f.rrsets[n] {
+					set.Values = append(set.Values, resourceRecord{Value: v})
+				}
+				break
+			}
+		}
+
+		resp := listRecordSetsResponse{}
+		if set.Name != "" {
+			resp.RecordSets = []resourceRecordSet{set}
+		}
+		data, _ := xml.Marshal(struct {
+			XMLName xml.Name `xml:"ListResourceRecordSetsResponse"`
+			listRecordSetsResponse
+		}{listRecordSetsResponse: resp})
+		_, _ = w.Write(data)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/hostedzone/Z123/rrset/":
+		body, _ := io.ReadAll(r.Body)
+		var req changeRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range req.Changes {
+			if c.Action != "UPSERT" || c.RecordSet.Type != "TXT" {
+				http.Error(w, "unexpected change", http.StatusBadRequest)
+				return
+			}
+			var values []string
+			for _, v := range c.RecordSet.Values {
+				values = append(values, v.Value)
+			}
+			f.rrsets[c.RecordSet.Name] = values
+		}
+		f.changes++
+		_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestStore(server *httptest.Server, zoneName, hostedZoneID string) *builtin.TXTStore {
+	p := newRoute53Plugin(server.URL, credentials{accessKeyID: "id", secretAccessKey: "secret"}, zoneName, hostedZoneID, 60)
+	return builtin.NewRRSetStore("route53", p, zoneName, "wg")
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		_, server := newFakeRoute53(t, "example.com")
+		return newTestStore(server, "example.com", "")
+	})
+}
+
+func TestRoute53_SetThenGet(t *testing.T) {
+	fake, server := newFakeRoute53(t, "example.com")
+	s := newTestStore(server, "example.com", "")
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second", "second"} {
+		if err := s.Set(ctx, "abc", value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+
+	got, err := s.Get(ctx, "abc")
+	if err != nil || got != "second" {
+		t.Errorf("Get() = (%q, %v), want (second, nil)", got, err)
+	}
+	if got := fake.rrsets["abc.wg.example.com."]; len(got) != 1 || got[0] != `"second"` {
+		t.Errorf("stored RRset = %q, want the quoted value alone", got)
+	}
+	if fake.changes != 2 {
+		t.Errorf("sent %d changes, want 2 with the unchanged value skipped", fake.changes)
+	}
+	if fake.zoneCalls != 1 {
+		t.Errorf("looked up the zone %d times, want 1", fake.zoneCalls)
+	}
+}
+
+func TestRoute53_GetIgnoresFollowingRRset(t *testing.T) {
+	fake, server := newFakeRoute53(t, "example.com")
+	fake.rrsets["zzz.wg.example.com."] = []string{`"other"`}
+	s := newTestStore(server, "example.com", "")
+
+	_, err := s.Get(context.Background(), "abc")
+	if err == nil || !strings.Contains(err.Error(), "record not found: abc.wg.example.com") {
+		t.Errorf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestRoute53_HostedZoneIDSkipsLookup(t *testing.T) {
+	fake, server := newFakeRoute53(t, "example.com")
+	s := newTestStore(server, "example.com", "/hostedzone/Z123")
+
+	if err := s.Set(context.Background(), "abc", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if fake.zoneCalls != 0 {
+		t.Errorf("looked up the zone %d times, want 0 with hosted_zone_id set", fake.zoneCalls)
+	}
+}
+
+func TestRoute53_UnknownZone(t *testing.T) {
+	_, server := newFakeRoute53(t, "example.net")
+	s := newTestStore(server, "example.com", "")
+
+	err := s.Set(context.Background(), "abc", "value")
+	if err == nil || !strings.Contains(err.Error(), "zone not found: example.com") {
+		t.Errorf("Set() error = %v, want zone not found", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !builtin_route53 && !builtin_all
+
+package route53
+
+// This file exists to provide an empty package when builtin_route53 tag is not set
+// This prevents import errors when the build tag is disabled
@@ -0,0 +1,288 @@
+package builtin
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// MaxTXTString is the longest character-string a TXT record holds. A longer
+// value is stored as several strings of one record, which resolvers hand
+// back joined.
+const MaxTXTString = 255
+
+// TXTRecord is one TXT record as a provider reports it.
+type TXTRecord struct {
+	// ID is the provider's handle for the record, for APIs that address
+	// records individually. RRset APIs leave it empty.
+	ID string
+	// Value is the record's content with its strings joined, as Set wrote it.
+	Value string
+}
+
+// RecordProvider is a DNS provider API that addresses TXT records one by one
+// (Cloudflare, DigitalOcean, Hetzner). Names are fully qualified, without
+// the trailing dot.
+type RecordProvider interface {
+	ListTXT(ctx context.Context, name string) ([]TXTRecord, error)
+	CreateTXT(ctx context.Context, name, value string) error
+	UpdateTXT(ctx context.Context, name string, record TXTRecord, value string) error
+	DeleteTXT(ctx context.Context, name string, record TXTRecord) error
+}
+
+// RRSetProvider is a DNS provider API that writes all the TXT records at a
+// name as one set (Route53, deSEC), so replacing it also drops duplicates.
+type RRSetProvider interface {
+	ListTXT(ctx context.Context, name string) ([]TXTRecord, error)
+	ReplaceTXT(ctx context.Context, name, value string) error
+}
+
+// TXTStore is the pluginapi.Store every DNS provider built-in shares: it
+// names the record for a key, keeps exactly one TXT record per name, and
+// leaves only the API calls to the provider.
+type TXTStore struct {
+	provider  string
+	zone      string
+	subdomain string
+
+	list    func(ctx context.Context, name string) ([]TXTRecord, error)
+	replace func(ctx context.Context, name string, existing []TXTRecord, value string) error
+}
+
+// NewRecordStore builds a TXTStore over a per-record API. provider names the
+// built-in in log lines.
+func NewRecordStore(provider string, api RecordProvider, zone, subdomain string) *TXTStore {
+	return &TXTStore{
+		provider:  provider,
+		zone:      strings.TrimSuffix(zone, "."),
+		subdomain: subdomain,
+		list:      api.ListTXT,
+		replace: func(ctx context.Context, name string, existing []TXTRecord, value string) error {
+			return replaceRecords(ctx, api, name, existing, value)
+		},
+	}
+}
+
+// NewRRSetStore builds a TXTStore over an RRset API.
+func NewRRSetStore(provider string, api RRSetProvider, zone, subdomain string) *TXTStore {
+	return &TXTStore{
+		provider:  provider,
+		zone:      strings.TrimSuffix(zone, "."),
+		subdomain: subdomain,
+		list:      api.ListTXT,
+		replace: func(ctx context.Context, name string, _ []TXTRecord, value string) error {
+			return api.ReplaceTXT(ctx, name, value)
+		},
+	}
+}
+
+// CheckKey rejects a key that cannot be the first label of a record name
+// on its own. Names are case-insensitive, so a key that is not all
+// lowercase could land on another key's record, and one with a dot on a
+// record further down the zone.
+func CheckKey(key string) error {
+	valid := key != "" && len(key) <= 63
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			valid = false
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("key is not a valid record label: %q", key)
+	}
+	return nil
+}
+
+// RecordName is the fully qualified name, without the trailing dot, that
+// holds key's record.
+func (s *TXTStore) RecordName(key string) string {
+	// Key is already SHA1 hex from stunmesh
+	if s.subdomain != "" {
+		return fmt.Sprintf("%s.%s.%s", key, s.subdomain, s.zone)
+	}
+	return fmt.Sprintf("%s.%s", key, s.zone)
+}
+
+// Domain is the name every record of the store sits directly under.
+func (s *TXTStore) Domain() string {
+	if s.subdomain != "" {
+		return s.subdomain + "." + s.zone
+	}
+	return s.zone
+}
+
+// KeyOf is the inverse of RecordName: it returns the key that name is the
+// record of, with ok false when name is not a record of this store. Keys
+// are SHA1 hex, which tells them apart from other TXT records in the zone.
+func (s *TXTStore) KeyOf(name string) (key string, ok bool) {
+	key, found := strings.CutSuffix(strings.TrimSuffix(name, "."), "."+s.Domain())
+	if !found || len(key) != 2*sha1.Size || strings.ToLower(key) != key {
+		return "", false
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// Get retrieves a value from the provider
+func (s *TXTStore) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msgf("get data from builtin %s plugin", s.provider)
+
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	name := s.RecordName(key)
+	records, err := s.list(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	record, err := FirstRecord(ctx, name, records)
+	if err != nil {
+		return "", err
+	}
+	return record.Value, nil
+}
+
+// FirstRecord returns the record a read of name uses out of the records
+// there, or pluginapi.ErrNotFound. Duplicates are left behind by two writers
+// racing to create the same name; the next Set cleans them up. Until then
+// they normally hold the same value, so the first one is as good as any.
+func FirstRecord(ctx context.Context, name string, records []TXTRecord) (TXTRecord, error) {
+	records = dedupRecords(records)
+	if len(records) == 0 || records[0].Value == "" {
+		return TXTRecord{}, fmt.Errorf("%w: %s", pluginapi.ErrNotFound, name)
+	}
+	if len(records) > 1 {
+		zerolog.Ctx(ctx).Warn().Str("name", name).Int("records", len(records)).Msg("multiple TXT records with different values, using the first")
+	}
+	return records[0], nil
+}
+
+// Set stores a value with the provider
+func (s *TXTStore) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msgf("set data to builtin %s plugin", s.provider)
+
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	name := s.RecordName(key)
+	existing, err := s.list(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	// Skip if content unchanged
+	if len(existing) == 1 && existing[0].Value == value {
+		return nil
+	}
+
+	return s.replace(ctx, name, existing, value)
+}
+
+// replaceRecords leaves value as the only record at name: the first existing
+// record is updated in place (keeping its ID, so nothing reading the name
+// sees it vanish), and any others are deleted.
+func replaceRecords(ctx context.Context, api RecordProvider, name string, existing []TXTRecord, value string) error {
+	if len(existing) == 0 {
+		return api.CreateTXT(ctx, name, value)
+	}
+
+	if existing[0].Value != value {
+		if err := api.UpdateTXT(ctx, name, existing[0], value); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range existing[1:] {
+		if err := api.DeleteTXT(ctx, name, record); err != nil {
+			return fmt.Errorf("failed to delete duplicate record: %w", err)
+		}
+	}
+	return nil
+}
+
+// dedupRecords drops records whose value an earlier one already holds.
+func dedupRecords(records []TXTRecord) []TXTRecord {
+	seen := make(map[string]struct{}, len(records))
+	out := records[:0:0]
+	for _, record := range records {
+		if _, ok := seen[record.Value]; ok {
+			continue
+		}
+		seen[record.Value] = struct{}{}
+		out = append(out, record)
+	}
+	return out
+}
+
+// RelativeName strips zone from a fully qualified name, for APIs that want
+// the name within the zone ("@" for the apex).
+func RelativeName(name, zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	name = strings.TrimSuffix(name, ".")
+	if name == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// SplitTXT cuts value into the character-strings of one TXT record.
+func SplitTXT(value string) []string {
+	if value == "" {
+		return []string{""}
+	}
+
+	var chunks []string
+	for len(value) > MaxTXTString {
+		chunks = append(chunks, value[:MaxTXTString])
+		value = value[MaxTXTString:]
+	}
+	return append(chunks, value)
+}
+
+// QuoteTXT renders value in zone-file presentation form, `"a" "b"`, which is
+// how the RRset APIs take TXT content longer than one string.
+func QuoteTXT(value string) string {
+	chunks := SplitTXT(value)
+	for i, chunk := range chunks {
+		chunk = strings.ReplaceAll(chunk, `\`, `\\`)
+		chunks[i] = `"` + strings.ReplaceAll(chunk, `"`, `\"`) + `"`
+	}
+	return strings.Join(chunks, " ")
+}
+
+// UnquoteTXT joins a presentation-form value back into the value it holds.
+// Content that does not start with a quote is returned as is: some APIs
+// hand back what was written rather than the presentation form.
+func UnquoteTXT(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, `"`) {
+		return content
+	}
+
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case c == '\\' && inQuote && i+1 < len(content):
+			i++
+			b.WriteByte(content[i])
+		case inQuote:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
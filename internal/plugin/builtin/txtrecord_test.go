@@ -0,0 +1,304 @@
+package builtin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// fakeRecords is a RecordProvider over an in-memory list, recording every
+// write so tests can check which calls Set made.
+type fakeRecords struct {
+	records []TXTRecord
+	calls   []string
+	nextID  int
+}
+
+func (f *fakeRecords) ListTXT(_ context.Context, _ string) ([]TXTRecord, error) {
+	return append([]TXTRecord(nil), f.records...), nil
+}
+
+func (f *fakeRecords) CreateTXT(_ context.Context, _ string, value string) error {
+	f.nextID++
+	f.records = append(f.records, TXTRecord{ID: fmt.Sprintf("r%d", f.nextID), Value: value})
+	f.calls = append(f.calls, "create")
+	return nil
+}
+
+func (f *fakeRecords) UpdateTXT(_ context.Context, _ string, record TXTRecord, value string) error {
+	for i := range f.records {
+		if f.records[i].ID == record.ID {
+			f.records[i].Value = value
+		}
+	}
+	f.calls = append(f.calls, "update "+record.ID)
+	return nil
+}
+
+func (f *fakeRecords) DeleteTXT(_ context.Context, _ string, record TXTRecord) error {
+	for i := range f.records {
+		if f.records[i].ID == record.ID {
+			f.records = append(f.records[:i], f.records[i+1:]...)
+			break
+		}
+	}
+	f.calls = append(f.calls, "delete "+record.ID)
+	return nil
+}
+
+// fakeRRSet is an RRSetProvider over an in-memory set.
+type fakeRRSet struct {
+	values   []string
+	replaced int
+}
+
+func (f *fakeRRSet) ListTXT(_ context.Context, _ string) ([]TXTRecord, error) {
+	var records []TXTRecord
+	for _, value := range f.values {
+		records = append(records, TXTRecord{Value: value})
+	}
+	return records, nil
+}
+
+func (f *fakeRRSet) ReplaceTXT(_ context.Context, _ string, value string) error {
+	f.values = []string{value}
+	f.replaced++
+	return nil
+}
+
+func TestTXTStore_RecordName(t *testing.T) {
+	tests := []struct {
+		name      string
+		zone      string
+		subdomain string
+		key       string
+		want      string
+	}{
+		{"with subdomain", "example.com", "stunmesh", "abc123", "abc123.stunmesh.example.com"},
+		{"without subdomain", "example.com", "", "abc123", "abc123.example.com"},
+		{"trailing dot zone", "example.com.", "wg", "abc123", "abc123.wg.example.com"},
+		{"sha1 hex", "example.com", "test", "3061b8fcbdb6972059518f1adc3590dca6a5f352", "3061b8fcbdb6972059518f1adc3590dca6a5f352.test.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRecordStore("test", &fakeRecords{}, tt.zone, tt.subdomain)
+			if got := s.RecordName(tt.key); got != tt.want {
+				t.Errorf("RecordName(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTXTStore_SetCreatesUpdatesAndDedups(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeRecords{}
+	s := NewRecordStore("test", api, "example.com", "")
+
+	if err := s.Set(ctx, "k", "v1"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set(ctx, "k", "v1"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := strings.Join(api.calls, ","); got != "create" {
+		t.Errorf("calls = %q, want a single create and no write for the unchanged value", got)
+	}
+
+	// A racing writer created a second record.
+	api.records = append(api.records, TXTRecord{ID: "z", Value: "v1"})
+	api.calls = nil
+
+	if err := s.Set(ctx, "k", "v2"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := strings.Join(api.calls, ","); got != "update r1,delete z" {
+		t.Errorf("calls = %q, want the first record updated and the duplicate deleted", got)
+	}
+
+	got, err := s.Get(ctx, "k")
+	if err != nil || got != "v2" {
+		t.Errorf("Get() = (%q, %v), want (v2, nil)", got, err)
+	}
+}
+
+func TestTXTStore_SetCleansIdenticalDuplicates(t *testing.T) {
+	api := &fakeRecords{records: []TXTRecord{{ID: "x", Value: "v"}, {ID: "y", Value: "v"}}}
+	s := NewRecordStore("test", api, "example.com", "")
+
+	if err := s.Set(context.Background(), "k", "v"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := strings.Join(api.calls, ","); got != "delete y" {
+		t.Errorf("calls = %q, want only the duplicate deleted", got)
+	}
+}
+
+func TestTXTStore_RRSet(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeRRSet{values: []string{"a", "b"}}
+	s := NewRRSetStore("test", api, "example.com", "")
+
+	if err := s.Set(ctx, "k", "c"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set(ctx, "k", "c"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if api.replaced != 1 || len(api.values) != 1 {
+		t.Errorf("replaced %d times leaving %v, want once leaving [c]", api.replaced, api.values)
+	}
+}
+
+func TestTXTStore_GetDedupsAndReportsMissing(t *testing.T) {
+	ctx := context.Background()
+
+	api := &fakeRecords{records: []TXTRecord{{ID: "x", Value: "v"}, {ID: "y", Value: "v"}}}
+	got, err := NewRecordStore("test", api, "example.com", "").Get(ctx, "k")
+	if err != nil || got != "v" {
+		t.Errorf("Get() = (%q, %v), want (v, nil)", got, err)
+	}
+
+	_, err = NewRecordStore("test", &fakeRecords{}, "example.com", "").Get(ctx, "k")
+	if !errors.Is(err, pluginapi.ErrNotFound) || !strings.Contains(err.Error(), "record not found: k.example.com") {
+		t.Errorf("Get() error = %v, want record not found", err)
+	}
+}
+
+func TestQuoteTXT(t *testing.T) {
+	long := strings.Repeat("a", MaxTXTString) + "bc"
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"deadbeef", `"deadbeef"`},
+		{long, `"` + strings.Repeat("a", MaxTXTString) + `" "bc"`},
+		{`say "hi"\`, `"say \"hi\"\\"`},
+		{"", `""`},
+	}
+
+	for _, tt := range tests {
+		got := QuoteTXT(tt.value)
+		if got != tt.want {
+			t.Errorf("QuoteTXT(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if back := UnquoteTXT(got); back != tt.value {
+			t.Errorf("UnquoteTXT(QuoteTXT(%q)) = %q", tt.value, back)
+		}
+	}
+
+	// Content a provider hands back unquoted passes through.
+	if got := UnquoteTXT("deadbeef"); got != "deadbeef" {
+		t.Errorf("UnquoteTXT(raw) = %q, want deadbeef", got)
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	chunks := SplitTXT(strings.Repeat("x", 2*MaxTXTString+1))
+	if len(chunks) != 3 || len(chunks[0]) != MaxTXTString || len(chunks[2]) != 1 {
+		t.Errorf("SplitTXT returned chunk lengths %d, want 255,255,1", len(chunks))
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	tests := []struct {
+		name, zone, want string
+	}{
+		{"abc.wg.example.com", "example.com", "abc.wg"},
+		{"abc.example.com.", "example.com.", "abc"},
+		{"example.com", "example.com", "@"},
+	}
+
+	for _, tt := range tests {
+		if got := RelativeName(tt.name, tt.zone); got != tt.want {
+			t.Errorf("RelativeName(%q, %q) = %q, want %q", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestLazyRetriesUntilResolved(t *testing.T) {
+	calls := 0
+	lazy := NewLazy(func(context.Context) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.New("provider unreachable")
+		}
+		return "zone1", nil
+	})
+
+	ctx := context.Background()
+	if _, err := lazy.Get(ctx); err == nil {
+		t.Fatal("first Get() should surface the resolution error")
+	}
+	for i := 0; i < 2; i++ {
+		if got, err := lazy.Get(ctx); err != nil || got != "zone1" {
+			t.Fatalf("Get() = (%q, %v), want (zone1, nil)", got, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("resolved %d times, want 2", calls)
+	}
+}
+
+func TestTXTStore_KeyOf(t *testing.T) {
+	const key = "3061b8fcbdb6972059518f1adc3590dca6a5f352"
+
+	tests := []struct {
+		name      string
+		subdomain string
+		record    string
+		want      string
+		wantOK    bool
+	}{
+		{"with subdomain", "wg", key + ".wg.example.com", key, true},
+		{"without subdomain", "", key + ".example.com", key, true},
+		{"trailing dot", "wg", key + ".wg.example.com.", key, true},
+		{"other subdomain", "wg", key + ".other.example.com", "", false},
+		{"deeper name", "", "x." + key + ".example.com", "", false},
+		{"not hex", "", strings.Repeat("z", 40) + ".example.com", "", false},
+		{"upper case", "", strings.ToUpper(key) + ".example.com", "", false},
+		{"short", "", "abc123.example.com", "", false},
+		{"zone apex", "", "example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRecordStore("test", &fakeRecords{}, "example.com", tt.subdomain)
+			got, ok := s.KeyOf(tt.record)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("KeyOf(%q) = (%q, %v), want (%q, %v)", tt.record, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	const key = "3061b8fcbdb6972059518f1adc3590dca6a5f352"
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"sha1 hex", key, false},
+		{"short", "abc123", false},
+		{"empty", "", true},
+		{"upper case", strings.ToUpper(key), true},
+		{"dot", "sub." + key, true},
+		{"slash", "../" + key, true},
+		{"newline", key + "\n", true},
+		{"too long", strings.Repeat("a", 64), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("CheckKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
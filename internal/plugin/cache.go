@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// configKeyCache names the block of a plugin instance's config that puts a
+// cachingStore in front of it.
+const configKeyCache = "cache"
+
+// CacheConfig is a plugin instance's cache block.
+type CacheConfig struct {
+	// MaxAge is the longest a read record is answered from the cache without
+	// asking the store. It caps how long the store itself says a record
+	// holds; for a store that says nothing, zero asks every time.
+	MaxAge time.Duration
+}
+
+// parseCache reads the cache block, returning nil when there is none.
+func parseCache(config pluginapi.PluginConfig) (*CacheConfig, error) {
+	values, ok, err := getBlock(config, configKeyCache)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	cc := &CacheConfig{}
+	if cc.MaxAge, err = getDuration(builtin.NewConfig(values), configKeyCache, "max_age", 0); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// cacheEntry is the last record read for a key.
+type cacheEntry struct {
+	value string
+	rev   pluginapi.Revision
+}
+
+// cachingStore is a read-through cache of the records Get returns. A record
+// is answered from the cache until it expires; after that, a store that is
+// a pluginapi.ConditionalGetter is asked whether it changed, which costs
+// less than reading it, and other stores are read again.
+//
+// Writes, deletes and watched changes drop the key's record, and a context
+// marked with pluginapi.Revalidate skips the expiry, so a record the daemon
+// suspects changed is always checked with the store.
+type cachingStore struct {
+	store       pluginapi.Store
+	conditional pluginapi.ConditionalGetter
+	cfg         CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCachingStore(store pluginapi.Store, cfg CacheConfig) *cachingStore {
+	conditional, _ := pluginapi.As[pluginapi.ConditionalGetter](store)
+	return &cachingStore{
+		store:       store,
+		conditional: conditional,
+		cfg:         cfg,
+		entries:     make(map[string]cacheEntry),
+	}
+}
+
+func (s *cachingStore) Unwrap() pluginapi.Store {
+	return s.store
+}
+
+func (s *cachingStore) lookup(key string) (cacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+// remember caches value as key's record, expiring when the store says or
+// after MaxAge, whichever comes first.
+func (s *cachingStore) remember(key string, value string, rev pluginapi.Revision) {
+	if s.cfg.MaxAge > 0 {
+		limit := time.Now().Add(s.cfg.MaxAge)
+		if rev.Expires.IsZero() || rev.Expires.After(limit) {
+			rev.Expires = limit
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = cacheEntry{value: value, rev: rev}
+}
+
+func (s *cachingStore) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+func (s *cachingStore) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	entry, cached := s.lookup(key)
+	if cached && !pluginapi.MustRevalidate(ctx) && time.Now().Before(entry.rev.Expires) {
+		logger.Debug().Str("key", key).Msg("record answered from cache")
+		return entry.value, nil
+	}
+
+	if s.conditional == nil {
+		value, err := s.store.Get(ctx, key)
+		if err != nil {
+			s.forget(key)
+			return "", err
+		}
+		s.remember(key, value, pluginapi.Revision{})
+		return value, nil
+	}
+
+	var rev pluginapi.Revision
+	if cached {
+		rev = entry.rev
+	}
+	value, rev, err := s.conditional.GetIfModified(ctx, key, rev)
+	switch {
+	case errors.Is(err, pluginapi.ErrNotModified) && cached:
+		logger.Debug().Str("key", key).Msg("record not modified, answered from cache")
+		s.remember(key, entry.value, rev)
+		return entry.value, nil
+	case err != nil:
+		s.forget(key)
+		return "", err
+	}
+	s.remember(key, value, rev)
+	return value, nil
+}
+
+func (s *cachingStore) Set(ctx context.Context, key string, value string) error {
+	s.forget(key)
+	return s.store.Set(ctx, key, value)
+}
+
+func (s *cachingStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	setter, ok := pluginapi.As[pluginapi.TTLSetter](s.store)
+	if !ok {
+		return s.Set(ctx, key, value)
+	}
+	s.forget(key)
+	return setter.SetWithTTL(ctx, key, value, ttl)
+}
+
+// GetMany answers the keys whose records have not expired from the cache,
+// and reads the rest with one GetMany. The records it reads expire after
+// MaxAge, as GetMany says nothing about their revisions.
+func (s *cachingStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	many, ok := pluginapi.As[pluginapi.BatchStore](s.store)
+	if !ok {
+		return nil, errors.New("plugin cannot read records at once")
+	}
+
+	records := make(map[string]string, len(keys))
+	var missing []string
+	for _, key := range keys {
+		entry, cached := s.lookup(key)
+		if cached && !pluginapi.MustRevalidate(ctx) && time.Now().Before(entry.rev.Expires) {
+			records[key] = entry.value
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		zerolog.Ctx(ctx).Debug().Int("records", len(records)).Msg("records answered from cache")
+		return records, nil
+	}
+
+	read, err := many.GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missing {
+		value, found := read[key]
+		if !found {
+			s.forget(key)
+			continue
+		}
+		s.remember(key, value, pluginapi.Revision{})
+		records[key] = value
+	}
+	return records, nil
+}
+
+func (s *cachingStore) SetMany(ctx context.Context, records map[string]string) error {
+	many, ok := pluginapi.As[pluginapi.BatchStore](s.store)
+	if !ok {
+		return errors.New("plugin cannot write records at once")
+	}
+	for key := range records {
+		s.forget(key)
+	}
+	return many.SetMany(ctx, records)
+}
+
+func (s *cachingStore) Delete(ctx context.Context, key string) error {
+	deleter, ok := pluginapi.As[pluginapi.Deleter](s.store)
+	if !ok {
+		return errors.New("plugin cannot delete records")
+	}
+	s.forget(key)
+	return deleter.Delete(ctx, key)
+}
+
+func (s *cachingStore) List(ctx context.Context) ([]string, error) {
+	lister, ok := pluginapi.As[pluginapi.Lister](s.store)
+	if !ok {
+		return nil, errors.New("plugin cannot list keys")
+	}
+	return lister.List(ctx)
+}
+
+func (s *cachingStore) Watch(ctx context.Context, keys []string, changed func(key string)) error {
+	watcher, ok := pluginapi.As[pluginapi.Watcher](s.store)
+	if !ok {
+		return errors.New("plugin cannot watch records")
+	}
+	return watcher.Watch(ctx, keys, func(key string) {
+		s.forget(key)
+		changed(key)
+	})
+}
+
+func (s *cachingStore) NewBatch() pluginapi.Batch {
+	batcher, ok := pluginapi.As[pluginapi.Batcher](s.store)
+	if !ok {
+		return nil
+	}
+	return &cachingBatch{Batch: batcher.NewBatch(), store: s}
+}
+
+// cachingBatch drops the record of each key set through it.
+type cachingBatch struct {
+	pluginapi.Batch
+	store *cachingStore
+}
+
+func (b *cachingBatch) Set(ctx context.Context, key string, value string) error {
+	b.store.forget(key)
+	return b.Batch.Set(ctx, key, value)
+}
@@ -0,0 +1,242 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// revisionedStore is a memStore whose records carry a revision that is
+// bumped on every Set, and that says how long a read holds.
+type revisionedStore struct {
+	*memStore
+	revs   map[string]int
+	ttl    time.Duration
+	checks []pluginapi.Revision
+}
+
+func newRevisionedStore(ttl time.Duration) *revisionedStore {
+	return &revisionedStore{memStore: newMemStore(), revs: make(map[string]int), ttl: ttl}
+}
+
+func (s *revisionedStore) Set(ctx context.Context, key string, value string) error {
+	s.revs[key]++
+	return s.memStore.Set(ctx, key, value)
+}
+
+func (s *revisionedStore) GetIfModified(ctx context.Context, key string, rev pluginapi.Revision) (string, pluginapi.Revision, error) {
+	s.checks = append(s.checks, rev)
+	current := pluginapi.Revision{Tag: string(rune('0' + s.revs[key]))}
+	if s.ttl > 0 {
+		current.Expires = time.Now().Add(s.ttl)
+	}
+	if rev.Tag == current.Tag {
+		return "", current, pluginapi.ErrNotModified
+	}
+	value, err := s.Get(ctx, key)
+	return value, current, err
+}
+
+func TestCachingStore_MaxAge(t *testing.T) {
+	inner := &countingStore{memStore: newMemStore()}
+	inner.data["key"] = "value"
+	store := newCachingStore(inner, CacheConfig{MaxAge: time.Hour})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if got, err := store.Get(ctx, "key"); err != nil || got != "value" {
+			t.Fatalf("Get() = (%q, %v), want (value, nil)", got, err)
+		}
+	}
+	if inner.gets != 1 {
+		t.Errorf("store read %d times, want 1", inner.gets)
+	}
+
+	// A revalidating read goes to the store, as does one after a write.
+	if _, err := store.Get(pluginapi.Revalidate(ctx), "key"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if err := store.Set(ctx, "key", "new"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := store.Get(ctx, "key"); err != nil || got != "new" {
+		t.Errorf("Get() after Set = (%q, %v), want (new, nil)", got, err)
+	}
+	if inner.gets != 3 {
+		t.Errorf("store read %d times, want 3", inner.gets)
+	}
+}
+
+func TestCachingStore_Conditional(t *testing.T) {
+	inner := newRevisionedStore(0)
+	ctx := context.Background()
+	if err := inner.Set(ctx, "key", "first"); err != nil {
+		t.Fatal(err)
+	}
+	store := newCachingStore(inner, CacheConfig{})
+
+	if got, err := store.Get(ctx, "key"); err != nil || got != "first" {
+		t.Fatalf("Get() = (%q, %v), want (first, nil)", got, err)
+	}
+	inner.data["key"] = "changed behind the revision's back"
+	if got, err := store.Get(ctx, "key"); err != nil || got != "first" {
+		t.Errorf("Get() of an unmodified record = (%q, %v), want the cached first", got, err)
+	}
+	if len(inner.checks) != 2 || inner.checks[1].Tag != "1" {
+		t.Errorf("revisions asked = %+v, want the first read's on the second", inner.checks)
+	}
+
+	if err := inner.Set(ctx, "key", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Get(ctx, "key"); err != nil || got != "second" {
+		t.Errorf("Get() of a modified record = (%q, %v), want (second, nil)", got, err)
+	}
+}
+
+func TestCachingStore_StoreExpiry(t *testing.T) {
+	inner := newRevisionedStore(time.Hour)
+	ctx := context.Background()
+	if err := inner.Set(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// MaxAge caps the hour the store allows.
+	store := newCachingStore(inner, CacheConfig{MaxAge: 20 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get(ctx, "key"); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if len(inner.checks) != 1 {
+		t.Errorf("store asked %d times within its expiry, want 1", len(inner.checks))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := store.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(inner.checks) != 2 {
+		t.Errorf("store asked %d times after MaxAge, want 2", len(inner.checks))
+	}
+}
+
+// countingStore is a memStore that counts its reads.
+type countingStore struct {
+	*memStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (string, error) {
+	s.gets++
+	return s.memStore.Get(ctx, key)
+}
+
+func TestLoadPlugins_Cache(t *testing.T) {
+	tests := []struct {
+		name    string
+		cache   interface{}
+		wantErr bool
+		want    CacheConfig
+	}{
+		{name: "defaults", cache: map[string]interface{}{}},
+		{name: "max age", cache: map[string]interface{}{"max_age": "30s"}, want: CacheConfig{MaxAge: 30 * time.Second}},
+		{name: "not a map", cache: true, wantErr: true},
+		{name: "negative max age", cache: map[string]interface{}{"max_age": -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			err := m.LoadPlugins(context.Background(), map[string]pluginapi.PluginDefinition{
+				"test_plugin": {
+					Type: "shell",
+					Config: pluginapi.PluginConfig{
+						"command":    "/bin/true",
+						"cache":      tt.cache,
+						"resilience": map[string]interface{}{"retries": 1},
+					},
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPlugins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			store, _ := m.GetPlugin("test_plugin")
+			cached, ok := store.(*cachingStore)
+			if !ok {
+				t.Fatalf("plugin is %T, want it wrapped in *cachingStore", store)
+			}
+			if cached.cfg != tt.want {
+				t.Errorf("config = %+v, want %+v", cached.cfg, tt.want)
+			}
+			if _, ok := cached.Unwrap().(*resilientStore); !ok {
+				t.Errorf("cache wraps %T, want the *resilientStore", cached.Unwrap())
+			}
+		})
+	}
+}
+
+// manyStore is a memStore that is also a pluginapi.BatchStore, recording the
+// keys each GetMany asked for.
+type manyStore struct {
+	*memStore
+	asked [][]string
+}
+
+func (s *manyStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	s.asked = append(s.asked, keys)
+	found := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := s.data[key]; ok {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+func (s *manyStore) SetMany(ctx context.Context, records map[string]string) error {
+	for key, value := range records {
+		s.data[key] = value
+	}
+	return nil
+}
+
+func TestCachingStore_GetMany(t *testing.T) {
+	inner := &manyStore{memStore: newMemStore()}
+	inner.data["a"], inner.data["b"] = "1", "2"
+	store := newCachingStore(newResilientStore(inner, ResilienceConfig{}), CacheConfig{MaxAge: time.Hour})
+	ctx := context.Background()
+
+	many, ok := pluginapi.As[pluginapi.BatchStore](store)
+	if !ok {
+		t.Fatal("As[BatchStore] did not see through the cache and resilience layers")
+	}
+	if _, err := store.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	got, err := many.GetMany(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(got) != 2 || got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("GetMany() = %v, want a and b", got)
+	}
+	if len(inner.asked) != 1 || len(inner.asked[0]) != 2 {
+		t.Errorf("store asked for %v, want only the uncached b and c", inner.asked)
+	}
+
+	// A write drops what it writes.
+	if err := many.SetMany(ctx, map[string]string{"b": "3"}); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+	if got, _ := store.Get(ctx, "b"); got != "3" {
+		t.Errorf("Get() after SetMany = %q, want 3", got)
+	}
+}
@@ -0,0 +1,376 @@
+package plugin
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/rs/zerolog"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// CompositeConfig configures the mirror, fallback and quorum plugin types,
+// which wrap other plugin instances of the same config by name.
+type CompositeConfig struct {
+	Stores []string `mapstructure:"stores"`
+	// Quorum is how many stores a quorum instance needs to agree; zero
+	// means a majority.
+	Quorum int `mapstructure:"quorum"`
+}
+
+// member is one wrapped plugin instance.
+type member struct {
+	name  string
+	store pluginapi.Store
+}
+
+// composite holds the members every composite type shares, and the
+// optional interfaces it offers by passing them on.
+type composite struct {
+	kind    string
+	members []member
+}
+
+func decodeCompositeConfig(config pluginapi.PluginConfig) (CompositeConfig, error) {
+	var cfg CompositeConfig
+	if err := mapstructure.Decode(config, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to decode composite config: %w", err)
+	}
+	if len(cfg.Stores) == 0 {
+		return cfg, fmt.Errorf("stores is required for a composite plugin")
+	}
+	return cfg, nil
+}
+
+// compositeStores returns the instance names a definition wraps, nil for
+// any other plugin type.
+func compositeStores(def pluginapi.PluginDefinition) []string {
+	switch def.Type {
+	case "mirror", "fallback", "quorum":
+		cfg, err := decodeCompositeConfig(def.Config)
+		if err != nil {
+			return nil
+		}
+		return cfg.Stores
+	default:
+		return nil
+	}
+}
+
+func (m *Manager) newComposite(kind string, names []string) (composite, error) {
+	c := composite{kind: kind}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return c, fmt.Errorf("store %s is listed twice", name)
+		}
+		seen[name] = true
+
+		store, err := m.GetPlugin(name)
+		if err != nil {
+			return c, err
+		}
+		c.members = append(c.members, member{name: name, store: store})
+	}
+	return c, nil
+}
+
+// NewMirrorPlugin creates a store that writes to every member and reads from
+// the first that answers.
+func (m *Manager) NewMirrorPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg, err := decodeCompositeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := m.newComposite("mirror", cfg.Stores)
+	if err != nil {
+		return nil, err
+	}
+	return &MirrorPlugin{composite: c}, nil
+}
+
+// NewFallbackPlugin creates a store that uses its members in order, moving
+// to the next only when one fails.
+func (m *Manager) NewFallbackPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg, err := decodeCompositeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := m.newComposite("fallback", cfg.Stores)
+	if err != nil {
+		return nil, err
+	}
+	return &FallbackPlugin{composite: c}, nil
+}
+
+// NewQuorumPlugin creates a store that writes to every member and trusts a
+// record only once quorum of them hold it. The quorum has to be a majority:
+// below that, two disjoint sets of members could each agree on a record,
+// and nothing would tell the fresh one from the stale one.
+func (m *Manager) NewQuorumPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	cfg, err := decodeCompositeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := m.newComposite("quorum", cfg.Stores)
+	if err != nil {
+		return nil, err
+	}
+
+	quorum := cfg.Quorum
+	if quorum == 0 {
+		quorum = len(c.members)/2 + 1
+	}
+	if quorum <= len(c.members)/2 || quorum > len(c.members) {
+		return nil, fmt.Errorf("quorum must be between a majority, %d, and the %d stores", len(c.members)/2+1, len(c.members))
+	}
+	return &QuorumPlugin{composite: c, quorum: quorum}, nil
+}
+
+// each runs fn on every member at once and returns their errors by index.
+func (c *composite) each(fn func(i int, m member) error) []error {
+	errs := make([]error, len(c.members))
+	var wg sync.WaitGroup
+	for i, m := range c.members {
+		wg.Add(1)
+		go func(i int, m member) {
+			defer wg.Done()
+			errs[i] = fn(i, m)
+		}(i, m)
+	}
+	wg.Wait()
+	return errs
+}
+
+// setMember writes to one member, with ttl when it is set and the member
+// can expire records.
+func setMember(ctx context.Context, m member, key, value string, ttl time.Duration) error {
+	if setter, ok := pluginapi.As[pluginapi.TTLSetter](m.store); ok && ttl > 0 {
+		return setter.SetWithTTL(ctx, key, value, ttl)
+	}
+	return m.store.Set(ctx, key, value)
+}
+
+// setAll writes to every member and returns how many succeeded, logging and
+// joining the failures.
+func (c *composite) setAll(ctx context.Context, key, value string, ttl time.Duration) (int, error) {
+	logger := zerolog.Ctx(ctx)
+	errs := c.each(func(_ int, m member) error {
+		return setMember(ctx, m, key, value, ttl)
+	})
+
+	written := 0
+	for i, err := range errs {
+		if err != nil {
+			logger.Warn().Err(err).Str("store", c.members[i].name).Msgf("%s plugin failed to set data", c.kind)
+			errs[i] = fmt.Errorf("%s: %w", c.members[i].name, err)
+			continue
+		}
+		written++
+	}
+	return written, errors.Join(errs...)
+}
+
+// getFirst returns the first member's answer in order, skipping failures.
+func (c *composite) getFirst(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	var errs []error
+	for _, m := range c.members {
+		value, err := m.store.Get(ctx, key)
+		if err == nil {
+			return value, nil
+		}
+		logger.Warn().Err(err).Str("store", m.name).Msgf("%s plugin failed to get data, trying next", c.kind)
+		errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// Delete removes key from every member that can delete records.
+func (c *composite) Delete(ctx context.Context, key string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msgf("delete data from %s plugin", c.kind)
+
+	errs := c.each(func(_ int, m member) error {
+		if deleter, ok := pluginapi.As[pluginapi.Deleter](m.store); ok {
+			return deleter.Delete(ctx, key)
+		}
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", c.members[i].name, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// List returns the keys of every member that can list them.
+func (c *composite) List(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msgf("list keys from %s plugin", c.kind)
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range c.members {
+		lister, ok := pluginapi.As[pluginapi.Lister](m.store)
+		if !ok {
+			continue
+		}
+		listed, err := lister.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.name, err)
+		}
+		for _, key := range listed {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// MirrorPlugin writes every record to all its members, so any one of them
+// can serve it, and reads from the first in order that answers.
+type MirrorPlugin struct {
+	composite
+}
+
+func (p *MirrorPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from mirror plugin")
+
+	return p.getFirst(ctx, key)
+}
+
+// Set writes to every member. It fails if any member did, so the record is
+// published again next round; the members that took it are not undone.
+func (p *MirrorPlugin) Set(ctx context.Context, key string, value string) error {
+	return p.SetWithTTL(ctx, key, value, 0)
+}
+
+func (p *MirrorPlugin) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to mirror plugin")
+
+	_, err := p.setAll(ctx, key, value, ttl)
+	return err
+}
+
+// FallbackPlugin uses its first member, and each next one only while the
+// ones before it fail. A member that was down when a record was written
+// may serve an older one once it is back, until the next publish.
+type FallbackPlugin struct {
+	composite
+}
+
+func (p *FallbackPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from fallback plugin")
+
+	return p.getFirst(ctx, key)
+}
+
+func (p *FallbackPlugin) Set(ctx context.Context, key string, value string) error {
+	return p.SetWithTTL(ctx, key, value, 0)
+}
+
+func (p *FallbackPlugin) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to fallback plugin")
+
+	var errs []error
+	for _, m := range p.members {
+		err := setMember(ctx, m, key, value, ttl)
+		if err == nil {
+			return nil
+		}
+		logger.Warn().Err(err).Str("store", m.name).Msg("fallback plugin failed to set data, trying next")
+		errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// minRecordSize is the smallest record stunmesh publishes, once decoded
+// from hex: a nacl box nonce and authentication tag around no content.
+const minRecordSize = 24 + 16
+
+// validRecord reports whether value can be a record stunmesh published:
+// hex, as every store holds it, of at least minRecordSize bytes. The quorum
+// plugin cannot decrypt records, so this is as far as it can check them.
+func validRecord(value string) bool {
+	data, err := hex.DecodeString(value)
+	return err == nil && len(data) >= minRecordSize
+}
+
+// QuorumPlugin writes every record to all its members as is, and reads the
+// valid record at least quorum of them hold, so no single member can serve
+// a stale or forged one on its own. The quorum being a majority, at most
+// one record can qualify.
+type QuorumPlugin struct {
+	composite
+	quorum int
+}
+
+// Get returns pluginapi.ErrNotFound when too many members have no record
+// for any record to reach quorum.
+func (p *QuorumPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from quorum plugin")
+
+	values := make([]string, len(p.members))
+	errs := p.each(func(i int, m member) error {
+		value, err := m.store.Get(ctx, key)
+		values[i] = value
+		return err
+	})
+
+	votes := make(map[string]int)
+	notFound := 0
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, pluginapi.ErrNotFound):
+			notFound++
+			continue
+		case err != nil:
+			logger.Debug().Err(err).Str("store", p.members[i].name).Msg("quorum plugin member failed to get data")
+			continue
+		case !validRecord(values[i]):
+			logger.Warn().Str("store", p.members[i].name).Msg("quorum plugin member holds an invalid record")
+			continue
+		}
+		votes[values[i]]++
+	}
+
+	for value, count := range votes {
+		if count >= p.quorum {
+			return value, nil
+		}
+	}
+	if notFound > len(p.members)-p.quorum {
+		return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, key)
+	}
+	return "", fmt.Errorf("no record held by %d of %d stores: %s", p.quorum, len(p.members), key)
+}
+
+func (p *QuorumPlugin) Set(ctx context.Context, key string, value string) error {
+	return p.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL writes to every member, and succeeds once quorum of them took
+// the record.
+func (p *QuorumPlugin) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("set data to quorum plugin")
+
+	written, err := p.setAll(ctx, key, value, ttl)
+	if written < p.quorum {
+		return fmt.Errorf("record written to %d of %d stores, %d needed: %w", written, len(p.members), p.quorum, err)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package plugin
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+// memStore is an in-memory store that can be made to fail.
+type memStore struct {
+	mu   sync.Mutex
+	data map[string]string
+	sets int
+	down bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string]string)}
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return "", errors.New("store down")
+	}
+	value, ok := s.data[key]
+	if !ok {
+		return "", pluginapi.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memStore) Set(ctx context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store down")
+	}
+	s.sets++
+	s.data[key] = value
+	return nil
+}
+
+// newCompositeManager returns a Manager holding the given stores as
+// instances a, b, c, ...
+func newCompositeManager(stores ...*memStore) *Manager {
+	m := NewManager()
+	for i, store := range stores {
+		m.plugins[string(rune('a'+i))] = store
+	}
+	return m
+}
+
+func TestComposite_Conformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(m *Manager, config pluginapi.PluginConfig) (pluginapi.Store, error)
+		config pluginapi.PluginConfig
+	}{
+		{"Mirror", (*Manager).NewMirrorPlugin, pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c"}}},
+		{"Fallback", (*Manager).NewFallbackPlugin, pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c"}}},
+		{"Quorum", (*Manager).NewQuorumPlugin, pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c"}, "quorum": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+				m := newCompositeManager(newMemStore(), newMemStore(), newMemStore())
+				store, err := tt.create(m, tt.config)
+				if err != nil {
+					t.Fatalf("failed to create plugin: %v", err)
+				}
+				return store
+			})
+		})
+	}
+}
+
+func TestMirrorPlugin(t *testing.T) {
+	a, b := newMemStore(), newMemStore()
+	m := newCompositeManager(a, b)
+	ctx := context.Background()
+
+	store, err := m.NewMirrorPlugin(pluginapi.PluginConfig{"stores": []interface{}{"a", "b"}})
+	if err != nil {
+		t.Fatalf("NewMirrorPlugin() error = %v", err)
+	}
+
+	if err := store.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if a.data["key"] != "value" || b.data["key"] != "value" {
+		t.Fatalf("records = %q, %q, want value in both", a.data["key"], b.data["key"])
+	}
+
+	// Reads carry on to the next member while one is down.
+	a.down = true
+	if got, err := store.Get(ctx, "key"); err != nil || got != "value" {
+		t.Errorf("Get() = (%q, %v), want (value, nil) from b", got, err)
+	}
+
+	// A write any member missed is reported, so it is published again.
+	if err := store.Set(ctx, "key", "new"); err == nil {
+		t.Error("Set() should fail while a member is down")
+	}
+	if b.data["key"] != "new" {
+		t.Errorf("b = %q, want new: the members that are up are still written", b.data["key"])
+	}
+}
+
+func TestFallbackPlugin(t *testing.T) {
+	a, b := newMemStore(), newMemStore()
+	m := newCompositeManager(a, b)
+	ctx := context.Background()
+
+	store, err := m.NewFallbackPlugin(pluginapi.PluginConfig{"stores": []interface{}{"a", "b"}})
+	if err != nil {
+		t.Fatalf("NewFallbackPlugin() error = %v", err)
+	}
+
+	if err := store.Set(ctx, "key", "first"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if b.sets != 0 {
+		t.Errorf("b written %d times while a is up, want 0", b.sets)
+	}
+
+	a.down = true
+	if err := store.Set(ctx, "key", "second"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := store.Get(ctx, "key"); err != nil || got != "second" {
+		t.Errorf("Get() = (%q, %v), want (second, nil) from b", got, err)
+	}
+
+	b.down = true
+	if _, err := store.Get(ctx, "key"); err == nil {
+		t.Error("Get() should fail with every member down")
+	}
+}
+
+// testRecord returns a value shaped like a published record: hex of a
+// nonce, tag and content, distinct per n.
+func testRecord(n byte) string {
+	return hex.EncodeToString(append(make([]byte, minRecordSize), n))
+}
+
+func TestQuorumPlugin(t *testing.T) {
+	a, b, c := newMemStore(), newMemStore(), newMemStore()
+	m := newCompositeManager(a, b, c)
+	ctx := context.Background()
+
+	store, err := m.NewQuorumPlugin(pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c"}})
+	if err != nil {
+		t.Fatalf("NewQuorumPlugin() error = %v", err)
+	}
+
+	if _, err := store.Get(ctx, "key"); !errors.Is(err, pluginapi.ErrNotFound) {
+		t.Errorf("Get() error = %v before any Set, want ErrNotFound", err)
+	}
+
+	first, second, forged := testRecord(1), testRecord(2), testRecord(3)
+	if err := store.Set(ctx, "key", first); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	// Members hold the record as is, for stores that only take hex and for
+	// nodes reading a member directly.
+	if a.data["key"] != first {
+		t.Errorf("member record = %q, want the published %q", a.data["key"], first)
+	}
+
+	// One member alone cannot override the others.
+	c.data["key"] = forged
+	if got, err := store.Get(ctx, "key"); err != nil || got != first {
+		t.Errorf("Get() = (%q, %v), want (first, nil)", got, err)
+	}
+
+	// With c down, a newer record on a majority wins over the older one.
+	c.down = true
+	if err := store.Set(ctx, "key", second); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	c.down = false
+	c.data["key"] = first
+	if got, err := store.Get(ctx, "key"); err != nil || got != second {
+		t.Errorf("Get() = (%q, %v), want (second, nil)", got, err)
+	}
+
+	// An invalid record does not count toward quorum.
+	b.data["key"] = "not a record"
+	if _, err := store.Get(ctx, "key"); err == nil || errors.Is(err, pluginapi.ErrNotFound) {
+		t.Errorf("Get() error = %v with one valid copy of each record, want a quorum error", err)
+	}
+
+	// Two members down leave no majority to write or read.
+	a.down, b.down = true, true
+	if err := store.Set(ctx, "key", testRecord(4)); err == nil {
+		t.Error("Set() should fail below quorum")
+	}
+	if _, err := store.Get(ctx, "key"); err == nil {
+		t.Error("Get() should fail below quorum")
+	}
+}
+
+// Members split evenly between a stale and a fresh record: neither is a
+// majority, so neither is trusted.
+func TestQuorumPlugin_EvenSplit(t *testing.T) {
+	a, b, c, d := newMemStore(), newMemStore(), newMemStore(), newMemStore()
+	m := newCompositeManager(a, b, c, d)
+	ctx := context.Background()
+
+	store, err := m.NewQuorumPlugin(pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c", "d"}})
+	if err != nil {
+		t.Fatalf("NewQuorumPlugin() error = %v", err)
+	}
+
+	stale, fresh := testRecord(1), testRecord(2)
+	a.data["key"], b.data["key"], c.data["key"], d.data["key"] = stale, fresh, fresh, stale
+	if got, err := store.Get(ctx, "key"); err == nil || errors.Is(err, pluginapi.ErrNotFound) {
+		t.Errorf("Get() = (%q, %v) on an even split, want a quorum error", got, err)
+	}
+	d.data["key"] = fresh
+	if got, err := store.Get(ctx, "key"); err != nil || got != fresh {
+		t.Errorf("Get() = (%q, %v), want the record a majority holds", got, err)
+	}
+}
+
+func TestQuorumPlugin_InvalidQuorum(t *testing.T) {
+	m := newCompositeManager(newMemStore(), newMemStore(), newMemStore(), newMemStore())
+	stores := []interface{}{"a", "b", "c", "d"}
+
+	if _, err := m.NewQuorumPlugin(pluginapi.PluginConfig{"stores": stores, "quorum": 5}); err == nil {
+		t.Error("NewQuorumPlugin() should reject a quorum larger than the stores")
+	}
+	// Half the members could agree on one record and the other half on
+	// another.
+	if _, err := m.NewQuorumPlugin(pluginapi.PluginConfig{"stores": stores, "quorum": 2}); err == nil {
+		t.Error("NewQuorumPlugin() should reject a quorum of half the stores")
+	}
+	if _, err := m.NewQuorumPlugin(pluginapi.PluginConfig{"stores": stores, "quorum": 3}); err != nil {
+		t.Errorf("NewQuorumPlugin() with a majority quorum error = %v", err)
+	}
+}
+
+func TestLoadPlugins_Composite(t *testing.T) {
+	shell := pluginapi.PluginDefinition{Type: "shell", Config: pluginapi.PluginConfig{"command": "/bin/true"}}
+
+	tests := []struct {
+		name        string
+		definitions map[string]pluginapi.PluginDefinition
+		wantErr     string
+	}{
+		{
+			name: "nested",
+			definitions: map[string]pluginapi.PluginDefinition{
+				"outer":   {Type: "fallback", Config: pluginapi.PluginConfig{"stores": []interface{}{"mirror", "c"}}},
+				"mirror":  {Type: "mirror", Config: pluginapi.PluginConfig{"stores": []interface{}{"a", "b"}}},
+				"a":       shell,
+				"b":       shell,
+				"c":       shell,
+				"unused":  shell,
+				"another": {Type: "quorum", Config: pluginapi.PluginConfig{"stores": []interface{}{"a", "b", "c"}}},
+			},
+		},
+		{
+			name: "unknown store",
+			definitions: map[string]pluginapi.PluginDefinition{
+				"mirror": {Type: "mirror", Config: pluginapi.PluginConfig{"stores": []interface{}{"a", "missing"}}},
+				"a":      shell,
+			},
+			wantErr: "plugin missing not found",
+		},
+		{
+			name: "cycle",
+			definitions: map[string]pluginapi.PluginDefinition{
+				"x": {Type: "mirror", Config: pluginapi.PluginConfig{"stores": []interface{}{"y"}}},
+				"y": {Type: "fallback", Config: pluginapi.PluginConfig{"stores": []interface{}{"x"}}},
+			},
+			wantErr: "wraps itself",
+		},
+		{
+			name: "no stores",
+			definitions: map[string]pluginapi.PluginDefinition{
+				"mirror": {Type: "mirror"},
+			},
+			wantErr: "stores is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			err := m.LoadPlugins(context.Background(), tt.definitions)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadPlugins() error = %v", err)
+				}
+				if len(m.plugins) != len(tt.definitions) {
+					t.Errorf("loaded %d plugins, want %d", len(m.plugins), len(tt.definitions))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadPlugins() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
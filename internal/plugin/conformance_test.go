@@ -0,0 +1,72 @@
+package plugin_test
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tjjh89017/stunmesh-go/internal/plugin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest/exectest"
+)
+
+func skipOnWindows(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin exec tests use .sh fixtures")
+	}
+}
+
+// runnerFactory returns a plugintest.Factory for one of stunmesh's runners.
+func runnerFactory(create func(pluginapi.PluginConfig) (pluginapi.Store, error), config pluginapi.PluginConfig) plugintest.Factory {
+	return func(t *testing.T) pluginapi.Store {
+		t.Helper()
+		store, err := create(config)
+		if err != nil {
+			t.Fatalf("failed to create plugin: %v", err)
+		}
+		return store
+	}
+}
+
+func TestConformance_ExecPlugin(t *testing.T) {
+	skipOnWindows(t)
+	plugintest.Run(t, runnerFactory(plugin.NewExecPlugin, pluginapi.PluginConfig{
+		"command": filepath.Join("testdata", "exec_test_plugin.sh"),
+	}))
+}
+
+func TestConformance_PersistentExecPlugin(t *testing.T) {
+	skipOnWindows(t)
+	plugintest.Run(t, runnerFactory(plugin.NewExecPlugin, pluginapi.PluginConfig{
+		"command":    filepath.Join("testdata", "exec_persistent_plugin.sh"),
+		"args":       []string{t.TempDir()},
+		"persistent": true,
+	}))
+}
+
+func TestConformance_ShellPlugin(t *testing.T) {
+	skipOnWindows(t)
+	plugintest.Run(t, runnerFactory(plugin.NewShellPlugin, pluginapi.PluginConfig{
+		"command": filepath.Join("testdata", "shell_test_plugin.sh"),
+	}))
+}
+
+// The exectest harness speaks the protocols on its own, so it runs against
+// the same fixtures to keep it in step with the runners above.
+
+func TestExectest_Exec(t *testing.T) {
+	skipOnWindows(t)
+	exectest.RunExec(t, filepath.Join("testdata", "exec_test_plugin.sh"))
+}
+
+func TestExectest_PersistentExec(t *testing.T) {
+	skipOnWindows(t)
+	exectest.RunPersistentExec(t, filepath.Join("testdata", "exec_persistent_plugin.sh"), t.TempDir())
+}
+
+func TestExectest_Shell(t *testing.T) {
+	skipOnWindows(t)
+	exectest.RunShell(t, filepath.Join("testdata", "shell_test_plugin.sh"))
+}
@@ -254,6 +254,24 @@ func ValidNameserver(s string) bool {
 	return ok
 }
 
+// NormalizeNameserver is ValidNameserver that also hands back the
+// "host:port" form, port defaulting to 53, for callers that dial the
+// nameserver themselves as well as resolving through it.
+func NormalizeNameserver(s string) (string, bool) {
+	return normalizeDNSAddr(s)
+}
+
+// WithNameserver pins the lookups made with ctx to server, keeping the rest
+// of the Escape ctx already carries. It is for callers that must ask one
+// particular nameserver -- the rfc2136 builtin reading records back from the
+// server it updates -- rather than whichever the platform would pick. server
+// takes any form ValidNameserver accepts.
+func WithNameserver(ctx context.Context, server string) context.Context {
+	escape := escapeFrom(ctx)
+	escape.DNSServers = []string{server}
+	return WithEscape(ctx, escape)
+}
+
 // boundInterface resolves the physical default-route interface for platforms
 // that escape by naming one, or returns ok=false when no covering tunnel route
 // exists and there is nothing to escape.
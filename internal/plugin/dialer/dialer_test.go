@@ -177,6 +177,20 @@ func TestValidNameserver(t *testing.T) {
 	}
 }
 
+// WithNameserver replaces only the nameserver list: the mark and interfaces
+// the caller's context already carries must still apply to the lookup.
+func TestWithNameserverKeepsEscape(t *testing.T) {
+	ctx := WithEscape(context.Background(), Escape{FirewallMark: 0x51820, DNSServers: []string{"192.0.2.1"}})
+
+	got := EscapeFrom(WithNameserver(ctx, "192.0.2.53:5353"))
+	if got.FirewallMark != 0x51820 {
+		t.Errorf("FirewallMark = %#x, want 0x51820", got.FirewallMark)
+	}
+	if len(got.DNSServers) != 1 || got.DNSServers[0] != "192.0.2.53:5353" {
+		t.Errorf("DNSServers = %v, want [192.0.2.53:5353]", got.DNSServers)
+	}
+}
+
 // Hostname entries that slip into the list anyway (only mobile filters
 // today) are skipped, not dialed: recursion protection lives here, at the
 // point of use, not only in the callers.
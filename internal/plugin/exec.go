@@ -14,11 +14,16 @@ import (
 type ExecConfig struct {
 	Command string   `mapstructure:"command"`
 	Args    []string `mapstructure:"args"`
+	// Persistent keeps one plugin process running for all requests instead
+	// of starting one per request; see pluginapi.ExecRequest.
+	Persistent bool `mapstructure:"persistent"`
 }
 
 type ExecPlugin struct {
 	command string
 	args    []string
+	// persistent is the long-running process, nil in one-shot mode.
+	persistent *persistentProcess
 }
 
 func NewExecPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
@@ -31,10 +36,22 @@ func NewExecPlugin(config pluginapi.PluginConfig) (pluginapi.Store, error) {
 		return nil, fmt.Errorf("command is required for exec plugin")
 	}
 
-	return &ExecPlugin{
+	plugin := &ExecPlugin{
 		command: cfg.Command,
 		args:    cfg.Args,
-	}, nil
+	}
+	if cfg.Persistent {
+		plugin.persistent = newPersistentProcess(cfg.Command, cfg.Args)
+	}
+	return plugin, nil
+}
+
+// Close stops the persistent plugin process, if there is one.
+func (p *ExecPlugin) Close() error {
+	if p.persistent == nil {
+		return nil
+	}
+	return p.persistent.close()
 }
 
 func (p *ExecPlugin) Get(ctx context.Context, key string) (string, error) {
@@ -52,7 +69,7 @@ func (p *ExecPlugin) Get(ctx context.Context, key string) (string, error) {
 	}
 
 	if !response.Success {
-		return "", fmt.Errorf("exec plugin error: %s", response.Error)
+		return "", responseError(response)
 	}
 
 	return response.Value, nil
@@ -74,13 +91,70 @@ func (p *ExecPlugin) Set(ctx context.Context, key string, value string) error {
 	}
 
 	if !response.Success {
-		return fmt.Errorf("exec plugin error: %s", response.Error)
+		return responseError(response)
 	}
 
 	return nil
 }
 
+// Delete asks the plugin to remove the record for key. Plugins written
+// before the delete action answer it with an error.
+func (p *ExecPlugin) Delete(ctx context.Context, key string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("delete data from exec plugin")
+
+	request := pluginapi.ExecRequest{
+		Action: pluginapi.OpDelete,
+		Key:    key,
+	}
+
+	response, err := p.executeCommand(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	if !response.Success {
+		return responseError(response)
+	}
+
+	return nil
+}
+
+// List asks the plugin for the keys it holds records for
+func (p *ExecPlugin) List(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("list keys from exec plugin")
+
+	request := pluginapi.ExecRequest{
+		Action: pluginapi.OpList,
+	}
+
+	response, err := p.executeCommand(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Success {
+		return nil, responseError(response)
+	}
+
+	return response.Keys, nil
+}
+
+// responseError is the error of a response that is not a success, wrapping
+// the pluginapi error its code names.
+func responseError(response *pluginapi.ExecResponse) error {
+	if sentinel := pluginapi.CodeError(response.Code); sentinel != nil {
+		return fmt.Errorf("exec plugin error: %s (%w)", response.Error, sentinel)
+	}
+	return fmt.Errorf("exec plugin error: %s", response.Error)
+}
+
 func (p *ExecPlugin) executeCommand(ctx context.Context, request pluginapi.ExecRequest) (*pluginapi.ExecResponse, error) {
+	if p.persistent != nil {
+		return p.persistent.call(ctx, request)
+	}
+
 	cmd := exec.CommandContext(ctx, p.command, p.args...)
 
 	stdin, err := cmd.StdinPipe()
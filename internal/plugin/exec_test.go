@@ -2,10 +2,13 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
@@ -342,3 +345,200 @@ func TestExecPlugin_WithArgs(t *testing.T) {
 		t.Errorf("Get() = %q, want %q", value, "test")
 	}
 }
+
+func newPersistentTestPlugin(t *testing.T) *ExecPlugin {
+	t.Helper()
+	skipOnWindows(t)
+
+	plugin, err := NewExecPlugin(pluginapi.PluginConfig{
+		"command":    getTestPluginPath("exec_persistent_plugin.sh"),
+		"args":       []string{t.TempDir()},
+		"persistent": true,
+	})
+	if err != nil {
+		t.Fatalf("NewExecPlugin() error = %v", err)
+	}
+	execPlugin := plugin.(*ExecPlugin)
+	t.Cleanup(func() { _ = execPlugin.Close() })
+	return execPlugin
+}
+
+func TestExecPlugin_Persistent_OneProcess(t *testing.T) {
+	plugin := newPersistentTestPlugin(t)
+	ctx := context.Background()
+
+	pid, err := plugin.Get(ctx, "pid")
+	if err != nil {
+		t.Fatalf("Get(pid) error = %v", err)
+	}
+
+	if err := plugin.Set(ctx, "testkey", "testvalue"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	value, err := plugin.Get(ctx, "testkey")
+	if err != nil || value != "testvalue" {
+		t.Errorf("Get() = (%q, %v), want (%q, nil)", value, err, "testvalue")
+	}
+
+	if _, err := plugin.Get(ctx, "nonexistent_key"); err == nil {
+		t.Error("Get() should return error for nonexistent key")
+	}
+
+	again, err := plugin.Get(ctx, "pid")
+	if err != nil || again != pid {
+		t.Errorf("Get(pid) = (%q, %v), want (%q, nil): requests should share one process", again, err, pid)
+	}
+}
+
+func TestExecPlugin_Persistent_ConcurrentRequests(t *testing.T) {
+	plugin := newPersistentTestPlugin(t)
+	ctx := context.Background()
+
+	type result struct {
+		value string
+		err   error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		value, err := plugin.Get(ctx, "slow")
+		slow <- result{value, err}
+	}()
+
+	// The slow request is in flight while this one is answered.
+	if _, err := plugin.Get(ctx, "pid"); err != nil {
+		t.Fatalf("Get(pid) error = %v", err)
+	}
+	select {
+	case <-slow:
+		t.Fatal("slow request was answered before the fast one")
+	default:
+	}
+
+	if r := <-slow; r.err != nil || r.value != "slow" {
+		t.Errorf("Get(slow) = (%q, %v), want (%q, nil)", r.value, r.err, "slow")
+	}
+}
+
+func TestExecPlugin_Persistent_RestartsAfterCrash(t *testing.T) {
+	plugin := newPersistentTestPlugin(t)
+	ctx := context.Background()
+
+	pid, err := plugin.Get(ctx, "pid")
+	if err != nil {
+		t.Fatalf("Get(pid) error = %v", err)
+	}
+
+	if _, err := plugin.Get(ctx, "crash"); err == nil {
+		t.Fatal("Get(crash) should fail when the process exits")
+	}
+
+	again, err := plugin.Get(ctx, "pid")
+	if err != nil {
+		t.Fatalf("Get(pid) after crash error = %v", err)
+	}
+	if again == pid {
+		t.Errorf("Get(pid) = %q after crash, want a new process", again)
+	}
+}
+
+// A child that stops reading its requests must not block callers past their
+// deadline, and is replaced by a new process.
+func TestExecPlugin_Persistent_StalledChildReplaced(t *testing.T) {
+	plugin := newPersistentTestPlugin(t)
+	ctx := context.Background()
+
+	pid, err := plugin.Get(ctx, "pid")
+	if err != nil {
+		t.Fatalf("Get(pid) error = %v", err)
+	}
+
+	// Without a deadline, this request waits until the child is replaced.
+	stalled := make(chan error, 1)
+	go func() {
+		_, err := plugin.Get(ctx, "stall")
+		stalled <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// A value larger than the pipe buffer, which the stalled child never
+	// drains, blocks the write itself.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = plugin.Set(timeoutCtx, "big", strings.Repeat("a", 1<<20))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set() to a stalled child error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Set() to a stalled child took %s, want it bounded by its deadline", elapsed)
+	}
+
+	select {
+	case err := <-stalled:
+		if err == nil {
+			t.Error("Get(stall) succeeded, want it failed with the killed child")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get(stall) still waiting after the child was killed")
+	}
+
+	again, err := plugin.Get(ctx, "pid")
+	if err != nil {
+		t.Fatalf("Get(pid) after the stall error = %v", err)
+	}
+	if again == pid {
+		t.Errorf("Get(pid) = %q after the stall, want a new process", again)
+	}
+}
+
+func TestExecPlugin_Persistent_Close(t *testing.T) {
+	plugin := newPersistentTestPlugin(t)
+	ctx := context.Background()
+
+	if _, err := plugin.Get(ctx, "pid"); err != nil {
+		t.Fatalf("Get(pid) error = %v", err)
+	}
+	if err := plugin.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := plugin.Get(ctx, "pid"); err == nil {
+		t.Error("Get() should fail after Close()")
+	}
+}
+
+func TestExecPlugin_DeleteAndList(t *testing.T) {
+	skipOnWindows(t)
+	pluginPath := getTestPluginPath("exec_test_plugin.sh")
+
+	storageDir := "/tmp/stunmesh-test-plugin"
+	_ = os.RemoveAll(storageDir)
+	defer os.RemoveAll(storageDir)
+
+	plugin, err := NewExecPlugin(pluginapi.PluginConfig{"command": pluginPath})
+	if err != nil {
+		t.Fatalf("NewExecPlugin() error = %v", err)
+	}
+	execPlugin := plugin.(*ExecPlugin)
+	ctx := context.Background()
+
+	for _, key := range []string{"key_a", "key_b"} {
+		if err := plugin.Set(ctx, key, "value"); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	if err := execPlugin.Delete(ctx, "key_a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := plugin.Get(ctx, "key_a"); err == nil {
+		t.Error("Get() should return error for a deleted key")
+	}
+
+	keys, err := execPlugin.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "key_b" {
+		t.Errorf("List() = %v, want [key_b]", keys)
+	}
+}
@@ -6,5 +6,13 @@ package plugin
 // why these imports need no build tag of their own.
 import (
 	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/cloudflare"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/desec"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/digitalocean"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/file"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/gitrepo"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/hetzner"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/lan"
 	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/opendht"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/rfc2136"
+	_ "github.com/tjjh89017/stunmesh-go/internal/plugin/builtin/route53"
 )
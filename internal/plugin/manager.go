@@ -2,35 +2,132 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
 
 type Manager struct {
-	plugins map[string]pluginapi.Store
-	dedup   map[string]bool
+	plugins   map[string]pluginapi.Store
+	dedup     map[string]bool
+	recordTTL map[string]time.Duration
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		plugins: make(map[string]pluginapi.Store),
-		dedup:   make(map[string]bool),
+		plugins:   make(map[string]pluginapi.Store),
+		dedup:     make(map[string]bool),
+		recordTTL: make(map[string]time.Duration),
 	}
 }
 
+// LoadPlugins creates the defined instances. When one fails, those it
+// already created are closed again, so a caller retrying the load does not
+// leave their plugin processes behind.
 func (m *Manager) LoadPlugins(ctx context.Context, definitions map[string]pluginapi.PluginDefinition) error {
-	for name, def := range definitions {
-		store, err := m.createPlugin(ctx, def)
-		if err != nil {
-			return fmt.Errorf("failed to create plugin %s: %w", name, err)
+	loaded := make(map[string]bool, len(definitions))
+	for name := range definitions {
+		if err := m.loadPlugin(ctx, name, definitions, loaded, nil); err != nil {
+			for name := range loaded {
+				_ = closeStore(m.plugins[name])
+				delete(m.plugins, name)
+			}
+			return err
 		}
-		m.plugins[name] = store
-		m.dedup[name] = parseDedup(def.Config["dedup"])
 	}
 	return nil
 }
 
+// Close stops what the loaded instances run in the background, such as an
+// exec or rpc plugin's process. The instances are unusable afterwards.
+func (m *Manager) Close() error {
+	var errs []error
+	for name, store := range m.plugins {
+		if err := closeStore(store); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close plugin %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeStore closes the store under store's decorators, if it is an
+// io.Closer. A composite's members are instances of their own, closed as
+// such.
+func closeStore(store pluginapi.Store) error {
+	for {
+		wrapper, ok := store.(pluginapi.Wrapper)
+		if !ok {
+			break
+		}
+		store = wrapper.Unwrap()
+	}
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// loadPlugin creates the named instance after the instances a composite
+// one wraps. path is the chain of composites being loaded, to catch one
+// that wraps itself.
+func (m *Manager) loadPlugin(ctx context.Context, name string, definitions map[string]pluginapi.PluginDefinition, loaded map[string]bool, path []string) error {
+	if loaded[name] {
+		return nil
+	}
+	if slices.Contains(path, name) {
+		return fmt.Errorf("failed to create plugin %s: it wraps itself through %s", name, strings.Join(path, " -> "))
+	}
+	def, ok := definitions[name]
+	if !ok {
+		return fmt.Errorf("failed to create plugin %s: plugin %s not found", path[len(path)-1], name)
+	}
+
+	for _, store := range compositeStores(def) {
+		if err := m.loadPlugin(ctx, store, definitions, loaded, append(path, name)); err != nil {
+			return err
+		}
+	}
+
+	store, err := m.createPlugin(ctx, def)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin %s: %w", name, err)
+	}
+	resilience, err := parseResilience(def.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin %s: %w", name, err)
+	}
+	if resilience != nil {
+		store = newResilientStore(store, *resilience)
+	}
+	cache, err := parseCache(def.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin %s: %w", name, err)
+	}
+	if cache != nil {
+		store = newCachingStore(store, *cache)
+	}
+	ttl, _, err := builtin.NewConfig(def.Config).GetDuration("record_ttl")
+	if err != nil {
+		return fmt.Errorf("failed to create plugin %s: %w", name, err)
+	}
+	dedup := parseDedup(def.Config["dedup"])
+	// Dedup stops republishing an unchanged record, which then expires.
+	if dedup && ttl > 0 {
+		return fmt.Errorf("failed to create plugin %s: dedup cannot be combined with record_ttl", name)
+	}
+	m.plugins[name] = store
+	m.dedup[name] = dedup
+	m.recordTTL[name] = ttl
+	loaded[name] = true
+	return nil
+}
+
 func (m *Manager) GetPlugin(name string) (pluginapi.Store, error) {
 	store, ok := m.plugins[name]
 	if !ok {
@@ -45,6 +142,13 @@ func (m *Manager) IsDedup(name string) bool {
 	return m.dedup[name]
 }
 
+// RecordTTL returns the named plugin instance's record_ttl, the lifetime
+// published records are given by stores that implement pluginapi.TTLSetter.
+// Zero, also for unknown names, leaves it to the store.
+func (m *Manager) RecordTTL(name string) time.Duration {
+	return m.recordTTL[name]
+}
+
 // parseDedup coerces a raw config value into a dedup flag. It accepts a
 // real bool, or a string such as "true"/"1" (e.g. from an environment
 // override). Anything else, including an absent value (nil), is false.
@@ -65,8 +169,16 @@ func (m *Manager) createPlugin(ctx context.Context, def pluginapi.PluginDefiniti
 		return NewExecPlugin(def.Config)
 	case "shell":
 		return NewShellPlugin(def.Config)
+	case "rpc":
+		return NewRPCPlugin(ctx, def.Config)
 	case "builtin":
 		return NewBuiltinPlugin(def.Config)
+	case "mirror":
+		return m.NewMirrorPlugin(def.Config)
+	case "fallback":
+		return m.NewFallbackPlugin(def.Config)
+	case "quorum":
+		return m.NewQuorumPlugin(def.Config)
 	default:
 		return nil, fmt.Errorf("unsupported plugin type: %s", def.Type)
 	}
@@ -2,7 +2,10 @@ package plugin
 
 import (
 	"context"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
 )
@@ -223,3 +226,99 @@ func TestIsDedup_UnknownPluginReturnsFalse(t *testing.T) {
 		t.Errorf("IsDedup() = %v, want false for unknown plugin name", got)
 	}
 }
+
+func TestRecordTTL(t *testing.T) {
+	m := NewManager()
+	ctx := context.Background()
+
+	definitions := map[string]pluginapi.PluginDefinition{
+		"with_ttl": {
+			Type: "shell",
+			Config: pluginapi.PluginConfig{
+				"command":    "/bin/true",
+				"record_ttl": "5m",
+			},
+		},
+		"without_ttl": {
+			Type: "shell",
+			Config: pluginapi.PluginConfig{
+				"command": "/bin/true",
+			},
+		},
+	}
+
+	if err := m.LoadPlugins(ctx, definitions); err != nil {
+		t.Fatalf("LoadPlugins() unexpected error: %v", err)
+	}
+
+	if got := m.RecordTTL("with_ttl"); got != 5*time.Minute {
+		t.Errorf("RecordTTL() = %v, want 5m", got)
+	}
+	if got := m.RecordTTL("without_ttl"); got != 0 {
+		t.Errorf("RecordTTL() = %v, want 0 when record_ttl is not set", got)
+	}
+}
+
+func TestRecordTTL_Invalid(t *testing.T) {
+	m := NewManager()
+
+	err := m.LoadPlugins(context.Background(), map[string]pluginapi.PluginDefinition{
+		"test_plugin": {
+			Type: "shell",
+			Config: pluginapi.PluginConfig{
+				"command":    "/bin/true",
+				"record_ttl": "soon",
+			},
+		},
+	})
+	if err == nil {
+		t.Error("LoadPlugins() should reject an invalid record_ttl")
+	}
+}
+
+func TestRecordTTL_WithDedup(t *testing.T) {
+	m := NewManager()
+
+	err := m.LoadPlugins(context.Background(), map[string]pluginapi.PluginDefinition{
+		"test_plugin": {
+			Type: "shell",
+			Config: pluginapi.PluginConfig{
+				"command":    "/bin/true",
+				"dedup":      true,
+				"record_ttl": "10m",
+			},
+		},
+	})
+	if err == nil {
+		t.Error("LoadPlugins() should reject record_ttl together with dedup")
+	}
+}
+
+func TestManager_Close(t *testing.T) {
+	t.Setenv(rpcHelperEnv, "1")
+	ctx := context.Background()
+
+	m := NewManager()
+	err := m.LoadPlugins(ctx, map[string]pluginapi.PluginDefinition{
+		"helper": {Type: "rpc", Config: pluginapi.PluginConfig{
+			"command":    os.Args[0],
+			"args":       []string{"-test.run=^TestRPCPluginHelper$"},
+			"resilience": map[string]interface{}{"retries": 1},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("LoadPlugins() error = %v", err)
+	}
+	store, _ := m.GetPlugin("helper")
+	if err := store.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// The plugin process is stopped through the resilience decorator.
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "key"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Get() after Close() error = %v, want the plugin closed", err)
+	}
+}
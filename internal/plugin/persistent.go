@@ -0,0 +1,337 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+const (
+	persistentRestartMin = 100 * time.Millisecond
+	persistentRestartMax = 30 * time.Second
+	persistentStopGrace  = 5 * time.Second
+	// persistentMaxLine bounds one response line; records are a few hundred
+	// bytes, so this only guards against a plugin writing garbage.
+	persistentMaxLine = 1 << 20
+)
+
+var errPersistentClosed = errors.New("exec plugin is closed")
+
+// persistentProcess keeps one exec plugin child running and multiplexes
+// requests over its stdin and stdout, one JSON object per line, matching
+// responses to requests by ID. When the child exits it is started again on
+// the next request, after a backoff that grows while it keeps exiting
+// without answering anything.
+type persistentProcess struct {
+	command string
+	args    []string
+
+	mu     sync.Mutex
+	child  *persistentChild
+	nextID uint64
+	// failures counts exits and failed starts since the last child that
+	// answered a request; it sets how long after exitedAt the next start
+	// waits.
+	failures int
+	exitedAt time.Time
+	closed   bool
+}
+
+// persistentChild is one run of the plugin process.
+type persistentChild struct {
+	cmd *exec.Cmd
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[uint64]chan *pluginapi.ExecResponse
+	// answered is set once the child has sent a valid response.
+	answered bool
+	// done is closed once the process has exited, with err set to why.
+	done chan struct{}
+	err  error
+}
+
+func newPersistentProcess(command string, args []string) *persistentProcess {
+	return &persistentProcess{command: command, args: args}
+}
+
+// call sends request to the child, starting it if needed, and waits for the
+// response with the same ID. A request in flight when the child exits fails
+// rather than being resent: a Set the plugin may have applied is not
+// repeated behind the caller's back. A child that does not take a request
+// before ctx is done, or answer it before its deadline, is taken to hang:
+// it is killed, and the next request starts another.
+func (p *persistentProcess) call(ctx context.Context, request pluginapi.ExecRequest) (*pluginapi.ExecResponse, error) {
+	child, id, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	request.ID = id
+
+	ch := make(chan *pluginapi.ExecResponse, 1)
+	child.mu.Lock()
+	if child.pending == nil {
+		child.mu.Unlock()
+		<-child.done
+		return nil, fmt.Errorf("exec plugin process exited: %w", child.err)
+	}
+	child.pending[id] = ch
+	child.mu.Unlock()
+
+	line, err := json.Marshal(request)
+	if err != nil {
+		child.forget(id)
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	// The write blocks while the child is not reading, so it runs apart
+	// from the caller, which only waits for it as long as ctx allows.
+	written := make(chan error, 1)
+	go func() {
+		child.writeMu.Lock()
+		_, err := child.stdin.Write(append(line, '\n'))
+		child.writeMu.Unlock()
+		written <- err
+	}()
+	select {
+	case err = <-written:
+	case <-ctx.Done():
+		child.forget(id)
+		p.kill(child)
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		child.forget(id)
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-child.done:
+		// The reader may have delivered the response just before exiting.
+		select {
+		case response := <-ch:
+			return response, nil
+		default:
+		}
+		return nil, fmt.Errorf("exec plugin process exited: %w", child.err)
+	case <-ctx.Done():
+		child.forget(id)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			p.kill(child)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// kill tears down a child that hangs. It stops being the running child at
+// once, counting as one that did not answer, so the next request starts
+// another after the backoff; its reader fails the requests still waiting
+// on it once it has exited.
+func (p *persistentProcess) kill(child *persistentChild) {
+	p.mu.Lock()
+	if p.child == child {
+		p.child = nil
+		p.exitedAt = time.Now()
+		p.failures++
+	}
+	p.mu.Unlock()
+	_ = child.cmd.Process.Kill()
+}
+
+// acquire returns the running child, or starts one once the restart
+// backoff has passed, together with a fresh request ID.
+func (p *persistentProcess) acquire(ctx context.Context) (*persistentChild, uint64, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, 0, errPersistentClosed
+		}
+		if p.child != nil {
+			p.nextID++
+			child, id := p.child, p.nextID
+			p.mu.Unlock()
+			return child, id, nil
+		}
+
+		wait := time.Until(p.exitedAt.Add(p.backoff()))
+		if wait <= 0 {
+			child, err := p.start()
+			if err != nil {
+				p.failures++
+				p.exitedAt = time.Now()
+				p.mu.Unlock()
+				return nil, 0, err
+			}
+			p.child = child
+			p.mu.Unlock()
+			continue
+		}
+		p.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, fmt.Errorf("exec plugin is restarting: %w", ctx.Err())
+		}
+	}
+}
+
+// backoff is the wait after exitedAt before the next start:
+// persistentRestartMin after a child that answered requests, doubling for
+// each one in a row that did not. Called with mu held.
+func (p *persistentProcess) backoff() time.Duration {
+	if p.failures == 0 {
+		return 0
+	}
+	wait := persistentRestartMin
+	for i := 1; i < p.failures && wait < persistentRestartMax; i++ {
+		wait *= 2
+	}
+	return min(wait, persistentRestartMax)
+}
+
+// start runs a new child. Called with mu held.
+func (p *persistentProcess) start() (*persistentChild, error) {
+	// Not CommandContext: the child outlives any one request.
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	child := &persistentChild{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan *pluginapi.ExecResponse),
+		done:    make(chan struct{}),
+	}
+	go p.read(child, stdout)
+	return child, nil
+}
+
+// read delivers response lines until the child's stdout closes, then reaps
+// the child and fails whatever was still waiting on it.
+func (p *persistentProcess) read(child *persistentChild, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), persistentMaxLine)
+
+	var readErr error
+	for scanner.Scan() {
+		var response pluginapi.ExecResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			// Without an ID there is nobody to hand the error to, and the
+			// stream can no longer be trusted.
+			readErr = fmt.Errorf("failed to decode response: %w", err)
+			break
+		}
+
+		child.mu.Lock()
+		child.answered = true
+		ch, ok := child.pending[response.ID]
+		delete(child.pending, response.ID)
+		child.mu.Unlock()
+		// An unknown ID is the answer to a request whose caller gave up.
+		if ok {
+			ch <- &response
+		}
+	}
+	if readErr == nil {
+		readErr = scanner.Err()
+	}
+
+	// Closing stdin asks a child that is still running to exit; a child
+	// that sent garbage is not waited for.
+	child.writeMu.Lock()
+	_ = child.stdin.Close()
+	child.writeMu.Unlock()
+	if readErr != nil {
+		_ = child.cmd.Process.Kill()
+	}
+	waitErr := child.cmd.Wait()
+
+	child.mu.Lock()
+	switch {
+	case readErr != nil:
+		child.err = readErr
+	case waitErr != nil:
+		child.err = waitErr
+	default:
+		child.err = io.EOF
+	}
+	child.pending = nil
+	answered := child.answered
+	child.mu.Unlock()
+	close(child.done)
+
+	p.mu.Lock()
+	if p.child == child {
+		p.child = nil
+		p.exitedAt = time.Now()
+		if answered {
+			p.failures = 0
+		} else {
+			p.failures++
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (c *persistentChild) forget(id uint64) {
+	c.mu.Lock()
+	if c.pending != nil {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// close stops the child for good: stdin is closed so it can exit on its
+// own, and it is killed if it has not within persistentStopGrace.
+func (p *persistentProcess) close() error {
+	p.mu.Lock()
+	p.closed = true
+	child := p.child
+	p.child = nil
+	p.mu.Unlock()
+
+	if child == nil {
+		return nil
+	}
+
+	child.writeMu.Lock()
+	err := child.stdin.Close()
+	child.writeMu.Unlock()
+
+	select {
+	case <-child.done:
+	case <-time.After(persistentStopGrace):
+		_ = child.cmd.Process.Kill()
+		<-child.done
+	}
+	return err
+}
@@ -0,0 +1,351 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/plugin/builtin"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+const (
+	// configKeyResilience names the block of a plugin instance's config that
+	// wraps it in a resilientStore. It is a block of its own because several
+	// built-ins already read a timeout key for their own requests.
+	configKeyResilience = "resilience"
+
+	defaultRetryBackoff    = time.Second
+	defaultBreakerCooldown = time.Minute
+)
+
+// ErrCircuitOpen is returned without calling the store while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("plugin circuit breaker is open")
+
+// ResilienceConfig is a plugin instance's resilience block.
+type ResilienceConfig struct {
+	// Timeout bounds each attempt of a call; zero leaves it unbounded.
+	Timeout time.Duration
+	// Retries is how many more attempts a failed call gets.
+	Retries int
+	// Backoff is the wait before the first retry, doubling for each next.
+	Backoff time.Duration
+	// BreakerThreshold is how many failed calls in a row open the circuit
+	// breaker; zero disables it.
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open before a single
+	// call is let through to probe the store again.
+	BreakerCooldown time.Duration
+}
+
+// parseResilience reads the resilience block, returning nil when there is
+// none.
+func parseResilience(config pluginapi.PluginConfig) (*ResilienceConfig, error) {
+	values, ok, err := getBlock(config, configKeyResilience)
+	if !ok || err != nil {
+		return nil, err
+	}
+	cfg := builtin.NewConfig(values)
+
+	rc := &ResilienceConfig{Backoff: defaultRetryBackoff, BreakerCooldown: defaultBreakerCooldown}
+	if rc.Timeout, err = getDuration(cfg, configKeyResilience, "timeout", rc.Timeout); err != nil {
+		return nil, err
+	}
+	if rc.Backoff, err = getDuration(cfg, configKeyResilience, "backoff", rc.Backoff); err != nil {
+		return nil, err
+	}
+	if rc.BreakerCooldown, err = getDuration(cfg, configKeyResilience, "breaker_cooldown", rc.BreakerCooldown); err != nil {
+		return nil, err
+	}
+	if rc.Retries, err = getCount(values, configKeyResilience, "retries"); err != nil {
+		return nil, err
+	}
+	if rc.BreakerThreshold, err = getCount(values, configKeyResilience, "breaker_threshold"); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// getBlock returns the nested block of config named block, if there is one.
+func getBlock(config pluginapi.PluginConfig, block string) (map[string]interface{}, bool, error) {
+	raw, ok := config[block]
+	if !ok {
+		return nil, false, nil
+	}
+	values, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("%s must be a map", block)
+	}
+	return values, true, nil
+}
+
+func getDuration(cfg *builtin.Config, block, key string, def time.Duration) (time.Duration, error) {
+	d, ok, err := cfg.GetDuration(key)
+	if err != nil {
+		return 0, fmt.Errorf("%s.%w", block, err)
+	}
+	if !ok {
+		return def, nil
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s.%s must not be negative", block, key)
+	}
+	return d, nil
+}
+
+func getCount(values map[string]interface{}, block, key string) (int, error) {
+	val, ok := values[key]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := val.(int)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%s.%s must be a non-negative integer", block, key)
+	}
+	return n, nil
+}
+
+// resilientStore wraps a store with per-attempt timeouts, retries with
+// exponential backoff, and a circuit breaker that fails calls fast while
+// the store keeps failing, so a dead backend or a hung plugin costs each
+// refresh little.
+//
+// The optional interfaces go through the same policy, and As sees through
+// to whether the wrapped store offers them. Watch is passed on as is: it is
+// meant to run until cancelled.
+type resilientStore struct {
+	store pluginapi.Store
+	cfg   ResilienceConfig
+
+	mu sync.Mutex
+	// failures counts failed calls since the last success.
+	failures  int
+	openUntil time.Time
+	// probing is set while the one call let through a half-open breaker
+	// is in flight.
+	probing bool
+}
+
+func newResilientStore(store pluginapi.Store, cfg ResilienceConfig) *resilientStore {
+	return &resilientStore{store: store, cfg: cfg}
+}
+
+func (s *resilientStore) Unwrap() pluginapi.Store {
+	return s.store
+}
+
+// call runs op under the policy.
+func (s *resilientStore) call(ctx context.Context, op func(ctx context.Context) error) error {
+	if err := s.allow(); err != nil {
+		return err
+	}
+
+	logger := zerolog.Ctx(ctx)
+	backoff := s.cfg.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = s.attempt(ctx, op)
+		if err == nil || !retryable(err) || ctx.Err() != nil || attempt == s.cfg.Retries {
+			break
+		}
+
+		logger.Warn().Err(err).Int("attempt", attempt+1).Dur("retry_in", backoff).Msg("plugin call failed, retrying")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	s.record(ctx, err)
+	return err
+}
+
+// retryable reports whether another attempt may succeed where err failed.
+// Not for an answer about the record, nor when the backend turned us away:
+// retrying a rate limit right away only prolongs it.
+func retryable(err error) bool {
+	for _, final := range []error{pluginapi.ErrNotModified, pluginapi.ErrNotFound, pluginapi.ErrUnauthorized, pluginapi.ErrRateLimited} {
+		if errors.Is(err, final) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *resilientStore) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if s.cfg.Timeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	return op(ctx)
+}
+
+// allow fails fast while the breaker is open, and lets a single call
+// through once its cooldown has passed.
+func (s *resilientStore) allow() error {
+	if s.cfg.BreakerThreshold == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures < s.cfg.BreakerThreshold {
+		return nil
+	}
+	if time.Now().Before(s.openUntil) || s.probing {
+		return ErrCircuitOpen
+	}
+	s.probing = true
+	return nil
+}
+
+// record counts a call's outcome towards the breaker. A call given up
+// because the caller's context ended says nothing about the store, and an
+// ErrNotModified or ErrNotFound is an answer like any other.
+func (s *resilientStore) record(ctx context.Context, err error) {
+	if s.cfg.BreakerThreshold == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+	switch {
+	case err == nil, errors.Is(err, pluginapi.ErrNotModified), errors.Is(err, pluginapi.ErrNotFound):
+		s.failures = 0
+	case ctx.Err() != nil:
+	default:
+		s.failures++
+		if s.failures >= s.cfg.BreakerThreshold {
+			s.openUntil = time.Now().Add(s.cfg.BreakerCooldown)
+			zerolog.Ctx(ctx).Warn().Err(err).Int("failures", s.failures).Dur("cooldown", s.cfg.BreakerCooldown).Msg("plugin keeps failing, pausing calls to it")
+		}
+	}
+}
+
+func (s *resilientStore) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = s.store.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (s *resilientStore) GetIfModified(ctx context.Context, key string, rev pluginapi.Revision) (string, pluginapi.Revision, error) {
+	conditional, ok := pluginapi.As[pluginapi.ConditionalGetter](s.store)
+	if !ok {
+		value, err := s.Get(ctx, key)
+		return value, pluginapi.Revision{}, err
+	}
+	var value string
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		value, rev, err = conditional.GetIfModified(ctx, key, rev)
+		return err
+	})
+	return value, rev, err
+}
+
+func (s *resilientStore) Set(ctx context.Context, key string, value string) error {
+	return s.call(ctx, func(ctx context.Context) error {
+		return s.store.Set(ctx, key, value)
+	})
+}
+
+func (s *resilientStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	setter, ok := pluginapi.As[pluginapi.TTLSetter](s.store)
+	if !ok {
+		return s.Set(ctx, key, value)
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return setter.SetWithTTL(ctx, key, value, ttl)
+	})
+}
+
+func (s *resilientStore) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	many, ok := pluginapi.As[pluginapi.BatchStore](s.store)
+	if !ok {
+		return nil, fmt.Errorf("plugin cannot read records at once")
+	}
+	var records map[string]string
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		records, err = many.GetMany(ctx, keys)
+		return err
+	})
+	return records, err
+}
+
+func (s *resilientStore) SetMany(ctx context.Context, records map[string]string) error {
+	many, ok := pluginapi.As[pluginapi.BatchStore](s.store)
+	if !ok {
+		return fmt.Errorf("plugin cannot write records at once")
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return many.SetMany(ctx, records)
+	})
+}
+
+func (s *resilientStore) Delete(ctx context.Context, key string) error {
+	deleter, ok := pluginapi.As[pluginapi.Deleter](s.store)
+	if !ok {
+		return fmt.Errorf("plugin cannot delete records")
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return deleter.Delete(ctx, key)
+	})
+}
+
+func (s *resilientStore) List(ctx context.Context) ([]string, error) {
+	lister, ok := pluginapi.As[pluginapi.Lister](s.store)
+	if !ok {
+		return nil, fmt.Errorf("plugin cannot list keys")
+	}
+	var keys []string
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		keys, err = lister.List(ctx)
+		return err
+	})
+	return keys, err
+}
+
+func (s *resilientStore) Watch(ctx context.Context, keys []string, changed func(key string)) error {
+	watcher, ok := pluginapi.As[pluginapi.Watcher](s.store)
+	if !ok {
+		return fmt.Errorf("plugin cannot watch records")
+	}
+	return watcher.Watch(ctx, keys, changed)
+}
+
+func (s *resilientStore) NewBatch() pluginapi.Batch {
+	batcher, ok := pluginapi.As[pluginapi.Batcher](s.store)
+	if !ok {
+		return nil
+	}
+	return &resilientBatch{Batch: batcher.NewBatch(), store: s}
+}
+
+// resilientBatch flushes a batch under its store's policy. Retrying a flush
+// is safe because a failed one wrote nothing.
+type resilientBatch struct {
+	pluginapi.Batch
+	store *resilientStore
+}
+
+func (b *resilientBatch) Flush(ctx context.Context) error {
+	return b.store.call(ctx, b.Batch.Flush)
+}
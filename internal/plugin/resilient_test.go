@@ -0,0 +1,223 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// flakyStore fails its first failures calls, with err when it is set, and
+// hangs until the context ends when hang is set.
+type flakyStore struct {
+	calls    int
+	failures int
+	err      error
+	hang     bool
+}
+
+func (s *flakyStore) Get(ctx context.Context, key string) (string, error) {
+	s.calls++
+	if s.hang {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if s.calls <= s.failures {
+		if s.err != nil {
+			return "", s.err
+		}
+		return "", errors.New("backend unavailable")
+	}
+	return "value", nil
+}
+
+func (s *flakyStore) Set(ctx context.Context, key string, value string) error {
+	_, err := s.Get(ctx, key)
+	return err
+}
+
+func TestResilientStore_Retries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "enough retries", retries: 2, wantCalls: 3},
+		{name: "too few retries", retries: 1, wantErr: true, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &flakyStore{failures: 2}
+			store := newResilientStore(inner, ResilienceConfig{Retries: tt.retries, Backoff: time.Millisecond})
+
+			_, err := store.Get(context.Background(), "key")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if inner.calls != tt.wantCalls {
+				t.Errorf("store called %d times, want %d", inner.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResilientStore_FinalErrors(t *testing.T) {
+	for _, final := range []error{pluginapi.ErrNotFound, pluginapi.ErrUnauthorized, pluginapi.ErrRateLimited} {
+		t.Run(final.Error(), func(t *testing.T) {
+			inner := &flakyStore{failures: 1, err: fmt.Errorf("backend says: %w", final)}
+			store := newResilientStore(inner, ResilienceConfig{Retries: 2, Backoff: time.Millisecond})
+
+			if _, err := store.Get(context.Background(), "key"); !errors.Is(err, final) {
+				t.Errorf("Get() error = %v, want %v", err, final)
+			}
+			if inner.calls != 1 {
+				t.Errorf("store called %d times, want no retries", inner.calls)
+			}
+		})
+	}
+}
+
+func TestResilientStore_NotFoundKeepsBreakerClosed(t *testing.T) {
+	inner := &flakyStore{failures: 3, err: pluginapi.ErrNotFound}
+	store := newResilientStore(inner, ResilienceConfig{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Get(context.Background(), "key"); !errors.Is(err, pluginapi.ErrNotFound) {
+			t.Fatalf("Get() #%d error = %v, want ErrNotFound", i+1, err)
+		}
+	}
+	if inner.calls != 3 {
+		t.Errorf("store called %d times, want every call let through", inner.calls)
+	}
+}
+
+func TestResilientStore_Timeout(t *testing.T) {
+	inner := &flakyStore{hang: true}
+	store := newResilientStore(inner, ResilienceConfig{Timeout: 20 * time.Millisecond})
+
+	start := time.Now()
+	_, err := store.Get(context.Background(), "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get() took %s, want it cut off at the timeout", elapsed)
+	}
+}
+
+func TestResilientStore_CircuitBreaker(t *testing.T) {
+	inner := &flakyStore{failures: 3}
+	store := newResilientStore(inner, ResilienceConfig{BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get(ctx, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Get() #%d error = %v, want the store's failure", i+1, err)
+		}
+	}
+
+	// Open: calls fail without reaching the store.
+	if _, err := store.Get(ctx, "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get() error = %v, want ErrCircuitOpen", err)
+	}
+	if inner.calls != 2 {
+		t.Fatalf("store called %d times while open, want 2", inner.calls)
+	}
+
+	// After the cooldown one probe goes through; it fails and reopens.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := store.Get(ctx, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe error = %v, want the store's failure", err)
+	}
+	if _, err := store.Get(ctx, "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get() after failed probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes it again.
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get(ctx, "key"); err != nil {
+			t.Fatalf("Get() after recovery error = %v", err)
+		}
+	}
+}
+
+// deletingStore is a memStore that can also delete records.
+type deletingStore struct {
+	*memStore
+}
+
+func (s deletingStore) Delete(ctx context.Context, key string) error {
+	delete(s.data, key)
+	return nil
+}
+
+func TestResilientStore_OptionalInterfaces(t *testing.T) {
+	plain := newResilientStore(newMemStore(), ResilienceConfig{})
+	if _, ok := pluginapi.As[pluginapi.Deleter](plain); ok {
+		t.Error("As[Deleter] found a Deleter the wrapped store does not implement")
+	}
+
+	inner := deletingStore{newMemStore()}
+	inner.data["key"] = "value"
+	wrapped := newResilientStore(inner, ResilienceConfig{})
+	deleter, ok := pluginapi.As[pluginapi.Deleter](wrapped)
+	if !ok {
+		t.Fatal("As[Deleter] did not find the wrapped store's Delete")
+	}
+	if err := deleter.Delete(context.Background(), "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := inner.data["key"]; ok {
+		t.Error("Delete() did not reach the wrapped store")
+	}
+}
+
+func TestLoadPlugins_Resilience(t *testing.T) {
+	tests := []struct {
+		name       string
+		resilience interface{}
+		wantErr    bool
+	}{
+		{name: "valid", resilience: map[string]interface{}{"timeout": "5s", "retries": 2, "backoff": "500ms", "breaker_threshold": 5, "breaker_cooldown": 60}},
+		{name: "not a map", resilience: "5s", wantErr: true},
+		{name: "bad timeout", resilience: map[string]interface{}{"timeout": "soon"}, wantErr: true},
+		{name: "negative retries", resilience: map[string]interface{}{"retries": -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			err := m.LoadPlugins(context.Background(), map[string]pluginapi.PluginDefinition{
+				"test_plugin": {
+					Type: "shell",
+					Config: pluginapi.PluginConfig{
+						"command":    "/bin/true",
+						"resilience": tt.resilience,
+					},
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPlugins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			store, _ := m.GetPlugin("test_plugin")
+			wrapped, ok := store.(*resilientStore)
+			if !ok {
+				t.Fatalf("plugin is %T, want it wrapped in *resilientStore", store)
+			}
+			want := ResilienceConfig{Timeout: 5 * time.Second, Retries: 2, Backoff: 500 * time.Millisecond, BreakerThreshold: 5, BreakerCooldown: time.Minute}
+			if wrapped.cfg != want {
+				t.Errorf("config = %+v, want %+v", wrapped.cfg, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/rs/zerolog"
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+const (
+	// rpcHealthInterval is how often a running rpc plugin is pinged; one
+	// that does not answer within rpcHealthTimeout is killed, and started
+	// again on the next call.
+	rpcHealthInterval = 30 * time.Second
+	rpcHealthTimeout  = 10 * time.Second
+	// rpcRestartDelay spaces out starts of a plugin that keeps exiting.
+	rpcRestartDelay = time.Second
+)
+
+type RPCConfig struct {
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+}
+
+// RPCPlugin is a plugin program serving pluginapi.Serve's protocol. It is
+// started when the plugin is loaded, so a missing or incompatible binary
+// fails at startup, and again on the next call whenever it exits.
+//
+// The capabilities the plugin reports decide which optional interfaces
+// NewRPCPlugin's store has. Those that have a plain fallback are always
+// there: SetWithTTL falls back to Set, GetMany and SetMany to a call per
+// key.
+type RPCPlugin struct {
+	command string
+	args    []string
+
+	mu           sync.Mutex
+	client       *rpc.Client
+	cmd          *exec.Cmd
+	capabilities []string
+	startedAt    time.Time
+	closed       bool
+	stop         chan struct{}
+}
+
+// rpcDeleter, rpcLister and rpcCleaner add the interfaces a plugin's
+// capabilities vouch for.
+type rpcDeleter struct{ *RPCPlugin }
+
+type rpcLister struct{ *RPCPlugin }
+
+type rpcCleaner struct{ *RPCPlugin }
+
+func (p rpcDeleter) Delete(ctx context.Context, key string) error { return p.delete(ctx, key) }
+func (p rpcLister) List(ctx context.Context) ([]string, error)    { return p.list(ctx) }
+func (p rpcCleaner) Delete(ctx context.Context, key string) error { return p.delete(ctx, key) }
+func (p rpcCleaner) List(ctx context.Context) ([]string, error)   { return p.list(ctx) }
+
+func NewRPCPlugin(ctx context.Context, config pluginapi.PluginConfig) (pluginapi.Store, error) {
+	var cfg RPCConfig
+	if err := mapstructure.Decode(config, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode rpc config: %w", err)
+	}
+
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("command is required for rpc plugin")
+	}
+
+	p := &RPCPlugin{
+		command: cfg.Command,
+		args:    cfg.Args,
+		stop:    make(chan struct{}),
+	}
+	if _, err := p.acquire(ctx); err != nil {
+		return nil, err
+	}
+	go p.checkHealth(zerolog.Ctx(ctx))
+
+	canDelete := slices.Contains(p.capabilities, pluginapi.CapabilityDelete)
+	canList := slices.Contains(p.capabilities, pluginapi.CapabilityList)
+	switch {
+	case canDelete && canList:
+		return rpcCleaner{p}, nil
+	case canDelete:
+		return rpcDeleter{p}, nil
+	case canList:
+		return rpcLister{p}, nil
+	default:
+		return p, nil
+	}
+}
+
+// Close stops the plugin process for good.
+func (p *RPCPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	p.shutdown()
+	return nil
+}
+
+// acquire returns the running plugin's client, starting the plugin first if
+// it is not running, though no sooner than rpcRestartDelay after the last
+// start.
+func (p *RPCPlugin) acquire(ctx context.Context) (*rpc.Client, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errors.New("rpc plugin is closed")
+		}
+		if p.client != nil {
+			client := p.client
+			p.mu.Unlock()
+			return client, nil
+		}
+
+		wait := time.Until(p.startedAt.Add(rpcRestartDelay))
+		if wait <= 0 {
+			p.startedAt = time.Now()
+			err := p.start()
+			client := p.client
+			p.mu.Unlock()
+			return client, err
+		}
+		p.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("rpc plugin is restarting: %w", ctx.Err())
+		}
+	}
+}
+
+// start runs the plugin and handshakes with it. Called with mu held.
+func (p *RPCPlugin) start() error {
+	// Not CommandContext: the plugin outlives any one call.
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Env = append(os.Environ(), pluginapi.RPCPluginEnv+"="+strconv.Itoa(pluginapi.RPCProtocolVersion))
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	client := rpc.NewClient(pipeConn{ReadCloser: stdout, WriteCloser: stdin})
+	var reply pluginapi.RPCHandshakeReply
+	call := client.Go(pluginapi.RPCServiceName+".Handshake", pluginapi.RPCHandshakeArgs{Version: pluginapi.RPCProtocolVersion}, &reply, nil)
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(rpcHealthTimeout):
+		err = errors.New("no answer")
+	}
+	if err == nil && reply.Version != pluginapi.RPCProtocolVersion {
+		err = fmt.Errorf("plugin speaks protocol %d, not %d", reply.Version, pluginapi.RPCProtocolVersion)
+	}
+	if err != nil {
+		_ = client.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("rpc plugin handshake failed: %w", err)
+	}
+
+	p.client, p.cmd, p.capabilities = client, cmd, reply.Capabilities
+	return nil
+}
+
+// shutdown closes the client, which closes the plugin's stdin and asks it
+// to exit, and reaps it in the background. Called with mu held.
+func (p *RPCPlugin) shutdown() {
+	if p.client == nil {
+		return
+	}
+	_ = p.client.Close()
+	cmd := p.cmd
+	go func() {
+		timer := time.AfterFunc(persistentStopGrace, func() { _ = cmd.Process.Kill() })
+		_ = cmd.Wait()
+		timer.Stop()
+	}()
+	p.client, p.cmd = nil, nil
+}
+
+// fail drops client if it is still the current one, so the next call
+// starts the plugin again.
+func (p *RPCPlugin) fail(client *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == client {
+		p.shutdown()
+	}
+}
+
+// call invokes method on the plugin, giving up when ctx ends. A call the
+// plugin answers with an error leaves it running; a broken connection does
+// not, and the plugin is started again on the next call.
+func (p *RPCPlugin) call(ctx context.Context, method string, request pluginapi.RPCRequest) (*pluginapi.RPCReply, error) {
+	client, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = deadline
+	}
+
+	var reply pluginapi.RPCReply
+	call := client.Go(pluginapi.RPCServiceName+"."+method, request, &reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var serverErr rpc.ServerError
+	switch {
+	case call.Error == nil:
+		return &reply, nil
+	case errors.As(call.Error, &serverErr):
+		// The plugin works to the same deadline, so its reply can beat
+		// ctx.Done, even ctx's timer, to report it; the caller should see
+		// ctx's own error.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, fmt.Errorf("rpc plugin error: %w", pluginapi.ParseCodedMessage(string(serverErr)))
+	default:
+		p.fail(client)
+		return nil, fmt.Errorf("rpc plugin connection failed: %w", call.Error)
+	}
+}
+
+// checkHealth pings the running plugin every rpcHealthInterval, killing one
+// that hangs so the next call starts it afresh.
+func (p *RPCPlugin) checkHealth(logger *zerolog.Logger) {
+	ticker := time.NewTicker(rpcHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		client := p.client
+		p.mu.Unlock()
+		if client == nil {
+			continue
+		}
+
+		call := client.Go(pluginapi.RPCServiceName+".Ping", struct{}{}, &struct{}{}, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+			if call.Error == nil {
+				continue
+			}
+			logger.Warn().Err(call.Error).Str("command", p.command).Msg("rpc plugin failed its health check, restarting it")
+		case <-time.After(rpcHealthTimeout):
+			logger.Warn().Str("command", p.command).Msg("rpc plugin did not answer its health check, restarting it")
+		}
+		p.fail(client)
+	}
+}
+
+func (p *RPCPlugin) has(capability string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Contains(p.capabilities, capability)
+}
+
+func (p *RPCPlugin) Get(ctx context.Context, key string) (string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("get data from rpc plugin")
+
+	reply, err := p.call(ctx, "Get", pluginapi.RPCRequest{Key: key})
+	if err != nil {
+		return "", err
+	}
+	return reply.Value, nil
+}
+
+func (p *RPCPlugin) Set(ctx context.Context, key string, value string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Str("value", value).Msg("set data to rpc plugin")
+
+	_, err := p.call(ctx, "Set", pluginapi.RPCRequest{Key: key, Value: value})
+	return err
+}
+
+func (p *RPCPlugin) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if !p.has(pluginapi.CapabilityTTL) {
+		return p.Set(ctx, key, value)
+	}
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Str("value", value).Dur("ttl", ttl).Msg("set data to rpc plugin")
+
+	_, err := p.call(ctx, "SetWithTTL", pluginapi.RPCRequest{Key: key, Value: value, TTL: ttl})
+	return err
+}
+
+func (p *RPCPlugin) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	if !p.has(pluginapi.CapabilityMany) {
+		records := make(map[string]string, len(keys))
+		for _, key := range keys {
+			value, err := p.Get(ctx, key)
+			switch {
+			case err == nil:
+				records[key] = value
+			case !errors.Is(err, pluginapi.ErrNotFound):
+				return nil, err
+			}
+		}
+		return records, nil
+	}
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(keys)).Msg("get many data from rpc plugin")
+
+	reply, err := p.call(ctx, "GetMany", pluginapi.RPCRequest{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+func (p *RPCPlugin) SetMany(ctx context.Context, records map[string]string) error {
+	if !p.has(pluginapi.CapabilityMany) {
+		for key, value := range records {
+			if err := p.Set(ctx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Int("keys", len(records)).Msg("set many data to rpc plugin")
+
+	_, err := p.call(ctx, "SetMany", pluginapi.RPCRequest{Records: records})
+	return err
+}
+
+func (p *RPCPlugin) delete(ctx context.Context, key string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("delete data from rpc plugin")
+
+	_, err := p.call(ctx, "Delete", pluginapi.RPCRequest{Key: key})
+	return err
+}
+
+func (p *RPCPlugin) list(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("list keys from rpc plugin")
+
+	reply, err := p.call(ctx, "List", pluginapi.RPCRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Keys, nil
+}
+
+// pipeConn joins a child's stdout and stdin into the connection net/rpc
+// wants.
+type pipeConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (c pipeConn) Close() error {
+	werr := c.WriteCloser.Close()
+	rerr := c.ReadCloser.Close()
+	return errors.Join(werr, rerr)
+}
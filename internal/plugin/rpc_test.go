@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+// rpcHelperEnv makes the test binary serve helperStore as an rpc plugin
+// instead of running tests, with the test binary as the plugin command.
+const rpcHelperEnv = "STUNMESH_RPC_TEST_HELPER"
+
+// helperStore is the rpc plugin the helper process serves. Its "crash" key
+// makes the process exit, its "hang" key blocks until the call's deadline
+// and its "denied" key fails with ErrUnauthorized.
+type helperStore struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *helperStore) Get(ctx context.Context, key string) (string, error) {
+	switch key {
+	case "crash":
+		os.Exit(1)
+	case "hang":
+		<-ctx.Done()
+		return "", ctx.Err()
+	case "denied":
+		return "", pluginapi.ErrUnauthorized
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", pluginapi.ErrNotFound, key)
+	}
+	return value, nil
+}
+
+func (s *helperStore) Set(ctx context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return nil
+}
+
+func (s *helperStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func TestRPCPluginHelper(t *testing.T) {
+	if os.Getenv(rpcHelperEnv) == "" {
+		t.Skip("only runs as the rpc plugin of the other tests")
+	}
+	if err := pluginapi.Serve(&helperStore{data: make(map[string]string)}); err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func newHelperPlugin(t *testing.T) pluginapi.Store {
+	t.Helper()
+	t.Setenv(rpcHelperEnv, "1")
+
+	store, err := NewRPCPlugin(context.Background(), pluginapi.PluginConfig{
+		"command": os.Args[0],
+		"args":    []string{"-test.run=^TestRPCPluginHelper$"},
+	})
+	if err != nil {
+		t.Fatalf("NewRPCPlugin() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.(interface{ Close() error }).Close()
+	})
+	return store
+}
+
+func TestRPCPlugin_SetGet(t *testing.T) {
+	store := newHelperPlugin(t)
+	ctx := context.Background()
+
+	if err := store.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := store.Get(ctx, "key"); err != nil || got != "value" {
+		t.Errorf("Get() = (%q, %v), want (value, nil)", got, err)
+	}
+
+	_, err := store.Get(ctx, "missing")
+	if !errors.Is(err, pluginapi.ErrNotFound) || !strings.Contains(err.Error(), "record not found: missing") {
+		t.Errorf("Get() of a missing key error = %v, want the plugin's ErrNotFound", err)
+	}
+}
+
+func TestRPCPlugin_Conformance(t *testing.T) {
+	plugintest.Run(t, newHelperPlugin)
+}
+
+func TestRPCPlugin_Capabilities(t *testing.T) {
+	store := newHelperPlugin(t)
+	ctx := context.Background()
+
+	deleter, ok := pluginapi.As[pluginapi.Deleter](store)
+	if !ok {
+		t.Fatal("store is not a Deleter though the plugin can delete")
+	}
+	if _, ok := pluginapi.As[pluginapi.Lister](store); ok {
+		t.Error("store is a Lister though the plugin cannot list")
+	}
+
+	_ = store.Set(ctx, "key", "value")
+	if err := deleter.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "key"); err == nil {
+		t.Error("Get() after Delete() should fail")
+	}
+
+	// Without the ttl capability the TTL is dropped rather than failing.
+	setter, _ := pluginapi.As[pluginapi.TTLSetter](store)
+	if err := setter.SetWithTTL(ctx, "key", "value", time.Minute); err != nil {
+		t.Errorf("SetWithTTL() error = %v", err)
+	}
+}
+
+// Without the many capability GetMany gets the keys one by one, leaving
+// out only those without a record.
+func TestRPCPlugin_GetManyFallback(t *testing.T) {
+	store := newHelperPlugin(t)
+	ctx := context.Background()
+	_ = store.Set(ctx, "key", "value")
+
+	batch, _ := pluginapi.As[pluginapi.BatchStore](store)
+	records, err := batch.GetMany(ctx, []string{"key", "missing"})
+	if err != nil || len(records) != 1 || records["key"] != "value" {
+		t.Errorf("GetMany() = (%v, %v), want only key", records, err)
+	}
+	if _, err := batch.GetMany(ctx, []string{"key", "denied"}); !errors.Is(err, pluginapi.ErrUnauthorized) {
+		t.Errorf("GetMany() with a denied key error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestRPCPlugin_Deadline(t *testing.T) {
+	store := newHelperPlugin(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := store.Get(ctx, "hang"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The plugin is still there for the next call.
+	if err := store.Set(context.Background(), "key", "value"); err != nil {
+		t.Errorf("Set() after a timed out call error = %v", err)
+	}
+}
+
+func TestRPCPlugin_Restart(t *testing.T) {
+	store := newHelperPlugin(t)
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "crash"); err == nil {
+		t.Fatal("Get() should fail when the plugin exits")
+	}
+	if err := store.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() after the plugin exited error = %v", err)
+	}
+	if got, err := store.Get(ctx, "key"); err != nil || got != "value" {
+		t.Errorf("Get() = (%q, %v), want (value, nil) from the restarted plugin", got, err)
+	}
+}
+
+func TestServe_RefusesToRunByHand(t *testing.T) {
+	t.Setenv(pluginapi.RPCPluginEnv, "")
+	if err := pluginapi.Serve(&helperStore{}); err == nil {
+		t.Error("Serve() should refuse to run when not started by stunmesh")
+	}
+}
+
+func TestNewRPCPlugin_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config pluginapi.PluginConfig
+	}{
+		{name: "missing command", config: pluginapi.PluginConfig{}},
+		{name: "not a plugin", config: pluginapi.PluginConfig{"command": "/bin/true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRPCPlugin(context.Background(), tt.config); err == nil {
+				t.Error("NewRPCPlugin() should fail")
+			}
+		})
+	}
+}
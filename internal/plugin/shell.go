@@ -3,6 +3,7 @@ package plugin
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -78,7 +79,67 @@ func (p *ShellPlugin) Set(ctx context.Context, key string, value string) error {
 	return nil
 }
 
+// Delete runs the command with the delete action. Scripts written before
+// it existed fail on the unknown action.
+func (p *ShellPlugin) Delete(ctx context.Context, key string) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("key", key).Msg("delete data from shell plugin")
+
+	_, stderr, err := p.executeCommand(ctx, pluginapi.OpDelete, key, "")
+	if err != nil {
+		if stderr != "" {
+			return fmt.Errorf("%w (stderr: %s)", err, stderr)
+		}
+		return err
+	}
+
+	// Log stderr if present (for debugging)
+	if stderr != "" {
+		logger.Debug().Str("stderr", stderr).Msg("shell plugin stderr")
+	}
+
+	return nil
+}
+
+// List runs the command with the list action, which prints one key per
+// line
+func (p *ShellPlugin) List(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("list keys from shell plugin")
+
+	stdout, stderr, err := p.executeCommand(ctx, pluginapi.OpList, "", "")
+	if err != nil {
+		if stderr != "" {
+			return nil, fmt.Errorf("%w (stderr: %s)", err, stderr)
+		}
+		return nil, err
+	}
+
+	// Log stderr if present (for debugging)
+	if stderr != "" {
+		logger.Debug().Str("stderr", stderr).Msg("shell plugin stderr")
+	}
+
+	var keys []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if key := strings.TrimSpace(line); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
 func (p *ShellPlugin) executeCommand(ctx context.Context, action, key, value string) (string, string, error) {
+	// Scripts may source or eval the input, which the protocol allows
+	// because it only ever carries hex, so anything else is refused here
+	// rather than handed to a shell.
+	if !shellSafe(key) {
+		return "", "", fmt.Errorf("key %q is not safe to pass to a shell plugin", key)
+	}
+	if !shellSafe(value) {
+		return "", "", fmt.Errorf("value for key %s is not safe to pass to a shell plugin", key)
+	}
+
 	cmd := exec.CommandContext(ctx, p.command, p.args...)
 
 	// Prepare stdin with shell variable assignments
@@ -98,8 +159,30 @@ func (p *ShellPlugin) executeCommand(ctx context.Context, action, key, value str
 
 	// Execute command
 	if err := cmd.Run(); err != nil {
+		// An exit code the protocol assigns to a pluginapi error is
+		// reported as that error too.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if sentinel := pluginapi.ExitCodeError(exitErr.ExitCode()); sentinel != nil {
+				return "", strings.TrimSpace(stderrBuf.String()), fmt.Errorf("command execution failed: %w (%w)", err, sentinel)
+			}
+		}
 		return "", strings.TrimSpace(stderrBuf.String()), fmt.Errorf("command execution failed: %w", err)
 	}
 
 	return strings.TrimSpace(stdoutBuf.String()), strings.TrimSpace(stderrBuf.String()), nil
 }
+
+// shellSafe reports whether s can be written as a shell variable assignment
+// that means the same when sourced: no quotes, expansions or line breaks.
+func shellSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
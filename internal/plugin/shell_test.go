@@ -423,3 +423,57 @@ func TestShellPlugin_SetStdinVariables(t *testing.T) {
 		t.Errorf("Set() error = %v, want nil", err)
 	}
 }
+
+func TestShellPlugin_DeleteAndList(t *testing.T) {
+	skipOnWindows(t)
+	pluginPath := getTestPluginPath("shell_test_plugin.sh")
+
+	storageDir := "/tmp/stunmesh-test-shell-plugin"
+	_ = os.RemoveAll(storageDir)
+	defer os.RemoveAll(storageDir)
+
+	plugin, err := NewShellPlugin(pluginapi.PluginConfig{"command": pluginPath})
+	if err != nil {
+		t.Fatalf("NewShellPlugin() error = %v", err)
+	}
+	shellPlugin := plugin.(*ShellPlugin)
+	ctx := context.Background()
+
+	for _, key := range []string{"key_a", "key_b"} {
+		if err := plugin.Set(ctx, key, "value"); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	if err := shellPlugin.Delete(ctx, "key_a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := plugin.Get(ctx, "key_a"); err == nil {
+		t.Error("Get() should return error for a deleted key")
+	}
+
+	keys, err := shellPlugin.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "key_b" {
+		t.Errorf("List() = %v, want [key_b]", keys)
+	}
+}
+
+func TestShellPlugin_RefusesUnsafeInput(t *testing.T) {
+	plugin, err := NewShellPlugin(pluginapi.PluginConfig{"command": "/bin/true"})
+	if err != nil {
+		t.Fatalf("NewShellPlugin() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"key\nSTUNMESH_KEY=other", "$(id)", "a/b", "a b"} {
+		if err := plugin.Set(ctx, key, "value"); err == nil {
+			t.Errorf("Set(%q) should fail", key)
+		}
+	}
+	if err := plugin.Set(ctx, "key", "value'; id; '"); err == nil {
+		t.Error("Set() of a value with quotes should fail")
+	}
+}
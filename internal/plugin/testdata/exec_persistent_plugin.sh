@@ -0,0 +1,60 @@
+#!/bin/sh
+# Test persistent exec plugin: reads one JSON request per line from stdin and
+# answers each with one JSON line carrying the request's id.
+#
+# Usage: exec_persistent_plugin.sh STORAGE_DIR
+#
+# Special keys:
+#   slow   the answer is sent after a delay, from the background, so later
+#          requests are answered first
+#   crash  the process exits without answering
+#   pid    the value is the process ID, to tell restarts apart
+#   stall  the process stops reading requests and never answers
+
+STORAGE_DIR="$1"
+mkdir -p "$STORAGE_DIR"
+
+while read -r INPUT; do
+  ID=$(echo "$INPUT" | grep -o '"id":[0-9]*' | cut -d: -f2)
+  ACTION=$(echo "$INPUT" | grep -o '"action":"[^"]*"' | cut -d'"' -f4)
+  KEY=$(echo "$INPUT" | grep -o '"key":"[^"]*"' | cut -d'"' -f4)
+  VALUE=$(echo "$INPUT" | grep -o '"value":"[^"]*"' | cut -d'"' -f4)
+
+  case "$KEY" in
+    slow)
+      (sleep 0.3; echo "{\"id\":$ID,\"success\":true,\"value\":\"slow\"}") &
+      continue
+      ;;
+    crash)
+      exit 1
+      ;;
+    pid)
+      echo "{\"id\":$ID,\"success\":true,\"value\":\"$$\"}"
+      continue
+      ;;
+    stall)
+      # Without stdin, stdout and stderr, so they close once this shell is
+      # killed.
+      sleep 60 </dev/null >/dev/null 2>&1
+      ;;
+  esac
+
+  case "$ACTION" in
+    get)
+      if [ -f "$STORAGE_DIR/$KEY" ]; then
+        echo "{\"id\":$ID,\"success\":true,\"value\":\"$(cat "$STORAGE_DIR/$KEY")\"}"
+      else
+        echo "{\"id\":$ID,\"success\":false,\"error\":\"key not found\",\"code\":\"not_found\"}"
+      fi
+      ;;
+    set)
+      echo "$VALUE" > "$STORAGE_DIR/$KEY"
+      echo "{\"id\":$ID,\"success\":true}"
+      ;;
+    *)
+      echo "{\"id\":$ID,\"success\":false,\"error\":\"unknown action\"}"
+      ;;
+  esac
+done
+
+wait
@@ -21,13 +21,21 @@ case "$ACTION" in
       STORED_VALUE=$(cat "$STORAGE_FILE")
       echo "{\"success\":true,\"value\":\"$STORED_VALUE\"}"
     else
-      echo "{\"success\":false,\"error\":\"key not found\"}"
+      echo "{\"success\":false,\"error\":\"key not found\",\"code\":\"not_found\"}"
     fi
     ;;
   set)
     echo "$VALUE" > "$STORAGE_FILE"
     echo "{\"success\":true}"
     ;;
+  delete)
+    rm -f "$STORAGE_FILE"
+    echo "{\"success\":true}"
+    ;;
+  list)
+    KEYS=$(ls "$STORAGE_DIR" | sed 's/.*/"&"/' | paste -sd, -)
+    echo "{\"success\":true,\"keys\":[$KEYS]}"
+    ;;
   *)
     echo "{\"success\":false,\"error\":\"unknown action\"}"
     exit 1
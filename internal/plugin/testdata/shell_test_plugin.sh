@@ -16,13 +16,21 @@ case "$STUNMESH_ACTION" in
       exit 0
     else
       echo "key not found" >&2
-      exit 1
+      exit 3
     fi
     ;;
   set)
     echo "$STUNMESH_VALUE" > "$STORAGE_FILE"
     exit 0
     ;;
+  delete)
+    rm -f "$STORAGE_FILE"
+    exit 0
+    ;;
+  list)
+    ls "$STORAGE_DIR"
+    exit 0
+    ;;
   *)
     echo "unknown action: $STUNMESH_ACTION" >&2
     exit 1
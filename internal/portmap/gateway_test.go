@@ -0,0 +1,105 @@
+package portmap
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+// fakeGateway answers NAT-PMP, and PCP when pcp is set, on a loopback UDP
+// port; it grants every request as asked and records what it was sent.
+type fakeGateway struct {
+	conn     *net.UDPConn
+	external netip.Addr
+	pcp      bool
+
+	mu       sync.Mutex
+	requests [][]byte
+	// result, when set, is returned as the result code of every mapping.
+	result uint16
+}
+
+func newFakeGateway(t *testing.T, pcp bool) *fakeGateway {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	g := &fakeGateway{conn: conn, external: netip.MustParseAddr("203.0.113.7"), pcp: pcp}
+	t.Cleanup(func() { _ = conn.Close() })
+	go g.serve()
+	return g
+}
+
+func (g *fakeGateway) addr() netip.AddrPort {
+	return g.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func (g *fakeGateway) sent() [][]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([][]byte(nil), g.requests...)
+}
+
+func (g *fakeGateway) setResult(code uint16) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.result = code
+}
+
+func (g *fakeGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, from, err := g.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		g.mu.Lock()
+		g.requests = append(g.requests, req)
+		result := g.result
+		g.mu.Unlock()
+
+		var resp []byte
+		switch {
+		case req[0] == pcpVersion && g.pcp:
+			resp = g.pcpReply(req, result)
+		case req[0] == pcpVersion:
+			// NAT-PMP only: unsupported version, answered as NAT-PMP.
+			resp = []byte{0, natpmpResponse | req[1], 0, 1, 0, 0, 0, 0}
+		case req[1] == natpmpOpExternalAddress:
+			resp = make([]byte, 12)
+			resp[1] = natpmpResponse
+			copy(resp[8:12], g.external.AsSlice())
+		case req[1] == natpmpOpMapUDP:
+			resp = make([]byte, 16)
+			resp[1] = natpmpResponse | natpmpOpMapUDP
+			binary.BigEndian.PutUint16(resp[2:4], result)
+			copy(resp[8:10], req[4:6])
+			external := req[6:8]
+			if binary.BigEndian.Uint16(external) == 0 {
+				external = req[4:6]
+			}
+			copy(resp[10:12], external)
+			copy(resp[12:16], req[8:12])
+		default:
+			continue
+		}
+		_, _ = g.conn.WriteToUDPAddrPort(resp, from)
+	}
+}
+
+func (g *fakeGateway) pcpReply(req []byte, result uint16) []byte {
+	resp := make([]byte, pcpMapSize)
+	copy(resp, req)
+	resp[1] = pcpResponse | pcpOpMap
+	resp[3] = byte(result)
+	if binary.BigEndian.Uint16(resp[42:44]) == 0 {
+		copy(resp[42:44], req[40:42])
+	}
+	external := g.external.As16()
+	copy(resp[44:60], external[:])
+	return resp
+}
@@ -0,0 +1,155 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	// natpmpPort is where the gateway listens for NAT-PMP and PCP alike.
+	natpmpPort = 5351
+
+	// initialRTO and maxAttempts shorten RFC 6886 section 3.1's
+	// retransmission schedule (250ms, doubling, nine tries) so a gateway
+	// that does not answer falls through to the next protocol in under two
+	// seconds.
+	initialRTO  = 250 * time.Millisecond
+	maxAttempts = 3
+
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpResponse          = 128
+)
+
+var errNoResponse = errors.New("no response from gateway")
+
+// resultError is a non-zero result code from a PCP or NAT-PMP gateway.
+type resultError struct {
+	protocol string
+	code     uint16
+}
+
+func (e resultError) Error() string {
+	return fmt.Sprintf("%s result code %d", e.protocol, e.code)
+}
+
+// exchange sends req to server until a reply accept takes arrives,
+// retransmitting on the schedule above.
+func exchange(ctx context.Context, server netip.AddrPort, req []byte, accept func([]byte) bool) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(server))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1100) // PCP's maximum message size
+	rto := initialRTO
+	for range maxAttempts {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(rto)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				// Typically ICMP port unreachable: nothing listens.
+				return nil, err
+			}
+			if accept(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rto *= 2
+	}
+	return nil, errNoResponse
+}
+
+func lifetimeSeconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// natpmpClient speaks NAT-PMP (RFC 6886).
+type natpmpClient struct {
+	server netip.AddrPort
+}
+
+func (c *natpmpClient) name() string { return "natpmp" }
+
+func (c *natpmpClient) request(ctx context.Context, req []byte, size int) ([]byte, error) {
+	op := req[1]
+	resp, err := exchange(ctx, c.server, req, func(b []byte) bool {
+		return len(b) >= 4 && b[0] == 0 && b[1] == natpmpResponse+op
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return nil, resultError{protocol: "natpmp", code: code}
+	}
+	if len(resp) < size {
+		return nil, fmt.Errorf("natpmp: short response (%d bytes)", len(resp))
+	}
+	return resp, nil
+}
+
+func (c *natpmpClient) externalAddress(ctx context.Context) (netip.Addr, error) {
+	resp, err := c.request(ctx, []byte{0, natpmpOpExternalAddress}, 12)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.AddrFrom4([4]byte(resp[8:12])), nil
+}
+
+func (c *natpmpClient) mapUDP(ctx context.Context, internal, external uint16, lifetime time.Duration) (uint16, time.Duration, error) {
+	req := make([]byte, 12)
+	req[1] = natpmpOpMapUDP
+	binary.BigEndian.PutUint16(req[4:6], internal)
+	binary.BigEndian.PutUint16(req[6:8], external)
+	binary.BigEndian.PutUint32(req[8:12], lifetimeSeconds(lifetime))
+	resp, err := c.request(ctx, req, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return binary.BigEndian.Uint16(resp[10:12]), time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second, nil
+}
+
+func (c *natpmpClient) mapPort(ctx context.Context, internal uint16, previous Mapping, lifetime time.Duration) (Mapping, error) {
+	port, granted, err := c.mapUDP(ctx, internal, previous.External.Port(), lifetime)
+	if err != nil {
+		return Mapping{}, err
+	}
+	ip, err := c.externalAddress(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+	return Mapping{
+		Protocol: c.name(),
+		Internal: internal,
+		External: netip.AddrPortFrom(ip, port),
+		Lifetime: granted,
+	}, nil
+}
+
+// unmap deletes m: a request with lifetime and external port zero.
+func (c *natpmpClient) unmap(ctx context.Context, m Mapping) error {
+	_, _, err := c.mapUDP(ctx, m.Internal, 0, 0)
+	return err
+}
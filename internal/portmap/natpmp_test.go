@@ -0,0 +1,62 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestNATPMP_MapAndUnmap(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, false)
+	c := &natpmpClient{server: g.addr()}
+
+	m, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour)
+	if err != nil {
+		t.Fatalf("mapPort: %v", err)
+	}
+	want := netip.MustParseAddrPort("203.0.113.7:51820")
+	if m.External != want || m.Lifetime != time.Hour || m.Protocol != "natpmp" {
+		t.Fatalf("mapPort = %+v, want %v for an hour over natpmp", m, want)
+	}
+
+	if err := c.unmap(context.Background(), m); err != nil {
+		t.Fatalf("unmap: %v", err)
+	}
+	sent := g.sent()
+	last := sent[len(sent)-1]
+	if last[1] != natpmpOpMapUDP || binary.BigEndian.Uint16(last[4:6]) != 51820 ||
+		binary.BigEndian.Uint16(last[6:8]) != 0 || binary.BigEndian.Uint32(last[8:12]) != 0 {
+		t.Errorf("delete request = %x, want a map of 51820 with external port and lifetime 0", last)
+	}
+}
+
+func TestNATPMP_ResultCode(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, false)
+	g.setResult(2) // not authorized
+	c := &natpmpClient{server: g.addr()}
+
+	_, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour)
+	var rerr resultError
+	if !errors.As(err, &rerr) || rerr.code != 2 {
+		t.Fatalf("mapPort error = %v, want result code 2", err)
+	}
+}
+
+func TestNATPMP_NoGateway(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, false)
+	addr := g.addr()
+	_ = g.conn.Close()
+	c := &natpmpClient{server: addr}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.mapPort(ctx, 51820, Mapping{}, time.Hour); err == nil {
+		t.Fatal("mapPort succeeded with nothing listening")
+	}
+}
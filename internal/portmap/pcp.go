@@ -0,0 +1,111 @@
+package portmap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+const (
+	pcpVersion  = 2
+	pcpOpMap    = 1
+	pcpResponse = 0x80
+	pcpUDP      = 17
+
+	pcpHeaderSize = 24
+	pcpMapSize    = pcpHeaderSize + 36
+
+	// pcpUnsupportedVersion is the result a NAT-PMP-only gateway answers a
+	// PCP request with (RFC 6887 section 9).
+	pcpUnsupportedVersion = 1
+)
+
+// errPCPUnsupported means the gateway only speaks NAT-PMP.
+var errPCPUnsupported = errors.New("pcp: gateway does not support PCP")
+
+// pcpClient speaks PCP's MAP opcode (RFC 6887).
+type pcpClient struct {
+	server netip.AddrPort
+}
+
+func (c *pcpClient) name() string { return "pcp" }
+
+// request sends a MAP request and returns the granted external address and
+// lifetime.
+func (c *pcpClient) request(ctx context.Context, nonce [12]byte, internal uint16, external netip.AddrPort, lifetime time.Duration) (netip.AddrPort, time.Duration, error) {
+	client, err := localAddrFor(c.server)
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+
+	req := make([]byte, pcpMapSize)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:8], lifetimeSeconds(lifetime))
+	// Addresses go in their IPv4-mapped IPv6 form, As16's for an IPv4 one.
+	clientIP := client.As16()
+	copy(req[8:24], clientIP[:])
+	copy(req[24:36], nonce[:])
+	req[36] = pcpUDP
+	binary.BigEndian.PutUint16(req[40:42], internal)
+	binary.BigEndian.PutUint16(req[42:44], external.Port())
+	suggested := [16]byte{10: 0xff, 11: 0xff} // ::ffff:0.0.0.0, any address
+	if external.Addr().Is4() {
+		suggested = external.Addr().As16()
+	}
+	copy(req[44:60], suggested[:])
+
+	resp, err := exchange(ctx, c.server, req, func(b []byte) bool {
+		if len(b) < 4 || b[1] != pcpResponse|pcpOpMap {
+			return false
+		}
+		// A full MAP response has to carry our nonce; a short one is an
+		// error reply, checked below.
+		return len(b) < pcpMapSize || [12]byte(b[24:36]) == nonce
+	})
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	if resp[0] != pcpVersion || resp[3] == pcpUnsupportedVersion {
+		return netip.AddrPort{}, 0, errPCPUnsupported
+	}
+	if resp[3] != 0 {
+		return netip.AddrPort{}, 0, resultError{protocol: "pcp", code: uint16(resp[3])}
+	}
+	if len(resp) < pcpMapSize {
+		return netip.AddrPort{}, 0, fmt.Errorf("pcp: short response (%d bytes)", len(resp))
+	}
+	granted := time.Duration(binary.BigEndian.Uint32(resp[4:8])) * time.Second
+	ip := netip.AddrFrom16([16]byte(resp[44:60])).Unmap()
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(resp[42:44])), granted, nil
+}
+
+func (c *pcpClient) mapPort(ctx context.Context, internal uint16, previous Mapping, lifetime time.Duration) (Mapping, error) {
+	nonce := previous.nonce
+	if previous.Protocol != c.name() {
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return Mapping{}, err
+		}
+	}
+	external, granted, err := c.request(ctx, nonce, internal, previous.External, lifetime)
+	if err != nil {
+		return Mapping{}, err
+	}
+	return Mapping{
+		Protocol: c.name(),
+		Internal: internal,
+		External: external,
+		Lifetime: granted,
+		nonce:    nonce,
+	}, nil
+}
+
+// unmap deletes m: its MAP request again, with lifetime zero.
+func (c *pcpClient) unmap(ctx context.Context, m Mapping) error {
+	_, _, err := c.request(ctx, m.nonce, m.Internal, netip.AddrPort{}, 0)
+	return err
+}
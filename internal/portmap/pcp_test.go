@@ -0,0 +1,70 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPCP_MapRenewAndUnmap(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, true)
+	c := &pcpClient{server: g.addr()}
+
+	m, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour)
+	if err != nil {
+		t.Fatalf("mapPort: %v", err)
+	}
+	want := netip.MustParseAddrPort("203.0.113.7:51820")
+	if m.External != want || m.Lifetime != time.Hour || m.Protocol != "pcp" {
+		t.Fatalf("mapPort = %+v, want %v for an hour over pcp", m, want)
+	}
+
+	renewed, err := c.mapPort(context.Background(), 51820, m, time.Hour)
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if renewed.nonce != m.nonce {
+		t.Error("renewal changed the mapping nonce")
+	}
+	if err := c.unmap(context.Background(), renewed); err != nil {
+		t.Fatalf("unmap: %v", err)
+	}
+
+	sent := g.sent()
+	if len(sent) != 3 {
+		t.Fatalf("gateway got %d requests, want 3", len(sent))
+	}
+	for i, req := range sent {
+		if len(req) != pcpMapSize || req[0] != pcpVersion || req[1] != pcpOpMap || req[36] != pcpUDP {
+			t.Fatalf("request %d = %x, want a PCP UDP MAP", i, req)
+		}
+		if [12]byte(req[24:36]) != m.nonce {
+			t.Errorf("request %d nonce differs from the mapping's", i)
+		}
+		if client := netip.AddrFrom16([16]byte(req[8:24])); client != netip.MustParseAddr("::ffff:127.0.0.1") {
+			t.Errorf("request %d client address = %v, want ::ffff:127.0.0.1", i, client)
+		}
+	}
+	if port := binary.BigEndian.Uint16(sent[1][42:44]); port != 51820 {
+		t.Errorf("renewal suggested external port %d, want 51820", port)
+	}
+	if lifetime := binary.BigEndian.Uint32(sent[2][4:8]); lifetime != 0 {
+		t.Errorf("delete lifetime = %d, want 0", lifetime)
+	}
+}
+
+// A NAT-PMP-only gateway answers PCP with a version-0 error; the client
+// reports it so the manager moves on to NAT-PMP.
+func TestPCP_NATPMPOnlyGateway(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, false)
+	c := &pcpClient{server: g.addr()}
+
+	if _, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour); !errors.Is(err, errPCPUnsupported) {
+		t.Fatalf("mapPort error = %v, want errPCPUnsupported", err)
+	}
+}
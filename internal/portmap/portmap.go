@@ -0,0 +1,272 @@
+// Package portmap asks the local gateway to forward a UDP port: PCP (RFC
+// 6887) first, then NAT-PMP (RFC 6886), then UPnP-IGD. A granted mapping is
+// an external address peers can reach without relying on the NAT's STUN
+// behaviour; Manager keeps one per device, renews it before it expires and
+// deletes it on Close.
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/routeprobe"
+)
+
+const (
+	// DefaultLifetime is the lifetime requested when Options leaves it unset.
+	DefaultLifetime = 2 * time.Hour
+	// retryAfter is how long a device whose gateway granted nothing waits
+	// before Map asks again, so an unsupportive gateway costs one round of
+	// timeouts per retryAfter rather than one per publish.
+	retryAfter = 10 * time.Minute
+	// renewRetry spaces renewal attempts once one has failed.
+	renewRetry = 30 * time.Second
+	// requestTimeout bounds each renewal and each deletion on Close.
+	requestTimeout = 10 * time.Second
+)
+
+// ErrNoMapping is returned when no protocol got a mapping from the gateway.
+var ErrNoMapping = errors.New("portmap: gateway granted no mapping")
+
+// Mapping is a UDP port forwarded by the gateway.
+type Mapping struct {
+	Protocol string // "pcp", "natpmp" or "upnp"
+	Internal uint16
+	External netip.AddrPort
+	// Lifetime is what the gateway granted; 0 means until deleted.
+	Lifetime time.Duration
+
+	// nonce identifies a PCP mapping to the gateway for renewal and deletion.
+	nonce [12]byte
+}
+
+// Options tune one device's mapping.
+type Options struct {
+	// Gateway is the PCP/NAT-PMP server; zero uses the default route's next
+	// hop. UPnP finds its gateway by discovery either way.
+	Gateway netip.Addr
+	// Lifetime is requested from the gateway; 0 means DefaultLifetime.
+	Lifetime time.Duration
+}
+
+// mapper is one port-mapping protocol's client.
+type mapper interface {
+	name() string
+	// mapPort requests internal forwarded, suggesting external (0 for any);
+	// previous is the mapping being renewed, zero for a new one.
+	mapPort(ctx context.Context, internal uint16, previous Mapping, lifetime time.Duration) (Mapping, error)
+	unmap(ctx context.Context, m Mapping) error
+}
+
+type deviceMapping struct {
+	port    uint16
+	opts    Options
+	mapper  mapper
+	mapping Mapping
+	expires time.Time // zero for a mapping without a lifetime
+	timer   *time.Timer
+	// failedUntil holds off Map after every protocol failed.
+	failedUntil time.Time
+}
+
+// Manager holds each device's mapping.
+type Manager struct {
+	logger       zerolog.Logger
+	tunnelIfaces routeprobe.TunnelInterfaces
+	// newMappers returns the clients to try, in order, for a gateway (zero
+	// when unknown); tests substitute fakes.
+	newMappers func(gateway netip.Addr) []mapper
+
+	mu      sync.Mutex
+	devices map[string]*deviceMapping
+	closed  bool
+}
+
+// NewManager returns a Manager that finds the default gateway skipping
+// tunnelIfaces, whose default routes lead into a tunnel rather than to it.
+func NewManager(logger *zerolog.Logger, tunnelIfaces routeprobe.TunnelInterfaces) *Manager {
+	return &Manager{
+		logger:       logger.With().Str("component", "portmap").Logger(),
+		tunnelIfaces: tunnelIfaces,
+		newMappers:   defaultMappers,
+		devices:      make(map[string]*deviceMapping),
+	}
+}
+
+func defaultMappers(gateway netip.Addr) []mapper {
+	var mappers []mapper
+	if gateway.IsValid() {
+		server := netip.AddrPortFrom(gateway, natpmpPort)
+		mappers = append(mappers, &pcpClient{server: server}, &natpmpClient{server: server})
+	}
+	return append(mappers, newUPnPClient())
+}
+
+// Map returns the external address forwarding deviceName's UDP port,
+// requesting a mapping on first use or when port changed, and reusing the
+// current one otherwise.
+func (m *Manager) Map(ctx context.Context, deviceName string, port uint16, opts Options) (netip.AddrPort, error) {
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = DefaultLifetime
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return netip.AddrPort{}, net.ErrClosed
+	}
+
+	logger := m.logger.With().Str("device", deviceName).Uint16("port", port).Logger()
+	now := time.Now()
+	if dm, ok := m.devices[deviceName]; ok {
+		if dm.port == port && dm.opts == opts {
+			if dm.mapper != nil {
+				return dm.mapping.External, nil
+			}
+			if now.Before(dm.failedUntil) {
+				return netip.AddrPort{}, ErrNoMapping
+			}
+		}
+		m.release(ctx, deviceName, dm, logger)
+	}
+
+	gateway := opts.Gateway
+	if !gateway.IsValid() {
+		route, ok, err := routeprobe.DefaultRouteInterface(routeprobe.IPv4, m.tunnelIfaces)
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to read the route table; trying UPnP only")
+		} else if ok {
+			gateway = route.Gateway
+		}
+	}
+
+	dm := &deviceMapping{port: port, opts: opts}
+	m.devices[deviceName] = dm
+	for _, mp := range m.newMappers(gateway) {
+		mapping, err := mp.mapPort(ctx, port, Mapping{}, opts.Lifetime)
+		if err != nil {
+			logger.Debug().Err(err).Str("protocol", mp.name()).Msg("port mapping failed")
+			continue
+		}
+		dm.mapper, dm.mapping = mp, mapping
+		m.scheduleRenewal(deviceName, dm, time.Now())
+		logger.Info().
+			Str("protocol", mp.name()).
+			Str("external", mapping.External.String()).
+			Dur("lifetime", mapping.Lifetime).
+			Msg("port mapped")
+		return mapping.External, nil
+	}
+
+	dm.failedUntil = now.Add(retryAfter)
+	logger.Warn().Str("gateway", gateway.String()).Msg("no port mapping protocol succeeded")
+	return netip.AddrPort{}, ErrNoMapping
+}
+
+// scheduleRenewal arms dm's timer for half its remaining lifetime.
+func (m *Manager) scheduleRenewal(deviceName string, dm *deviceMapping, granted time.Time) {
+	if dm.mapping.Lifetime <= 0 {
+		dm.expires = time.Time{}
+		return
+	}
+	dm.expires = granted.Add(dm.mapping.Lifetime)
+	m.armRenewal(deviceName, dm, dm.mapping.Lifetime/2)
+}
+
+func (m *Manager) armRenewal(deviceName string, dm *deviceMapping, after time.Duration) {
+	dm.timer = time.AfterFunc(after, func() { m.renew(deviceName, dm) })
+}
+
+// renew refreshes dm if it is still deviceName's mapping. A failed renewal
+// retries every renewRetry until the mapping expires, after which the next
+// Map starts over.
+func (m *Manager) renew(deviceName string, dm *deviceMapping) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || m.devices[deviceName] != dm || dm.mapper == nil {
+		return
+	}
+	logger := m.logger.With().Str("device", deviceName).Str("protocol", dm.mapper.name()).Logger()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	mapping, err := dm.mapper.mapPort(ctx, dm.port, dm.mapping, dm.opts.Lifetime)
+	now := time.Now()
+	if err != nil {
+		if left := dm.expires.Sub(now); left > 0 {
+			logger.Warn().Err(err).Dur("expires_in", left).Msg("port mapping renewal failed; retrying")
+			m.armRenewal(deviceName, dm, min(renewRetry, left))
+			return
+		}
+		logger.Error().Err(err).Msg("port mapping expired")
+		delete(m.devices, deviceName)
+		return
+	}
+	if mapping.External != dm.mapping.External {
+		logger.Info().Str("external", mapping.External.String()).Msg("port mapping moved")
+	}
+	dm.mapping = mapping
+	m.scheduleRenewal(deviceName, dm, now)
+}
+
+// release stops dm's renewal and deletes its mapping; called with m.mu held.
+func (m *Manager) release(ctx context.Context, deviceName string, dm *deviceMapping, logger zerolog.Logger) error {
+	if dm.timer != nil {
+		dm.timer.Stop()
+	}
+	delete(m.devices, deviceName)
+	if dm.mapper == nil {
+		return nil
+	}
+	if err := dm.mapper.unmap(ctx, dm.mapping); err != nil {
+		logger.Warn().Err(err).Str("protocol", dm.mapper.name()).Msg("failed to delete port mapping")
+		return fmt.Errorf("portmap: delete %s mapping for %s: %w", dm.mapper.name(), deviceName, err)
+	}
+	return nil
+}
+
+// Unmap deletes deviceName's mapping, if it has one.
+func (m *Manager) Unmap(ctx context.Context, deviceName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dm, ok := m.devices[deviceName]
+	if !ok {
+		return nil
+	}
+	return m.release(ctx, deviceName, dm, m.logger.With().Str("device", deviceName).Logger())
+}
+
+// Close deletes every mapping; Map fails afterwards.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var errs []error
+	for deviceName, dm := range m.devices {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		errs = append(errs, m.release(ctx, deviceName, dm, m.logger.With().Str("device", deviceName).Logger()))
+		cancel()
+	}
+	return errors.Join(errs...)
+}
+
+// localAddrFor returns the local address the host uses to reach server,
+// which PCP and UPnP put in their requests as the mapping's internal host.
+func localAddrFor(server netip.AddrPort) (netip.Addr, error) {
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(server))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
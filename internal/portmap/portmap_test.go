@@ -0,0 +1,208 @@
+package portmap
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeMapper grants or refuses mappings without a network.
+type fakeMapper struct {
+	protocol string
+	external netip.Addr
+	lifetime time.Duration
+
+	mu       sync.Mutex
+	fail     bool
+	mapped   []Mapping // the previous mapping passed to each mapPort
+	unmapped []Mapping
+}
+
+func (f *fakeMapper) name() string { return f.protocol }
+
+func (f *fakeMapper) mapPort(ctx context.Context, internal uint16, previous Mapping, lifetime time.Duration) (Mapping, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mapped = append(f.mapped, previous)
+	if f.fail {
+		return Mapping{}, errors.New("refused")
+	}
+	return Mapping{
+		Protocol: f.protocol,
+		Internal: internal,
+		External: netip.AddrPortFrom(f.external, internal+1000),
+		Lifetime: f.lifetime,
+	}, nil
+}
+
+func (f *fakeMapper) unmap(ctx context.Context, m Mapping) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unmapped = append(f.unmapped, m)
+	return nil
+}
+
+func (f *fakeMapper) calls() (mapped, unmapped int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.mapped), len(f.unmapped)
+}
+
+var testOptions = Options{Gateway: netip.MustParseAddr("192.0.2.1")}
+
+func newTestManager(t *testing.T, mappers ...*fakeMapper) *Manager {
+	t.Helper()
+	logger := zerolog.Nop()
+	m := NewManager(&logger, nil)
+	m.newMappers = func(gateway netip.Addr) []mapper {
+		if gateway != testOptions.Gateway {
+			t.Errorf("newMappers(%v), want the configured gateway %v", gateway, testOptions.Gateway)
+		}
+		out := make([]mapper, len(mappers))
+		for i, mp := range mappers {
+			out[i] = mp
+		}
+		return out
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+func TestManager_FallsThroughProtocols(t *testing.T) {
+	t.Parallel()
+	pcp := &fakeMapper{protocol: "pcp", fail: true}
+	natpmp := &fakeMapper{protocol: "natpmp", external: netip.MustParseAddr("203.0.113.7"), lifetime: time.Hour}
+	m := newTestManager(t, pcp, natpmp)
+
+	got, err := m.Map(context.Background(), "wg0", 51820, testOptions)
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	if want := netip.MustParseAddrPort("203.0.113.7:52820"); got != want {
+		t.Fatalf("Map = %v, want %v", got, want)
+	}
+
+	// A second call reuses the mapping.
+	if _, err := m.Map(context.Background(), "wg0", 51820, testOptions); err != nil {
+		t.Fatalf("Map again: %v", err)
+	}
+	if mapped, _ := natpmp.calls(); mapped != 1 {
+		t.Errorf("natpmp mapped %d times, want 1", mapped)
+	}
+}
+
+func TestManager_FailureIsRemembered(t *testing.T) {
+	t.Parallel()
+	pcp := &fakeMapper{protocol: "pcp", fail: true}
+	m := newTestManager(t, pcp)
+
+	for range 2 {
+		if _, err := m.Map(context.Background(), "wg0", 51820, testOptions); !errors.Is(err, ErrNoMapping) {
+			t.Fatalf("Map error = %v, want ErrNoMapping", err)
+		}
+	}
+	if mapped, _ := pcp.calls(); mapped != 1 {
+		t.Errorf("pcp asked %d times, want 1 until retryAfter passes", mapped)
+	}
+}
+
+func TestManager_RenewsBeforeExpiry(t *testing.T) {
+	t.Parallel()
+	natpmp := &fakeMapper{protocol: "natpmp", external: netip.MustParseAddr("203.0.113.7"), lifetime: 100 * time.Millisecond}
+	m := newTestManager(t, natpmp)
+
+	if _, err := m.Map(context.Background(), "wg0", 51820, testOptions); err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if mapped, _ := natpmp.calls(); mapped >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mapping was not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	natpmp.mu.Lock()
+	renewal := natpmp.mapped[1]
+	natpmp.mu.Unlock()
+	if renewal.External != netip.MustParseAddrPort("203.0.113.7:52820") {
+		t.Errorf("renewal passed previous mapping %+v, want the granted one", renewal)
+	}
+}
+
+func TestManager_PortChangeRemaps(t *testing.T) {
+	t.Parallel()
+	natpmp := &fakeMapper{protocol: "natpmp", external: netip.MustParseAddr("203.0.113.7"), lifetime: time.Hour}
+	m := newTestManager(t, natpmp)
+
+	if _, err := m.Map(context.Background(), "wg0", 51820, testOptions); err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	got, err := m.Map(context.Background(), "wg0", 40000, testOptions)
+	if err != nil {
+		t.Fatalf("Map new port: %v", err)
+	}
+	if got.Port() != 41000 {
+		t.Errorf("Map = %v, want the new port's mapping", got)
+	}
+	if mapped, unmapped := natpmp.calls(); mapped != 2 || unmapped != 1 {
+		t.Errorf("natpmp mapped %d and unmapped %d times, want 2 and 1", mapped, unmapped)
+	}
+}
+
+func TestManager_CloseDeletesMappings(t *testing.T) {
+	t.Parallel()
+	natpmp := &fakeMapper{protocol: "natpmp", external: netip.MustParseAddr("203.0.113.7"), lifetime: time.Hour}
+	m := newTestManager(t, natpmp)
+
+	for _, dev := range []string{"wg0", "wg1"} {
+		if _, err := m.Map(context.Background(), dev, 51820, testOptions); err != nil {
+			t.Fatalf("Map(%s): %v", dev, err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, unmapped := natpmp.calls(); unmapped != 2 {
+		t.Errorf("Close deleted %d mappings, want 2", unmapped)
+	}
+	if _, err := m.Map(context.Background(), "wg0", 51820, testOptions); err == nil {
+		t.Error("Map after Close succeeded")
+	}
+}
+
+// The real clients against the fake gateways: PCP is tried first and a
+// NAT-PMP-only gateway still gets a mapping.
+func TestManager_DefaultMappersFallBackToNATPMP(t *testing.T) {
+	t.Parallel()
+	g := newFakeGateway(t, false)
+	logger := zerolog.Nop()
+	m := NewManager(&logger, nil)
+	m.newMappers = func(netip.Addr) []mapper {
+		return []mapper{&pcpClient{server: g.addr()}, &natpmpClient{server: g.addr()}}
+	}
+	defer m.Close()
+
+	got, err := m.Map(context.Background(), "wg0", 51820, Options{Gateway: g.addr().Addr()})
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	if want := netip.MustParseAddrPort("203.0.113.7:51820"); got != want {
+		t.Fatalf("Map = %v, want %v", got, want)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	sent := g.sent()
+	if last := sent[len(sent)-1]; last[0] != 0 || last[1] != natpmpOpMapUDP {
+		t.Errorf("last request = %x, want a NAT-PMP delete", last)
+	}
+}
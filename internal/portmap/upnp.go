@@ -0,0 +1,369 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ssdpWait bounds how long discovery waits for gateways to answer.
+	ssdpWait = 2 * time.Second
+	// upnpMaxBody caps the description and SOAP responses read.
+	upnpMaxBody = 1 << 20
+
+	// UPnP-IGD error codes AddPortMapping retries around.
+	upnpConflict           = 718 // ConflictInMappingEntry
+	upnpOnlyPermanentLease = 725 // OnlyPermanentLeasesSupported
+
+	upnpDescription = "stunmesh"
+)
+
+// ssdpMulticast is where M-SEARCH goes; tests point clients elsewhere.
+var ssdpMulticast = netip.MustParseAddrPort("239.255.255.250:1900")
+
+// upnpServiceTypes are the WAN connection services that can map ports, in
+// order of preference.
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpError is a SOAP fault from the gateway.
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e upnpError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.code, e.description)
+}
+
+// upnpService is a discovered WAN connection service.
+type upnpService struct {
+	serviceType string
+	controlURL  string
+	// local is this host's address toward the gateway, the mapping's
+	// internal client.
+	local netip.Addr
+}
+
+// upnpClient speaks UPnP-IGD's WANIPConnection/WANPPPConnection.
+type upnpClient struct {
+	ssdp netip.AddrPort
+	http *http.Client
+	// service is cached from discovery until a request to it fails.
+	service *upnpService
+}
+
+func newUPnPClient() *upnpClient {
+	return &upnpClient{ssdp: ssdpMulticast, http: &http.Client{Timeout: requestTimeout}}
+}
+
+func (c *upnpClient) name() string { return "upnp" }
+
+// discover finds the gateway's WAN connection service via SSDP.
+func (c *upnpClient) discover(ctx context.Context) (*upnpService, error) {
+	if c.service != nil {
+		return c.service, nil
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := net.UDPAddrFromAddrPort(c.ssdp)
+	for _, st := range upnpServiceTypes {
+		msg := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: 239.255.255.250:1900\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + st + "\r\n\r\n"
+		if _, err := conn.WriteToUDP([]byte(msg), dst); err != nil {
+			return nil, fmt.Errorf("upnp: send M-SEARCH: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(ssdpWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	var lastErr error = errors.New("upnp: no gateway answered discovery")
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, lastErr
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if location == "" || seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		service, err := c.describe(ctx, location)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.service = service
+		return service, nil
+	}
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// find returns the control URL of the first service of type st anywhere in
+// d's device tree.
+func (d *upnpDevice) find(st string) (string, bool) {
+	for _, s := range d.Services {
+		if strings.TrimSpace(s.ServiceType) == st {
+			return strings.TrimSpace(s.ControlURL), true
+		}
+	}
+	for i := range d.Devices {
+		if u, ok := d.Devices[i].find(st); ok {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+// describe fetches a gateway's device description from location and picks
+// its preferred WAN connection service.
+func (c *upnpClient) describe(ctx context.Context, location string) (*upnpService, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("upnp: location %q: %w", location, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upnp: fetch description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp: fetch description: %s", resp.Status)
+	}
+	var root upnpRoot
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, upnpMaxBody)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("upnp: parse description: %w", err)
+	}
+	if root.URLBase != "" {
+		if u, err := url.Parse(strings.TrimSpace(root.URLBase)); err == nil {
+			base = u
+		}
+	}
+
+	for _, st := range upnpServiceTypes {
+		control, ok := root.Device.find(st)
+		if !ok {
+			continue
+		}
+		u, err := base.Parse(control)
+		if err != nil {
+			return nil, fmt.Errorf("upnp: control URL %q: %w", control, err)
+		}
+		gateway, err := netip.ParseAddr(u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("upnp: control URL %q has no IP host", u)
+		}
+		local, err := localAddrFor(netip.AddrPortFrom(gateway, natpmpPort))
+		if err != nil {
+			return nil, err
+		}
+		return &upnpService{serviceType: st, controlURL: u.String(), local: local}, nil
+	}
+	return nil, errors.New("upnp: gateway has no WAN connection service")
+}
+
+type upnpArg struct {
+	name, value string
+}
+
+// call invokes a SOAP action and returns the response's leaf elements by
+// name.
+func (c *upnpClient) call(ctx context.Context, service *upnpService, action string, args ...upnpArg) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, service.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg.name)
+		_ = xml.EscapeText(&body, []byte(arg.value))
+		fmt.Fprintf(&body, "</%s>", arg.name)
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, service.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service.serviceType+"#"+action+`"`)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.service = nil
+		return nil, fmt.Errorf("upnp: %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	values, err := soapValues(io.LimitReader(resp.Body, upnpMaxBody))
+	if resp.StatusCode != http.StatusOK {
+		if code, cerr := strconv.Atoi(values["errorCode"]); cerr == nil {
+			return nil, upnpError{code: code, description: values["errorDescription"]}
+		}
+		return nil, fmt.Errorf("upnp: %s: %s", action, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upnp: %s: %w", action, err)
+	}
+	return values, nil
+}
+
+// soapValues collects the text of every leaf element in a SOAP envelope,
+// keyed by local name; the actions used here return flat argument lists.
+func soapValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	dec := xml.NewDecoder(r)
+	var (
+		name string
+		text strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}
+
+func (c *upnpClient) addPortMapping(ctx context.Context, service *upnpService, internal, external uint16, lease uint32) error {
+	_, err := c.call(ctx, service, "AddPortMapping",
+		upnpArg{"NewRemoteHost", ""},
+		upnpArg{"NewExternalPort", strconv.Itoa(int(external))},
+		upnpArg{"NewProtocol", "UDP"},
+		upnpArg{"NewInternalPort", strconv.Itoa(int(internal))},
+		upnpArg{"NewInternalClient", service.local.String()},
+		upnpArg{"NewEnabled", "1"},
+		upnpArg{"NewPortMappingDescription", upnpDescription},
+		upnpArg{"NewLeaseDuration", strconv.FormatUint(uint64(lease), 10)},
+	)
+	return err
+}
+
+func (c *upnpClient) mapPort(ctx context.Context, internal uint16, previous Mapping, lifetime time.Duration) (Mapping, error) {
+	service, err := c.discover(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	external := previous.External.Port()
+	if external == 0 {
+		external = internal
+	}
+	lease := lifetimeSeconds(lifetime)
+	if previous.Protocol == c.name() && previous.Lifetime == 0 {
+		lease = 0
+	}
+	for attempt := 0; ; attempt++ {
+		err = c.addPortMapping(ctx, service, internal, external, lease)
+		var uerr upnpError
+		if err == nil || !errors.As(err, &uerr) || attempt == 2 {
+			break
+		}
+		switch {
+		case uerr.code == upnpOnlyPermanentLease && lease != 0:
+			lease = 0
+		case uerr.code == upnpConflict && previous.External.Port() == 0:
+			// Someone else holds the port; any other will do.
+			external = uint16(1024 + rand.IntN(65535-1024))
+		default:
+			return Mapping{}, err
+		}
+	}
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	values, err := c.call(ctx, service, "GetExternalIPAddress")
+	if err != nil {
+		return Mapping{}, err
+	}
+	ip, err := netip.ParseAddr(values["NewExternalIPAddress"])
+	if err != nil {
+		return Mapping{}, fmt.Errorf("upnp: external address %q: %w", values["NewExternalIPAddress"], err)
+	}
+	return Mapping{
+		Protocol: c.name(),
+		Internal: internal,
+		External: netip.AddrPortFrom(ip, external),
+		Lifetime: time.Duration(lease) * time.Second,
+	}, nil
+}
+
+func (c *upnpClient) unmap(ctx context.Context, m Mapping) error {
+	service, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.call(ctx, service, "DeletePortMapping",
+		upnpArg{"NewRemoteHost", ""},
+		upnpArg{"NewExternalPort", strconv.Itoa(int(m.External.Port()))},
+		upnpArg{"NewProtocol", "UDP"},
+	)
+	return err
+}
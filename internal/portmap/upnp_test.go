@@ -0,0 +1,216 @@
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// fakeIGD is a UPnP internet gateway: an SSDP responder on a loopback UDP
+// port and its description and control endpoints over HTTP.
+type fakeIGD struct {
+	ssdp *net.UDPConn
+	http *httptest.Server
+
+	// permanentOnly rejects leases other than 0, as some gateways do.
+	permanentOnly bool
+
+	mu      sync.Mutex
+	actions []string
+	bodies  []string
+}
+
+func newFakeIGD(t *testing.T, permanentOnly bool) *fakeIGD {
+	t.Helper()
+	g := &fakeIGD{permanentOnly: permanentOnly}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, fakeIGDDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", g.control)
+	g.http = httptest.NewServer(mux)
+	t.Cleanup(g.http.Close)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	g.ssdp = conn
+	t.Cleanup(func() { _ = conn.Close() })
+	go g.serveSSDP()
+	return g
+}
+
+func (g *fakeIGD) client() *upnpClient {
+	c := newUPnPClient()
+	c.ssdp = g.ssdp.LocalAddr().(*net.UDPAddr).AddrPort()
+	return c
+}
+
+func (g *fakeIGD) calls() ([]string, []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.actions...), append([]string(nil), g.bodies...)
+}
+
+func (g *fakeIGD) serveSSDP() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := g.ssdp.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+			continue
+		}
+		resp := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=120\r\n" +
+			"ST: urn:schemas-upnp-org:service:WANIPConnection:1\r\n" +
+			"LOCATION: " + g.http.URL + "/rootDesc.xml\r\n\r\n"
+		_, _ = g.ssdp.WriteToUDPAddrPort([]byte(resp), from)
+	}
+}
+
+func (g *fakeIGD) control(w http.ResponseWriter, r *http.Request) {
+	action := r.Header.Get("SOAPAction")
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	g.actions = append(g.actions, action)
+	g.bodies = append(g.bodies, string(body))
+	g.mu.Unlock()
+
+	const service = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	switch action {
+	case `"` + service + `#AddPortMapping"`:
+		if g.permanentOnly && !strings.Contains(string(body), "<NewLeaseDuration>0</NewLeaseDuration>") {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:AddPortMappingResponse xmlns:u="%s"/></s:Body></s:Envelope>`, service)
+	case `"` + service + `#GetExternalIPAddress"`:
+		fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:GetExternalIPAddressResponse xmlns:u="%s"><NewExternalIPAddress>198.51.100.20</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`, service)
+	case `"` + service + `#DeletePortMapping"`:
+		fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:DeletePortMappingResponse xmlns:u="%s"/></s:Body></s:Envelope>`, service)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestUPnP_MapAndUnmap(t *testing.T) {
+	t.Parallel()
+	g := newFakeIGD(t, false)
+	c := g.client()
+
+	m, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour)
+	if err != nil {
+		t.Fatalf("mapPort: %v", err)
+	}
+	want := netip.MustParseAddrPort("198.51.100.20:51820")
+	if m.External != want || m.Lifetime != time.Hour || m.Protocol != "upnp" {
+		t.Fatalf("mapPort = %+v, want %v for an hour over upnp", m, want)
+	}
+	if err := c.unmap(context.Background(), m); err != nil {
+		t.Fatalf("unmap: %v", err)
+	}
+
+	actions, bodies := g.calls()
+	wantActions := []string{"AddPortMapping", "GetExternalIPAddress", "DeletePortMapping"}
+	if len(actions) != len(wantActions) {
+		t.Fatalf("actions = %v, want %v", actions, wantActions)
+	}
+	for i, a := range wantActions {
+		if !strings.HasSuffix(actions[i], "#"+a+`"`) {
+			t.Errorf("action %d = %s, want %s", i, actions[i], a)
+		}
+	}
+	for _, arg := range []string{
+		"<NewExternalPort>51820</NewExternalPort>",
+		"<NewProtocol>UDP</NewProtocol>",
+		"<NewInternalPort>51820</NewInternalPort>",
+		"<NewInternalClient>127.0.0.1</NewInternalClient>",
+		"<NewLeaseDuration>3600</NewLeaseDuration>",
+	} {
+		if !strings.Contains(bodies[0], arg) {
+			t.Errorf("AddPortMapping body lacks %s:\n%s", arg, bodies[0])
+		}
+	}
+	if !strings.Contains(bodies[2], "<NewExternalPort>51820</NewExternalPort>") {
+		t.Errorf("DeletePortMapping body names the wrong port:\n%s", bodies[2])
+	}
+}
+
+// A gateway that only supports permanent leases gets one, and the mapping
+// is reported without a lifetime so it is not renewed.
+func TestUPnP_PermanentLeaseFallback(t *testing.T) {
+	t.Parallel()
+	g := newFakeIGD(t, true)
+	c := g.client()
+
+	m, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour)
+	if err != nil {
+		t.Fatalf("mapPort: %v", err)
+	}
+	if m.Lifetime != 0 {
+		t.Errorf("Lifetime = %v, want 0 for a permanent lease", m.Lifetime)
+	}
+	actions, _ := g.calls()
+	if len(actions) != 3 {
+		t.Errorf("actions = %v, want a rejected and a permanent AddPortMapping, then GetExternalIPAddress", actions)
+	}
+}
+
+func TestUPnP_NoGateway(t *testing.T) {
+	t.Parallel()
+	g := newFakeIGD(t, false)
+	c := g.client()
+	_ = g.ssdp.Close()
+
+	var uerr upnpError
+	if _, err := c.mapPort(context.Background(), 51820, Mapping{}, time.Hour); err == nil || errors.As(err, &uerr) {
+		t.Fatalf("mapPort error = %v, want a discovery failure", err)
+	}
+}
+
+func TestSOAPValues(t *testing.T) {
+	values, err := soapValues(strings.NewReader(`<s:Envelope xmlns:s="x"><s:Body><u:R xmlns:u="y"><A> 1 </A><B>two</B></u:R></s:Body></s:Envelope>`))
+	if err != nil {
+		t.Fatalf("soapValues: %v", err)
+	}
+	if values["A"] != "1" || values["B"] != "two" {
+		t.Errorf("soapValues = %v, want A=1 B=two", values)
+	}
+}
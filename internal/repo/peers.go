@@ -69,6 +69,13 @@ func (r *Peers) Save(ctx context.Context, peer *entity.Peer) {
 	r.entities[peer.Id()] = peer
 }
 
+func (r *Peers) Delete(ctx context.Context, id entity.PeerId) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.entities, id)
+}
+
 func (r *Peers) GetDevicePeerMap(ctx context.Context, deviceName string) (map[entity.PeerKey]bool, error) {
 	device, err := r.wgCtrl.Device(deviceName)
 	if err != nil {
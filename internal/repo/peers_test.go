@@ -61,6 +61,32 @@ func Test_PeerRepository_Find(t *testing.T) {
 	}
 }
 
+func Test_PeerRepository_Delete(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockWgClient := mock.NewMockWireGuardClient(mockCtrl)
+
+	kept := entity.NewPeerId([]byte{}, []byte{1})
+	removed := entity.NewPeerId([]byte{}, []byte{2})
+
+	peers := repo.NewPeers(mockWgClient)
+	for _, id := range []entity.PeerId{kept, removed} {
+		peers.Save(context.TODO(), entity.NewPeer(id, "wg0", [32]byte{}, "cloudflare", "ipv4", entity.PeerPingConfig{}))
+	}
+
+	peers.Delete(context.TODO(), removed)
+	// Deleting an unknown peer is a no-op.
+	peers.Delete(context.TODO(), entity.NewPeerId([]byte{}, []byte{3}))
+
+	if _, err := peers.Find(context.TODO(), removed); err != entity.ErrPeerNotFound {
+		t.Errorf("PeerRepository.Find() after Delete error = %v, want %v", err, entity.ErrPeerNotFound)
+	}
+	if _, err := peers.Find(context.TODO(), kept); err != nil {
+		t.Errorf("PeerRepository.Find() error = %v, want the other peer kept", err)
+	}
+}
+
 func Test_PeerRepository_List(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
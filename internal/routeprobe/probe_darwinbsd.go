@@ -46,6 +46,7 @@ func routesFromMessages(msgs []route.Message, family Family) []Route {
 		if !ok {
 			continue
 		}
+		r.Gateway = routeMessageGateway(rm)
 
 		routes = append(routes, r)
 	}
@@ -92,3 +93,18 @@ func routeMessagePrefix(rm *route.RouteMessage, family Family) (netip.Prefix, bo
 
 	return netip.PrefixFrom(addr, bits), true
 }
+
+// routeMessageGateway returns a RouteMessage's RTAX_GATEWAY address when it
+// is an IP next hop; on-link routes carry a link-layer address there instead.
+func routeMessageGateway(rm *route.RouteMessage) netip.Addr {
+	if len(rm.Addrs) <= syscall.RTAX_GATEWAY {
+		return netip.Addr{}
+	}
+	switch a := rm.Addrs[syscall.RTAX_GATEWAY].(type) {
+	case *route.Inet4Addr:
+		return netip.AddrFrom4(a.IP)
+	case *route.Inet6Addr:
+		return netip.AddrFrom16(a.IP)
+	}
+	return netip.Addr{}
+}
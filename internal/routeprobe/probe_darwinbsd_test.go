@@ -195,6 +195,7 @@ func TestRoutesFromMessages(t *testing.T) {
 			Index: iface.Index,
 			Addrs: []route.Addr{
 				syscall.RTAX_DST:     &route.Inet4Addr{IP: [4]byte{10, 0, 0, 0}},
+				syscall.RTAX_GATEWAY: &route.Inet4Addr{IP: [4]byte{192, 168, 1, 1}},
 				syscall.RTAX_NETMASK: &route.Inet4Addr{IP: [4]byte{255, 0, 0, 0}},
 			},
 		},
@@ -203,7 +204,7 @@ func TestRoutesFromMessages(t *testing.T) {
 	got := routesFromMessages(msgs, IPv4)
 
 	want := []Route{
-		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Interface: iface.Name, Index: iface.Index},
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Interface: iface.Name, Index: iface.Index, Gateway: netip.MustParseAddr("192.168.1.1")},
 	}
 
 	if len(got) != len(want) {
@@ -48,7 +48,7 @@ func currentRoutes(family Family) ([]Route, error) {
 //	Iface   Destination     Gateway         Flags   RefCnt  Use     Metric  Mask            MTU     Window  IRTT
 //	wlan0   00000000        0101A8C0        0003    0       0       600     00000000        0       0       0
 //
-// Destination/Mask are 8 hex chars holding the address in little-endian
+// Destination/Gateway/Mask are 8 hex chars holding the address in little-endian
 // (i.e. byte-reversed relative to dotted-quad network order).
 func parseProcNetRouteV4(data []byte) ([]Route, error) {
 	var routes []Route
@@ -72,6 +72,13 @@ func parseProcNetRouteV4(data []byte) ([]Route, error) {
 		if err != nil {
 			return nil, fmt.Errorf("routeprobe: parse destination %q: %w", fields[1], err)
 		}
+		gateway, err := decodeLEHexIPv4(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("routeprobe: parse gateway %q: %w", fields[2], err)
+		}
+		if gateway.IsUnspecified() {
+			gateway = netip.Addr{}
+		}
 		maskAddr, err := decodeLEHexIPv4(fields[7])
 		if err != nil {
 			return nil, fmt.Errorf("routeprobe: parse mask %q: %w", fields[7], err)
@@ -79,7 +86,7 @@ func parseProcNetRouteV4(data []byte) ([]Route, error) {
 
 		ones := ipv4MaskOnes(maskAddr)
 		prefix := netip.PrefixFrom(destAddr, ones)
-		routes = append(routes, Route{Prefix: prefix, Interface: iface})
+		routes = append(routes, Route{Prefix: prefix, Interface: iface, Gateway: gateway})
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("routeprobe: scan %s: %w", procNetRoute, err)
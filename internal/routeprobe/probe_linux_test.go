@@ -22,7 +22,7 @@ func TestParseProcNetRouteV4(t *testing.T) {
 	}
 
 	want := []Route{
-		{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Interface: "wlan0"},
+		{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Interface: "wlan0", Gateway: netip.MustParseAddr("192.168.1.1")},
 		{Prefix: netip.MustParsePrefix("169.254.0.0/16"), Interface: "eth0"},
 		{Prefix: netip.MustParsePrefix("0.0.0.0/1"), Interface: "wg0"},
 		{Prefix: netip.MustParsePrefix("128.0.0.0/1"), Interface: "wg0"},
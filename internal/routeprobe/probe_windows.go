@@ -50,6 +50,7 @@ func routesFromRows(rows []windows.MibIpForwardRow2, family Family) []Route {
 		if !ok {
 			continue
 		}
+		r.Gateway = nextHop(row.NextHop)
 
 		routes = append(routes, r)
 	}
@@ -78,3 +79,19 @@ func forwardPrefix(p windows.IpAddressPrefix, family Family) (netip.Prefix, bool
 		return netip.Prefix{}, false
 	}
 }
+
+// nextHop converts a row's NextHop SOCKADDR_INET into an address, zero for
+// an on-link route (unspecified next hop).
+func nextHop(sa windows.RawSockaddrInet) netip.Addr {
+	var addr netip.Addr
+	switch sa.Family {
+	case windows.AF_INET:
+		addr = netip.AddrFrom4((*windows.RawSockaddrInet4)(unsafe.Pointer(&sa)).Addr)
+	case windows.AF_INET6:
+		addr = netip.AddrFrom16((*windows.RawSockaddrInet6)(unsafe.Pointer(&sa)).Addr)
+	}
+	if addr.IsUnspecified() {
+		return netip.Addr{}
+	}
+	return addr
+}
@@ -145,13 +145,14 @@ func TestRoutesFromRows(t *testing.T) {
 		{
 			InterfaceIndex:    uint32(iface.Index),
 			DestinationPrefix: prefix4(v4.As4(), 8),
+			NextHop:           prefix4([4]byte{192, 168, 1, 1}, 0).Prefix,
 		},
 	}
 
 	got := routesFromRows(rows, IPv4)
 
 	want := []Route{
-		{Prefix: netip.PrefixFrom(v4, 8), Interface: iface.Name, Index: iface.Index},
+		{Prefix: netip.PrefixFrom(v4, 8), Interface: iface.Name, Index: iface.Index, Gateway: netip.MustParseAddr("192.168.1.1")},
 	}
 
 	if len(got) != len(want) {
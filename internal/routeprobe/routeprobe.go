@@ -30,6 +30,9 @@ type Route struct {
 	// specific interface for binding (darwin/freebsd via DefaultRouteInterface).
 	// Left zero where unused (Linux escape uses SO_MARK, not an interface index).
 	Index int
+	// Gateway is the route's next hop; zero for on-link routes and where the
+	// platform table does not name one.
+	Gateway netip.Addr
 }
 
 var (
@@ -40,22 +40,25 @@ func NewProxyBacked(transport StunTransport, protocol string, logger *zerolog.Lo
 	}
 }
 
-// TransportLookup resolves a device name to its STUN transport. It must never
-// create one — proxies are bound by the wg decorator before any Resolve.
-type TransportLookup func(deviceName string) (StunTransport, error)
+// TransportLookup resolves a device name and local port to its STUN
+// transport: the outer socket bound on port, or the device's default one. It
+// must never create one — proxies are bound by the wg decorator before any
+// Resolve.
+type TransportLookup func(deviceName string, port uint16) (StunTransport, error)
 
 // NewProxyLookupFactory builds a ClientFactory resolving the transport per
-// device; socket-owning arguments are ignored, warned once per process.
+// device and port; socket-owning arguments are ignored, warned once per
+// process.
 func NewProxyLookupFactory(lookup TransportLookup, logger *zerolog.Logger) ClientFactory {
 	var warnIgnored sync.Once
 	return func(_ context.Context, deviceName string, port uint16, protocol string, firewallMark int, listenInterfaces []string, listenDefaultRoute bool) (StunClient, error) {
-		transport, err := lookup(deviceName)
+		transport, err := lookup(deviceName, port)
 		if err != nil {
 			return nil, err
 		}
-		if port != 0 || firewallMark != 0 || len(listenInterfaces) > 0 || listenDefaultRoute {
+		if firewallMark != 0 || len(listenInterfaces) > 0 || listenDefaultRoute {
 			warnIgnored.Do(func() {
-				logger.Warn().Msg("port/fwmark/listen_interfaces/listen_default_route are ignored by the proxy-backed STUN client (it borrows the proxy's outer socket)")
+				logger.Warn().Msg("fwmark/listen_interfaces/listen_default_route are ignored by the proxy-backed STUN client (it borrows the proxy's outer socket)")
 			})
 		}
 		return NewProxyBacked(transport, protocol, logger), nil
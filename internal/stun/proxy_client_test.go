@@ -16,8 +16,12 @@ import (
 	"golang.org/x/net/dns/dnsmessage"
 )
 
-// Compile-time guard: *wgproxy.Proxy satisfies StunTransport structurally.
-var _ StunTransport = (*wgproxy.Proxy)(nil)
+// Compile-time guard: *wgproxy.Proxy and its per-port transports satisfy
+// StunTransport structurally.
+var (
+	_ StunTransport = (*wgproxy.Proxy)(nil)
+	_ StunTransport = (*wgproxy.PortTransport)(nil)
+)
 
 // fakeTransport records Exchange calls and replies from a scripted function.
 type fakeTransport struct {
@@ -195,7 +199,7 @@ func TestProxyLookupFactory_ResolvesTransportPerDevice(t *testing.T) {
 		}},
 	}
 	logger := zerolog.Nop()
-	factory := NewProxyLookupFactory(func(deviceName string) (StunTransport, error) {
+	factory := NewProxyLookupFactory(func(deviceName string, _ uint16) (StunTransport, error) {
 		ft, ok := byDevice[deviceName]
 		if !ok {
 			return nil, errors.New("unknown device")
@@ -218,10 +222,33 @@ func TestProxyLookupFactory_ResolvesTransportPerDevice(t *testing.T) {
 	}
 }
 
+// The port picks the proxy's outer socket, so it reaches the lookup rather
+// than being ignored.
+func TestProxyLookupFactory_PassesPort(t *testing.T) {
+	ft := &fakeTransport{respond: func(_ [12]byte, _ []byte) ([]byte, error) { return nil, errors.New("unused") }}
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	var gotPort uint16
+	factory := NewProxyLookupFactory(func(_ string, port uint16) (StunTransport, error) {
+		gotPort = port
+		return ft, nil
+	}, &logger)
+
+	if _, err := factory(context.Background(), "wg0", 51821, "ipv4", 0, nil, false); err != nil {
+		t.Fatalf("factory: %v", err)
+	}
+	if gotPort != 51821 {
+		t.Fatalf("lookup port = %d, want 51821", gotPort)
+	}
+	if strings.Contains(buf.String(), "ignored") {
+		t.Fatalf("unexpected ignored-args warning: %s", buf.String())
+	}
+}
+
 func TestProxyLookupFactory_LookupErrorPropagates(t *testing.T) {
 	logger := zerolog.Nop()
 	wantErr := errors.New("proxy not initialized yet")
-	factory := NewProxyLookupFactory(func(string) (StunTransport, error) {
+	factory := NewProxyLookupFactory(func(string, uint16) (StunTransport, error) {
 		return nil, wantErr
 	}, &logger)
 
@@ -234,10 +261,10 @@ func TestProxyLookupFactory_WarnsOnceForIgnoredArgs(t *testing.T) {
 	ft := &fakeTransport{respond: func(_ [12]byte, _ []byte) ([]byte, error) { return nil, errors.New("unused") }}
 	var buf bytes.Buffer
 	logger := zerolog.New(&buf)
-	factory := NewProxyLookupFactory(func(string) (StunTransport, error) { return ft, nil }, &logger)
+	factory := NewProxyLookupFactory(func(string, uint16) (StunTransport, error) { return ft, nil }, &logger)
 
 	for i := 0; i < 3; i++ {
-		if _, err := factory(context.Background(), "wg0", 51820, "ipv4", 0, nil, false); err != nil {
+		if _, err := factory(context.Background(), "wg0", 51820, "ipv4", 1, nil, false); err != nil {
 			t.Fatalf("factory cycle %d: %v", i, err)
 		}
 	}
@@ -250,7 +277,7 @@ func TestProxyLookupFactory_NoWarnWithoutIgnoredArgs(t *testing.T) {
 	ft := &fakeTransport{respond: func(_ [12]byte, _ []byte) ([]byte, error) { return nil, errors.New("unused") }}
 	var buf bytes.Buffer
 	logger := zerolog.New(&buf)
-	factory := NewProxyLookupFactory(func(string) (StunTransport, error) { return ft, nil }, &logger)
+	factory := NewProxyLookupFactory(func(string, uint16) (StunTransport, error) { return ft, nil }, &logger)
 
 	if _, err := factory(context.Background(), "wg0", 0, "ipv6", 0, nil, false); err != nil {
 		t.Fatalf("factory: %v", err)
@@ -0,0 +1,69 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/tjjh89017/stunmesh-go/internal/embedded"
+)
+
+// embeddedClient decorates a Client so devices running in-process (see
+// package embedded) are read and configured through wireguard-go, and every
+// other device through inner.
+type embeddedClient struct {
+	// inner is nil when every device is embedded, so no kernel or
+	// wireguard-tools backend is needed at all.
+	inner   Client
+	devices *embedded.Manager
+}
+
+// NewEmbeddedClient wraps inner, which may be nil, with the embedded
+// decorator.
+func NewEmbeddedClient(inner Client, devices *embedded.Manager) Client {
+	return &embeddedClient{inner: inner, devices: devices}
+}
+
+func (c *embeddedClient) Device(name string) (*DeviceInfo, error) {
+	d, err := c.devices.Get(name)
+	if errors.Is(err, embedded.ErrNotEmbedded) && c.inner != nil {
+		return c.inner.Device(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state, err := d.State()
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceInfo{
+		Name:       name,
+		ListenPort: int(state.ListenPort),
+		PrivateKey: state.PrivateKey,
+		PublicKey:  state.PublicKey,
+		PeerKeys:   state.Peers,
+	}, nil
+}
+
+func (c *embeddedClient) UpdatePeerEndpoint(u PeerEndpointUpdate) error {
+	d, err := c.devices.Get(u.DeviceName)
+	if errors.Is(err, embedded.ErrNotEmbedded) && c.inner != nil {
+		return c.inner.UpdatePeerEndpoint(u)
+	}
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(u.Host)
+	if err != nil {
+		return fmt.Errorf("wg: parse peer endpoint host %q: %w", u.Host, err)
+	}
+	return d.SetPeerEndpoint(u.PublicKey, netip.AddrPortFrom(addr, uint16(u.Port)))
+}
+
+func (c *embeddedClient) Close() error {
+	var err error
+	if c.inner != nil {
+		err = c.inner.Close()
+	}
+	return errors.Join(err, c.devices.Close())
+}
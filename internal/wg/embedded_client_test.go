@@ -0,0 +1,94 @@
+package wg
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/tjjh89017/stunmesh-go/internal/embedded"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// newTestEmbedded returns a manager with wg0 up on an in-memory TUN.
+func newTestEmbedded(t *testing.T, private, peer Key) *embedded.Manager {
+	t.Helper()
+	logger := zerolog.Nop()
+	m := embedded.NewManager(&logger, "linux",
+		embedded.WithRunner(func(context.Context, string, ...string) ([]byte, error) { return nil, nil }),
+		embedded.WithTUN(func(string, int) (tun.Device, error) { return tuntest.NewChannelTUN().TUN(), nil }),
+	)
+	t.Cleanup(func() { _ = m.Close() })
+	if _, err := m.Up(context.Background(), embedded.Interface{Name: "wg0", PrivateKey: private, Peers: []embedded.Peer{{PublicKey: peer}}}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	return m
+}
+
+func TestEmbeddedClient_RoutesByDevice(t *testing.T) {
+	// Already clamped, so wireguard-go reports it back unchanged.
+	private := Key{0: 0x08, 31: 0x40}
+	peer := Key{0x02}
+	m := newTestEmbedded(t, private, peer)
+	inner := &fakeClient{device: &DeviceInfo{Name: "wg1"}}
+	c := NewEmbeddedClient(inner, m)
+
+	info, err := c.Device("wg0")
+	if err != nil {
+		t.Fatalf("Device(wg0): %v", err)
+	}
+	public, _ := curve25519.X25519(private[:], curve25519.Basepoint)
+	if info.Name != "wg0" || info.PrivateKey != private || info.PublicKey != Key(public) || info.ListenPort == 0 || !slices.Equal(info.PeerKeys, []Key{peer}) {
+		t.Errorf("Device(wg0) = %+v, want the embedded device's keys, bound port and peer", info)
+	}
+	if err := c.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg0", PublicKey: peer, Host: "192.0.2.1", Port: 51820}); err != nil {
+		t.Errorf("UpdatePeerEndpoint(wg0): %v", err)
+	}
+	if err := c.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg0", PublicKey: peer, Host: "not-an-ip", Port: 51820}); err == nil {
+		t.Error("UpdatePeerEndpoint with a hostname succeeded, want an error")
+	}
+	if inner.deviceCalls != 0 || len(inner.updates) != 0 {
+		t.Errorf("inner saw %d Device and %d update calls for the embedded device, want none", inner.deviceCalls, len(inner.updates))
+	}
+
+	if info, err := c.Device("wg1"); err != nil || info.Name != "wg1" {
+		t.Errorf("Device(wg1) = %+v, %v; want inner's device", info, err)
+	}
+	if err := c.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg1", Host: "192.0.2.1", Port: 1}); err != nil || len(inner.updates) != 1 {
+		t.Errorf("UpdatePeerEndpoint(wg1) = %v with %d inner updates, want it delegated", err, len(inner.updates))
+	}
+
+	if err := c.Close(); err != nil || inner.closeCalls != 1 {
+		t.Errorf("Close = %v with %d inner closes, want both closed", err, inner.closeCalls)
+	}
+	if _, err := m.Up(context.Background(), embedded.Interface{Name: "wg2"}); !errors.Is(err, embedded.ErrManagerClosed) {
+		t.Errorf("Up after Close = %v, want ErrManagerClosed", err)
+	}
+}
+
+func TestEmbeddedClient_WithoutInner(t *testing.T) {
+	c := NewEmbeddedClient(nil, newTestEmbedded(t, Key{0: 0x08, 31: 0x40}, Key{0x02}))
+	if _, err := c.Device("wg1"); !errors.Is(err, embedded.ErrNotEmbedded) {
+		t.Errorf("Device(wg1) = %v, want ErrNotEmbedded", err)
+	}
+	if err := c.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg1", Host: "192.0.2.1", Port: 1}); !errors.Is(err, embedded.ErrNotEmbedded) {
+		t.Errorf("UpdatePeerEndpoint(wg1) = %v, want ErrNotEmbedded", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+// An IPv6 endpoint must reach wireguard-go bracketed.
+func TestEmbeddedClient_IPv6Endpoint(t *testing.T) {
+	peer := Key{0x02}
+	c := NewEmbeddedClient(nil, newTestEmbedded(t, Key{0: 0x08, 31: 0x40}, peer))
+	defer c.Close()
+	if err := c.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg0", PublicKey: peer, Host: netip.MustParseAddr("2001:db8::1").String(), Port: 51820}); err != nil {
+		t.Errorf("UpdatePeerEndpoint with an IPv6 host: %v", err)
+	}
+}
@@ -3,8 +3,10 @@ package wg
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net/netip"
 	"runtime"
+	"slices"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/routeprobe"
@@ -15,10 +17,23 @@ import (
 // by *config.DeviceConfig. Platform gating is wiring's responsibility.
 type ProxyConfig interface {
 	GetInterfaceProtocol(deviceName string) string
-	GetProxyListenPort(deviceName string) uint16
+	// GetProxyListenPorts returns deviceName's outer ports: the first is
+	// the families' own (0 means ephemeral), the rest extra sockets (see
+	// wgproxy.WithExtraPorts).
+	GetProxyListenPorts(deviceName string) []uint16
 	// GetProxyFib returns the freebsd-only escape FIB number for deviceName
 	// (0 means not configured); ignored on every other platform.
 	GetProxyFib(deviceName string) int
+	// GetProxyRoaming reports whether the proxy follows peers to a new
+	// source after a NAT rebind (see wgproxy.WithRoaming).
+	GetProxyRoaming(deviceName string) bool
+	// GetProxyObfuscation reports whether deviceName masks its packets to
+	// peers that do too, and how many junk packets precede each handshake
+	// initiation (see wgproxy.SetObfuscation).
+	GetProxyObfuscation(deviceName string) (enabled bool, junk int)
+	// GetRelayListenPort returns the port deviceName serves as a relay node
+	// on; 0 means it is not one.
+	GetRelayListenPort(deviceName string) uint16
 	// TunnelInterfaceNames returns every configured WireGuard interface name,
 	// used to scope the tunnel-escape route probe to devices stunmesh manages.
 	TunnelInterfaceNames() []string
@@ -48,8 +63,13 @@ func NewProxyClient(inner Client, manager *wgproxy.Manager, config ProxyConfig,
 	}
 }
 
-// Device delegates, then feeds the proxy the WG-side target and registers
-// every peer. DeviceInfo is returned unchanged — ListenPort stays real.
+// Device delegates, then feeds the proxy the WG-side target and syncs its
+// peer set with the device's PeerKeys: new peers get an inner socket and
+// peers gone from the device lose theirs. A proxy bound for other families
+// or ports than the device's configuration is rebound first. Obfuscation,
+// and the node of a device serving as a relay node, are keyed with the
+// device's private key and peers. DeviceInfo is returned unchanged —
+// ListenPort stays real.
 // Devices whose own proxy.enabled resolves false are passed straight through
 // to inner, even when proxy mode is on for the process as a whole — the
 // decorator being installed only means at least one interface opted in, not
@@ -64,19 +84,80 @@ func (c *proxyClient) Device(name string) (*DeviceInfo, error) {
 		return nil, err
 	}
 	tunnelIfaces := routeprobe.NewTunnelInterfaces(c.config.TunnelInterfaceNames()...)
-	proxy, err := c.ensureProxy(name, wgproxy.WithEscape(info.FirewallMark, c.config.GetProxyFib(name), tunnelIfaces))
+	opts := []wgproxy.Option{
+		wgproxy.WithEscape(info.FirewallMark, c.config.GetProxyFib(name), tunnelIfaces),
+		wgproxy.WithRoaming(c.config.GetProxyRoaming(name)),
+		wgproxy.WithExtraPorts(c.extraPorts(name)...),
+	}
+	proxy, err := c.ensureProxy(name, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if families := c.families(name); !maps.Equal(proxy.Families(), families) || !slices.Equal(proxy.ExtraPorts(), c.extraPorts(name)) {
+		if proxy, err = c.rebind(name, families, opts); err != nil {
+			return nil, err
+		}
+	}
 	proxy.SetWGTarget(uint16(info.ListenPort))
+
+	keep := make(map[Key]bool, len(info.PeerKeys))
 	for _, key := range info.PeerKeys {
+		keep[key] = true
 		if _, err := proxy.AddPeer(key); err != nil {
 			return nil, fmt.Errorf("wg: register peer with proxy: %w", err)
 		}
 	}
+	for _, key := range proxy.Peers() {
+		if !keep[key] {
+			proxy.RemovePeer(key)
+			c.logger.Debug().Str("device", name).Msg("peer removed from device, closed its proxy inner socket")
+		}
+	}
+	if enabled, junk := c.config.GetProxyObfuscation(name); enabled {
+		proxy.SetObfuscation(info.PrivateKey, &wgproxy.ObfuscationOptions{Junk: junk})
+	} else {
+		proxy.SetObfuscation(info.PrivateKey, nil)
+	}
+
+	if port := c.config.GetRelayListenPort(name); port != 0 {
+		node, err := c.manager.RelayNode(name, port)
+		if err != nil {
+			return nil, fmt.Errorf("wg: relay node: %w", err)
+		}
+		if err := node.SetKeys(info.PrivateKey, info.PeerKeys); err != nil {
+			return nil, fmt.Errorf("wg: relay node: %w", err)
+		}
+	}
 	return info, nil
 }
 
+// rebind re-creates the device's proxy for families and points WireGuard
+// at each carried-over peer's new inner socket.
+func (c *proxyClient) rebind(name string, families map[wgproxy.Family]uint16, opts []wgproxy.Option) (*wgproxy.Proxy, error) {
+	proxy, err := c.manager.Rebind(name, families, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("wg: rebind proxy: %w", err)
+	}
+	for _, key := range proxy.Peers() {
+		if _, ok := proxy.PeerEndpoint(key); !ok {
+			continue
+		}
+		innerAddr, err := proxy.AddPeer(key)
+		if err != nil {
+			return nil, fmt.Errorf("wg: register peer with proxy: %w", err)
+		}
+		if err := c.inner.UpdatePeerEndpoint(PeerEndpointUpdate{
+			DeviceName: name,
+			PublicKey:  key,
+			Host:       innerAddr.Addr().String(),
+			Port:       int(innerAddr.Port()),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return proxy, nil
+}
+
 // UpdatePeerEndpoint programs the proxy with the real remote, then delegates
 // with the endpoint replaced by the peer's loopback inner socket. A device
 // that opted out of proxy mode delegates the endpoint unchanged.
@@ -121,7 +202,10 @@ func (c *proxyClient) ensureProxy(deviceName string, opts ...wgproxy.Option) (*w
 }
 
 func (c *proxyClient) families(deviceName string) map[wgproxy.Family]uint16 {
-	port := c.config.GetProxyListenPort(deviceName)
+	var port uint16
+	if ports := c.config.GetProxyListenPorts(deviceName); len(ports) > 0 {
+		port = ports[0]
+	}
 	switch c.config.GetInterfaceProtocol(deviceName) {
 	case "ipv6":
 		return map[wgproxy.Family]uint16{wgproxy.FamilyIPv6: port}
@@ -131,3 +215,11 @@ func (c *proxyClient) families(deviceName string) map[wgproxy.Family]uint16 {
 		return map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: port}
 	}
 }
+
+// extraPorts returns the device's outer ports past the first.
+func (c *proxyClient) extraPorts(deviceName string) []uint16 {
+	if ports := c.config.GetProxyListenPorts(deviceName); len(ports) > 1 {
+		return ports[1:]
+	}
+	return nil
+}
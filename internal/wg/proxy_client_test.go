@@ -41,6 +41,9 @@ func (f *fakeClient) Close() error {
 type fakeProxyConfig struct {
 	protocol     string
 	listen       uint16
+	extra        []uint16
+	relayListen  uint16
+	obfuscate    bool
 	tunnelIfaces []string
 	// disabled, not enabled: zero value keeps every existing literal in this
 	// file exercising the proxy path unchanged.
@@ -48,12 +51,19 @@ type fakeProxyConfig struct {
 }
 
 func (f *fakeProxyConfig) GetInterfaceProtocol(deviceName string) string { return f.protocol }
-func (f *fakeProxyConfig) GetProxyListenPort(deviceName string) uint16   { return f.listen }
-func (f *fakeProxyConfig) GetProxyFib(deviceName string) int             { return 0 }
-func (f *fakeProxyConfig) TunnelInterfaceNames() []string                { return f.tunnelIfaces }
+func (f *fakeProxyConfig) GetProxyListenPorts(deviceName string) []uint16 {
+	return append([]uint16{f.listen}, f.extra...)
+}
+func (f *fakeProxyConfig) GetProxyFib(deviceName string) int           { return 0 }
+func (f *fakeProxyConfig) GetProxyRoaming(deviceName string) bool      { return false }
+func (f *fakeProxyConfig) GetRelayListenPort(deviceName string) uint16 { return f.relayListen }
+func (f *fakeProxyConfig) TunnelInterfaceNames() []string              { return f.tunnelIfaces }
 func (f *fakeProxyConfig) GetProxyEnabled(deviceName string, goos string) bool {
 	return !f.disabled
 }
+func (f *fakeProxyConfig) GetProxyObfuscation(deviceName string) (bool, int) {
+	return f.obfuscate, 0
+}
 
 func newTestProxyClient(t *testing.T, inner Client, cfg ProxyConfig) (Client, *wgproxy.Manager) {
 	t.Helper()
@@ -338,6 +348,68 @@ func TestProxyClient_ListenOverride(t *testing.T) {
 	}
 }
 
+func TestProxyClient_Device_StartsRelayNode(t *testing.T) {
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("probe bind: %v", err)
+	}
+	port := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+	_ = probe.Close()
+
+	inner := &fakeClient{device: &DeviceInfo{Name: "wg0", ListenPort: 51820, PrivateKey: testKey(0x09), PeerKeys: []Key{testKey(0x01)}}}
+	pc, manager := newTestProxyClient(t, inner, &fakeProxyConfig{protocol: "ipv4", relayListen: port})
+
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	node, err := manager.RelayNode("wg0", 0)
+	if err != nil {
+		t.Fatalf("manager.RelayNode: %v", err)
+	}
+	if got := node.Port(); got != port {
+		t.Errorf("relay node port = %d, want configured %d", got, port)
+	}
+	// Later refreshes reuse the node.
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device again: %v", err)
+	}
+	if again, _ := manager.RelayNode("wg0", 0); again != node {
+		t.Error("refresh replaced the relay node")
+	}
+}
+
+// Obfuscation follows the config on every refresh; a peer's packets are
+// masked only once it is marked as obfuscating too.
+func TestProxyClient_Device_SetsObfuscation(t *testing.T) {
+	peer := testKey(0x01)
+	inner := &fakeClient{device: &DeviceInfo{Name: "wg0", ListenPort: 51820, PrivateKey: testKey(0x09), PeerKeys: []Key{peer}}}
+	config := &fakeProxyConfig{protocol: "ipv4", obfuscate: true}
+	pc, manager := newTestProxyClient(t, inner, config)
+
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	proxy, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+	if proxy.PeerObfuscated(peer) {
+		t.Fatal("PeerObfuscated before the peer is marked, want false")
+	}
+	proxy.SetPeerObfuscated(peer, true)
+	if !proxy.PeerObfuscated(peer) {
+		t.Fatal("PeerObfuscated = false with obfuscation on and the peer marked")
+	}
+
+	config.obfuscate = false
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device again: %v", err)
+	}
+	if proxy.PeerObfuscated(peer) {
+		t.Error("PeerObfuscated = true after obfuscation was turned off")
+	}
+}
+
 // A device with proxy.enabled=false must be passed straight through to the
 // inner client even though the decorator is installed (because some other
 // interface in the same process opted in) — this is the mixed-config case.
@@ -441,3 +513,126 @@ func TestProxyClient_Close_JoinsErrors(t *testing.T) {
 		t.Fatalf("Close error = %v, want %v", err, wantErr)
 	}
 }
+
+func TestProxyClient_Device_RemovesPeersGoneFromDevice(t *testing.T) {
+	kept, removed := testKey(0x11), testKey(0x12)
+	info := &DeviceInfo{Name: "wg0", ListenPort: 51820, PeerKeys: []Key{kept, removed}}
+	inner := &fakeClient{device: info}
+	pc, manager := newTestProxyClient(t, inner, &fakeProxyConfig{protocol: "ipv4"})
+
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	proxy, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+	if got := len(proxy.Peers()); got != 2 {
+		t.Fatalf("proxy peers = %d, want 2", got)
+	}
+
+	info.PeerKeys = []Key{kept}
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	if got := proxy.Peers(); len(got) != 1 || got[0] != kept {
+		t.Fatalf("proxy peers = %v, want only the kept peer", got)
+	}
+}
+
+func TestProxyClient_Device_RebindsOnFamilyChange(t *testing.T) {
+	peer, idle := testKey(0x13), testKey(0x14)
+	inner := &fakeClient{device: &DeviceInfo{Name: "wg0", ListenPort: 51820, PeerKeys: []Key{peer, idle}}}
+	cfg := &fakeProxyConfig{protocol: "ipv4"}
+	pc, manager := newTestProxyClient(t, inner, cfg)
+
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	if err := pc.UpdatePeerEndpoint(PeerEndpointUpdate{DeviceName: "wg0", PublicKey: peer, Host: "203.0.113.9", Port: 4242}); err != nil {
+		t.Fatalf("UpdatePeerEndpoint: %v", err)
+	}
+	old, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+
+	// Same configuration: the proxy stays.
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	if got, _ := manager.Get("wg0"); got != old {
+		t.Fatal("an unchanged configuration must keep the proxy")
+	}
+
+	// Families carry their port, so pinning one is a change too, and unlike
+	// adding IPv6 it needs no IPv6 loopback in the test environment.
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	cfg.listen = uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+	_ = probe.Close()
+	inner.updates = nil
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device after family change: %v", err)
+	}
+	proxy, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+	if proxy == old {
+		t.Fatal("a changed configuration must rebind the proxy")
+	}
+	if got := proxy.OuterPort(wgproxy.FamilyIPv4); got != cfg.listen {
+		t.Fatalf("outer port = %d, want pinned %d", got, cfg.listen)
+	}
+
+	// WireGuard is re-pointed at the new inner socket of the peer that had
+	// an endpoint; the idle peer has nothing to re-point.
+	if len(inner.updates) != 1 {
+		t.Fatalf("inner updates = %d, want 1", len(inner.updates))
+	}
+	innerAddr, err := proxy.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	if u := inner.updates[0]; u.PublicKey != peer || u.Port != int(innerAddr.Port()) {
+		t.Fatalf("update = %+v, want peer re-pointed at %s", u, innerAddr)
+	}
+}
+
+func TestProxyClient_Device_RebindsOnExtraPortsChange(t *testing.T) {
+	peer := testKey(0x15)
+	inner := &fakeClient{device: &DeviceInfo{Name: "wg0", ListenPort: 51820, PeerKeys: []Key{peer}}}
+	cfg := &fakeProxyConfig{protocol: "ipv4"}
+	pc, manager := newTestProxyClient(t, inner, cfg)
+
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device: %v", err)
+	}
+	old, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	cfg.extra = []uint16{uint16(probe.LocalAddr().(*net.UDPAddr).Port)}
+	_ = probe.Close()
+	if _, err := pc.Device("wg0"); err != nil {
+		t.Fatalf("Device after adding a port: %v", err)
+	}
+	proxy, err := manager.Get("wg0")
+	if err != nil {
+		t.Fatalf("manager.Get: %v", err)
+	}
+	if proxy == old {
+		t.Fatal("an added port must rebind the proxy")
+	}
+	if got := proxy.ExtraPorts(); len(got) != 1 || got[0] != cfg.extra[0] {
+		t.Fatalf("extra ports = %v, want %v", got, cfg.extra)
+	}
+}
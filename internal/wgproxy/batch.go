@@ -0,0 +1,334 @@
+// Batched relay: proxy.go's loops moving up to a batch of datagrams per
+// syscall (recvmmsg/sendmmsg via x/net), and on Linux letting the kernel
+// merge inbound datagrams (UDP GRO) and split outbound ones (UDP GSO) so a
+// burst from one peer costs a single buffer. x/net only batches on Linux;
+// elsewhere batchedIO is false and New keeps proxy.go's one-datagram loops.
+package wgproxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// outerBatchSize is how many datagrams an outer read moves; each buffer
+	// holds a whole GRO-merged burst.
+	outerBatchSize = 32
+	// innerBatchSize and innerBufSize size a peer's inner reads: WireGuard
+	// writes one packet per datagram, so a buffer needs to hold only the
+	// largest packet of any practical MTU, jumbo frames included.
+	innerBatchSize = 16
+	innerBufSize   = 16 << 10
+
+	// writeBatchSize bounds the datagrams of one batched write.
+	writeBatchSize = 64
+
+	// maxGSOSegments and maxGSOBytes bound one GSO datagram, below the
+	// kernel's UDP_MAX_SEGMENTS and the UDP length limit.
+	maxGSOSegments = 64
+	maxGSOBytes    = 65000
+)
+
+// batchConn is the batched face of a UDP socket. ipv4.Message and
+// ipv6.Message are the same type, so both families' PacketConns are one.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newBatchConn(conn *net.UDPConn, fam Family) batchConn {
+	if fam == FamilyIPv6 {
+		return ipv6.NewPacketConn(conn)
+	}
+	return ipv4.NewPacketConn(conn)
+}
+
+// WithBatching turns the batched relay off (or back on) where it is
+// available; off means the one-datagram-per-syscall loops of proxy.go.
+func WithBatching(enabled bool) Option {
+	return func(o *options) {
+		o.unbatched = !enabled
+	}
+}
+
+// newReadMessages allocates n messages of one bufSize buffer each, with
+// room for a GRO control message when oob is set.
+func newReadMessages(n, bufSize int, oob bool) []ipv4.Message {
+	msgs := make([]ipv4.Message, n)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, bufSize)}
+		if oob {
+			msgs[i].OOB = make([]byte, groOOBSize)
+		}
+	}
+	return msgs
+}
+
+// newWriteMessages allocates writeBatchSize messages for batchWriter to
+// fill, with room for a GSO control message each.
+func newWriteMessages() []ipv4.Message {
+	msgs := make([]ipv4.Message, writeBatchSize)
+	for i := range msgs {
+		msgs[i].OOB = make([]byte, 0, gsoOOBSize)
+	}
+	return msgs
+}
+
+// writeAll writes every message, across as many syscalls as it takes.
+func writeAll(conn batchConn, msgs []ipv4.Message) error {
+	for len(msgs) > 0 {
+		n, err := conn.WriteBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.New("wgproxy: batched write made no progress")
+		}
+		msgs = msgs[n:]
+	}
+	return nil
+}
+
+// gsoRun returns how many of pkts, from the first, the kernel can send as
+// one GSO datagram: packets the size of the first, then at most one
+// shorter packet ending the run.
+func gsoRun(pkts [][]byte) int {
+	if len(pkts) == 0 {
+		return 0
+	}
+	size := len(pkts[0])
+	total := size
+	n := 1
+	for n < len(pkts) && n < maxGSOSegments {
+		next := len(pkts[n])
+		if next > size || total+next > maxGSOBytes {
+			break
+		}
+		total += next
+		n++
+		if next < size {
+			break
+		}
+	}
+	return n
+}
+
+func addrPortOf(addr net.Addr) netip.AddrPort {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return normalize(udp.AddrPort())
+	}
+	return netip.AddrPort{}
+}
+
+// outerBatchLoop is outerLoop, batched: a GRO-merged datagram is split back
+// into its packets, each classified on its own, and consecutive packets for
+// the same peer are written to WireGuard with one syscall.
+func (p *Proxy) outerBatchLoop(fam Family, sock *outerSocket) {
+	defer p.loops.Done()
+	msgs := newReadMessages(outerBatchSize, relayBufSize, sock.gro)
+	writes := newWriteMessages()
+	local := outerLocal(fam, sock.port)
+	var (
+		pending     int
+		pendingLen  int
+		pendingPeer *peerState
+		target      netip.AddrPort
+		targetAddr  *net.UDPAddr
+	)
+	flush := func() {
+		if pending == 0 {
+			return
+		}
+		if err := writeAll(pendingPeer.batch, writes[:pending]); err != nil {
+			p.countWarn(&p.writeErrs, "inner write failed")
+		} else {
+			pendingPeer.stats.received(pending, pendingLen)
+		}
+		pending, pendingLen = 0, 0
+	}
+
+	consecutiveErrs := 0
+	for {
+		for i := range msgs {
+			msgs[i].Buffers[0] = msgs[i].Buffers[0][:relayBufSize]
+			msgs[i].OOB = msgs[i].OOB[:cap(msgs[i].OOB)]
+		}
+		n, err := sock.batch.ReadBatch(msgs, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || p.isClosed() {
+				return
+			}
+			consecutiveErrs++
+			p.logger.Warn().Err(err).Stringer("family", fam).Int("consecutive", consecutiveErrs).Msg("outer read error, retrying")
+			backoff(consecutiveErrs)
+			continue
+		}
+		consecutiveErrs = 0
+
+		if t := p.wgTarget(); t != target {
+			target, targetAddr = t, net.UDPAddrFromAddrPort(t)
+		}
+		for i := range msgs[:n] {
+			m := &msgs[i]
+			p.noteTruncation(m.N, relayBufSize)
+			src := addrPortOf(m.Addr)
+			segSize := m.N
+			if sock.gro {
+				if size := groSegmentSize(m.OOB[:m.NN]); size > 0 {
+					segSize = size
+				}
+			}
+			for b := m.Buffers[0][:m.N]; len(b) > 0; {
+				seg := b[:min(segSize, len(b))]
+				b = b[len(seg):]
+
+				decision := p.demux.Classify(src, seg)
+				if decision.Bucket != BucketRelay {
+					p.tapClassified(src, local, seg, decision)
+					continue
+				}
+				p.mu.RLock()
+				ps := p.peers[decision.Peer]
+				p.mu.RUnlock()
+				if ps == nil || !target.IsValid() {
+					p.countWarn(&p.unroutable, "relay packet for peer without inner socket or WG target")
+					p.tap(sideOuter, true, src, local, seg, noteNoInner)
+					continue
+				}
+				in, note, ok := ps.inbound(seg)
+				p.tap(sideOuter, true, src, local, seg, note)
+				if !ok {
+					continue
+				}
+				packet := in.packet(seg)
+				p.tap(sideInner, false, ps.innerAddr, target, packet, "relay")
+				if ps != pendingPeer || pending == len(writes) {
+					flush()
+					pendingPeer = ps
+				}
+				w := &writes[pending]
+				w.Buffers = append(w.Buffers[:0], packet)
+				w.OOB = w.OOB[:0]
+				w.Addr = targetAddr
+				pending++
+				pendingLen += len(seg)
+			}
+		}
+		flush()
+	}
+}
+
+// innerBatchLoop is innerLoop, batched: a burst WireGuard wrote for the
+// peer goes out with one syscall, as GSO datagrams where the kernel can
+// split them.
+func (p *Proxy) innerBatchLoop(ps *peerState) {
+	defer p.loops.Done()
+	msgs := newReadMessages(innerBatchSize, innerBufSize, false)
+	writes := newWriteMessages()
+	pkts := make([][]byte, 0, innerBatchSize)
+	masked := make([][]byte, innerBatchSize)
+	for i := range masked {
+		masked[i] = make([]byte, 0, innerBufSize+obfsOverhead+obfsMaxPadding)
+	}
+	var (
+		remote     netip.AddrPort
+		remoteAddr *net.UDPAddr
+	)
+
+	consecutiveErrs := 0
+	for {
+		for i := range msgs {
+			msgs[i].Buffers[0] = msgs[i].Buffers[0][:innerBufSize]
+		}
+		n, err := ps.batch.ReadBatch(msgs, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || p.isClosed() {
+				return
+			}
+			consecutiveErrs++
+			p.logger.Warn().Err(err).Str("inner", ps.innerAddr.String()).Int("consecutive", consecutiveErrs).Msg("inner read error, retrying")
+			backoff(consecutiveErrs)
+			continue
+		}
+		consecutiveErrs = 0
+		if p.demux.roam != nil {
+			for i := range msgs[:n] {
+				p.roamOutbound(ps, msgs[i].Buffers[0][:msgs[i].N])
+			}
+		}
+
+		current := ps.remote.Load()
+		if current == nil {
+			for i := range msgs[:n] {
+				p.countWarn(&p.unroutable, "outbound packet before peer endpoint programmed")
+				p.tap(sideInner, true, p.wgTarget(), ps.innerAddr, msgs[i].Buffers[0][:msgs[i].N], noteNoEndpoint)
+			}
+			continue
+		}
+		sock, ok := p.peerSocket(ps, familyOf(*current))
+		if !ok {
+			for i := range msgs[:n] {
+				p.countWarn(&p.unroutable, "outbound packet for disabled protocol family")
+				p.tap(sideInner, true, p.wgTarget(), ps.innerAddr, msgs[i].Buffers[0][:msgs[i].N], noteNoFamily)
+			}
+			continue
+		}
+		if *current != remote {
+			remote, remoteAddr = *current, net.UDPAddrFromAddrPort(*current)
+		}
+
+		pkts = pkts[:0]
+		for i := range msgs[:n] {
+			p.noteTruncation(msgs[i].N, innerBufSize)
+			packet := msgs[i].Buffers[0][:msgs[i].N]
+			pkts = append(pkts, p.outbound(ps, sock, remote, packet, masked[i]))
+			p.tapOutbound(ps, sock, remote, packet, pkts[i])
+		}
+		if p.writeOuter(sock, remoteAddr, pkts, writes) {
+			total := 0
+			for i := range msgs[:n] {
+				total += msgs[i].N
+			}
+			ps.stats.sent(len(pkts), total)
+		}
+	}
+}
+
+// writeOuter sends pkts to addr through sock, coalesced into GSO datagrams
+// while the socket takes them. The first send GSO fails for good, e.g. on a
+// NIC without checksum offload, turns it off for the socket and resends
+// the packets one datagram each. It reports whether every packet went out.
+func (p *Proxy) writeOuter(sock *outerSocket, addr *net.UDPAddr, pkts [][]byte, writes []ipv4.Message) bool {
+	gso := sock.gso.Load()
+	n := 0
+	for rest := pkts; len(rest) > 0; n++ {
+		run := 1
+		if gso {
+			run = gsoRun(rest)
+		}
+		w := &writes[n]
+		w.Buffers = append(w.Buffers[:0], rest[:run]...)
+		w.OOB = w.OOB[:0]
+		if run > 1 {
+			w.OOB = appendGSO(w.OOB, uint16(len(rest[0])))
+		}
+		w.Addr = addr
+		rest = rest[run:]
+	}
+
+	err := writeAll(sock.batch, writes[:n])
+	if err != nil && gso && isGSOError(err) {
+		sock.gso.Store(false)
+		p.logger.Warn().Err(err).Msg("outer socket cannot send GSO datagrams, sending packets one by one")
+		return p.writeOuter(sock, addr, pkts, writes)
+	}
+	if err != nil {
+		p.countWarn(&p.writeErrs, "outer write failed")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package wgproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// batchedIO reports whether x/net batches reads and writes here.
+const batchedIO = true
+
+var (
+	// groOOBSize fits the UDP_GRO control message: the segment size, an int.
+	groOOBSize = unix.CmsgSpace(4)
+	// gsoOOBSize fits the UDP_SEGMENT control message: a uint16.
+	gsoOOBSize = unix.CmsgSpace(2)
+)
+
+// enableGRO asks the kernel to merge a burst of datagrams from one source
+// into one read (Linux 5.0 and later), reporting whether it will.
+func enableGRO(conn *net.UDPConn) bool {
+	return setUDPOption(conn, unix.UDP_GRO, 1) == nil
+}
+
+// supportsGSO reports whether the socket takes UDP_SEGMENT (Linux 4.18 and
+// later).
+func supportsGSO(conn *net.UDPConn) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		_, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT)
+	})
+	return err == nil && sockErr == nil
+}
+
+func setUDPOption(conn *net.UDPConn, opt, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_UDP, opt, value)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// groSegmentSize returns the size of the datagrams a read merged, from its
+// control messages; 0 when the read holds a single datagram.
+func groSegmentSize(oob []byte) int {
+	for len(oob) >= unix.SizeofCmsghdr {
+		hdr, data, rest, err := unix.ParseOneSocketControlMessage(oob)
+		if err != nil {
+			return 0
+		}
+		if hdr.Level == unix.SOL_UDP && hdr.Type == unix.UDP_GRO && len(data) >= 4 {
+			return int(binary.NativeEndian.Uint32(data))
+		}
+		oob = rest
+	}
+	return 0
+}
+
+// appendGSO appends the control message that has the kernel split a
+// datagram into segments of size bytes.
+func appendGSO(oob []byte, size uint16) []byte {
+	start := len(oob)
+	oob = append(oob, make([]byte, gsoOOBSize)...)
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[start]))
+	hdr.Level = unix.SOL_UDP
+	hdr.Type = unix.UDP_SEGMENT
+	hdr.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[start+unix.CmsgLen(0):], size)
+	return oob
+}
+
+// isGSOError reports whether a send failed because the route cannot take
+// GSO datagrams, which the kernel reports as EIO.
+func isGSOError(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
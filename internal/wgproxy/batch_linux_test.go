@@ -0,0 +1,45 @@
+package wgproxy
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAppendGSO(t *testing.T) {
+	oob := appendGSO(nil, 1420)
+	if len(oob) != gsoOOBSize {
+		t.Fatalf("appendGSO wrote %d bytes, want %d", len(oob), gsoOOBSize)
+	}
+	hdr, data, _, err := unix.ParseOneSocketControlMessage(oob)
+	if err != nil {
+		t.Fatalf("ParseOneSocketControlMessage: %v", err)
+	}
+	if hdr.Level != unix.SOL_UDP || hdr.Type != unix.UDP_SEGMENT {
+		t.Fatalf("control message level/type = %d/%d, want SOL_UDP/UDP_SEGMENT", hdr.Level, hdr.Type)
+	}
+	if got := binary.NativeEndian.Uint16(data); got != 1420 {
+		t.Fatalf("segment size = %d, want 1420", got)
+	}
+}
+
+func TestGROSegmentSize(t *testing.T) {
+	oob := make([]byte, groOOBSize)
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = unix.SOL_UDP
+	hdr.Type = unix.UDP_GRO
+	hdr.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgLen(0):], 1280)
+
+	if got := groSegmentSize(oob); got != 1280 {
+		t.Fatalf("groSegmentSize = %d, want 1280", got)
+	}
+	if got := groSegmentSize(nil); got != 0 {
+		t.Fatalf("groSegmentSize(nil) = %d, want 0", got)
+	}
+	if got := groSegmentSize(appendGSO(nil, 1420)); got != 0 {
+		t.Fatalf("groSegmentSize of a non-GRO message = %d, want 0", got)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package wgproxy
+
+import "net"
+
+// batchedIO reports whether x/net batches reads and writes here; it does
+// only on Linux, so batching would cost a syscall per datagram as before.
+const batchedIO = false
+
+const (
+	groOOBSize = 0
+	gsoOOBSize = 0
+)
+
+func enableGRO(*net.UDPConn) bool { return false }
+
+func supportsGSO(*net.UDPConn) bool { return false }
+
+func groSegmentSize([]byte) int { return 0 }
+
+func appendGSO(oob []byte, _ uint16) []byte { return oob }
+
+func isGSOError(error) bool { return false }
@@ -0,0 +1,38 @@
+package wgproxy
+
+import "testing"
+
+func sizedPackets(sizes ...int) [][]byte {
+	pkts := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		pkts[i] = make([]byte, size)
+	}
+	return pkts
+}
+
+func TestGSORun(t *testing.T) {
+	same := make([]int, maxGSOSegments+8)
+	for i := range same {
+		same[i] = 100
+	}
+	tests := []struct {
+		name  string
+		sizes []int
+		want  int
+	}{
+		{"empty", nil, 0},
+		{"single", []int{1420}, 1},
+		{"equal sizes", []int{1420, 1420, 1420}, 3},
+		{"shorter packet ends the run", []int{1420, 1420, 600, 1420}, 3},
+		{"longer packet starts a new run", []int{600, 1420}, 1},
+		{"segment cap", same, maxGSOSegments},
+		{"byte cap", []int{30000, 30000, 30000}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gsoRun(sizedPackets(tt.sizes...)); got != tt.want {
+				t.Fatalf("gsoRun(%v) = %d, want %d", tt.sizes, got, tt.want)
+			}
+		})
+	}
+}
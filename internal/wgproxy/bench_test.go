@@ -10,6 +10,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
+	"golang.org/x/net/ipv4"
 )
 
 const (
@@ -25,6 +26,10 @@ const (
 	// benchSocketBuf is applied to TEST-owned sockets only: larger buffers
 	// keep burst loss small without touching proxy internals.
 	benchSocketBuf = 4 << 20
+
+	// benchSendBatch is how many packets a batched sender writes per
+	// syscall, roughly a WireGuard send burst.
+	benchSendBatch = 32
 )
 
 type relayEnv struct {
@@ -48,10 +53,10 @@ func benchLoopbackConn(tb testing.TB) (*net.UDPConn, netip.AddrPort) {
 	return conn, netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
 }
 
-func newRelayEnv(tb testing.TB) *relayEnv {
+func newRelayEnv(tb testing.TB, opts ...wgproxy.Option) *relayEnv {
 	tb.Helper()
 	logger := zerolog.Nop()
-	p, err := wgproxy.New(&logger, map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0})
+	p, err := wgproxy.New(&logger, map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0}, opts...)
 	if err != nil {
 		tb.Fatalf("New: %v", err)
 	}
@@ -77,16 +82,21 @@ func newRelayEnv(tb testing.TB) *relayEnv {
 	}
 }
 
-// measureRelay pushes count packets from src and counts what dst receives.
-// pps counts delivered packets, so kernel drops shrink the numerator instead
-// of stalling; dst reads with deadlines so total loss ends the run.
-func measureRelay(tb testing.TB, src, dst *net.UDPConn, target netip.AddrPort, count int) (received int, elapsed time.Duration) {
+// measureRelay pushes count packets from src, batch per write (1 for one
+// datagram per syscall), and counts what dst receives. pps counts delivered
+// packets, so kernel drops shrink the numerator instead of stalling; dst
+// reads with deadlines so total loss ends the run.
+func measureRelay(tb testing.TB, src, dst *net.UDPConn, target netip.AddrPort, count, batch int) (received int, elapsed time.Duration) {
 	tb.Helper()
 	payload := wgMessage(4, benchPayloadSize)
 	done := make(chan struct{})
 	start := time.Now()
 	go func() {
 		defer close(done)
+		if batch > 1 {
+			sendBatched(src, payload, target, count, batch)
+			return
+		}
 		for i := 0; i < count; i++ {
 			sent := false
 			for attempt := 0; attempt < 100; attempt++ {
@@ -103,13 +113,28 @@ func measureRelay(tb testing.TB, src, dst *net.UDPConn, target netip.AddrPort, c
 		}
 	}()
 
-	buf := make([]byte, benchPayloadSize+1)
+	// The receiver reads batch packets per syscall too, so a batched run
+	// measures the relay rather than the test's own reads.
+	dstBatch := ipv4.NewPacketConn(dst)
+	msgs := make([]ipv4.Message, batch)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, benchPayloadSize+1)}
+	}
 	last := start
 	for received < count {
 		if err := dst.SetReadDeadline(time.Now().Add(senderIdleWindow)); err != nil {
 			tb.Fatalf("SetReadDeadline: %v", err)
 		}
-		n, _, err := dst.ReadFromUDPAddrPort(buf)
+		var (
+			n   int
+			err error
+		)
+		if batch > 1 {
+			n, err = dstBatch.ReadBatch(msgs, 0)
+		} else {
+			msgs[0].N, _, err = dst.ReadFromUDPAddrPort(msgs[0].Buffers[0])
+			n = 1
+		}
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				select {
@@ -122,26 +147,56 @@ func measureRelay(tb testing.TB, src, dst *net.UDPConn, target netip.AddrPort, c
 			}
 			tb.Fatalf("relay read: %v", err)
 		}
-		if n != benchPayloadSize {
-			tb.Fatalf("relay read %d bytes, want %d", n, benchPayloadSize)
+		for _, m := range msgs[:n] {
+			if m.N != benchPayloadSize {
+				tb.Fatalf("relay read %d bytes, want %d", m.N, benchPayloadSize)
+			}
 		}
-		received++
+		received += n
 		last = time.Now()
 	}
 	<-done
 	return received, last.Sub(start)
 }
 
-func benchmarkRelayDirection(b *testing.B, src func(*relayEnv) *net.UDPConn, dst func(*relayEnv) *net.UDPConn, target func(*relayEnv) netip.AddrPort) {
+// sendBatched is measureRelay's sender writing batch packets per syscall
+// where x/net batches (Linux); the relay then sees bursts as WireGuard's
+// own batched sends produce them.
+func sendBatched(src *net.UDPConn, payload []byte, target netip.AddrPort, count, batch int) {
+	pc := ipv4.NewPacketConn(src)
+	addr := net.UDPAddrFromAddrPort(target)
+	msgs := make([]ipv4.Message, batch)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{payload}
+		msgs[i].Addr = addr
+	}
+	for sent := 0; sent < count; {
+		chunk := msgs[:min(batch, count-sent)]
+		n, err := 0, error(nil)
+		for attempt := 0; attempt < 100; attempt++ {
+			if n, err = pc.WriteBatch(chunk, 0); err == nil && n > 0 {
+				break
+			}
+			// ENOBUFS-class transient under burst; back off briefly.
+			time.Sleep(time.Millisecond)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+		sent += n
+	}
+}
+
+func benchmarkRelayDirection(b *testing.B, opts []wgproxy.Option, batch int, src func(*relayEnv) *net.UDPConn, dst func(*relayEnv) *net.UDPConn, target func(*relayEnv) netip.AddrPort) {
 	// Pinned via runtime.GOMAXPROCS, not b.SetParallelism: SetParallelism only
 	// scales RunParallel goroutines and does not bound scheduler Ps.
 	prev := runtime.GOMAXPROCS(benchGOMAXPROCS)
 	defer runtime.GOMAXPROCS(prev)
 
-	env := newRelayEnv(b)
+	env := newRelayEnv(b, opts...)
 	b.SetBytes(benchPayloadSize)
 	b.ResetTimer()
-	received, elapsed := measureRelay(b, src(env), dst(env), target(env), b.N)
+	received, elapsed := measureRelay(b, src(env), dst(env), target(env), b.N, batch)
 	b.StopTimer()
 	if received == 0 {
 		b.Fatal("no packets relayed")
@@ -150,19 +205,40 @@ func benchmarkRelayDirection(b *testing.B, src func(*relayEnv) *net.UDPConn, dst
 	b.ReportMetric(float64(b.N-received)/float64(b.N), "loss")
 }
 
+// BenchmarkRelay compares the one-datagram relay loops with the batched
+// ones, each fed one packet per syscall and in benchSendBatch bursts; the
+// batched relay only differs where x/net batches (Linux).
 func BenchmarkRelay(b *testing.B) {
-	b.Run("inbound", func(b *testing.B) {
-		benchmarkRelayDirection(b,
-			func(e *relayEnv) *net.UDPConn { return e.remoteConn },
-			func(e *relayEnv) *net.UDPConn { return e.wgConn },
-			func(e *relayEnv) netip.AddrPort { return e.outerAddr })
-	})
-	b.Run("outbound", func(b *testing.B) {
-		benchmarkRelayDirection(b,
-			func(e *relayEnv) *net.UDPConn { return e.wgConn },
-			func(e *relayEnv) *net.UDPConn { return e.remoteConn },
-			func(e *relayEnv) netip.AddrPort { return e.innerAddr })
-	})
+	relays := []struct {
+		name string
+		opts []wgproxy.Option
+	}{
+		{"unbatched", []wgproxy.Option{wgproxy.WithBatching(false)}},
+		{"batched", []wgproxy.Option{wgproxy.WithBatching(true)}},
+	}
+	senders := []struct {
+		name  string
+		batch int
+	}{
+		{"single", 1},
+		{"burst", benchSendBatch},
+	}
+	for _, relay := range relays {
+		for _, sender := range senders {
+			b.Run(relay.name+"/"+sender.name+"/inbound", func(b *testing.B) {
+				benchmarkRelayDirection(b, relay.opts, sender.batch,
+					func(e *relayEnv) *net.UDPConn { return e.remoteConn },
+					func(e *relayEnv) *net.UDPConn { return e.wgConn },
+					func(e *relayEnv) netip.AddrPort { return e.outerAddr })
+			})
+			b.Run(relay.name+"/"+sender.name+"/outbound", func(b *testing.B) {
+				benchmarkRelayDirection(b, relay.opts, sender.batch,
+					func(e *relayEnv) *net.UDPConn { return e.wgConn },
+					func(e *relayEnv) *net.UDPConn { return e.remoteConn },
+					func(e *relayEnv) netip.AddrPort { return e.innerAddr })
+			})
+		}
+	}
 }
 
 // TestRelayThroughputFloor enforces a 50k pps relay floor; env-gated because
@@ -176,7 +252,7 @@ func TestRelayThroughputFloor(t *testing.T) {
 	defer runtime.GOMAXPROCS(prev)
 
 	env := newRelayEnv(t)
-	received, elapsed := measureRelay(t, env.remoteConn, env.wgConn, env.outerAddr, floorPacketCount)
+	received, elapsed := measureRelay(t, env.remoteConn, env.wgConn, env.outerAddr, floorPacketCount, 1)
 	if received == 0 {
 		t.Fatal("no packets relayed")
 	}
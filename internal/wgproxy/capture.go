@@ -0,0 +1,372 @@
+// Debug packet capture. When started, every packet the proxy reads or
+// writes, on the outer sockets and on the inner loopback ones, is appended
+// to a pcapng file as a raw IP packet with synthesized IP and UDP headers,
+// so Wireshark dissects the WireGuard or STUN payload. Each packet carries
+// a comment saying how the proxy classified it and, for a dropped one, why.
+// Payloads are cut to a snap length; files rotate at a size limit and only
+// a few are kept, so a capture left running stays bounded.
+package wgproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultCaptureMaxSize is the file size a capture rotates at.
+	DefaultCaptureMaxSize = 8 << 20
+	// DefaultCaptureMaxFiles is how many rotated files a capture keeps.
+	DefaultCaptureMaxFiles = 3
+	// DefaultCaptureSnapLen is how many payload bytes a capture keeps per
+	// packet: WireGuard's and STUN's headers, not the data they carry.
+	DefaultCaptureSnapLen = 64
+
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngLinkTypeRaw    = 101
+
+	pcapngOptEnd     = 0
+	pcapngOptComment = 1
+	pcapngOptShbApp  = 4
+	pcapngOptIfName  = 2
+	pcapngOptEpbFlag = 2
+
+	pcapngInbound  = 1
+	pcapngOutbound = 2
+)
+
+// ErrCaptureOptions is returned by StartCapture for invalid options.
+var ErrCaptureOptions = errors.New("wgproxy: invalid capture options")
+
+// CaptureOptions configures a debug capture.
+type CaptureOptions struct {
+	// Path is the file written; rotated files are Path.1 (newest) to
+	// Path.MaxFiles.
+	Path string
+	// MaxSize is the size in bytes a file rotates at; 0 means
+	// DefaultCaptureMaxSize.
+	MaxSize int64
+	// MaxFiles is how many rotated files are kept besides Path; 0 means
+	// DefaultCaptureMaxFiles.
+	MaxFiles int
+	// SnapLen is how many payload bytes are kept per packet; 0 means
+	// DefaultCaptureSnapLen.
+	SnapLen int
+}
+
+func (o CaptureOptions) withDefaults() (CaptureOptions, error) {
+	if o.Path == "" || o.MaxSize < 0 || o.MaxFiles < 0 || o.SnapLen < 0 {
+		return o, ErrCaptureOptions
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultCaptureMaxSize
+	}
+	if o.MaxFiles == 0 {
+		o.MaxFiles = DefaultCaptureMaxFiles
+	}
+	if o.SnapLen == 0 {
+		o.SnapLen = DefaultCaptureSnapLen
+	}
+	return o, nil
+}
+
+// captureSide is one of the two pcapng interfaces a capture describes.
+type captureSide uint32
+
+const (
+	sideOuter captureSide = iota // the outer sockets, facing the internet
+	sideInner                    // the inner sockets, facing WireGuard
+)
+
+// capture writes one Proxy's packets to a rotating pcapng file.
+type capture struct {
+	opts   CaptureOptions
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	err    error // the first write error; the capture is dead after it
+	buf    []byte
+	closed bool
+}
+
+// openCapture starts a capture at opts.Path, rotating away a file already
+// there so an earlier capture is kept rather than overwritten.
+func openCapture(opts CaptureOptions, logger zerolog.Logger) (*capture, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	c := &capture{opts: opts, logger: logger}
+	if info, err := os.Stat(opts.Path); err == nil && info.Size() > 0 {
+		c.rotateFiles()
+	}
+	if err := c.create(); err != nil {
+		if c.file != nil {
+			_ = c.file.Close()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// create opens a fresh file at the capture's path and writes the section
+// and interface headers.
+func (c *capture) create() error {
+	f, err := os.OpenFile(c.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("wgproxy: open capture: %w", err)
+	}
+	c.file, c.size = f, 0
+
+	b := pcapngBlock(nil, pcapngSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, pcapngByteOrderMagic)
+		b = binary.LittleEndian.AppendUint16(b, 1) // major version
+		b = binary.LittleEndian.AppendUint16(b, 0) // minor version
+		b = binary.LittleEndian.AppendUint64(b, ^uint64(0))
+		b = pcapngOption(b, pcapngOptShbApp, []byte("stunmesh-go wgproxy"))
+		return pcapngOption(b, pcapngOptEnd, nil)
+	})
+	for _, name := range []string{"outer", "inner"} {
+		b = pcapngBlock(b, pcapngInterfaceDesc, func(b []byte) []byte {
+			b = binary.LittleEndian.AppendUint16(b, pcapngLinkTypeRaw)
+			b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+			b = binary.LittleEndian.AppendUint32(b, uint32(ipv6HeaderLen+udpHeaderLen+c.opts.SnapLen))
+			b = pcapngOption(b, pcapngOptIfName, []byte(name))
+			return pcapngOption(b, pcapngOptEnd, nil)
+		})
+	}
+	return c.write(b)
+}
+
+// rotateFiles shifts Path.N-1 to Path.N, and so on down to Path to Path.1;
+// the oldest file beyond MaxFiles is overwritten.
+func (c *capture) rotateFiles() {
+	for i := c.opts.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", c.opts.Path, i), fmt.Sprintf("%s.%d", c.opts.Path, i+1))
+	}
+	_ = os.Rename(c.opts.Path, c.opts.Path+".1")
+}
+
+func (c *capture) write(b []byte) error {
+	n, err := c.file.Write(b)
+	c.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("wgproxy: write capture: %w", err)
+	}
+	return nil
+}
+
+// packet records one packet between src and dst; note says how the proxy
+// classified it.
+func (c *capture) packet(side captureSide, inbound bool, src, dst netip.AddrPort, payload []byte, note string) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.err != nil {
+		return
+	}
+
+	kept := payload[:min(len(payload), c.opts.SnapLen)]
+	c.buf = pcapngBlock(c.buf[:0], pcapngEnhancedPacket, func(b []byte) []byte {
+		us := uint64(now.UnixMicro())
+		b = binary.LittleEndian.AppendUint32(b, uint32(side))
+		b = binary.LittleEndian.AppendUint32(b, uint32(us>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(us))
+		lenAt := len(b)
+		b = binary.LittleEndian.AppendUint32(b, 0) // captured length
+		b = binary.LittleEndian.AppendUint32(b, 0) // original length
+		start := len(b)
+		b = appendIPUDPHeaders(b, src, dst, len(payload))
+		headers := len(b) - start
+		b = append(b, kept...)
+		binary.LittleEndian.PutUint32(b[lenAt:], uint32(len(b)-start))
+		binary.LittleEndian.PutUint32(b[lenAt+4:], uint32(headers+len(payload)))
+		b = pad4(b)
+
+		flags := uint32(pcapngOutbound)
+		if inbound {
+			flags = pcapngInbound
+		}
+		b = pcapngOption(b, pcapngOptEpbFlag, binary.LittleEndian.AppendUint32(nil, flags))
+		if note != "" {
+			b = pcapngOption(b, pcapngOptComment, []byte(note))
+		}
+		return pcapngOption(b, pcapngOptEnd, nil)
+	})
+
+	if c.size+int64(len(c.buf)) > c.opts.MaxSize {
+		_ = c.file.Close()
+		c.file = nil
+		c.rotateFiles()
+		c.err = c.create()
+	}
+	if c.err == nil {
+		c.err = c.write(c.buf)
+	}
+	if c.err != nil {
+		c.logger.Warn().Err(c.err).Str("path", c.opts.Path).Msg("debug capture stopped")
+	}
+}
+
+func (c *capture) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.file == nil {
+		c.closed = true
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+// StartCapture starts writing a debug capture of the proxy's traffic as
+// opts says, replacing a running one.
+func (p *Proxy) StartCapture(opts CaptureOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrProxyClosed
+	}
+	c, err := openCapture(opts, p.logger)
+	if err != nil {
+		return err
+	}
+	if old := p.capture.Swap(c); old != nil {
+		_ = old.close()
+	}
+	p.logger.Info().Str("path", c.opts.Path).Int64("max_size", c.opts.MaxSize).Int("max_files", c.opts.MaxFiles).Int("snaplen", c.opts.SnapLen).Msg("debug capture started")
+	return nil
+}
+
+// StopCapture stops the running debug capture; safe when none runs.
+func (p *Proxy) StopCapture() error {
+	old := p.capture.Swap(nil)
+	if old == nil {
+		return nil
+	}
+	p.logger.Info().Str("path", old.opts.Path).Msg("debug capture stopped")
+	return old.close()
+}
+
+// tap records a packet in the running capture, if any.
+func (p *Proxy) tap(side captureSide, inbound bool, src, dst netip.AddrPort, b []byte, note string) {
+	if c := p.capture.Load(); c != nil {
+		c.packet(side, inbound, src, dst, b, note)
+	}
+}
+
+// tapClassified records an inbound outer packet with its classification.
+func (p *Proxy) tapClassified(src, local netip.AddrPort, b []byte, decision Decision) {
+	if c := p.capture.Load(); c != nil {
+		c.packet(sideOuter, true, src, local, b, decision.note())
+	}
+}
+
+// Capture notes for packets the relay drops after classification.
+const (
+	noteNoInner    = "relay, dropped: no inner socket or WireGuard target"
+	noteNoEndpoint = "relay, dropped: no peer endpoint programmed"
+	noteNoFamily   = "relay, dropped: protocol family not enabled"
+	noteJunk       = "relay, obfuscation junk"
+	noteUnmaskable = "relay, dropped: unmasks to no WireGuard message"
+)
+
+// tapOutbound records a packet WireGuard wrote for ps on both sides, as it
+// leaves on sock for remote: b as written, sent as sent (masked, when the
+// peer's traffic is obfuscated).
+func (p *Proxy) tapOutbound(ps *peerState, sock *outerSocket, remote netip.AddrPort, b, sent []byte) {
+	if c := p.capture.Load(); c != nil {
+		c.packet(sideInner, true, p.wgTarget(), ps.innerAddr, b, "relay")
+		c.packet(sideOuter, false, outerLocal(familyOf(remote), sock.port), remote, sent, "relay")
+	}
+}
+
+// outerLocal is how a capture shows an outer socket's own address: the
+// family's unspecified address, which the socket is bound to, and its port.
+func outerLocal(fam Family, port uint16) netip.AddrPort {
+	if fam == FamilyIPv6 {
+		return netip.AddrPortFrom(netip.IPv6Unspecified(), port)
+	}
+	return netip.AddrPortFrom(netip.IPv4Unspecified(), port)
+}
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+)
+
+// appendIPUDPHeaders appends the IP and UDP headers of a datagram carrying
+// n payload bytes from src to dst. The UDP checksum is left zero; nothing
+// reading a capture needs it.
+func appendIPUDPHeaders(b []byte, src, dst netip.AddrPort, n int) []byte {
+	srcAddr, dstAddr := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcAddr.Is4() && dstAddr.Is4() {
+		start := len(b)
+		b = append(b, 0x45, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(ipv4HeaderLen+udpHeaderLen+n))
+		b = append(b, 0, 0, 0x40, 0, 64, 17, 0, 0) // id, DF, TTL, UDP, checksum
+		b = append(b, srcAddr.AsSlice()...)
+		b = append(b, dstAddr.AsSlice()...)
+		binary.BigEndian.PutUint16(b[start+10:], ipv4Checksum(b[start:]))
+	} else {
+		src16, dst16 := src.Addr().As16(), dst.Addr().As16()
+		b = append(b, 0x60, 0, 0, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(udpHeaderLen+n))
+		b = append(b, 17, 64) // UDP, hop limit
+		b = append(b, src16[:]...)
+		b = append(b, dst16[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+	b = binary.BigEndian.AppendUint16(b, uint16(udpHeaderLen+n))
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pcapngBlock appends a block of type typ whose body body appends.
+func pcapngBlock(b []byte, typ uint32, body func([]byte) []byte) []byte {
+	start := len(b)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = body(b)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	total := uint32(len(b) - start)
+	binary.LittleEndian.PutUint32(b[start+4:], total)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], total)
+	return b
+}
+
+func pcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return pad4(append(b, value...))
+}
+
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
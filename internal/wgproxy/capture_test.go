@@ -0,0 +1,257 @@
+package wgproxy_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
+)
+
+// capturedPacket is one Enhanced Packet Block read back from a capture.
+type capturedPacket struct {
+	iface     uint32
+	data      []byte
+	origLen   int
+	inbound   bool
+	comment   string
+	linkTypes []uint16
+}
+
+// readCapture parses a pcapng file just far enough for the tests: the
+// interface link types and every packet with its flags and comment.
+func readCapture(t *testing.T, path string) []capturedPacket {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(b) < 12 || binary.LittleEndian.Uint32(b) != 0x0A0D0D0A || binary.LittleEndian.Uint32(b[8:]) != 0x1A2B3C4D {
+		t.Fatalf("%s does not start with a little-endian pcapng section header", path)
+	}
+	var (
+		links   []uint16
+		packets []capturedPacket
+	)
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block header")
+		}
+		typ, n := binary.LittleEndian.Uint32(b), int(binary.LittleEndian.Uint32(b[4:]))
+		if n < 12 || n > len(b) || n%4 != 0 || binary.LittleEndian.Uint32(b[n-4:]) != uint32(n) {
+			t.Fatalf("block of type %#x has bad length %d", typ, n)
+		}
+		body := b[8 : n-4]
+		switch typ {
+		case 1:
+			links = append(links, binary.LittleEndian.Uint16(body))
+		case 6:
+			capLen := int(binary.LittleEndian.Uint32(body[12:]))
+			pkt := capturedPacket{
+				iface:     binary.LittleEndian.Uint32(body),
+				data:      body[20 : 20+capLen],
+				origLen:   int(binary.LittleEndian.Uint32(body[16:])),
+				linkTypes: links,
+			}
+			for opts := body[20+(capLen+3)&^3:]; len(opts) >= 4; {
+				code, l := binary.LittleEndian.Uint16(opts), int(binary.LittleEndian.Uint16(opts[2:]))
+				if code == 0 {
+					break
+				}
+				value := opts[4 : 4+l]
+				switch code {
+				case 1:
+					pkt.comment = string(value)
+				case 2:
+					pkt.inbound = binary.LittleEndian.Uint32(value)&3 == 1
+				}
+				opts = opts[4+(l+3)&^3:]
+			}
+			packets = append(packets, pkt)
+		}
+		b = b[n:]
+	}
+	return packets
+}
+
+// waitForCapture polls the capture until a packet with each comment in
+// want is in it.
+func waitForCapture(t *testing.T, path string, want ...string) []capturedPacket {
+	t.Helper()
+	deadline := time.Now().Add(testReadTimeout)
+	for {
+		packets := readCapture(t, path)
+		missing := slices.DeleteFunc(slices.Clone(want), func(comment string) bool {
+			return slices.ContainsFunc(packets, func(p capturedPacket) bool { return p.comment == comment })
+		})
+		if len(missing) == 0 {
+			return packets
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("capture has no packets annotated %q", missing)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxy_Capture_AnnotatesAndTruncates(t *testing.T) {
+	p := newTestProxy(t)
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	strangerConn, _ := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	peer := testPeerKey(0x01)
+	innerAddr, err := p.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(peer, remoteAddr)
+
+	path := filepath.Join(t.TempDir(), "wg0.pcapng")
+	if err := p.StartCapture(wgproxy.CaptureOptions{Path: path, SnapLen: 16}); err != nil {
+		t.Fatalf("StartCapture: %v", err)
+	}
+
+	outer := proxyOuterAddr(t, p, wgproxy.FamilyIPv4)
+	if _, err := remoteConn.WriteToUDPAddrPort(wgMessage(1, 148), outer); err != nil {
+		t.Fatalf("remote write: %v", err)
+	}
+	readPacket(t, wgConn)
+	if _, err := wgConn.WriteToUDPAddrPort(wgMessage(4, 96), innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	readPacket(t, remoteConn)
+	if _, err := strangerConn.WriteToUDPAddrPort(wgMessage(4, 96), outer); err != nil {
+		t.Fatalf("stranger write: %v", err)
+	}
+
+	packets := waitForCapture(t, path, "relay", "drop, dropped: unattributable source")
+	if err := p.StopCapture(); err != nil {
+		t.Fatalf("StopCapture: %v", err)
+	}
+
+	var relayed [2][2]int // [iface][inbound]
+	for _, pkt := range packets {
+		if want := []uint16{101, 101}; !slices.Equal(pkt.linkTypes, want) {
+			t.Fatalf("interface link types = %v, want raw IP for both", pkt.linkTypes)
+		}
+		// 20 bytes of IPv4 header and 8 of UDP precede the payload.
+		if len(pkt.data) != 28+16 {
+			t.Errorf("captured %d bytes, want headers and the 16-byte snap length", len(pkt.data))
+		}
+		if pkt.data[0] != 0x45 || pkt.data[9] != 17 {
+			t.Errorf("packet does not start with an IPv4 UDP header: %x", pkt.data[:20])
+		}
+		if got := int(binary.BigEndian.Uint16(pkt.data[2:])); got != pkt.origLen {
+			t.Errorf("IPv4 total length %d, want the original length %d", got, pkt.origLen)
+		}
+		if pkt.comment == "relay" {
+			inbound := 0
+			if pkt.inbound {
+				inbound = 1
+			}
+			relayed[pkt.iface][inbound]++
+		}
+	}
+	// Each relayed packet shows once on each side: the inbound handshake
+	// comes in outside and goes out inside, the data packet the other way.
+	if relayed != [2][2]int{{1, 1}, {1, 1}} {
+		t.Errorf("relay packets by [interface][inbound] = %v, want one each", relayed)
+	}
+}
+
+func TestProxy_Capture_RotatesAndKeepsBoundedFiles(t *testing.T) {
+	p := newTestProxy(t)
+	strangerConn, _ := newLoopbackConn(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg0.pcapng")
+	// An earlier capture at the path is rotated away, not overwritten.
+	if err := os.WriteFile(path, []byte("earlier"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	const maxSize = 1024
+	if err := p.StartCapture(wgproxy.CaptureOptions{Path: path, MaxSize: maxSize, MaxFiles: 2}); err != nil {
+		t.Fatalf("StartCapture: %v", err)
+	}
+	if b, err := os.ReadFile(path + ".1"); err != nil || string(b) != "earlier" {
+		t.Fatalf("earlier capture = %q, %v; want it rotated to .1", b, err)
+	}
+
+	outer := proxyOuterAddr(t, p, wgproxy.FamilyIPv4)
+	for range 100 {
+		if _, err := strangerConn.WriteToUDPAddrPort(wgMessage(4, 96), outer); err != nil {
+			t.Fatalf("stranger write: %v", err)
+		}
+	}
+	deadline := time.Now().Add(testReadTimeout)
+	for p.Stats().DroppedUnattributable < 100 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The last drop's tap follows its count; the capture lock orders it
+	// before StopCapture's close at the latest.
+	time.Sleep(50 * time.Millisecond)
+	if err := p.StopCapture(); err != nil {
+		t.Fatalf("StopCapture: %v", err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", filepath.Base(name), err)
+		}
+		if info.Size() > maxSize {
+			t.Errorf("%s is %d bytes, want at most %d", filepath.Base(name), info.Size(), maxSize)
+		}
+		readCapture(t, name)
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(.3) = %v, want no file beyond MaxFiles", err)
+	}
+}
+
+func TestProxy_StartCapture_InvalidOptions(t *testing.T) {
+	p := newTestProxy(t)
+	if err := p.StartCapture(wgproxy.CaptureOptions{}); !errors.Is(err, wgproxy.ErrCaptureOptions) {
+		t.Fatalf("StartCapture without a path = %v, want ErrCaptureOptions", err)
+	}
+}
+
+// A capture asked of the manager starts with the device's proxy and
+// follows it across Rebind.
+func TestManagerCapture_FollowsTheDevicesProxy(t *testing.T) {
+	m := newTestManager(t)
+	path := filepath.Join(t.TempDir(), "wg0.pcapng")
+
+	if err := m.Capture("wg0", &wgproxy.CaptureOptions{Path: path}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat = %v, want no capture before the proxy exists", err)
+	}
+	if _, err := m.For("wg0", ipv4Families()); err != nil {
+		t.Fatalf("For: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("capture not started with the proxy: %v", err)
+	}
+	if _, err := m.Rebind("wg0", ipv4Families()); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("capture not restarted on the rebound proxy: %v", err)
+	}
+
+	if err := m.Capture("wg0", nil); err != nil {
+		t.Fatalf("Capture(nil): %v", err)
+	}
+	if _, err := m.Rebind("wg0", ipv4Families()); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if _, err := os.Stat(path + ".2"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat(.2) = %v, want a stopped capture to stay stopped", err)
+	}
+}
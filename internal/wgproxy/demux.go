@@ -45,10 +45,34 @@ const (
 )
 
 // Decision is the outcome of classifying one inbound packet. Peer is set only
-// when Bucket is BucketRelay.
+// when Bucket is BucketRelay; Dropped says why a packet was dropped, with
+// BucketDrop and with a STUN-shaped packet no transaction was waiting for.
 type Decision struct {
-	Bucket Bucket
-	Peer   PeerKey
+	Bucket  Bucket
+	Peer    PeerKey
+	Dropped string
+}
+
+// String names the bucket, as debug captures annotate packets with it.
+func (b Bucket) String() string {
+	switch b {
+	case BucketSTUN:
+		return "stun"
+	case BucketRelay:
+		return "relay"
+	case BucketDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
+
+// note is how a debug capture annotates the classified packet.
+func (d Decision) note() string {
+	if d.Dropped != "" {
+		return d.Bucket.String() + ", dropped: " + d.Dropped
+	}
+	return d.Bucket.String()
 }
 
 // TxnRegistry routes binding responses to the waiting goroutine. Timeouts are
@@ -92,9 +116,10 @@ func (r *TxnRegistry) Unregister(id TxnID) {
 }
 
 // route delivers a STUN-shaped packet to its waiter, false when unmatched.
+// Binding responses and relay node allocation responses are routed.
 func (r *TxnRegistry) route(src netip.AddrPort, b []byte) bool {
 	msgType := binary.BigEndian.Uint16(b[0:2])
-	if msgType != stunBindingSuccess && msgType != stunBindingError {
+	if msgType != stunBindingSuccess && msgType != stunBindingError && !isRelayResponse(msgType) {
 		return false
 	}
 	var id TxnID
@@ -119,17 +144,26 @@ func (r *TxnRegistry) route(src netip.AddrPort, b []byte) bool {
 
 // Demux classifies outer-socket packets: STUN-shaped, programmed peer relay,
 // or drop. Mappings change only via Program/Unprogram — never learned from
-// inbound source addresses.
+// inbound source addresses, except through the roaming of roam.go once
+// WireGuard confirms a peer's new source.
 type Demux struct {
 	txns   *TxnRegistry
 	logger zerolog.Logger
 
+	// roam is nil unless the proxy roams; set before any Classify.
+	roam   *roamState
+	roamed atomic.Uint64
+
 	mu        sync.RWMutex
 	peerBySrc map[netip.AddrPort]PeerKey
 	srcByPeer map[PeerKey]netip.AddrPort
+	// obfs holds the obfuscators of peers whose packets may be masked, for
+	// roaming to attribute them (obfs.go).
+	obfs map[PeerKey]*obfuscator
 
 	droppedSTUN  atomic.Uint64
 	droppedOther atomic.Uint64
+	dropSources  dropSources
 }
 
 func NewDemux(logger *zerolog.Logger) *Demux {
@@ -138,6 +172,7 @@ func NewDemux(logger *zerolog.Logger) *Demux {
 		logger:    logger.With().Str("component", "wgproxy.demux").Logger(),
 		peerBySrc: make(map[netip.AddrPort]PeerKey),
 		srcByPeer: make(map[PeerKey]netip.AddrPort),
+		obfs:      make(map[PeerKey]*obfuscator),
 	}
 }
 
@@ -156,6 +191,9 @@ func (d *Demux) Program(peer PeerKey, remote netip.AddrPort) {
 	}
 	d.srcByPeer[peer] = remote
 	d.peerBySrc[remote] = peer
+	if d.roam != nil {
+		d.roam.reprogrammed(peer)
+	}
 }
 
 // Unprogram removes a peer's mapping; safe for an unknown peer.
@@ -166,6 +204,9 @@ func (d *Demux) Unprogram(peer PeerKey) {
 		delete(d.peerBySrc, old)
 		delete(d.srcByPeer, peer)
 	}
+	if d.roam != nil {
+		d.roam.forget(peer)
+	}
 }
 
 // Classify buckets one packet. b is only read during the call — routed STUN
@@ -174,6 +215,7 @@ func (d *Demux) Classify(src netip.AddrPort, b []byte) Decision {
 	if isSTUNShaped(b) {
 		if !d.txns.route(src, b) {
 			d.countDrop(&d.droppedSTUN, src, "dropped unmatched STUN-shaped packet")
+			return Decision{Bucket: BucketSTUN, Dropped: "no matching transaction"}
 		}
 		return Decision{Bucket: BucketSTUN}
 	}
@@ -182,11 +224,19 @@ func (d *Demux) Classify(src netip.AddrPort, b []byte) Decision {
 	peer, ok := d.peerBySrc[normalize(src)]
 	d.mu.RUnlock()
 	if ok {
+		if d.roam != nil {
+			d.roamMapped(peer, b)
+		}
 		return Decision{Bucket: BucketRelay, Peer: peer}
 	}
+	if d.roam != nil {
+		if peer, ok := d.roamInbound(normalize(src), b); ok {
+			return Decision{Bucket: BucketRelay, Peer: peer}
+		}
+	}
 
 	d.countDrop(&d.droppedOther, src, "dropped unattributable packet")
-	return Decision{Bucket: BucketDrop}
+	return Decision{Bucket: BucketDrop, Dropped: "unattributable source"}
 }
 
 // DroppedSTUN reports how many STUN-shaped packets were dropped unmatched.
@@ -199,8 +249,16 @@ func (d *Demux) DroppedUnattributable() uint64 {
 	return d.droppedOther.Load()
 }
 
-// countDrop logs rate-limited: the first drop, then every 1024th.
+// Roamed reports how many times a peer was re-programmed to a new source by
+// roaming.
+func (d *Demux) Roamed() uint64 {
+	return d.roamed.Load()
+}
+
+// countDrop counts a drop, also against its source, and logs rate-limited:
+// the first drop, then every 1024th.
 func (d *Demux) countDrop(counter *atomic.Uint64, src netip.AddrPort, msg string) {
+	d.dropSources.add(normalize(src))
 	n := counter.Add(1)
 	if n == 1 || n%1024 == 0 {
 		d.logger.Warn().Uint64("count", n).Str("src", src.String()).Msg(msg)
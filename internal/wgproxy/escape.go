@@ -29,9 +29,6 @@ type escapeOptions struct {
 	tunnelIfaces routeprobe.TunnelInterfaces
 }
 
-// Option configures optional Proxy construction behavior.
-type Option func(*escapeOptions)
-
 // WithEscape enables the tunnel-escape hook for every outer socket New
 // creates. firewallMark is the WireGuard device's own fwmark (0 means none
 // configured), used by the linux hook. fib is the freebsd analog: the
@@ -39,10 +36,8 @@ type Option func(*escapeOptions)
 // means none configured, matching firewallMark's convention). tunnelIfaces
 // limits routeprobe detection to interfaces stunmesh manages.
 func WithEscape(firewallMark int, fib int, tunnelIfaces routeprobe.TunnelInterfaces) Option {
-	return func(o *escapeOptions) {
-		o.firewallMark = firewallMark
-		o.fib = fib
-		o.tunnelIfaces = tunnelIfaces
+	return func(o *options) {
+		o.escape = escapeOptions{firewallMark: firewallMark, fib: fib, tunnelIfaces: tunnelIfaces}
 	}
 }
 
@@ -1,9 +1,12 @@
-// Process-level Manager: one memoized Proxy per WireGuard interface.
+// Process-level Manager: one memoized Proxy per WireGuard interface, the
+// relay nodes of the interfaces serving as one, and the debug captures
+// asked of their proxies.
 package wgproxy
 
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"sync"
 
 	"github.com/rs/zerolog"
@@ -21,21 +24,28 @@ type Manager struct {
 
 	mu      sync.Mutex
 	proxies map[string]*Proxy
-	closed  bool
+	relays  map[string]*RelayNode
+	// captures holds the capture asked for each device, started on every
+	// proxy the device gets.
+	captures map[string]CaptureOptions
+	closed   bool
 }
 
 // NewManager creates an empty Manager.
 func NewManager(logger *zerolog.Logger) *Manager {
 	return &Manager{
-		logger:  logger.With().Str("component", "wgproxy.manager").Logger(),
-		proxies: make(map[string]*Proxy),
+		logger:   logger.With().Str("component", "wgproxy.manager").Logger(),
+		proxies:  make(map[string]*Proxy),
+		relays:   make(map[string]*RelayNode),
+		captures: make(map[string]CaptureOptions),
 	}
 }
 
 // For returns the device's proxy, creating it on the first call; later calls
 // ignore families and opts — sockets are bound exactly once, ports never
-// change, and escape configuration is fixed at creation. After Close it
-// errors: a freshly-bound proxy would silently rotate published ports.
+// change, and escape configuration is fixed at creation (Rebind is the one
+// deliberate exception). After Close it errors: a freshly-bound proxy would
+// silently rotate published ports.
 func (m *Manager) For(deviceName string, families map[Family]uint16, opts ...Option) (*Proxy, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -50,10 +60,61 @@ func (m *Manager) For(deviceName string, families map[Family]uint16, opts ...Opt
 		return nil, err
 	}
 	m.proxies[deviceName] = p
+	m.startCapture(deviceName, p)
 	m.logger.Info().Str("device", deviceName).Msg("proxy created")
 	return p, nil
 }
 
+// Rebind replaces the device's proxy with one bound for families and opts,
+// for when the device's families or ports change. The old proxy is closed
+// first, so a pinned port is free to rebind; its WG target, peers, their
+// endpoints and obfuscation carry over, but inner sockets are new — callers
+// must re-point WireGuard at each peer's new inner address. On error the
+// device is left without a proxy and the next For creates one.
+func (m *Manager) Rebind(deviceName string, families map[Family]uint16, opts ...Option) (*Proxy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	old, ok := m.proxies[deviceName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProxyNotReady, deviceName)
+	}
+	delete(m.proxies, deviceName)
+	endpoints := make(map[PeerKey]netip.AddrPort)
+	peers := old.Peers()
+	for _, key := range peers {
+		if remote, ok := old.PeerEndpoint(key); ok {
+			endpoints[key] = remote
+		}
+	}
+	obfs, obfsPeers := old.obfuscation()
+	if err := old.Close(); err != nil {
+		m.logger.Warn().Err(err).Str("device", deviceName).Msg("closing replaced proxy failed")
+	}
+
+	p, err := New(&m.logger, families, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.wgPort.Store(old.wgPort.Load())
+	for _, key := range peers {
+		if _, err := p.AddPeer(key); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		if remote, ok := endpoints[key]; ok {
+			p.SetPeerEndpoint(key, remote)
+		}
+	}
+	p.restoreObfuscation(obfs, obfsPeers)
+	m.proxies[deviceName] = p
+	m.startCapture(deviceName, p)
+	m.logger.Info().Str("device", deviceName).Msg("proxy rebound for changed families or ports")
+	return p, nil
+}
+
 // Get returns the existing proxy without creating one — only For binds sockets.
 func (m *Manager) Get(deviceName string) (*Proxy, error) {
 	m.mu.Lock()
@@ -68,7 +129,90 @@ func (m *Manager) Get(deviceName string) (*Proxy, error) {
 	return p, nil
 }
 
-// Close closes every proxy; process-shutdown only, idempotent.
+// RelayNode returns the device's relay node, creating it on the first call
+// and re-creating it when port changes.
+func (m *Manager) RelayNode(deviceName string, port uint16) (*RelayNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	if n, ok := m.relays[deviceName]; ok {
+		if port == 0 || n.Port() == port {
+			return n, nil
+		}
+		delete(m.relays, deviceName)
+		if err := n.Close(); err != nil {
+			m.logger.Warn().Err(err).Str("device", deviceName).Msg("closing replaced relay node failed")
+		}
+	}
+	n, err := NewRelayNode(&m.logger, port)
+	if err != nil {
+		return nil, err
+	}
+	m.relays[deviceName] = n
+	m.logger.Info().Str("device", deviceName).Msg("relay node created")
+	return n, nil
+}
+
+// Capture starts a debug capture of the device's proxy traffic as opts
+// says, or stops it for nil opts. The capture is remembered for the device:
+// it starts with a proxy For creates later and carries over Rebind.
+// Unchanged opts leave a running capture alone.
+func (m *Manager) Capture(deviceName string, opts *CaptureOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrManagerClosed
+	}
+	p := m.proxies[deviceName]
+	if opts == nil {
+		delete(m.captures, deviceName)
+		if p != nil {
+			return p.StopCapture()
+		}
+		return nil
+	}
+	if _, err := opts.withDefaults(); err != nil {
+		return err
+	}
+	if current, ok := m.captures[deviceName]; ok && current == *opts {
+		return nil
+	}
+	if p != nil {
+		if err := p.StartCapture(*opts); err != nil {
+			return err
+		}
+	}
+	m.captures[deviceName] = *opts
+	return nil
+}
+
+// startCapture starts the device's remembered capture on a new proxy; a
+// capture that fails to start costs only the capture.
+func (m *Manager) startCapture(deviceName string, p *Proxy) {
+	opts, ok := m.captures[deviceName]
+	if !ok {
+		return
+	}
+	if err := p.StartCapture(opts); err != nil {
+		m.logger.Warn().Err(err).Str("device", deviceName).Msg("failed to start debug capture")
+	}
+}
+
+// Stats snapshots every proxy's counters, keyed by device name.
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]Stats, len(m.proxies))
+	for name, p := range m.proxies {
+		stats[name] = p.Stats()
+	}
+	return stats
+}
+
+// Close closes every proxy and relay node; process-shutdown only,
+// idempotent.
 func (m *Manager) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -83,5 +227,11 @@ func (m *Manager) Close() error {
 		}
 		delete(m.proxies, name)
 	}
+	for name, n := range m.relays {
+		if err := n.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(m.relays, name)
+	}
 	return errors.Join(errs...)
 }
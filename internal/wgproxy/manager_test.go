@@ -1,7 +1,9 @@
 package wgproxy_test
 
 import (
+	"bytes"
 	"errors"
+	"net"
 	"sync"
 	"testing"
 
@@ -163,3 +165,99 @@ func TestManagerGet_AfterCloseReturnsClosed(t *testing.T) {
 		t.Fatalf("expected ErrManagerClosed, got %v", err)
 	}
 }
+
+// freeUDPPort returns a loopback UDP port that was free a moment ago.
+func freeUDPPort(t *testing.T) uint16 {
+	t.Helper()
+	conn, _ := newLoopbackConn(t)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	_ = conn.Close()
+	return uint16(port)
+}
+
+func TestManagerRebind_CarriesPeersOver(t *testing.T) {
+	m := newTestManager(t)
+	old, err := m.For("wg0", ipv4Families())
+	if err != nil {
+		t.Fatalf("For: %v", err)
+	}
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	old.SetWGTarget(wgAddr.Port())
+	withEndpoint, idle := testPeerKey(0x61), testPeerKey(0x62)
+	for _, key := range []wgproxy.PeerKey{withEndpoint, idle} {
+		if _, err := old.AddPeer(key); err != nil {
+			t.Fatalf("AddPeer: %v", err)
+		}
+	}
+	old.SetPeerEndpoint(withEndpoint, remoteAddr)
+
+	pinned := freeUDPPort(t)
+	families := map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: pinned}
+	p, err := m.Rebind("wg0", families, wgproxy.WithBatching(false))
+	if err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if p == old {
+		t.Fatal("Rebind must return a new proxy")
+	}
+	if got := p.OuterPort(wgproxy.FamilyIPv4); got != pinned {
+		t.Fatalf("rebound outer port = %d, want %d", got, pinned)
+	}
+	if got, _ := m.Get("wg0"); got != p {
+		t.Fatal("Get must return the rebound proxy")
+	}
+	if _, err := old.AddPeer(testPeerKey(0x63)); !errors.Is(err, wgproxy.ErrProxyClosed) {
+		t.Fatalf("old proxy AddPeer = %v, want ErrProxyClosed", err)
+	}
+	if got := len(p.Peers()); got != 2 {
+		t.Fatalf("rebound proxy has %d peers, want 2", got)
+	}
+	if got, ok := p.PeerEndpoint(withEndpoint); !ok || got != remoteAddr {
+		t.Fatalf("PeerEndpoint = %s, %v; want %s", got, ok, remoteAddr)
+	}
+	if _, ok := p.PeerEndpoint(idle); ok {
+		t.Fatal("a peer without an endpoint must not gain one")
+	}
+
+	// The WG target carried over: relay still reaches WireGuard.
+	packet := wgMessage(4, 64)
+	if _, err := remoteConn.WriteToUDPAddrPort(packet, proxyOuterAddr(t, p, wgproxy.FamilyIPv4)); err != nil {
+		t.Fatalf("remote write: %v", err)
+	}
+	if got, _ := readPacket(t, wgConn); !bytes.Equal(got, packet) {
+		t.Fatal("relayed packet mismatch after Rebind")
+	}
+}
+
+func TestManagerRebind_UnknownDevice(t *testing.T) {
+	m := newTestManager(t)
+	if _, err := m.Rebind("wg0", ipv4Families()); !errors.Is(err, wgproxy.ErrProxyNotReady) {
+		t.Fatalf("expected ErrProxyNotReady, got %v", err)
+	}
+}
+
+func TestManagerStats_PerDevice(t *testing.T) {
+	m := newTestManager(t)
+	p, err := m.For("wg0", ipv4Families())
+	if err != nil {
+		t.Fatalf("For: %v", err)
+	}
+	if _, err := p.AddPeer(testPeerKey(0x71)); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	if _, err := m.For("wg1", ipv4Families()); err != nil {
+		t.Fatalf("For: %v", err)
+	}
+
+	stats := m.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Stats() has %d devices, want 2", len(stats))
+	}
+	if got := len(stats["wg0"].Peers); got != 1 {
+		t.Fatalf("wg0 has %d peers, want 1", got)
+	}
+	if got := len(stats["wg1"].Peers); got != 0 {
+		t.Fatalf("wg1 has %d peers, want 0", got)
+	}
+}
@@ -0,0 +1,357 @@
+// Obfuscation: optional masking of the WireGuard packets the proxy relays
+// to a peer, for networks that fingerprint and block WireGuard by its fixed
+// message types and sizes (SetObfuscation, SetPeerObfuscated).
+//
+// Both ends derive the same per-peer key from their WireGuard keys. A
+// masked packet is a random nonce, then the padding length and the start
+// of the WireGuard packet XORed with an AES keystream of that nonce, then
+// the rest of the packet and random padding:
+//
+//	nonce[8] | mask(padLen[1] | packet[:31]) | packet[31:] | padding[padLen]
+//
+// so no byte of the header is fixed, and handshake messages and short
+// packets lose their telltale sizes. A masked packet with no WireGuard
+// packet in it is junk, which peers may send ahead of a handshake
+// initiation and the receiver drops. Nonces that would leave a packet
+// looking like a WireGuard or STUN message are redrawn, so masked and plain
+// packets never mix up: a proxy that masks for a peer still takes its
+// plain packets, and turning obfuscation on needs no lockstep.
+//
+// This is camouflage, not security — WireGuard's own cryptography is
+// untouched, and the mask only has to outlast a middlebox's glance.
+package wgproxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"math/rand/v2"
+	"net/netip"
+)
+
+const (
+	obfsKeyLabel = "stunmesh-go wgproxy obfuscation v1"
+
+	obfsNonceLen = 8
+	// obfsMaskLen bytes after the nonce are masked: the padding length and
+	// the WireGuard header, its type, indexes and counter.
+	obfsMaskLen = 2 * aes.BlockSize
+	// obfsOverhead is what masking adds to a packet before padding.
+	obfsOverhead = obfsNonceLen + 1
+
+	// Packets shorter than obfsPadBelow, handshake messages among them,
+	// get up to obfsMaxPadding random bytes; longer ones none, to stay
+	// inside the path MTU.
+	obfsPadBelow   = 256
+	obfsMaxPadding = 64
+	// obfsMaxJunkLen bounds a junk packet's random padding.
+	obfsMaxJunkLen = 255
+
+	// MaxJunkPackets bounds ObfuscationOptions.Junk.
+	MaxJunkPackets = 16
+)
+
+// ObfuscationOptions tunes obfuscation.
+type ObfuscationOptions struct {
+	// Junk is how many junk packets precede each handshake initiation sent
+	// to a peer; 0 sends none. At most MaxJunkPackets.
+	Junk int
+}
+
+// obfsConfig is the proxy's obfuscation setting: the device's private key
+// every per-peer key derives from, and the options.
+type obfsConfig struct {
+	private PeerKey
+	opts    ObfuscationOptions
+}
+
+// obfuscator masks and unmasks the packets of one peer.
+type obfuscator struct {
+	block cipher.Block
+	junk  int
+}
+
+// newObfuscator derives the obfuscator between a private key and a peer's
+// public key; both ends derive the same one.
+func newObfuscator(private, public PeerKey, opts ObfuscationOptions) (*obfuscator, error) {
+	key, err := deriveKey(obfsKeyLabel, private, public)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &obfuscator{block: block, junk: min(max(opts.Junk, 0), MaxJunkPackets)}, nil
+}
+
+// mask returns the keystream for nonce.
+func (o *obfuscator) mask(nonce []byte) [obfsMaskLen]byte {
+	var in [aes.BlockSize]byte
+	var m [obfsMaskLen]byte
+	copy(in[:], nonce)
+	o.block.Encrypt(m[:aes.BlockSize], in[:])
+	in[aes.BlockSize-1] = 1
+	o.block.Encrypt(m[aes.BlockSize:], in[:])
+	return m
+}
+
+// seal appends packet, masked and padded with padding random bytes, to dst.
+func (o *obfuscator) seal(dst, packet []byte, padding int) []byte {
+	start := len(dst)
+	dst = append(dst, make([]byte, obfsOverhead)...)
+	dst = append(dst, packet...)
+	for range padding {
+		dst = append(dst, byte(rand.Uint32()))
+	}
+	out := dst[start:]
+	out[obfsNonceLen] = byte(padding)
+	region := out[obfsNonceLen:min(len(out), obfsNonceLen+obfsMaskLen)]
+	var plain [obfsMaskLen]byte
+	copy(plain[:], region)
+	for {
+		binary.LittleEndian.PutUint64(out, rand.Uint64())
+		m := o.mask(out[:obfsNonceLen])
+		for i := range region {
+			region[i] = plain[i] ^ m[i]
+		}
+		if wgMessageType(out) == 0 && !isSTUNShaped(out) {
+			return dst
+		}
+	}
+}
+
+// sealed is a masked packet's header, unmasked without changing the packet.
+type sealed struct {
+	head [obfsMaskLen]byte
+	// size is the WireGuard packet's length; 0 for junk.
+	size int
+}
+
+// open reads b as a masked packet; false when it cannot be one.
+func (o *obfuscator) open(b []byte) (sealed, bool) {
+	if len(b) < obfsOverhead {
+		return sealed{}, false
+	}
+	var s sealed
+	m := o.mask(b[:obfsNonceLen])
+	region := b[obfsNonceLen:min(len(b), obfsNonceLen+obfsMaskLen)]
+	for i := range region {
+		s.head[i] = region[i] ^ m[i]
+	}
+	s.size = len(b) - obfsOverhead - int(s.head[0])
+	return s, s.size >= 0
+}
+
+// messageType is the unmasked packet's WireGuard message type, 0 for junk
+// or when it is not shaped like one.
+func (s *sealed) messageType() byte {
+	if s.size == 0 {
+		return 0
+	}
+	return wgMessageTypeOf(s.head[1:], s.size)
+}
+
+// unmask writes s's header back over b and returns the WireGuard packet.
+func (s *sealed) unmask(b []byte) []byte {
+	copy(b[obfsNonceLen:min(len(b), obfsNonceLen+obfsMaskLen)], s.head[:])
+	return b[obfsOverhead : obfsOverhead+s.size]
+}
+
+// padding draws how many random bytes pad packet.
+func padding(packet []byte) int {
+	if len(packet) >= obfsPadBelow {
+		return 0
+	}
+	return rand.IntN(obfsMaxPadding + 1)
+}
+
+// SetObfuscation turns obfuscation on for the proxy's peers with keys
+// derived from the device's private key, or off for nil opts. Either way
+// packets to a peer are masked only once SetPeerObfuscated says the peer
+// obfuscates too; masked packets from any peer are taken while on.
+func (p *Proxy) SetObfuscation(private PeerKey, opts *ObfuscationOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var cfg *obfsConfig
+	if opts != nil {
+		cfg = &obfsConfig{private: private, opts: *opts}
+	}
+	if (cfg == nil && p.obfs == nil) || (cfg != nil && p.obfs != nil && *cfg == *p.obfs) {
+		return
+	}
+	p.obfs = cfg
+	for _, ps := range p.peers {
+		p.setObfuscatorLocked(ps)
+	}
+}
+
+// setObfuscatorLocked derives ps's obfuscator from the proxy's setting; a
+// peer key no obfuscator derives from leaves the peer unmasked.
+func (p *Proxy) setObfuscatorLocked(ps *peerState) {
+	var o *obfuscator
+	if p.obfs != nil {
+		var err error
+		if o, err = newObfuscator(p.obfs.private, ps.key, p.obfs.opts); err != nil {
+			p.logger.Warn().Err(err).Msg("cannot derive obfuscation key for peer, its packets stay unmasked")
+		}
+	}
+	ps.obfs.Store(o)
+	p.demux.setObfuscator(ps.key, o)
+}
+
+// SetPeerObfuscated says whether the peer obfuscates too, so packets to it
+// are masked while the proxy obfuscates; safe for an unknown peer.
+func (p *Proxy) SetPeerObfuscated(key PeerKey, enabled bool) {
+	p.mu.RLock()
+	ps := p.peers[key]
+	p.mu.RUnlock()
+	if ps != nil {
+		ps.obfsPeer.Store(enabled)
+	}
+}
+
+// PeerObfuscated reports whether packets to the peer are masked.
+func (p *Proxy) PeerObfuscated(key PeerKey) bool {
+	p.mu.RLock()
+	ps := p.peers[key]
+	p.mu.RUnlock()
+	return ps != nil && ps.sendObfuscator() != nil
+}
+
+// sendObfuscator returns the obfuscator packets to ps are masked with; nil
+// sends them plain.
+func (ps *peerState) sendObfuscator() *obfuscator {
+	if !ps.obfsPeer.Load() {
+		return nil
+	}
+	return ps.obfs.Load()
+}
+
+// inboundPacket is a packet from a peer, masked or plain.
+type inboundPacket struct {
+	sealed sealed
+	masked bool
+}
+
+// packet returns the WireGuard packet in b: b itself, or b unmasked in
+// place.
+func (in *inboundPacket) packet(b []byte) []byte {
+	if !in.masked {
+		return b
+	}
+	return in.sealed.unmask(b)
+}
+
+// inbound reads b, which came from ps's peer, without changing it. ok is
+// false for junk and for packets that unmask to no WireGuard message; note
+// is how captures annotate b either way.
+func (ps *peerState) inbound(b []byte) (in inboundPacket, note string, ok bool) {
+	o := ps.obfs.Load()
+	if o == nil || wgMessageType(b) != 0 {
+		return in, "relay", true
+	}
+	s, ok := o.open(b)
+	switch {
+	case ok && s.size == 0:
+		return in, noteJunk, false
+	case !ok || s.messageType() == 0:
+		return in, noteUnmaskable, false
+	}
+	return inboundPacket{sealed: s, masked: true}, "relay", true
+}
+
+// outbound returns what to send for packet to ps's peer: packet itself, or
+// packet masked into buf, after junk ahead of a handshake initiation.
+func (p *Proxy) outbound(ps *peerState, sock *outerSocket, remote netip.AddrPort, packet, buf []byte) []byte {
+	o := ps.sendObfuscator()
+	if o == nil {
+		return packet
+	}
+	if o.junk > 0 && wgMessageType(packet) == wgMessageInitiation {
+		junk := make([]byte, 0, obfsOverhead+obfsMaxJunkLen)
+		for range o.junk {
+			junk = o.seal(junk[:0], nil, rand.IntN(obfsMaxJunkLen+1))
+			p.tap(sideOuter, false, outerLocal(familyOf(remote), sock.port), remote, junk, noteJunk)
+			if _, err := sock.conn.WriteToUDPAddrPort(junk, remote); err != nil {
+				p.countWarn(&p.writeErrs, "outer write failed")
+			}
+		}
+	}
+	return o.seal(buf[:0], packet, padding(packet))
+}
+
+// setObfuscator sets the obfuscator a peer's packets unmask with when they
+// come from a source roaming has yet to attribute; nil removes it.
+func (d *Demux) setObfuscator(peer PeerKey, o *obfuscator) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if o == nil {
+		delete(d.obfs, peer)
+		return
+	}
+	d.obfs[peer] = o
+}
+
+// roamInbound is roam.inbound for a packet that may be masked: each
+// peer's obfuscator is tried on it, and a roam candidate attributed to the
+// peer whose key unmasks it to a WireGuard message naming that peer.
+func (d *Demux) roamInbound(src netip.AddrPort, b []byte) (PeerKey, bool) {
+	if wgMessageType(b) != 0 {
+		return d.roam.inbound(src, b)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for peer, o := range d.obfs {
+		s, ok := o.open(b)
+		if !ok || s.messageType() == 0 {
+			continue
+		}
+		// A copy: the packet is unmasked in place only once relayed.
+		packet := s.unmask(append([]byte(nil), b...))
+		if got, ok := d.roam.inbound(src, packet); ok && got == peer {
+			return peer, true
+		}
+	}
+	return PeerKey{}, false
+}
+
+// roamMapped is roam.mapped for a packet that may be masked with peer's
+// obfuscator; the header a masked packet's sealed holds is enough.
+func (d *Demux) roamMapped(peer PeerKey, b []byte) {
+	if wgMessageType(b) != 0 {
+		d.roam.mapped(peer, b, len(b))
+		return
+	}
+	d.mu.RLock()
+	o := d.obfs[peer]
+	d.mu.RUnlock()
+	if o == nil {
+		return
+	}
+	if s, ok := o.open(b); ok {
+		d.roam.mapped(peer, s.head[1:], s.size)
+	}
+}
+
+// obfuscation snapshots the proxy's obfuscation setting and the peers that
+// obfuscate too, for Manager.Rebind to carry over.
+func (p *Proxy) obfuscation() (*obfsConfig, map[PeerKey]bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make(map[PeerKey]bool, len(p.peers))
+	for key, ps := range p.peers {
+		peers[key] = ps.obfsPeer.Load()
+	}
+	return p.obfs, peers
+}
+
+// restoreObfuscation applies an obfuscation snapshot.
+func (p *Proxy) restoreObfuscation(cfg *obfsConfig, peers map[PeerKey]bool) {
+	if cfg != nil {
+		p.SetObfuscation(cfg.private, &cfg.opts)
+	}
+	for key, enabled := range peers {
+		p.SetPeerObfuscated(key, enabled)
+	}
+}
@@ -0,0 +1,162 @@
+package wgproxy_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
+)
+
+// obfsEnd is one side of an obfuscated link: a proxy, the WireGuard socket
+// behind it, and its key pair.
+type obfsEnd struct {
+	proxy   *wgproxy.Proxy
+	wg      *net.UDPConn
+	private wgproxy.PeerKey
+	public  wgproxy.PeerKey
+}
+
+func newObfsEnd(t *testing.T, opts ...wgproxy.Option) *obfsEnd {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	p := newTestProxy(t, opts...)
+	wgConn, wgAddr := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	return &obfsEnd{
+		proxy:   p,
+		wg:      wgConn,
+		private: wgproxy.PeerKey(key.Bytes()),
+		public:  wgproxy.PeerKey(key.PublicKey().Bytes()),
+	}
+}
+
+// obfsLink connects a to b through wire, a socket the test reads to see
+// what goes over the network and forwards on to b by hand. It returns the
+// address a's WireGuard sends to.
+func obfsLink(t *testing.T, a, b *obfsEnd, wire netip.AddrPort) netip.AddrPort {
+	t.Helper()
+	inner, err := a.proxy.AddPeer(b.public)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	a.proxy.SetPeerEndpoint(b.public, wire)
+	if _, err := b.proxy.AddPeer(a.public); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	b.proxy.SetPeerEndpoint(a.public, wire)
+	return inner
+}
+
+func TestProxy_Obfuscation_MasksOnTheWire(t *testing.T) {
+	for _, batched := range []bool{true, false} {
+		t.Run(map[bool]string{true: "batched", false: "unbatched"}[batched], func(t *testing.T) {
+			testObfuscationMasksOnTheWire(t, wgproxy.WithBatching(batched))
+		})
+	}
+}
+
+func testObfuscationMasksOnTheWire(t *testing.T, opts ...wgproxy.Option) {
+	a, b := newObfsEnd(t, opts...), newObfsEnd(t, opts...)
+	wireConn, wireAddr := newLoopbackConn(t)
+	inner := obfsLink(t, a, b, wireAddr)
+	a.proxy.SetObfuscation(a.private, &wgproxy.ObfuscationOptions{Junk: 2})
+	b.proxy.SetObfuscation(b.private, &wgproxy.ObfuscationOptions{})
+	a.proxy.SetPeerObfuscated(b.public, true)
+	if !a.proxy.PeerObfuscated(b.public) {
+		t.Fatal("PeerObfuscated = false after both ends turned obfuscation on")
+	}
+
+	for _, msg := range [][]byte{wgMessage(1, 148), wgMessage(4, 1200)} {
+		if _, err := a.wg.WriteToUDPAddrPort(msg, inner); err != nil {
+			t.Fatalf("wg write: %v", err)
+		}
+		// The initiation comes after its junk; the data packet alone.
+		packets := 1
+		if msg[0] == 1 {
+			packets = 3
+		}
+		var sent [][]byte
+		for range packets {
+			got, _ := readPacket(t, wireConn)
+			sent = append(sent, got)
+		}
+		expectNoPacket(t, wireConn, 50*time.Millisecond)
+		got := sent[len(sent)-1]
+		if bytes.Equal(got[:4], msg[:4]) || bytes.Contains(got, msg[:16]) {
+			t.Errorf("type %d message went out with its header in the clear: %x", msg[0], got[:16])
+		}
+		if msg[0] == 1 && len(got) > len(msg)+9+64 {
+			t.Errorf("initiation went out as %d bytes, want at most %d", len(got), len(msg)+9+64)
+		}
+		if msg[0] == 4 && len(got) != len(msg)+9 {
+			t.Errorf("data packet went out as %d bytes, want %d unpadded", len(got), len(msg)+9)
+		}
+
+		// b unmasks it and drops the junk.
+		for _, pkt := range sent {
+			if _, err := wireConn.WriteToUDPAddrPort(pkt, proxyOuterAddr(t, b.proxy, wgproxy.FamilyIPv4)); err != nil {
+				t.Fatalf("wire write: %v", err)
+			}
+		}
+		if got, _ := readPacket(t, b.wg); !bytes.Equal(got, msg) {
+			t.Fatalf("b's WireGuard received %d bytes, want the %d-byte type %d message", len(got), len(msg), msg[0])
+		}
+		expectNoPacket(t, b.wg, 50*time.Millisecond)
+	}
+}
+
+// A proxy that obfuscates still takes a peer's plain packets, so the ends
+// need not turn it on in lockstep, and sends plain to a peer that does not
+// obfuscate.
+func TestProxy_Obfuscation_PlainPeer(t *testing.T) {
+	a, b := newObfsEnd(t), newObfsEnd(t)
+	wireConn, wireAddr := newLoopbackConn(t)
+	inner := obfsLink(t, a, b, wireAddr)
+	a.proxy.SetObfuscation(a.private, &wgproxy.ObfuscationOptions{Junk: 2})
+	b.proxy.SetObfuscation(b.private, &wgproxy.ObfuscationOptions{})
+
+	msg := wgMessage(1, 148)
+	if _, err := a.wg.WriteToUDPAddrPort(msg, inner); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	got, _ := readPacket(t, wireConn)
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("wire saw %d bytes, want the plain initiation", len(got))
+	}
+	if _, err := wireConn.WriteToUDPAddrPort(got, proxyOuterAddr(t, b.proxy, wgproxy.FamilyIPv4)); err != nil {
+		t.Fatalf("wire write: %v", err)
+	}
+	if got, _ := readPacket(t, b.wg); !bytes.Equal(got, msg) {
+		t.Fatalf("b's WireGuard received %d bytes, want the plain initiation", len(got))
+	}
+}
+
+func TestManagerRebind_CarriesObfuscation(t *testing.T) {
+	m := newTestManager(t)
+	a, b := newObfsEnd(t), newObfsEnd(t)
+	p, err := m.For("wg0", ipv4Families())
+	if err != nil {
+		t.Fatalf("For: %v", err)
+	}
+	if _, err := p.AddPeer(b.public); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetObfuscation(a.private, &wgproxy.ObfuscationOptions{})
+	p.SetPeerObfuscated(b.public, true)
+
+	p, err = m.Rebind("wg0", ipv4Families())
+	if err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if !p.PeerObfuscated(b.public) {
+		t.Error("PeerObfuscated = false after Rebind, want it carried over")
+	}
+}
@@ -0,0 +1,82 @@
+// Several outer sockets per family. New binds one more socket per family on
+// each extra port, and every peer is pinned to one of them by a hash of its
+// key, so the peer's traffic always leaves from the socket whose STUN result
+// was published to it and NAT mappings stay stable. Each socket has its own
+// receive loop, which spreads the relay across cores.
+package wgproxy
+
+import (
+	"context"
+	"hash/fnv"
+	"net/netip"
+	"slices"
+)
+
+// WithExtraPorts binds one more outer socket per family on each of ports,
+// which must be fixed (non-zero) and distinct from each other and from the
+// families' ports.
+func WithExtraPorts(ports ...uint16) Option {
+	return func(o *options) { o.extraPorts = slices.Clone(ports) }
+}
+
+// pinIndex returns which of n outer sockets key is pinned to.
+func pinIndex(key PeerKey, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(key[:])
+	// Multiply-shift takes the hash's high bits, which FNV mixes far
+	// better than the low bits a modulo would.
+	return int(uint64(h.Sum32()) * uint64(n) >> 32)
+}
+
+// pinnedIndex returns the index of key's outer socket in every family,
+// whether or not key has been added yet.
+func (p *Proxy) pinnedIndex(key PeerKey) int {
+	return pinIndex(key, 1+len(p.extraPorts))
+}
+
+// peerSocket returns the family's outer socket ps is pinned to.
+func (p *Proxy) peerSocket(ps *peerState, fam Family) (*outerSocket, bool) {
+	socks := p.outer[fam]
+	if len(socks) == 0 {
+		return nil, false
+	}
+	return socks[ps.socket], true
+}
+
+// ExtraPorts returns the extra ports New was asked for; Manager.Rebind
+// compares it against a device's configuration.
+func (p *Proxy) ExtraPorts() []uint16 {
+	return slices.Clone(p.extraPorts)
+}
+
+// PeerExtraPort reports the extra port key is pinned to, or 0 when key is
+// pinned to the families' own sockets; it never changes for the life of the
+// proxy.
+func (p *Proxy) PeerExtraPort(key PeerKey) uint16 {
+	if i := p.pinnedIndex(key); i > 0 {
+		return p.extraPorts[i-1]
+	}
+	return 0
+}
+
+// Transport returns the StunTransport that exchanges on the outer sockets
+// bound on port when it is an extra port, or on the families' own ones
+// otherwise.
+func (p *Proxy) Transport(port uint16) *PortTransport {
+	return &PortTransport{p: p, index: slices.Index(p.extraPorts, port) + 1}
+}
+
+// PortTransport is a Proxy's STUN transport pinned to one outer socket per
+// family.
+type PortTransport struct {
+	p     *Proxy
+	index int
+}
+
+// Exchange is Proxy.Exchange on the transport's outer socket.
+func (t *PortTransport) Exchange(ctx context.Context, server netip.AddrPort, txnID TxnID, packet []byte) ([]byte, error) {
+	return t.p.exchangeOn(ctx, t.index, server, txnID, packet)
+}
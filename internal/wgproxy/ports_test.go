@@ -0,0 +1,122 @@
+package wgproxy_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
+)
+
+// pinnedPeers returns one peer key pinned to each of the primary sockets
+// and the extra port.
+func pinnedPeers(t *testing.T, p *wgproxy.Proxy, extra uint16) (primary, pinned wgproxy.PeerKey) {
+	t.Helper()
+	var havePrimary, havePinned bool
+	for seed := byte(1); seed != 0 && !(havePrimary && havePinned); seed++ {
+		key := testPeerKey(seed)
+		switch p.PeerExtraPort(key) {
+		case 0:
+			primary, havePrimary = key, true
+		case extra:
+			pinned, havePinned = key, true
+		default:
+			t.Fatalf("PeerExtraPort = %d, want 0 or %d", p.PeerExtraPort(key), extra)
+		}
+	}
+	if !havePrimary || !havePinned {
+		t.Fatal("no peer keys spread over both sockets")
+	}
+	return primary, pinned
+}
+
+func TestProxy_ExtraPorts_PeersLeaveFromTheirSocket(t *testing.T) {
+	extra := freeUDPPort(t)
+	p := newTestProxy(t, wgproxy.WithExtraPorts(extra))
+	if got := p.ExtraPorts(); len(got) != 1 || got[0] != extra {
+		t.Fatalf("ExtraPorts() = %v, want [%d]", got, extra)
+	}
+	wgConn, wgAddr := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	primary, pinned := pinnedPeers(t, p, extra)
+
+	for _, tt := range []struct {
+		name string
+		key  wgproxy.PeerKey
+		port uint16
+	}{
+		{"primary", primary, p.OuterPort(wgproxy.FamilyIPv4)},
+		{"extra", pinned, extra},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteConn, remoteAddr := newLoopbackConn(t)
+			innerAddr, err := p.AddPeer(tt.key)
+			if err != nil {
+				t.Fatalf("AddPeer: %v", err)
+			}
+			p.SetPeerEndpoint(tt.key, remoteAddr)
+
+			outbound := wgMessage(1, 148)
+			if _, err := wgConn.WriteToUDPAddrPort(outbound, innerAddr); err != nil {
+				t.Fatalf("wg write: %v", err)
+			}
+			got, src := readPacket(t, remoteConn)
+			if !bytes.Equal(got, outbound) {
+				t.Fatalf("remote received %d bytes, want the %d-byte initiation", len(got), len(outbound))
+			}
+			if src.Port() != tt.port {
+				t.Fatalf("remote saw source port %d, want pinned port %d", src.Port(), tt.port)
+			}
+
+			// The reply comes back to the socket it was sent from.
+			inbound := wgMessage(2, 92)
+			if _, err := remoteConn.WriteToUDPAddrPort(inbound, src); err != nil {
+				t.Fatalf("remote write: %v", err)
+			}
+			if got, src := readPacket(t, wgConn); !bytes.Equal(got, inbound) || src != innerAddr {
+				t.Fatalf("WG side received %d bytes from %s, want the response from %s", len(got), src, innerAddr)
+			}
+		})
+	}
+}
+
+func TestProxy_Transport_ExchangesOnThePortSocket(t *testing.T) {
+	extra := freeUDPPort(t)
+	p := newTestProxy(t, wgproxy.WithExtraPorts(extra))
+	server, serverAddr := newLoopbackConn(t)
+
+	for _, tt := range []struct {
+		name string
+		port uint16
+		want uint16
+	}{
+		{"extra port", extra, extra},
+		{"other port falls back to the primary", 51820, p.OuterPort(wgproxy.FamilyIPv4)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := testTxnID(byte(tt.want))
+			type result struct {
+				reply []byte
+				err   error
+			}
+			done := make(chan result, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testReadTimeout)
+				defer cancel()
+				reply, err := p.Transport(tt.port).Exchange(ctx, serverAddr, txn, stunMessage(0x0001, txn))
+				done <- result{reply, err}
+			}()
+
+			_, src := readPacket(t, server)
+			if src.Port() != tt.want {
+				t.Errorf("request left from port %d, want %d", src.Port(), tt.want)
+			}
+			if _, err := server.WriteToUDPAddrPort(stunMessage(0x0101, txn), src); err != nil {
+				t.Fatalf("server write: %v", err)
+			}
+			if r := <-done; r.err != nil || len(r.reply) < 20 || !bytes.Equal(r.reply[8:20], txn[:]) {
+				t.Fatalf("Exchange = %x, %v; want the matching response", r.reply, r.err)
+			}
+		})
+	}
+}
@@ -1,16 +1,19 @@
 // Socket relay: one Proxy per WireGuard interface, owning the per-family
 // outer sockets and per-peer inner loopback sockets (classification lives in
 // demux.go). Port lifetime invariant: every socket is bound exactly once and
-// never rebound; read errors retry in place, Close is process-shutdown only,
-// and there deliberately is no Stop.
+// never rebound; read errors retry in place. A peer's inner socket closes
+// with RemovePeer; the outer sockets only with Close, which is
+// process-shutdown or Manager.Rebind when the device's families change.
 package wgproxy
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -78,33 +81,72 @@ func familyOf(ap netip.AddrPort) Family {
 type outerSocket struct {
 	conn *net.UDPConn
 	port uint16
+
+	// batch, gro and gso serve the batched relay (batch.go); batch is nil
+	// without it. gso is cleared if the route turns out not to take GSO.
+	batch batchConn
+	gro   bool
+	gso   atomic.Bool
 }
 
 type peerState struct {
+	key       PeerKey
 	inner     *net.UDPConn
+	batch     batchConn // nil without the batched relay
 	innerAddr netip.AddrPort
 	// remote is programmed via SetPeerEndpoint only, never from packets.
 	remote atomic.Pointer[netip.AddrPort]
+	stats  peerCounters
+	// socket indexes the peer's outer socket in each family (ports.go).
+	socket int
+	// obfs unmasks the peer's packets, nil unless the proxy obfuscates;
+	// obfsPeer says the peer does too, so packets to it are masked
+	// (obfs.go).
+	obfs     atomic.Pointer[obfuscator]
+	obfsPeer atomic.Bool
+}
+
+// options carries New's optional behavior.
+type options struct {
+	escape escapeOptions
+	// unbatched keeps the one-datagram loops where batching is available.
+	unbatched  bool
+	roaming    bool
+	extraPorts []uint16
 }
 
+// Option configures optional Proxy construction behavior.
+type Option func(*options)
+
 // Proxy relays UDP between one WireGuard interface (over loopback) and the
 // internet, and multiplexes STUN exchanges onto the same outer sockets.
 type Proxy struct {
 	logger zerolog.Logger
 	demux  *Demux
 
-	outer map[Family]*outerSocket // immutable after New
+	// outer holds each family's sockets: the families port first, then
+	// one per extra port, in WithExtraPorts order; immutable after New.
+	outer      map[Family][]*outerSocket
+	families   map[Family]uint16 // as requested of New; immutable
+	extraPorts []uint16          // as requested of New; immutable
 
 	// escapeStops holds cleanup funcs returned by escapeOuterSocket for
 	// sockets that started a background watcher (currently darwin's
 	// route-change watcher); immutable after New, called by Close.
 	escapeStops []func()
 
+	// batched selects the loops of batch.go; immutable after New.
+	batched bool
+
 	// wgPort is fed via SetWGTarget only, never learned from packets.
 	wgPort atomic.Uint32
 
+	// capture is the running debug capture, nil when off (capture.go).
+	capture atomic.Pointer[capture]
+
 	mu     sync.RWMutex
 	peers  map[PeerKey]*peerState
+	obfs   *obfsConfig // nil while obfuscation is off (obfs.go)
 	closed bool
 
 	loops sync.WaitGroup
@@ -116,23 +158,32 @@ type Proxy struct {
 	writeErrs  atomic.Uint64
 }
 
-// New binds one outer socket per requested family (port 0 = ephemeral) and
-// starts its receive loop. opts (see WithEscape) configure the per-OS
-// tunnel-escape hook applied to each outer socket at creation.
+// New binds one outer socket per requested family (port 0 = ephemeral), and
+// one more per extra port (WithExtraPorts), and starts their receive loops.
+// opts configure the per-OS tunnel-escape hook applied to each outer socket
+// at creation (WithEscape), whether the relay batches its I/O
+// (WithBatching; on by default where available) and whether it follows
+// roaming peers (WithRoaming; off by default).
 func New(logger *zerolog.Logger, families map[Family]uint16, opts ...Option) (*Proxy, error) {
 	if len(families) == 0 {
 		return nil, ErrNoFamilies
 	}
-	var eo escapeOptions
+	var o options
 	for _, opt := range opts {
-		opt(&eo)
+		opt(&o)
 	}
 	p := &Proxy{
 		logger:          logger.With().Str("component", "wgproxy.proxy").Logger(),
 		demux:           NewDemux(logger),
-		outer:           make(map[Family]*outerSocket, len(families)),
+		outer:           make(map[Family][]*outerSocket, len(families)),
+		families:        maps.Clone(families),
+		extraPorts:      slices.Clone(o.extraPorts),
 		peers:           make(map[PeerKey]*peerState),
 		exchangeTimeout: defaultExchangeTimeout,
+		batched:         batchedIO && !o.unbatched,
+	}
+	if o.roaming {
+		p.demux.roam = newRoamState()
 	}
 	for fam, port := range families {
 		network := fam.network()
@@ -140,21 +191,35 @@ func New(logger *zerolog.Logger, families map[Family]uint16, opts ...Option) (*P
 			p.closeOnError()
 			return nil, fmt.Errorf("wgproxy: unknown protocol family %d", uint8(fam))
 		}
-		conn, err := net.ListenUDP(network, &net.UDPAddr{Port: int(port)})
-		if err != nil {
-			p.closeOnError()
-			return nil, fmt.Errorf("wgproxy: bind %s outer socket: %w", fam, err)
-		}
-		if stop := escapeOuterSocket(conn, fam, eo, p.logger); stop != nil {
-			p.escapeStops = append(p.escapeStops, stop)
+		for _, port := range append([]uint16{port}, o.extraPorts...) {
+			conn, err := net.ListenUDP(network, &net.UDPAddr{Port: int(port)})
+			if err != nil {
+				p.closeOnError()
+				return nil, fmt.Errorf("wgproxy: bind %s outer socket: %w", fam, err)
+			}
+			if stop := escapeOuterSocket(conn, fam, o.escape, p.logger); stop != nil {
+				p.escapeStops = append(p.escapeStops, stop)
+			}
+			local := conn.LocalAddr().(*net.UDPAddr)
+			sock := &outerSocket{conn: conn, port: uint16(local.Port)}
+			if p.batched {
+				sock.batch = newBatchConn(conn, fam)
+				sock.gro = enableGRO(conn)
+				sock.gso.Store(supportsGSO(conn))
+			}
+			p.outer[fam] = append(p.outer[fam], sock)
+			p.logger.Info().Stringer("family", fam).Int("port", local.Port).Bool("batched", p.batched).Bool("gro", sock.gro).Bool("gso", sock.gso.Load()).Msg("outer socket bound")
 		}
-		local := conn.LocalAddr().(*net.UDPAddr)
-		p.outer[fam] = &outerSocket{conn: conn, port: uint16(local.Port)}
-		p.logger.Info().Stringer("family", fam).Int("port", local.Port).Msg("outer socket bound")
 	}
-	for fam, sock := range p.outer {
-		p.loops.Add(1)
-		go p.outerLoop(fam, sock.conn)
+	for fam, socks := range p.outer {
+		for _, sock := range socks {
+			p.loops.Add(1)
+			if p.batched {
+				go p.outerBatchLoop(fam, sock)
+			} else {
+				go p.outerLoop(fam, sock.conn)
+			}
+		}
 	}
 	return p, nil
 }
@@ -189,14 +254,64 @@ func (p *Proxy) AddPeer(key PeerKey) (netip.AddrPort, error) {
 		return netip.AddrPort{}, fmt.Errorf("wgproxy: bind inner socket: %w", err)
 	}
 	addr := conn.LocalAddr().(*net.UDPAddr).AddrPort()
-	ps := &peerState{inner: conn, innerAddr: normalize(addr)}
+	ps := &peerState{key: key, inner: conn, innerAddr: normalize(addr), socket: p.pinnedIndex(key)}
 	p.peers[key] = ps
+	if p.obfs != nil {
+		p.setObfuscatorLocked(ps)
+	}
 	p.loops.Add(1)
-	go p.innerLoop(ps)
+	if p.batched {
+		ps.batch = newBatchConn(conn, FamilyIPv4)
+		go p.innerBatchLoop(ps)
+	} else {
+		go p.innerLoop(ps)
+	}
 	p.logger.Debug().Str("inner", ps.innerAddr.String()).Msg("peer inner socket bound")
 	return ps.innerAddr, nil
 }
 
+// RemovePeer closes the peer's inner socket, which ends its relay
+// goroutine, and unprograms its demux mapping; safe for an unknown peer.
+func (p *Proxy) RemovePeer(key PeerKey) {
+	p.mu.Lock()
+	ps, ok := p.peers[key]
+	if ok {
+		delete(p.peers, key)
+	}
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	p.demux.Unprogram(key)
+	p.demux.setObfuscator(key, nil)
+	_ = ps.inner.Close()
+	p.logger.Debug().Str("inner", ps.innerAddr.String()).Msg("peer inner socket closed")
+}
+
+// Peers returns the keys of every peer with an inner socket, in no
+// particular order.
+func (p *Proxy) Peers() []PeerKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Collect(maps.Keys(p.peers))
+}
+
+// PeerEndpoint returns the remote last programmed for the peer; false when
+// the peer is unknown or has none yet.
+func (p *Proxy) PeerEndpoint(key PeerKey) (netip.AddrPort, bool) {
+	p.mu.RLock()
+	ps := p.peers[key]
+	p.mu.RUnlock()
+	if ps == nil {
+		return netip.AddrPort{}, false
+	}
+	remote := ps.remote.Load()
+	if remote == nil {
+		return netip.AddrPort{}, false
+	}
+	return *remote, true
+}
+
 // SetPeerEndpoint programs the peer's inbound demux mapping and outbound
 // remote — the only way forwarding state changes.
 func (p *Proxy) SetPeerEndpoint(key PeerKey, remote netip.AddrPort) {
@@ -212,15 +327,21 @@ func (p *Proxy) SetPeerEndpoint(key PeerKey, remote netip.AddrPort) {
 	ps.remote.Store(&remote)
 }
 
-// OuterPort reports the family's outer-socket port (0 when not enabled); it
-// never changes for the life of the proxy.
+// OuterPort reports the family's primary outer-socket port (0 when not
+// enabled); it never changes for the life of the proxy.
 func (p *Proxy) OuterPort(fam Family) uint16 {
-	if sock, ok := p.outer[fam]; ok {
-		return sock.port
+	if socks := p.outer[fam]; len(socks) > 0 {
+		return socks[0].port
 	}
 	return 0
 }
 
+// Families returns the families and ports New was asked for, port 0 meaning
+// ephemeral; Manager.Rebind compares it against a device's configuration.
+func (p *Proxy) Families() map[Family]uint16 {
+	return maps.Clone(p.families)
+}
+
 // Demux exposes the packet classifier.
 func (p *Proxy) Demux() *Demux {
 	return p.demux
@@ -231,20 +352,27 @@ func (p *Proxy) Truncated() uint64 {
 	return p.truncated.Load()
 }
 
-// Exchange sends the request on the family-matching outer socket and returns
-// the raw demux-routed response. Timeouts use select — never socket read
-// deadlines, which would break the relay loop sharing the socket.
+// Exchange sends the request on the family-matching primary outer socket
+// and returns the raw demux-routed response. Timeouts use select — never
+// socket read deadlines, which would break the relay loop sharing the socket.
 func (p *Proxy) Exchange(ctx context.Context, server netip.AddrPort, txnID TxnID, packet []byte) ([]byte, error) {
-	sock, ok := p.outer[familyOf(server)]
-	if !ok {
+	return p.exchangeOn(ctx, 0, server, txnID, packet)
+}
+
+// exchangeOn is Exchange on the family's index'th outer socket.
+func (p *Proxy) exchangeOn(ctx context.Context, index int, server netip.AddrPort, txnID TxnID, packet []byte) ([]byte, error) {
+	socks := p.outer[familyOf(server)]
+	if len(socks) == 0 {
 		return nil, fmt.Errorf("%w: %s", ErrFamilyNotEnabled, familyOf(server))
 	}
+	sock := socks[index]
 	reply, err := p.demux.Registry().Register(txnID, server)
 	if err != nil {
 		return nil, err
 	}
 	defer p.demux.Registry().Unregister(txnID)
 
+	p.tap(sideOuter, false, outerLocal(familyOf(server), sock.port), server, packet, "stun")
 	if _, err := sock.conn.WriteToUDPAddrPort(packet, server); err != nil {
 		return nil, fmt.Errorf("wgproxy: send STUN request: %w", err)
 	}
@@ -272,7 +400,7 @@ func (p *Proxy) Close() error {
 	p.mu.Unlock()
 	p.closeOnError()
 	p.loops.Wait()
-	return nil
+	return p.StopCapture()
 }
 
 // closeOnError runs the escape stops collected so far, then closes every
@@ -286,8 +414,10 @@ func (p *Proxy) closeOnError() {
 }
 
 func (p *Proxy) closeSockets() {
-	for _, sock := range p.outer {
-		_ = sock.conn.Close()
+	for _, socks := range p.outer {
+		for _, sock := range socks {
+			_ = sock.conn.Close()
+		}
 	}
 	p.mu.RLock()
 	for _, ps := range p.peers {
@@ -308,6 +438,7 @@ func (p *Proxy) isClosed() bool {
 func (p *Proxy) outerLoop(fam Family, conn *net.UDPConn) {
 	defer p.loops.Done()
 	buf := make([]byte, relayBufSize)
+	local := outerLocal(fam, uint16(conn.LocalAddr().(*net.UDPAddr).Port))
 	consecutiveErrs := 0
 	for {
 		n, src, err := conn.ReadFromUDPAddrPort(buf)
@@ -325,6 +456,7 @@ func (p *Proxy) outerLoop(fam Family, conn *net.UDPConn) {
 
 		decision := p.demux.Classify(src, buf[:n])
 		if decision.Bucket != BucketRelay {
+			p.tapClassified(src, local, buf[:n], decision)
 			continue
 		}
 		p.mu.RLock()
@@ -333,11 +465,21 @@ func (p *Proxy) outerLoop(fam Family, conn *net.UDPConn) {
 		target := p.wgTarget()
 		if ps == nil || !target.IsValid() {
 			p.countWarn(&p.unroutable, "relay packet for peer without inner socket or WG target")
+			p.tap(sideOuter, true, src, local, buf[:n], noteNoInner)
 			continue
 		}
-		if _, err := ps.inner.WriteToUDPAddrPort(buf[:n], target); err != nil {
+		in, note, ok := ps.inbound(buf[:n])
+		p.tap(sideOuter, true, src, local, buf[:n], note)
+		if !ok {
+			continue
+		}
+		packet := in.packet(buf[:n])
+		p.tap(sideInner, false, ps.innerAddr, target, packet, "relay")
+		if _, err := ps.inner.WriteToUDPAddrPort(packet, target); err != nil {
 			p.countWarn(&p.writeErrs, "inner write failed")
+			continue
 		}
+		ps.stats.received(1, n)
 	}
 }
 
@@ -346,6 +488,7 @@ func (p *Proxy) outerLoop(fam Family, conn *net.UDPConn) {
 func (p *Proxy) innerLoop(ps *peerState) {
 	defer p.loops.Done()
 	buf := make([]byte, relayBufSize)
+	masked := make([]byte, 0, relayBufSize+obfsOverhead+obfsMaxPadding)
 	consecutiveErrs := 0
 	for {
 		n, _, err := ps.inner.ReadFromUDPAddrPort(buf)
@@ -360,20 +503,29 @@ func (p *Proxy) innerLoop(ps *peerState) {
 		}
 		consecutiveErrs = 0
 		p.noteTruncation(n, len(buf))
+		if p.demux.roam != nil {
+			p.roamOutbound(ps, buf[:n])
+		}
 
 		remote := ps.remote.Load()
 		if remote == nil {
 			p.countWarn(&p.unroutable, "outbound packet before peer endpoint programmed")
+			p.tap(sideInner, true, p.wgTarget(), ps.innerAddr, buf[:n], noteNoEndpoint)
 			continue
 		}
-		sock, ok := p.outer[familyOf(*remote)]
+		sock, ok := p.peerSocket(ps, familyOf(*remote))
 		if !ok {
 			p.countWarn(&p.unroutable, "outbound packet for disabled protocol family")
+			p.tap(sideInner, true, p.wgTarget(), ps.innerAddr, buf[:n], noteNoFamily)
 			continue
 		}
-		if _, err := sock.conn.WriteToUDPAddrPort(buf[:n], *remote); err != nil {
+		packet := p.outbound(ps, sock, *remote, buf[:n], masked)
+		p.tapOutbound(ps, sock, *remote, buf[:n], packet)
+		if _, err := sock.conn.WriteToUDPAddrPort(packet, *remote); err != nil {
 			p.countWarn(&p.writeErrs, "outer write failed")
+			continue
 		}
+		ps.stats.sent(1, n)
 	}
 }
 
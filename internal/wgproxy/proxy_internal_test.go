@@ -9,7 +9,7 @@ import "testing"
 // platform.
 func TestProxy_CloseOnErrorRunsEscapeStops(t *testing.T) {
 	p := &Proxy{
-		outer: make(map[Family]*outerSocket),
+		outer: make(map[Family][]*outerSocket),
 		peers: make(map[PeerKey]*peerState),
 	}
 	var calls int
@@ -0,0 +1,59 @@
+package wgproxy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
+	"golang.org/x/sys/unix"
+)
+
+// TestProxy_InboundGSOBurst sends one UDP_SEGMENT datagram standing for a
+// burst of packets; loopback hands it to the outer socket whole when GRO is
+// on, and WireGuard must still see each packet as its own datagram.
+func TestProxy_InboundGSOBurst(t *testing.T) {
+	const segments, segSize = 4, 1000
+	p := newTestProxy(t)
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	peer := testPeerKey(0x41)
+	innerAddr, err := p.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(peer, remoteAddr)
+
+	var payload []byte
+	for i := range segments {
+		pkt := wgMessage(4, segSize)
+		pkt[16] = byte(i)
+		payload = append(payload, pkt...)
+	}
+	// One trailing shorter segment, as GSO allows.
+	tail := wgMessage(4, segSize/2)
+	tail[16] = segments
+	payload = append(payload, tail...)
+
+	oob := make([]byte, unix.CmsgSpace(2))
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = unix.SOL_UDP
+	hdr.Type = unix.UDP_SEGMENT
+	hdr.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], segSize)
+	outer := net.UDPAddrFromAddrPort(proxyOuterAddr(t, p, wgproxy.FamilyIPv4))
+	if _, _, err := remoteConn.WriteMsgUDP(payload, oob, outer); err != nil {
+		t.Skipf("kernel cannot send UDP GSO: %v", err)
+	}
+
+	for i := range segments + 1 {
+		want := payload[i*segSize : min((i+1)*segSize, len(payload))]
+		got, src := readPacket(t, wgConn)
+		if !bytes.Equal(got, want) || src != innerAddr {
+			t.Fatalf("segment %d: got %dB from %s, want %dB from %s", i, len(got), src, len(want), innerAddr)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package wgproxy_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"net"
 	"net/netip"
 	"testing"
@@ -15,10 +16,10 @@ import (
 const testReadTimeout = 2 * time.Second
 
 // newTestProxy creates an IPv4-only proxy with an ephemeral outer port.
-func newTestProxy(t *testing.T) *wgproxy.Proxy {
+func newTestProxy(t *testing.T, opts ...wgproxy.Option) *wgproxy.Proxy {
 	t.Helper()
 	logger := zerolog.Nop()
-	p, err := wgproxy.New(&logger, map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0})
+	p, err := wgproxy.New(&logger, map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0}, opts...)
 	if err != nil {
 		t.Fatalf("New: %v", err)
 	}
@@ -206,6 +207,48 @@ func TestProxy_TwoPeers_DistinctInnerSocketsAndCorrectRouting(t *testing.T) {
 	expectNoPacket(t, remoteConnB, 50*time.Millisecond)
 }
 
+func TestProxy_RemovePeer(t *testing.T) {
+	p := newTestProxy(t)
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	peer := testPeerKey(0x21)
+	innerAddr, err := p.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(peer, remoteAddr)
+
+	p.RemovePeer(peer)
+	p.RemovePeer(peer) // idempotent
+	p.RemovePeer(testPeerKey(0x22))
+	if got := p.Peers(); len(got) != 0 {
+		t.Fatalf("Peers() = %d keys after RemovePeer, want none", len(got))
+	}
+	if _, ok := p.PeerEndpoint(peer); ok {
+		t.Fatal("PeerEndpoint must forget a removed peer")
+	}
+
+	// The demux mapping is gone: the remote's packets no longer reach WG.
+	if _, err := remoteConn.WriteToUDPAddrPort(wgMessage(4, 64), proxyOuterAddr(t, p, wgproxy.FamilyIPv4)); err != nil {
+		t.Fatalf("remote write: %v", err)
+	}
+	expectNoPacket(t, wgConn, 50*time.Millisecond)
+	// The inner socket is closed: WG's packets to it are not relayed.
+	if _, err := wgConn.WriteToUDPAddrPort(wgMessage(4, 64), innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	expectNoPacket(t, remoteConn, 50*time.Millisecond)
+
+	// The peer can come back with a fresh inner socket.
+	if _, err := p.AddPeer(peer); err != nil {
+		t.Fatalf("AddPeer after RemovePeer: %v", err)
+	}
+	if got := p.Peers(); len(got) != 1 || got[0] != peer {
+		t.Fatalf("Peers() = %v, want the re-added peer", got)
+	}
+}
+
 func TestProxy_AddPeerIdempotent(t *testing.T) {
 	p := newTestProxy(t)
 	peer := testPeerKey(0x11)
@@ -404,6 +447,269 @@ func TestProxy_IPv6RoundTrip(t *testing.T) {
 	}
 }
 
+// TestProxy_RelayBurst runs a burst each way through both relay loops: the
+// batched one must deliver every packet, unmodified and in order, just like
+// the one-datagram loop it replaces.
+func TestProxy_RelayBurst(t *testing.T) {
+	const burst = 100
+	for _, tc := range []struct {
+		name    string
+		batched bool
+	}{
+		{"batched", true},
+		{"unbatched", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProxy(t, wgproxy.WithBatching(tc.batched))
+			wgConn, wgAddr := newLoopbackConn(t)
+			remoteConn, remoteAddr := newLoopbackConn(t)
+			p.SetWGTarget(wgAddr.Port())
+			peer := testPeerKey(0x31)
+			innerAddr, err := p.AddPeer(peer)
+			if err != nil {
+				t.Fatalf("AddPeer: %v", err)
+			}
+			p.SetPeerEndpoint(peer, remoteAddr)
+
+			packets := make([][]byte, burst)
+			for i := range packets {
+				// Distinct sizes and contents catch reordering and buffer reuse.
+				packets[i] = wgMessage(4, 64+i)
+				packets[i][16] = byte(i)
+			}
+			for _, pkt := range packets {
+				if _, err := remoteConn.WriteToUDPAddrPort(pkt, proxyOuterAddr(t, p, wgproxy.FamilyIPv4)); err != nil {
+					t.Fatalf("remote write: %v", err)
+				}
+			}
+			for i, want := range packets {
+				got, src := readPacket(t, wgConn)
+				if !bytes.Equal(got, want) || src != innerAddr {
+					t.Fatalf("inbound packet %d: got %dB from %s, want %dB from %s", i, len(got), src, len(want), innerAddr)
+				}
+			}
+
+			for _, pkt := range packets {
+				if _, err := wgConn.WriteToUDPAddrPort(pkt, innerAddr); err != nil {
+					t.Fatalf("wg write: %v", err)
+				}
+			}
+			for i, want := range packets {
+				got, src := readPacket(t, remoteConn)
+				if !bytes.Equal(got, want) || src.Port() != p.OuterPort(wgproxy.FamilyIPv4) {
+					t.Fatalf("outbound packet %d: got %dB from %s, want %dB from outer port %d", i, len(got), src, len(want), p.OuterPort(wgproxy.FamilyIPv4))
+				}
+			}
+		})
+	}
+}
+
+// wgIndexed builds a WireGuard message of msgType and size with the given
+// little-endian session indexes written from offset 4 on.
+func wgIndexed(msgType byte, size int, idx ...uint32) []byte {
+	b := wgMessage(msgType, size)
+	for i, index := range idx {
+		binary.LittleEndian.PutUint32(b[4+4*i:], index)
+	}
+	return b
+}
+
+// TestProxy_Roaming walks a NAT rebind: the peer's traffic arrives from a
+// new source, the proxy relays it and copies WireGuard's next handshake
+// there, and only WireGuard's data for the handshake answered from the new
+// source moves the peer's endpoint.
+func TestProxy_Roaming(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		batched bool
+	}{
+		{"batched", true},
+		{"unbatched", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProxy(t, wgproxy.WithRoaming(true), wgproxy.WithBatching(tc.batched))
+			wgConn, wgAddr := newLoopbackConn(t)
+			oldConn, oldAddr := newLoopbackConn(t)
+			newConn, _ := newLoopbackConn(t)
+			outer := proxyOuterAddr(t, p, wgproxy.FamilyIPv4)
+			p.SetWGTarget(wgAddr.Port())
+			peer := testPeerKey(0x51)
+			innerAddr, err := p.AddPeer(peer)
+			if err != nil {
+				t.Fatalf("AddPeer: %v", err)
+			}
+			p.SetPeerEndpoint(peer, oldAddr)
+
+			send := func(conn *net.UDPConn, b []byte, to netip.AddrPort) {
+				t.Helper()
+				if _, err := conn.WriteToUDPAddrPort(b, to); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+			}
+			expect := func(conn *net.UDPConn, want []byte) {
+				t.Helper()
+				if got, _ := readPacket(t, conn); !bytes.Equal(got, want) {
+					t.Fatalf("received %dB type %d, want %dB type %d", len(got), got[0], len(want), want[0])
+				}
+			}
+
+			// WireGuard's session index 0x11 is now known to belong to the peer.
+			initiation := wgIndexed(1, 148, 0x11)
+			send(wgConn, initiation, innerAddr)
+			expect(oldConn, initiation)
+
+			// The peer's NAT rebinds: its data arrives from a new source.
+			data := wgIndexed(4, 64, 0x11)
+			send(newConn, data, outer)
+			expect(wgConn, data)
+
+			// WireGuard's next initiation reaches both sources.
+			initiation = wgIndexed(1, 148, 0x22)
+			send(wgConn, initiation, innerAddr)
+			expect(oldConn, initiation)
+			expect(newConn, initiation)
+
+			// The peer answers from the new source; data WireGuard sends to
+			// the answering session confirms it, and goes there.
+			response := wgIndexed(2, 92, 0x33, 0x22)
+			send(newConn, response, outer)
+			expect(wgConn, response)
+			keepalive := wgIndexed(4, 32, 0x33)
+			send(wgConn, keepalive, innerAddr)
+			expect(newConn, keepalive)
+			expectNoPacket(t, oldConn, 50*time.Millisecond)
+			if got := p.Demux().Roamed(); got != 1 {
+				t.Fatalf("Roamed() = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// TestProxy_RoamingNeedsWireGuardConfirmation replays the peer's packets
+// from another source without WireGuard ever confirming them: the endpoint
+// must stay where it was programmed.
+func TestProxy_RoamingNeedsWireGuardConfirmation(t *testing.T) {
+	p := newTestProxy(t, wgproxy.WithRoaming(true))
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	spoofConn, _ := newLoopbackConn(t)
+	outer := proxyOuterAddr(t, p, wgproxy.FamilyIPv4)
+	p.SetWGTarget(wgAddr.Port())
+	peer := testPeerKey(0x52)
+	innerAddr, err := p.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(peer, remoteAddr)
+
+	if _, err := wgConn.WriteToUDPAddrPort(wgIndexed(1, 148, 0x11), innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	readPacket(t, remoteConn)
+	if _, err := spoofConn.WriteToUDPAddrPort(wgIndexed(2, 92, 0x66, 0x11), outer); err != nil {
+		t.Fatalf("spoof write: %v", err)
+	}
+	readPacket(t, wgConn) // relayed for WireGuard to reject
+
+	// WireGuard keeps using its real session, never the spoofed one.
+	data := wgIndexed(4, 64, 0x77)
+	if _, err := wgConn.WriteToUDPAddrPort(data, innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	if got, _ := readPacket(t, remoteConn); !bytes.Equal(got, data) {
+		t.Fatal("data must still go to the programmed endpoint")
+	}
+	expectNoPacket(t, spoofConn, 50*time.Millisecond)
+	if got := p.Demux().Roamed(); got != 0 {
+		t.Fatalf("Roamed() = %d, want 0", got)
+	}
+}
+
+// TestProxy_RoamingOffByDefault checks the default proxy still drops a
+// known session's packets from an unprogrammed source.
+func TestProxy_RoamingOffByDefault(t *testing.T) {
+	p := newTestProxy(t)
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	newConn, _ := newLoopbackConn(t)
+	p.SetWGTarget(wgAddr.Port())
+	peer := testPeerKey(0x53)
+	innerAddr, err := p.AddPeer(peer)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(peer, remoteAddr)
+
+	if _, err := wgConn.WriteToUDPAddrPort(wgIndexed(1, 148, 0x11), innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	readPacket(t, remoteConn)
+	if _, err := newConn.WriteToUDPAddrPort(wgIndexed(4, 64, 0x11), proxyOuterAddr(t, p, wgproxy.FamilyIPv4)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectNoPacket(t, wgConn, 50*time.Millisecond)
+}
+
+func TestProxy_Stats(t *testing.T) {
+	p := newTestProxy(t)
+	wgConn, wgAddr := newLoopbackConn(t)
+	remoteConn, remoteAddr := newLoopbackConn(t)
+	scanner, scannerAddr := newLoopbackConn(t)
+	outer := proxyOuterAddr(t, p, wgproxy.FamilyIPv4)
+	p.SetWGTarget(wgAddr.Port())
+	active, silent := testPeerKey(0x01), testPeerKey(0x02)
+	innerAddr, err := p.AddPeer(active)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	if _, err := p.AddPeer(silent); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	p.SetPeerEndpoint(active, remoteAddr)
+
+	before := time.Now()
+	for _, size := range []int{100, 200} {
+		if _, err := remoteConn.WriteToUDPAddrPort(wgMessage(4, size), outer); err != nil {
+			t.Fatalf("remote write: %v", err)
+		}
+		readPacket(t, wgConn)
+	}
+	if _, err := wgConn.WriteToUDPAddrPort(wgMessage(4, 300), innerAddr); err != nil {
+		t.Fatalf("wg write: %v", err)
+	}
+	readPacket(t, remoteConn)
+	for range 3 {
+		if _, err := scanner.WriteToUDPAddrPort(wgMessage(1, 148), outer); err != nil {
+			t.Fatalf("scanner write: %v", err)
+		}
+	}
+	expectNoPacket(t, wgConn, 50*time.Millisecond)
+
+	stats := p.Stats()
+	if len(stats.Peers) != 2 {
+		t.Fatalf("Stats().Peers has %d entries, want 2", len(stats.Peers))
+	}
+	got := stats.Peers[0]
+	if got.Key != active || got.Remote != remoteAddr {
+		t.Fatalf("first peer = %x at %s, want %x at %s", got.Key[:1], got.Remote, active[:1], remoteAddr)
+	}
+	if got.RxPackets != 2 || got.RxBytes != 300 || got.TxPackets != 1 || got.TxBytes != 300 {
+		t.Fatalf("active peer rx %d/%dB tx %d/%dB, want rx 2/300B tx 1/300B", got.RxPackets, got.RxBytes, got.TxPackets, got.TxBytes)
+	}
+	if got.LastRx.Before(before) || got.LastTx.Before(before) {
+		t.Fatalf("active peer last rx %v tx %v, want after %v", got.LastRx, got.LastTx, before)
+	}
+	if idle := stats.Peers[1]; idle.Key != silent || idle.RxPackets != 0 || !idle.LastRx.IsZero() || idle.Remote.IsValid() {
+		t.Fatalf("silent peer = %+v, want no traffic and no remote", idle)
+	}
+	if stats.DroppedUnattributable != 3 {
+		t.Fatalf("DroppedUnattributable = %d, want 3", stats.DroppedUnattributable)
+	}
+	if len(stats.DropSources) != 1 || stats.DropSources[0] != (wgproxy.SourceDrops{Src: scannerAddr, Packets: 3}) {
+		t.Fatalf("DropSources = %v, want the scanner with 3 packets", stats.DropSources)
+	}
+}
+
 func TestProxy_TruncationCounter(t *testing.T) {
 	// A real UDP datagram can't fill a 65535-byte buffer (payload max 65507),
 	// so the counter is tested through the exported hook.
@@ -0,0 +1,437 @@
+// Relay node: a publicly reachable stunmesh node forwarding WireGuard
+// datagrams between two of its peers that cannot reach each other. The
+// node never decrypts anything; it only makes sure each allocation carries
+// traffic between the two addresses its two peers authenticated from (see
+// relaynode_proto.go), so it cannot be used as an open relay.
+package wgproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// relayMinLifetime and relayMaxLifetime bound what a request may ask
+	// for; relayDefaultLifetime is granted when it asks for none.
+	relayMinLifetime     = time.Minute
+	relayMaxLifetime     = 2 * time.Hour
+	relayDefaultLifetime = 10 * time.Minute
+
+	// relayMaxSkew bounds the difference between a request's timestamp and
+	// the node's clock.
+	relayMaxSkew = 2 * time.Minute
+
+	// relayMaxAllocations caps the node's allocations, and
+	// relayMaxAllocationsPerKey those naming any one peer.
+	relayMaxAllocations       = 256
+	relayMaxAllocationsPerKey = 32
+
+	relayReapInterval = 30 * time.Second
+)
+
+// ErrRelayNodeClosed is returned by SetKeys after Close.
+var ErrRelayNodeClosed = errors.New("wgproxy: relay node closed")
+
+// relayPair names an allocation's two peers, in byte order so both ends'
+// requests name the same allocation.
+type relayPair [2]PeerKey
+
+func makeRelayPair(a, b PeerKey) relayPair {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return relayPair{a, b}
+}
+
+// side returns which of the pair key is.
+func (p relayPair) side(key PeerKey) int {
+	if p[0] == key {
+		return 0
+	}
+	return 1
+}
+
+// relayAllocation is one pair's relayed port. addrs are the sources each
+// side last authenticated from; a datagram from one is sent to the other
+// and anything else is dropped.
+type relayAllocation struct {
+	pair    relayPair
+	conn    *net.UDPConn
+	port    uint16
+	addrs   [2]atomic.Pointer[netip.AddrPort]
+	expires time.Time // guarded by RelayNode.mu
+}
+
+// RelayNode serves allocation requests on its control socket and forwards
+// each allocation's datagrams. It refuses every request until SetKeys has
+// given it the device's private key and the peers allowed to use it.
+type RelayNode struct {
+	logger zerolog.Logger
+	conn   *net.UDPConn
+	port   uint16
+
+	mu       sync.Mutex
+	private  PeerKey
+	keyed    bool
+	allowed  map[PeerKey]bool
+	macKeys  map[PeerKey][]byte // derived per client, dropped with the client
+	lastSeen map[PeerKey]int64  // newest timestamp accepted per client
+	allocs   map[relayPair]*relayAllocation
+	closed   bool
+
+	done  chan struct{}
+	loops sync.WaitGroup
+
+	forwarded atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewRelayNode binds the IPv4 control socket on port (0 = ephemeral) and
+// starts serving it.
+func NewRelayNode(logger *zerolog.Logger, port uint16) (*RelayNode, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return nil, fmt.Errorf("wgproxy: bind relay node socket: %w", err)
+	}
+	n := &RelayNode{
+		logger:   logger.With().Str("component", "wgproxy.relaynode").Logger(),
+		conn:     conn,
+		port:     uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		allowed:  make(map[PeerKey]bool),
+		macKeys:  make(map[PeerKey][]byte),
+		lastSeen: make(map[PeerKey]int64),
+		allocs:   make(map[relayPair]*relayAllocation),
+		done:     make(chan struct{}),
+	}
+	n.loops.Add(2)
+	go n.controlLoop()
+	go n.reapLoop()
+	n.logger.Info().Int("port", int(n.port)).Msg("relay node listening")
+	return n, nil
+}
+
+// Port returns the control socket's port.
+func (n *RelayNode) Port() uint16 {
+	return n.port
+}
+
+// SetKeys sets the device's private key and the peers allowed to allocate.
+// Allocations naming a peer no longer allowed are closed, as is everything
+// when the private key changes.
+func (n *RelayNode) SetKeys(private PeerKey, allowed []PeerKey) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrRelayNodeClosed
+	}
+	rekeyed := n.keyed && n.private != private
+	if rekeyed {
+		clear(n.macKeys)
+	}
+	n.private = private
+	n.keyed = true
+
+	n.allowed = make(map[PeerKey]bool, len(allowed))
+	for _, key := range allowed {
+		n.allowed[key] = true
+	}
+	for key := range n.macKeys {
+		if !n.allowed[key] {
+			delete(n.macKeys, key)
+			delete(n.lastSeen, key)
+		}
+	}
+	for pair, a := range n.allocs {
+		if rekeyed || !n.allowed[pair[0]] || !n.allowed[pair[1]] {
+			n.closeAllocationLocked(a)
+		}
+	}
+	return nil
+}
+
+// Allocations returns the number of open allocations.
+func (n *RelayNode) Allocations() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.allocs)
+}
+
+// Forwarded and Dropped count datagrams over all allocations: relayed, and
+// discarded for not coming from either registered side.
+func (n *RelayNode) Forwarded() uint64 { return n.forwarded.Load() }
+func (n *RelayNode) Dropped() uint64   { return n.dropped.Load() }
+
+// Close closes the control socket and every allocation; idempotent.
+func (n *RelayNode) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.done)
+	_ = n.conn.Close()
+	for _, a := range n.allocs {
+		n.closeAllocationLocked(a)
+	}
+	n.mu.Unlock()
+	n.loops.Wait()
+	return nil
+}
+
+func (n *RelayNode) controlLoop() {
+	defer n.loops.Done()
+	buf := make([]byte, 1500)
+	consecutiveErrs := 0
+	for {
+		size, src, err := n.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			consecutiveErrs++
+			n.logger.Warn().Err(err).Int("consecutive", consecutiveErrs).Msg("relay node read error, retrying")
+			backoff(consecutiveErrs)
+			continue
+		}
+		consecutiveErrs = 0
+		if reply := n.handle(normalize(src), buf[:size], time.Now()); reply != nil {
+			if _, err := n.conn.WriteToUDPAddrPort(reply, src); err != nil {
+				n.logger.Debug().Err(err).Stringer("src", src).Msg("relay node reply failed")
+			}
+		}
+	}
+}
+
+// handle answers one control datagram; nil means no reply, which is what
+// anything unauthenticated gets.
+func (n *RelayNode) handle(src netip.AddrPort, b []byte, now time.Time) []byte {
+	m, macInput, mac, err := parseRelayMessage(b)
+	if err != nil || m.typ != relayRequestType {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil
+	}
+	key, ok := n.macKeyLocked(m.client, m.peer)
+	if !ok || !verifyRelayMAC(key, macInput, mac) {
+		n.logger.Debug().Stringer("src", src).Msg("dropped unauthenticated relay request")
+		return nil
+	}
+	reply := relayMessage{typ: relayErrorType, txnID: m.txnID}
+
+	skew := now.Sub(time.Unix(0, m.timestamp))
+	if skew < -relayMaxSkew || skew > relayMaxSkew || m.timestamp <= n.lastSeen[m.client] {
+		reply.errCode = relayErrStale
+		return reply.marshal(key)
+	}
+	n.lastSeen[m.client] = m.timestamp
+
+	pair := makeRelayPair(m.client, m.peer)
+	a, ok := n.allocs[pair]
+	if !ok {
+		if !n.hasRoomLocked(pair) {
+			reply.errCode = relayErrCapacity
+			return reply.marshal(key)
+		}
+		if a, err = n.openAllocationLocked(pair); err != nil {
+			n.logger.Warn().Err(err).Msg("relay allocation failed")
+			reply.errCode = relayErrCapacity
+			return reply.marshal(key)
+		}
+	}
+	a.addrs[pair.side(m.client)].Store(&src)
+
+	lifetime := m.lifetime
+	switch {
+	case lifetime == 0:
+		lifetime = relayDefaultLifetime
+	case lifetime < relayMinLifetime:
+		lifetime = relayMinLifetime
+	case lifetime > relayMaxLifetime:
+		lifetime = relayMaxLifetime
+	}
+	if expires := now.Add(lifetime); expires.After(a.expires) {
+		a.expires = expires
+	}
+	n.logger.Debug().Stringer("src", src).Int("port", int(a.port)).Dur("lifetime", lifetime).Msg("relay allocation granted")
+
+	reply.typ = relaySuccessType
+	reply.port = a.port
+	reply.lifetime = lifetime
+	return reply.marshal(key)
+}
+
+// macKeyLocked returns the MAC key for client, if client may allocate a
+// relay to peer.
+func (n *RelayNode) macKeyLocked(client, peer PeerKey) ([]byte, bool) {
+	if !n.keyed || client == peer || !n.allowed[client] || !n.allowed[peer] {
+		return nil, false
+	}
+	if key, ok := n.macKeys[client]; ok {
+		return key, true
+	}
+	key, err := relayMACKey(n.private, client)
+	if err != nil {
+		return nil, false
+	}
+	n.macKeys[client] = key
+	return key, true
+}
+
+func (n *RelayNode) hasRoomLocked(pair relayPair) bool {
+	if len(n.allocs) >= relayMaxAllocations {
+		return false
+	}
+	var counts [2]int
+	for other := range n.allocs {
+		for i, key := range pair {
+			if other[0] == key || other[1] == key {
+				counts[i]++
+			}
+		}
+	}
+	return counts[0] < relayMaxAllocationsPerKey && counts[1] < relayMaxAllocationsPerKey
+}
+
+func (n *RelayNode) openAllocationLocked(pair relayPair) (*relayAllocation, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	a := &relayAllocation{
+		pair: pair,
+		conn: conn,
+		port: uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+	}
+	n.allocs[pair] = a
+	n.loops.Add(1)
+	go n.forwardLoop(a)
+	return a, nil
+}
+
+func (n *RelayNode) closeAllocationLocked(a *relayAllocation) {
+	_ = a.conn.Close()
+	delete(n.allocs, a.pair)
+}
+
+// forwardLoop relays one allocation's datagrams between its two sides.
+func (n *RelayNode) forwardLoop(a *relayAllocation) {
+	defer n.loops.Done()
+	buf := make([]byte, relayBufSize)
+	consecutiveErrs := 0
+	for {
+		size, src, err := a.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			consecutiveErrs++
+			n.logger.Warn().Err(err).Int("port", int(a.port)).Int("consecutive", consecutiveErrs).Msg("relay allocation read error, retrying")
+			backoff(consecutiveErrs)
+			continue
+		}
+		consecutiveErrs = 0
+
+		src = normalize(src)
+		from, to := a.addrs[0].Load(), a.addrs[1].Load()
+		if to != nil && *to == src {
+			from, to = to, from
+		}
+		if from == nil || to == nil || *from != src {
+			n.dropped.Add(1)
+			continue
+		}
+		if _, err := a.conn.WriteToUDPAddrPort(buf[:size], *to); err != nil {
+			n.dropped.Add(1)
+			continue
+		}
+		n.forwarded.Add(1)
+	}
+}
+
+// reapLoop closes allocations whose lifetime ran out.
+func (n *RelayNode) reapLoop() {
+	defer n.loops.Done()
+	ticker := time.NewTicker(relayReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case now := <-ticker.C:
+			n.reap(now)
+		}
+	}
+}
+
+func (n *RelayNode) reap(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, a := range n.allocs {
+		if now.After(a.expires) {
+			n.logger.Debug().Int("port", int(a.port)).Msg("relay allocation expired")
+			n.closeAllocationLocked(a)
+		}
+	}
+}
+
+// AllocateRelay asks the relay node at server, whose WireGuard public key
+// is serverKey, for an allocation between this device (private) and peer;
+// the request leaves on the outer socket peer is pinned to, so the
+// allocation forwards to the address WireGuard's own traffic comes from.
+// It returns the relayed endpoint both sides should use and the lifetime
+// granted.
+func (p *Proxy) AllocateRelay(ctx context.Context, server netip.AddrPort, serverKey, private, peer PeerKey, lifetime time.Duration) (netip.AddrPort, time.Duration, error) {
+	client, err := publicKeyOf(private)
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	key, err := relayMACKey(private, serverKey)
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	txnID, err := newRelayTxnID()
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	req := relayMessage{
+		typ:       relayRequestType,
+		txnID:     txnID,
+		client:    client,
+		peer:      peer,
+		lifetime:  lifetime,
+		timestamp: time.Now().UnixNano(),
+	}
+
+	b, err := p.exchangeOn(ctx, p.pinnedIndex(peer), server, txnID, req.marshal(key))
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	resp, macInput, mac, err := parseRelayMessage(b)
+	if err != nil {
+		return netip.AddrPort{}, 0, err
+	}
+	if !verifyRelayMAC(key, macInput, mac) {
+		return netip.AddrPort{}, 0, errRelayMAC
+	}
+	if resp.typ == relayErrorType {
+		return netip.AddrPort{}, 0, fmt.Errorf("%w: %w", ErrRelayRefused, relayErrorCode(resp.errCode))
+	}
+	if resp.port == 0 {
+		return netip.AddrPort{}, 0, errRelayMessage
+	}
+	return netip.AddrPortFrom(server.Addr().Unmap(), resp.port), resp.lifetime, nil
+}
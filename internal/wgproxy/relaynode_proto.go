@@ -0,0 +1,227 @@
+// Relay node allocation protocol. Messages are STUN-shaped (RFC 8489
+// header, TLV attributes) under a method of stunmesh's own, so replies to a
+// proxy ride the demux's STUN transaction registry like binding responses.
+//
+// A request names the requesting peer's and the other peer's WireGuard
+// public keys, a lifetime and a timestamp, and ends in an HMAC-SHA256 keyed
+// with the X25519 secret between the requester's and the relay node's
+// WireGuard keys; the response carries the allocation's port under the same
+// MAC. Only a holder of an allowed peer's private key can allocate, and
+// timestamps must increase per requester, so captured requests do not
+// replay.
+package wgproxy
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// relayAllocateMethod is outside the IANA-assigned STUN methods.
+	relayAllocateMethod = 0x0A0
+
+	relayClassRequest = 0x00
+	relayClassSuccess = 0x02
+	relayClassError   = 0x03
+
+	relayAttrClientKey   = 0xC0A1
+	relayAttrPeerKey     = 0xC0A2
+	relayAttrLifetime    = 0xC0A3 // seconds, uint32
+	relayAttrTimestamp   = 0xC0A4 // unix nanoseconds, uint64
+	relayAttrRelayedPort = 0xC0A5 // uint16 and 2 bytes padding
+	relayAttrError       = 0xC0A6 // uint32 relay error code
+	relayAttrMAC         = 0xC0AF // HMAC-SHA256, always the last attribute
+
+	relayMACSize = sha256.Size
+
+	relayKeyLabel = "stunmesh relay v1"
+)
+
+// Relay error codes, returned under the requester's MAC.
+const (
+	relayErrStale    = 1 // timestamp outside the clock skew or not increasing
+	relayErrCapacity = 2 // no room for another allocation
+)
+
+var (
+	// ErrRelayRefused is returned by AllocateRelay for an authenticated
+	// refusal from the relay node.
+	ErrRelayRefused = errors.New("wgproxy: relay node refused the allocation")
+	errRelayMessage = errors.New("wgproxy: malformed relay message")
+	errRelayMAC     = errors.New("wgproxy: relay message failed authentication")
+)
+
+// stunMessageType interleaves a method's and a class's bits into a STUN
+// message type (RFC 8489 section 5).
+func stunMessageType(method uint16, class uint8) uint16 {
+	c := uint16(class)
+	return method&0x000F | (method&0x0070)<<1 | (method&0x0F80)<<2 | (c&1)<<4 | (c&2)<<7
+}
+
+var (
+	relayRequestType = stunMessageType(relayAllocateMethod, relayClassRequest)
+	relaySuccessType = stunMessageType(relayAllocateMethod, relayClassSuccess)
+	relayErrorType   = stunMessageType(relayAllocateMethod, relayClassError)
+)
+
+// relayMACKey derives the MAC key between a private key and a peer's public
+// key; both ends of the exchange compute the same one.
+func relayMACKey(private, public PeerKey) ([]byte, error) {
+	return deriveKey(relayKeyLabel, private, public)
+}
+
+// deriveKey derives a 32-byte key for label from the X25519 shared secret
+// of a private key and a peer's public key.
+func deriveKey(label string, private, public PeerKey) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private[:])
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(public[:])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(shared)
+	return h.Sum(nil), nil
+}
+
+// publicKeyOf returns the public key for a WireGuard private key.
+func publicKeyOf(private PeerKey) (PeerKey, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private[:])
+	if err != nil {
+		return PeerKey{}, err
+	}
+	return PeerKey(priv.PublicKey().Bytes()), nil
+}
+
+// relayMessage is a decoded allocation message.
+type relayMessage struct {
+	typ       uint16
+	txnID     TxnID
+	client    PeerKey
+	peer      PeerKey
+	lifetime  time.Duration
+	timestamp int64
+	port      uint16
+	errCode   uint32
+}
+
+// marshal encodes m, MAC'd with key.
+func (m *relayMessage) marshal(key []byte) []byte {
+	b := make([]byte, stunHeaderLen, 256)
+	binary.BigEndian.PutUint16(b[0:2], m.typ)
+	binary.BigEndian.PutUint32(b[4:8], stunMagicCookie)
+	copy(b[8:stunHeaderLen], m.txnID[:])
+
+	attr := func(typ uint16, value []byte) {
+		b = binary.BigEndian.AppendUint16(b, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+		b = append(b, value...)
+	}
+	switch m.typ {
+	case relayRequestType:
+		attr(relayAttrClientKey, m.client[:])
+		attr(relayAttrPeerKey, m.peer[:])
+		attr(relayAttrLifetime, binary.BigEndian.AppendUint32(nil, uint32(m.lifetime/time.Second)))
+		attr(relayAttrTimestamp, binary.BigEndian.AppendUint64(nil, uint64(m.timestamp)))
+	case relaySuccessType:
+		attr(relayAttrRelayedPort, binary.BigEndian.AppendUint16(nil, m.port))
+		b = append(b, 0, 0)
+		attr(relayAttrLifetime, binary.BigEndian.AppendUint32(nil, uint32(m.lifetime/time.Second)))
+	case relayErrorType:
+		attr(relayAttrError, binary.BigEndian.AppendUint32(nil, m.errCode))
+	}
+
+	// The length covers the MAC attribute, as MESSAGE-INTEGRITY's does.
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-stunHeaderLen+4+relayMACSize))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	attr(relayAttrMAC, mac.Sum(nil))
+	return b
+}
+
+// parseRelayMessage decodes b without checking its MAC; macInput and mac
+// are what verifyRelayMAC needs.
+func parseRelayMessage(b []byte) (m relayMessage, macInput, mac []byte, err error) {
+	if !isSTUNShaped(b) || int(binary.BigEndian.Uint16(b[2:4]))+stunHeaderLen != len(b) {
+		return m, nil, nil, errRelayMessage
+	}
+	m.typ = binary.BigEndian.Uint16(b[0:2])
+	copy(m.txnID[:], b[8:stunHeaderLen])
+
+	for off := stunHeaderLen; off < len(b); {
+		if off+4 > len(b) {
+			return m, nil, nil, errRelayMessage
+		}
+		typ := binary.BigEndian.Uint16(b[off : off+2])
+		n := int(binary.BigEndian.Uint16(b[off+2 : off+4]))
+		value := b[off+4:]
+		if n > len(value) {
+			return m, nil, nil, errRelayMessage
+		}
+		value = value[:n]
+		switch {
+		case typ == relayAttrClientKey && n == 32:
+			m.client = PeerKey(value)
+		case typ == relayAttrPeerKey && n == 32:
+			m.peer = PeerKey(value)
+		case typ == relayAttrLifetime && n == 4:
+			m.lifetime = time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+		case typ == relayAttrTimestamp && n == 8:
+			m.timestamp = int64(binary.BigEndian.Uint64(value))
+		case typ == relayAttrRelayedPort && n == 2:
+			m.port = binary.BigEndian.Uint16(value)
+		case typ == relayAttrError && n == 4:
+			m.errCode = binary.BigEndian.Uint32(value)
+		case typ == relayAttrMAC && n == relayMACSize && off+4+n == len(b):
+			return m, b[:off], value, nil
+		default:
+			return m, nil, nil, errRelayMessage
+		}
+		off += 4 + (n+3)&^3
+	}
+	return m, nil, nil, errRelayMessage
+}
+
+func verifyRelayMAC(key, macInput, mac []byte) bool {
+	h := hmac.New(sha256.New, key)
+	h.Write(macInput)
+	return hmac.Equal(h.Sum(nil), mac)
+}
+
+func newRelayTxnID() (TxnID, error) {
+	var id TxnID
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// isRelayResponse reports whether a STUN-shaped packet is an allocation
+// response, which the transaction registry routes like a binding response.
+func isRelayResponse(msgType uint16) bool {
+	return msgType == relaySuccessType || msgType == relayErrorType
+}
+
+// relayErrorCode is a relay error code as returned to AllocateRelay.
+type relayErrorCode uint32
+
+func (e relayErrorCode) Error() string {
+	switch e {
+	case relayErrStale:
+		return "stale timestamp"
+	case relayErrCapacity:
+		return "no capacity"
+	}
+	return fmt.Sprintf("error %d", uint32(e))
+}
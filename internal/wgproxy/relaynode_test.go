@@ -0,0 +1,324 @@
+package wgproxy
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// relayTestKey is a WireGuard key pair.
+type relayTestKey struct {
+	private, public PeerKey
+}
+
+func newRelayTestKey(t *testing.T) relayTestKey {
+	t.Helper()
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return relayTestKey{private: PeerKey(priv.Bytes()), public: PeerKey(priv.PublicKey().Bytes())}
+}
+
+func newTestRelayNode(t *testing.T, node relayTestKey, allowed ...PeerKey) *RelayNode {
+	t.Helper()
+	logger := zerolog.Nop()
+	n, err := NewRelayNode(&logger, 0)
+	if err != nil {
+		t.Fatalf("NewRelayNode: %v", err)
+	}
+	t.Cleanup(func() { _ = n.Close() })
+	if err := n.SetKeys(node.private, allowed); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+	return n
+}
+
+func newRelayTestProxy(t *testing.T) *Proxy {
+	t.Helper()
+	logger := zerolog.Nop()
+	p, err := New(&logger, map[Family]uint16{FamilyIPv4: 0})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func relayRequest(t *testing.T, client relayTestKey, node, peer PeerKey, timestamp int64) []byte {
+	t.Helper()
+	key, err := relayMACKey(client.private, node)
+	if err != nil {
+		t.Fatalf("relayMACKey: %v", err)
+	}
+	m := relayMessage{typ: relayRequestType, client: client.public, peer: peer, lifetime: time.Hour, timestamp: timestamp}
+	return m.marshal(key)
+}
+
+func TestStunMessageType(t *testing.T) {
+	t.Parallel()
+	// Binding (method 1) must come out as RFC 8489's well-known types.
+	if got := stunMessageType(0x001, relayClassSuccess); got != stunBindingSuccess {
+		t.Errorf("binding success = %#04x, want %#04x", got, stunBindingSuccess)
+	}
+	if got := stunMessageType(0x001, relayClassError); got != stunBindingError {
+		t.Errorf("binding error = %#04x, want %#04x", got, stunBindingError)
+	}
+	if isRelayResponse(stunBindingSuccess) || !isRelayResponse(relaySuccessType) || isRelayResponse(relayRequestType) {
+		t.Error("isRelayResponse misclassifies message types")
+	}
+}
+
+func TestRelayMessage_RoundTrip(t *testing.T) {
+	t.Parallel()
+	a, b := newRelayTestKey(t), newRelayTestKey(t)
+	keyA, err := relayMACKey(a.private, b.public)
+	if err != nil {
+		t.Fatalf("relayMACKey: %v", err)
+	}
+	keyB, err := relayMACKey(b.private, a.public)
+	if err != nil {
+		t.Fatalf("relayMACKey: %v", err)
+	}
+	if string(keyA) != string(keyB) {
+		t.Fatal("the two ends derived different MAC keys")
+	}
+
+	for _, m := range []relayMessage{
+		{typ: relayRequestType, txnID: TxnID{1}, client: a.public, peer: b.public, lifetime: time.Hour, timestamp: 42},
+		{typ: relaySuccessType, txnID: TxnID{2}, port: 40000, lifetime: time.Minute},
+		{typ: relayErrorType, txnID: TxnID{3}, errCode: relayErrCapacity},
+	} {
+		b := m.marshal(keyA)
+		if !isSTUNShaped(b) {
+			t.Fatalf("%#04x: marshaled message is not STUN-shaped", m.typ)
+		}
+		got, macInput, mac, err := parseRelayMessage(b)
+		if err != nil {
+			t.Fatalf("%#04x: parse: %v", m.typ, err)
+		}
+		if got != m {
+			t.Errorf("parsed %+v, want %+v", got, m)
+		}
+		if !verifyRelayMAC(keyB, macInput, mac) {
+			t.Errorf("%#04x: MAC did not verify", m.typ)
+		}
+
+		b[len(b)-relayMACSize-8] ^= 1
+		if _, macInput, mac, err := parseRelayMessage(b); err == nil && verifyRelayMAC(keyB, macInput, mac) {
+			t.Errorf("%#04x: tampered message verified", m.typ)
+		}
+	}
+}
+
+func TestParseRelayMessage_Malformed(t *testing.T) {
+	t.Parallel()
+	key := make([]byte, 32)
+	m := relayMessage{typ: relayErrorType, errCode: relayErrStale}
+	good := m.marshal(key)
+	for name, b := range map[string][]byte{
+		"truncated":  good[:len(good)-1],
+		"no MAC":     good[:stunHeaderLen+8],
+		"not STUN":   append([]byte{0x80}, good[1:]...),
+		"header len": append(append([]byte(nil), good...), 0, 0, 0, 0),
+	} {
+		if _, _, _, err := parseRelayMessage(b); err == nil {
+			t.Errorf("%s: parse succeeded", name)
+		}
+	}
+}
+
+// Two proxies that cannot reach each other directly get one allocation and
+// exchange WireGuard datagrams through it.
+func TestRelayNode_ForwardsBetweenAllocatedPeers(t *testing.T) {
+	t.Parallel()
+	nodeKey, keyA, keyB := newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t)
+	node := newTestRelayNode(t, nodeKey, keyA.public, keyB.public)
+	server := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), node.Port())
+	ctx := context.Background()
+
+	proxyA, proxyB := newRelayTestProxy(t), newRelayTestProxy(t)
+	relayed, lifetime, err := proxyA.AllocateRelay(ctx, server, nodeKey.public, keyA.private, keyB.public, time.Hour)
+	if err != nil {
+		t.Fatalf("AllocateRelay(A): %v", err)
+	}
+	if lifetime != time.Hour || relayed.Addr() != server.Addr() || relayed.Port() == server.Port() {
+		t.Fatalf("AllocateRelay(A) = %v for %v, want a relayed port on %v for an hour", relayed, lifetime, server.Addr())
+	}
+	relayedB, _, err := proxyB.AllocateRelay(ctx, server, nodeKey.public, keyB.private, keyA.public, time.Hour)
+	if err != nil {
+		t.Fatalf("AllocateRelay(B): %v", err)
+	}
+	if relayedB != relayed {
+		t.Fatalf("B was allocated %v, want the pair's %v", relayedB, relayed)
+	}
+	if got := node.Allocations(); got != 1 {
+		t.Fatalf("Allocations = %d, want 1", got)
+	}
+
+	// Stand-ins for each side's WireGuard.
+	wgA, wgB := relayTestConn(t), relayTestConn(t)
+	proxyA.SetWGTarget(uint16(wgA.LocalAddr().(*net.UDPAddr).Port))
+	proxyB.SetWGTarget(uint16(wgB.LocalAddr().(*net.UDPAddr).Port))
+	innerA, err := proxyA.AddPeer(keyB.public)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	innerB, err := proxyB.AddPeer(keyA.public)
+	if err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	proxyA.SetPeerEndpoint(keyB.public, relayed)
+	proxyB.SetPeerEndpoint(keyA.public, relayed)
+
+	for _, tc := range []struct {
+		from  *net.UDPConn
+		inner netip.AddrPort
+		to    *net.UDPConn
+	}{{wgA, innerA, wgB}, {wgB, innerB, wgA}} {
+		payload := []byte{4, 0, 0, 0, 'h', 'i'}
+		if _, err := tc.from.WriteToUDPAddrPort(payload, tc.inner); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if got := relayTestRead(t, tc.to); string(got) != string(payload) {
+			t.Fatalf("received %x, want %x", got, payload)
+		}
+	}
+
+	// Nobody else gets through the allocation.
+	stranger := relayTestConn(t)
+	if _, err := stranger.WriteToUDPAddrPort([]byte{4, 0, 0, 0}, relayed); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	deadline := time.Now().Add(testRelayTimeout)
+	for node.Dropped() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if node.Dropped() != 1 || node.Forwarded() != 2 {
+		t.Errorf("forwarded %d and dropped %d, want 2 and 1", node.Forwarded(), node.Dropped())
+	}
+}
+
+// A key the node does not know gets no answer at all.
+func TestRelayNode_IgnoresUnallowedKeys(t *testing.T) {
+	t.Parallel()
+	nodeKey, keyA, keyB, stranger := newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t)
+	node := newTestRelayNode(t, nodeKey, keyA.public, keyB.public)
+	server := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), node.Port())
+
+	p := newRelayTestProxy(t)
+	p.SetExchangeTimeoutForTest(200 * time.Millisecond)
+	for name, tc := range map[string]struct {
+		private, peer PeerKey
+	}{
+		"unallowed client": {stranger.private, keyB.public},
+		"unallowed peer":   {keyA.private, stranger.public},
+		"self":             {keyA.private, keyA.public},
+	} {
+		_, _, err := p.AllocateRelay(context.Background(), server, nodeKey.public, tc.private, tc.peer, time.Hour)
+		if !errors.Is(err, ErrExchangeTimeout) {
+			t.Errorf("%s: AllocateRelay error = %v, want no reply", name, err)
+		}
+	}
+	// A client with the wrong idea of the node's key fails the MAC too.
+	_, _, err := p.AllocateRelay(context.Background(), server, stranger.public, keyA.private, keyB.public, time.Hour)
+	if !errors.Is(err, ErrExchangeTimeout) {
+		t.Errorf("wrong node key: AllocateRelay error = %v, want no reply", err)
+	}
+	if got := node.Allocations(); got != 0 {
+		t.Errorf("Allocations = %d, want 0", got)
+	}
+}
+
+func TestRelayNode_RejectsReplayAndSkew(t *testing.T) {
+	t.Parallel()
+	nodeKey, keyA, keyB := newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t)
+	node := newTestRelayNode(t, nodeKey, keyA.public, keyB.public)
+	src := netip.MustParseAddrPort("192.0.2.1:51820")
+	now := time.Now()
+
+	replyType := func(b []byte) uint16 {
+		t.Helper()
+		if b == nil {
+			t.Fatal("no reply")
+		}
+		m, _, _, err := parseRelayMessage(b)
+		if err != nil {
+			t.Fatalf("parse reply: %v", err)
+		}
+		return m.typ
+	}
+
+	req := relayRequest(t, keyA, nodeKey.public, keyB.public, now.UnixNano())
+	if got := replyType(node.handle(src, req, now)); got != relaySuccessType {
+		t.Fatalf("first request answered %#04x, want success", got)
+	}
+	if got := replyType(node.handle(src, req, now)); got != relayErrorType {
+		t.Errorf("replayed request answered %#04x, want an error", got)
+	}
+	old := relayRequest(t, keyA, nodeKey.public, keyB.public, now.Add(-time.Hour).UnixNano())
+	if got := replyType(node.handle(src, old, now)); got != relayErrorType {
+		t.Errorf("stale request answered %#04x, want an error", got)
+	}
+	// keyB's timestamps are its own.
+	reqB := relayRequest(t, keyB, nodeKey.public, keyA.public, now.UnixNano())
+	if got := replyType(node.handle(src, reqB, now)); got != relaySuccessType {
+		t.Errorf("other client's request answered %#04x, want success", got)
+	}
+}
+
+func TestRelayNode_SetKeysClosesRevokedAllocations(t *testing.T) {
+	t.Parallel()
+	nodeKey, keyA, keyB, keyC := newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t), newRelayTestKey(t)
+	node := newTestRelayNode(t, nodeKey, keyA.public, keyB.public, keyC.public)
+	src := netip.MustParseAddrPort("192.0.2.1:51820")
+	now := time.Now()
+
+	node.handle(src, relayRequest(t, keyA, nodeKey.public, keyB.public, now.UnixNano()), now)
+	node.handle(src, relayRequest(t, keyA, nodeKey.public, keyC.public, now.UnixNano()+1), now)
+	if got := node.Allocations(); got != 2 {
+		t.Fatalf("Allocations = %d, want 2", got)
+	}
+	if err := node.SetKeys(nodeKey.private, []PeerKey{keyA.public, keyB.public}); err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+	if got := node.Allocations(); got != 1 {
+		t.Errorf("Allocations after revoking C = %d, want 1", got)
+	}
+	node.reap(now.Add(2 * time.Hour))
+	if got := node.Allocations(); got != 0 {
+		t.Errorf("Allocations after expiry = %d, want 0", got)
+	}
+}
+
+const testRelayTimeout = 2 * time.Second
+
+func relayTestConn(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func relayTestRead(t *testing.T, conn *net.UDPConn) []byte {
+	t.Helper()
+	buf := make([]byte, 2048)
+	if err := conn.SetReadDeadline(time.Now().Add(testRelayTimeout)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return buf[:n]
+}
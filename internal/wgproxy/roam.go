@@ -0,0 +1,287 @@
+// Roaming: opt-in learning of a peer's new outer source after its NAT
+// rebinds (WithRoaming). Without it the demux never learns from inbound
+// sources, and a rebound peer's packets drop until the next
+// publish/establish cycle re-programs it.
+//
+// The proxy cannot authenticate WireGuard traffic, so it lets WireGuard do
+// it. Session indexes WireGuard sends from a peer's inner socket tie later
+// inbound messages to that peer: a packet from an unknown source naming one
+// of them as receiver makes the source the peer's roam candidate, and is
+// relayed so WireGuard can judge it. Handshake messages are copied to the
+// candidate, and the peer is re-programmed only when WireGuard sends
+// transport data to the session index of a handshake that came from the
+// candidate — which it does only after authenticating that handshake.
+// Forged messages fail WireGuard's checks and replays its timestamp and
+// handshake state, so neither moves the peer. A replay of the peer's
+// genuine handshake from another source would pass once WireGuard takes
+// the genuine copy, so a handshake that also came from the peer's mapped
+// source confirms nothing. Candidates change at most once per
+// roamCandidateInterval per peer.
+package wgproxy
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// WireGuard message types and sizes (whitepaper section 5.4).
+const (
+	wgMessageInitiation  = 1
+	wgMessageResponse    = 2
+	wgMessageCookieReply = 3
+	wgMessageTransport   = 4
+
+	wgInitiationSize   = 148
+	wgResponseSize     = 92
+	wgCookieReplySize  = 64
+	wgTransportMinSize = 32
+)
+
+const (
+	// roamIndexesPerPeer bounds the session indexes remembered per peer:
+	// WireGuard holds at most a current, previous and next keypair, plus
+	// one handshake in flight.
+	roamIndexesPerPeer = 4
+	// roamCandidateInterval is how long a peer's candidate source holds
+	// before another source may replace it.
+	roamCandidateInterval = time.Second
+)
+
+// WithRoaming lets the proxy follow a peer to a new outer source once
+// WireGuard authenticates a handshake from it; off by default.
+func WithRoaming(enabled bool) Option {
+	return func(o *options) {
+		o.roaming = enabled
+	}
+}
+
+// wgMessageType returns b's WireGuard message type, 0 when b is not shaped
+// like one.
+func wgMessageType(b []byte) byte {
+	return wgMessageTypeOf(b, len(b))
+}
+
+// wgMessageTypeOf is wgMessageType for a size-byte message starting with
+// head.
+func wgMessageTypeOf(head []byte, size int) byte {
+	if len(head) < 4 || head[1] != 0 || head[2] != 0 || head[3] != 0 {
+		return 0
+	}
+	switch typ := head[0]; {
+	case typ == wgMessageInitiation && size == wgInitiationSize,
+		typ == wgMessageResponse && size == wgResponseSize,
+		typ == wgMessageCookieReply && size == wgCookieReplySize,
+		typ == wgMessageTransport && size >= wgTransportMinSize:
+		return typ
+	}
+	return 0
+}
+
+// senderIndex returns the index a handshake message's sender picked for
+// the session; only meaningful for initiations and responses.
+func senderIndex(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b[4:8])
+}
+
+// receiverIndex returns the session index b is addressed to; false for an
+// initiation, which has none.
+func receiverIndex(typ byte, b []byte) (uint32, bool) {
+	switch typ {
+	case wgMessageResponse:
+		return binary.LittleEndian.Uint32(b[8:12]), true
+	case wgMessageCookieReply, wgMessageTransport:
+		return binary.LittleEndian.Uint32(b[4:8]), true
+	}
+	return 0, false
+}
+
+type roamCandidate struct {
+	src   netip.AddrPort
+	since time.Time
+	// remoteIndex is the sender index of the latest handshake message from
+	// src; WireGuard addressing transport data to it confirms the roam.
+	remoteIndex uint32
+	handshake   bool
+}
+
+// roamState is the demux's roaming bookkeeping; nil when roaming is off.
+type roamState struct {
+	now func() time.Time
+
+	mu            sync.Mutex
+	peerByIndex   map[uint32]PeerKey
+	indexesByPeer map[PeerKey][]uint32
+	candidates    map[PeerKey]*roamCandidate
+	// mappedIndexes are the sender indexes of the latest handshake messages
+	// from each peer's mapped source, at most roamIndexesPerPeer.
+	mappedIndexes map[PeerKey][]uint32
+}
+
+func newRoamState() *roamState {
+	return &roamState{
+		now:           time.Now,
+		peerByIndex:   make(map[uint32]PeerKey),
+		indexesByPeer: make(map[PeerKey][]uint32),
+		candidates:    make(map[PeerKey]*roamCandidate),
+		mappedIndexes: make(map[PeerKey][]uint32),
+	}
+}
+
+// mapped notes a packet from peer's mapped source, size bytes starting with
+// head, so the handshakes it carries cannot confirm a candidate.
+func (r *roamState) mapped(peer PeerKey, head []byte, size int) {
+	typ := wgMessageTypeOf(head, size)
+	if typ != wgMessageInitiation && typ != wgMessageResponse {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	indexes := append(r.mappedIndexes[peer], senderIndex(head))
+	if len(indexes) > roamIndexesPerPeer {
+		indexes = indexes[1:]
+	}
+	r.mappedIndexes[peer] = indexes
+}
+
+// inbound attributes a packet from an unmapped source to the peer whose
+// session it names, making src that peer's candidate; false drops it.
+func (r *roamState) inbound(src netip.AddrPort, b []byte) (PeerKey, bool) {
+	typ := wgMessageType(b)
+	if typ == 0 {
+		return PeerKey{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var peer PeerKey
+	if index, ok := receiverIndex(typ, b); ok {
+		if peer, ok = r.peerByIndex[index]; !ok {
+			return PeerKey{}, false
+		}
+	} else if peer, ok = r.peerByCandidate(src); !ok {
+		// An initiation names no session; only a candidate's is relayed.
+		return PeerKey{}, false
+	}
+
+	c := r.candidates[peer]
+	if c == nil || c.src != src {
+		now := r.now()
+		if c != nil && now.Sub(c.since) < roamCandidateInterval {
+			return PeerKey{}, false
+		}
+		c = &roamCandidate{src: src, since: now}
+		r.candidates[peer] = c
+	}
+	if typ == wgMessageInitiation || typ == wgMessageResponse {
+		c.remoteIndex, c.handshake = senderIndex(b), true
+	}
+	return peer, true
+}
+
+func (r *roamState) peerByCandidate(src netip.AddrPort) (PeerKey, bool) {
+	for peer, c := range r.candidates {
+		if c.src == src {
+			return peer, true
+		}
+	}
+	return PeerKey{}, false
+}
+
+// outbound notes a packet WireGuard sent for peer. copyTo is set for a
+// handshake message the peer's candidate should also get; roamTo is set
+// once WireGuard confirms the candidate, which it stops being. A candidate
+// whose handshake also came from the mapped source is dropped instead.
+func (r *roamState) outbound(peer PeerKey, b []byte) (copyTo, roamTo netip.AddrPort) {
+	typ := wgMessageType(b)
+	if typ == 0 {
+		return netip.AddrPort{}, netip.AddrPort{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.candidates[peer]
+	switch typ {
+	case wgMessageInitiation, wgMessageResponse:
+		r.rememberIndex(peer, senderIndex(b))
+		if c != nil {
+			copyTo = c.src
+		}
+	case wgMessageTransport:
+		if index, _ := receiverIndex(typ, b); c != nil && c.handshake && c.remoteIndex == index {
+			if !slices.Contains(r.mappedIndexes[peer], index) {
+				roamTo = c.src
+			}
+			delete(r.candidates, peer)
+		}
+	}
+	return copyTo, roamTo
+}
+
+// rememberIndex maps a session index to peer, forgetting the peer's oldest
+// beyond roamIndexesPerPeer.
+func (r *roamState) rememberIndex(peer PeerKey, index uint32) {
+	if old, ok := r.peerByIndex[index]; ok && old == peer {
+		return
+	}
+	r.peerByIndex[index] = peer
+	indexes := append(r.indexesByPeer[peer], index)
+	if len(indexes) > roamIndexesPerPeer {
+		if evicted := indexes[0]; r.peerByIndex[evicted] == peer {
+			delete(r.peerByIndex, evicted)
+		}
+		indexes = indexes[1:]
+	}
+	r.indexesByPeer[peer] = indexes
+}
+
+// reprogrammed drops peer's candidate once its endpoint is set explicitly.
+func (r *roamState) reprogrammed(peer PeerKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.candidates, peer)
+	delete(r.mappedIndexes, peer)
+}
+
+// forget drops everything known about peer.
+func (r *roamState) forget(peer PeerKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.candidates, peer)
+	delete(r.mappedIndexes, peer)
+	for _, index := range r.indexesByPeer[peer] {
+		if r.peerByIndex[index] == peer {
+			delete(r.peerByIndex, index)
+		}
+	}
+	delete(r.indexesByPeer, peer)
+}
+
+// roamOutbound feeds one packet WireGuard wrote for ps to the roaming
+// state: handshake messages are copied to the peer's candidate, and a
+// confirmed candidate becomes the peer's endpoint.
+func (p *Proxy) roamOutbound(ps *peerState, b []byte) {
+	copyTo, roamTo := p.demux.roam.outbound(ps.key, b)
+	if roamTo.IsValid() {
+		p.demux.Program(ps.key, roamTo)
+		ps.remote.Store(&roamTo)
+		p.demux.roamed.Add(1)
+		p.logger.Info().Str("remote", roamTo.String()).Msg("peer roamed to a new source")
+	}
+	if !copyTo.IsValid() {
+		return
+	}
+	sock, ok := p.peerSocket(ps, familyOf(copyTo))
+	if !ok {
+		return
+	}
+	p.tap(sideOuter, false, outerLocal(familyOf(copyTo), sock.port), copyTo, b, "relay, roaming candidate")
+	if o := ps.sendObfuscator(); o != nil {
+		b = o.seal(nil, b, padding(b))
+	}
+	if _, err := sock.conn.WriteToUDPAddrPort(b, copyTo); err != nil {
+		p.countWarn(&p.writeErrs, "outer write failed")
+	}
+}
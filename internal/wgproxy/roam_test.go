@@ -0,0 +1,206 @@
+package wgproxy
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func roamPeer(seed byte) PeerKey {
+	var key PeerKey
+	for i := range key {
+		key[i] = seed
+	}
+	return key
+}
+
+func wgInitiation(sender uint32) []byte {
+	b := make([]byte, wgInitiationSize)
+	b[0] = wgMessageInitiation
+	binary.LittleEndian.PutUint32(b[4:8], sender)
+	return b
+}
+
+func wgResponse(sender, receiver uint32) []byte {
+	b := make([]byte, wgResponseSize)
+	b[0] = wgMessageResponse
+	binary.LittleEndian.PutUint32(b[4:8], sender)
+	binary.LittleEndian.PutUint32(b[8:12], receiver)
+	return b
+}
+
+func wgTransport(receiver uint32) []byte {
+	b := make([]byte, wgTransportMinSize)
+	b[0] = wgMessageTransport
+	binary.LittleEndian.PutUint32(b[4:8], receiver)
+	return b
+}
+
+type roamFixture struct {
+	r   *roamState
+	now time.Time
+}
+
+func newRoamFixture() *roamFixture {
+	f := &roamFixture{r: newRoamState(), now: time.Unix(1700000000, 0)}
+	f.r.now = func() time.Time { return f.now }
+	return f
+}
+
+func TestWGMessageType(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want byte
+	}{
+		{"initiation", wgInitiation(1), wgMessageInitiation},
+		{"response", wgResponse(1, 2), wgMessageResponse},
+		{"transport", wgTransport(1), wgMessageTransport},
+		{"short initiation", wgInitiation(1)[:100], 0},
+		{"short transport", wgTransport(1)[:16], 0},
+		{"reserved bytes set", append([]byte{4, 1, 0, 0}, make([]byte, 28)...), 0},
+		{"unknown type", append([]byte{9, 0, 0, 0}, make([]byte, 28)...), 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wgMessageType(tt.b); got != tt.want {
+				t.Fatalf("wgMessageType = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoamState_UnknownSessionDropped(t *testing.T) {
+	f := newRoamFixture()
+	src := netip.MustParseAddrPort("198.51.100.7:4000")
+	if _, ok := f.r.inbound(src, wgTransport(0x11)); ok {
+		t.Fatal("transport for a session never seen outbound must not be attributed")
+	}
+	if _, ok := f.r.inbound(src, wgInitiation(0x11)); ok {
+		t.Fatal("initiation from a source that is no candidate must not be attributed")
+	}
+}
+
+func TestRoamState_ConfirmedByWireGuard(t *testing.T) {
+	f := newRoamFixture()
+	peer := roamPeer(1)
+	newSrc := netip.MustParseAddrPort("198.51.100.7:4000")
+
+	f.r.outbound(peer, wgInitiation(0x11))
+	got, ok := f.r.inbound(newSrc, wgTransport(0x11))
+	if !ok || got != peer {
+		t.Fatalf("inbound transport for a known session = %v, %v; want the peer", got, ok)
+	}
+
+	copyTo, roamTo := f.r.outbound(peer, wgInitiation(0x22))
+	if copyTo != newSrc || roamTo.IsValid() {
+		t.Fatalf("outbound initiation: copyTo %s roamTo %s, want a copy to %s and no roam", copyTo, roamTo, newSrc)
+	}
+	if _, ok := f.r.inbound(newSrc, wgResponse(0x33, 0x22)); !ok {
+		t.Fatal("response from the candidate must be relayed")
+	}
+	// Data for another session does not confirm the candidate's handshake.
+	if _, roamTo := f.r.outbound(peer, wgTransport(0x99)); roamTo.IsValid() {
+		t.Fatalf("unrelated transport roamed to %s", roamTo)
+	}
+	if _, roamTo := f.r.outbound(peer, wgTransport(0x33)); roamTo != newSrc {
+		t.Fatalf("transport to the candidate's session roamTo = %s, want %s", roamTo, newSrc)
+	}
+	if _, roamTo := f.r.outbound(peer, wgTransport(0x33)); roamTo.IsValid() {
+		t.Fatal("a confirmed candidate must not roam twice")
+	}
+}
+
+// An on-path attacker replaying the peer's genuine handshake response from
+// its own address must not become the peer's endpoint, whichever copy
+// arrives first, even though WireGuard answers the genuine one.
+func TestDemux_ReplayedHandshakeResponseDoesNotRoam(t *testing.T) {
+	mappedSrc := netip.MustParseAddrPort("203.0.113.10:51820")
+	attacker := netip.MustParseAddrPort("192.0.2.66:6666")
+	for _, replayFirst := range []bool{false, true} {
+		logger := zerolog.Nop()
+		d := NewDemux(&logger)
+		d.roam = newRoamState()
+		peer := roamPeer(1)
+		d.Program(peer, mappedSrc)
+
+		d.roam.outbound(peer, wgInitiation(0x11))
+		response := wgResponse(0x33, 0x11)
+		if !replayFirst {
+			d.Classify(mappedSrc, response)
+		}
+		if got := d.Classify(attacker, response); got.Bucket != BucketRelay || got.Peer != peer {
+			t.Fatalf("replayFirst=%v: replayed response = %+v, want it relayed for WireGuard to judge", replayFirst, got)
+		}
+		if replayFirst {
+			d.Classify(mappedSrc, response)
+		}
+		if _, roamTo := d.roam.outbound(peer, wgTransport(0x33)); roamTo.IsValid() {
+			t.Errorf("replayFirst=%v: roamed to %s on a handshake the mapped source also sent", replayFirst, roamTo)
+		}
+	}
+}
+
+func TestRoamState_InitiationFromCandidate(t *testing.T) {
+	f := newRoamFixture()
+	peer := roamPeer(1)
+	newSrc := netip.MustParseAddrPort("198.51.100.7:4000")
+
+	f.r.outbound(peer, wgResponse(0x11, 0x01))
+	if _, ok := f.r.inbound(newSrc, wgTransport(0x11)); !ok {
+		t.Fatal("transport for a known session must be relayed")
+	}
+	if got, ok := f.r.inbound(newSrc, wgInitiation(0x44)); !ok || got != peer {
+		t.Fatalf("initiation from the candidate = %v, %v; want the peer", got, ok)
+	}
+	if copyTo, _ := f.r.outbound(peer, wgResponse(0x55, 0x44)); copyTo != newSrc {
+		t.Fatalf("response copyTo = %s, want %s", copyTo, newSrc)
+	}
+	if _, roamTo := f.r.outbound(peer, wgTransport(0x44)); roamTo != newSrc {
+		t.Fatalf("roamTo = %s, want %s", roamTo, newSrc)
+	}
+}
+
+func TestRoamState_CandidateRateLimited(t *testing.T) {
+	f := newRoamFixture()
+	peer := roamPeer(1)
+	first := netip.MustParseAddrPort("198.51.100.7:4000")
+	second := netip.MustParseAddrPort("203.0.113.9:5000")
+
+	f.r.outbound(peer, wgInitiation(0x11))
+	if _, ok := f.r.inbound(first, wgTransport(0x11)); !ok {
+		t.Fatal("first candidate must be accepted")
+	}
+	if _, ok := f.r.inbound(second, wgResponse(0x66, 0x11)); ok {
+		t.Fatal("a second source within the interval must be dropped")
+	}
+	f.now = f.now.Add(roamCandidateInterval)
+	if _, ok := f.r.inbound(second, wgTransport(0x11)); !ok {
+		t.Fatal("a second source after the interval must replace the candidate")
+	}
+	if copyTo, _ := f.r.outbound(peer, wgInitiation(0x22)); copyTo != second {
+		t.Fatalf("copyTo = %s, want the replacing candidate %s", copyTo, second)
+	}
+}
+
+func TestRoamState_IndexesBounded(t *testing.T) {
+	f := newRoamFixture()
+	peer := roamPeer(1)
+	for index := uint32(1); index <= roamIndexesPerPeer+2; index++ {
+		f.r.outbound(peer, wgInitiation(index))
+	}
+	if len(f.r.peerByIndex) != roamIndexesPerPeer {
+		t.Fatalf("remembered %d indexes, want %d", len(f.r.peerByIndex), roamIndexesPerPeer)
+	}
+	if _, ok := f.r.peerByIndex[1]; ok {
+		t.Fatal("the oldest index must be forgotten")
+	}
+	f.r.forget(peer)
+	if len(f.r.peerByIndex) != 0 || len(f.r.indexesByPeer) != 0 {
+		t.Fatal("forget must drop every index of the peer")
+	}
+}
@@ -0,0 +1,176 @@
+// Statistics: per-peer traffic counters kept by the relay loops and
+// per-source drop counts kept by the demux, read together via Proxy.Stats
+// for status output and metrics.
+package wgproxy
+
+import (
+	"bytes"
+	"cmp"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// dropSourcesTracked bounds the sources the demux counts drops for; a
+	// flood from spoofed sources costs no more memory than this.
+	dropSourcesTracked = 256
+	// dropSourcesTop is how many of them Stats reports.
+	dropSourcesTop = 16
+)
+
+// Stats is a snapshot of a Proxy's counters.
+type Stats struct {
+	// Peers holds one entry per peer with an inner socket, ordered by key.
+	Peers []PeerStats
+	// DropSources lists the unknown senders with the most dropped packets,
+	// most first, at most dropSourcesTop of them.
+	DropSources []SourceDrops
+
+	Truncated             uint64
+	Unroutable            uint64
+	WriteErrors           uint64
+	DroppedSTUN           uint64
+	DroppedUnattributable uint64
+	Roamed                uint64
+}
+
+// PeerStats counts one peer's relayed traffic. Rx is inbound (remote to
+// WireGuard), Tx outbound (WireGuard to remote); Last* are zero until the
+// first packet that way.
+type PeerStats struct {
+	Key    PeerKey
+	Remote netip.AddrPort // zero until programmed
+
+	RxPackets uint64
+	RxBytes   uint64
+	LastRx    time.Time
+	TxPackets uint64
+	TxBytes   uint64
+	LastTx    time.Time
+}
+
+// SourceDrops counts packets dropped from one unknown sender. Once more
+// than dropSourcesTracked senders were seen, Packets is an upper bound: a
+// newly seen sender inherits the count of the one it evicts.
+type SourceDrops struct {
+	Src     netip.AddrPort
+	Packets uint64
+}
+
+// peerCounters is peerState's share of Stats, updated by the relay loops.
+type peerCounters struct {
+	rxPackets, rxBytes atomic.Uint64
+	txPackets, txBytes atomic.Uint64
+	lastRx, lastTx     atomic.Int64 // unix nanoseconds, 0 for never
+}
+
+func (c *peerCounters) received(packets, n int) {
+	c.rxPackets.Add(uint64(packets))
+	c.rxBytes.Add(uint64(n))
+	c.lastRx.Store(time.Now().UnixNano())
+}
+
+func (c *peerCounters) sent(packets, n int) {
+	c.txPackets.Add(uint64(packets))
+	c.txBytes.Add(uint64(n))
+	c.lastTx.Store(time.Now().UnixNano())
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// dropSources counts drops per sender with the space-saving algorithm:
+// when the table is full, a new sender replaces the least-counted one.
+type dropSources struct {
+	mu     sync.Mutex
+	counts map[netip.AddrPort]uint64
+}
+
+func (s *dropSources) add(src netip.AddrPort) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[netip.AddrPort]uint64)
+	}
+	if _, ok := s.counts[src]; !ok && len(s.counts) >= dropSourcesTracked {
+		var (
+			victim netip.AddrPort
+			least  uint64
+		)
+		for addr, n := range s.counts {
+			if !victim.IsValid() || n < least {
+				victim, least = addr, n
+			}
+		}
+		delete(s.counts, victim)
+		s.counts[src] = least
+	}
+	s.counts[src]++
+}
+
+// top returns the n senders with the most drops, most first.
+func (s *dropSources) top(n int) []SourceDrops {
+	s.mu.Lock()
+	all := make([]SourceDrops, 0, len(s.counts))
+	for src, packets := range s.counts {
+		all = append(all, SourceDrops{Src: src, Packets: packets})
+	}
+	s.mu.Unlock()
+	slices.SortFunc(all, func(a, b SourceDrops) int {
+		if c := cmp.Compare(b.Packets, a.Packets); c != 0 {
+			return c
+		}
+		return a.Src.Compare(b.Src)
+	})
+	return all[:min(n, len(all))]
+}
+
+// DropSources returns the n unknown senders with the most dropped packets,
+// most first.
+func (d *Demux) DropSources(n int) []SourceDrops {
+	return d.dropSources.top(n)
+}
+
+// Stats snapshots the proxy's counters. Counters are read one by one, so a
+// snapshot taken under traffic may be off by the packets in flight.
+func (p *Proxy) Stats() Stats {
+	p.mu.RLock()
+	peers := make([]PeerStats, 0, len(p.peers))
+	for key, ps := range p.peers {
+		s := PeerStats{
+			Key:       key,
+			RxPackets: ps.stats.rxPackets.Load(),
+			RxBytes:   ps.stats.rxBytes.Load(),
+			LastRx:    unixNanoTime(ps.stats.lastRx.Load()),
+			TxPackets: ps.stats.txPackets.Load(),
+			TxBytes:   ps.stats.txBytes.Load(),
+			LastTx:    unixNanoTime(ps.stats.lastTx.Load()),
+		}
+		if remote := ps.remote.Load(); remote != nil {
+			s.Remote = *remote
+		}
+		peers = append(peers, s)
+	}
+	p.mu.RUnlock()
+	slices.SortFunc(peers, func(a, b PeerStats) int {
+		return bytes.Compare(a.Key[:], b.Key[:])
+	})
+
+	return Stats{
+		Peers:                 peers,
+		DropSources:           p.demux.DropSources(dropSourcesTop),
+		Truncated:             p.truncated.Load(),
+		Unroutable:            p.unroutable.Load(),
+		WriteErrors:           p.writeErrs.Load(),
+		DroppedSTUN:           p.demux.DroppedSTUN(),
+		DroppedUnattributable: p.demux.DroppedUnattributable(),
+		Roamed:                p.demux.Roamed(),
+	}
+}
@@ -0,0 +1,51 @@
+package wgproxy
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDropSources_TopOrdered(t *testing.T) {
+	var s dropSources
+	a := netip.MustParseAddrPort("198.51.100.1:1000")
+	b := netip.MustParseAddrPort("198.51.100.2:1000")
+	c := netip.MustParseAddrPort("198.51.100.3:1000")
+	for range 3 {
+		s.add(b)
+	}
+	s.add(a)
+	s.add(c)
+	s.add(c)
+
+	got := s.top(2)
+	want := []SourceDrops{{Src: b, Packets: 3}, {Src: c, Packets: 2}}
+	if len(got) != len(want) {
+		t.Fatalf("top(2) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("top(2)[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if got := s.top(10); len(got) != 3 {
+		t.Fatalf("top(10) returned %d sources, want all 3", len(got))
+	}
+}
+
+func TestDropSources_Bounded(t *testing.T) {
+	var s dropSources
+	heavy := netip.MustParseAddrPort("203.0.113.1:53")
+	for range 100 {
+		s.add(heavy)
+	}
+	// A spoofed flood: every packet from a new source.
+	for i := range 4 * dropSourcesTracked {
+		s.add(netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 1))
+	}
+	if len(s.counts) != dropSourcesTracked {
+		t.Fatalf("tracking %d sources, want the bound %d", len(s.counts), dropSourcesTracked)
+	}
+	if top := s.top(1); len(top) != 1 || top[0].Src != heavy {
+		t.Fatalf("top(1) = %v, want the heavy sender to survive the flood", top)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
 	"runtime/debug"
 
 	"github.com/tjjh89017/stunmesh-go/internal/config"
@@ -49,6 +50,14 @@ func main() {
 		panic(err)
 	}
 
+	if flag.Arg(0) == "gc" {
+		if err := runGC(ctx, cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	daemon, cleanup, err := setup(cfg)
 	if err != nil {
 		panic(err)
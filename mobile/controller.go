@@ -106,11 +106,13 @@ func (c *controller) start() {
 	go c.run(ctx)
 }
 
-// stop cancels the loop and waits for the current cycle to finish. Must not
-// be called with the node mutex held: a running cycle may be blocked on it.
+// stop cancels the loop, waits for the current cycle to finish and closes
+// the plugins. Must not be called with the node mutex held: a running cycle
+// may be blocked on it.
 func (c *controller) stop() {
 	c.cancel()
 	<-c.done
+	_ = c.manager.Close()
 }
 
 func (c *controller) run(ctx context.Context) {
@@ -23,6 +23,7 @@ func TestSelectEndpoint(t *testing.T) {
 	ipv4Only := ctrl.EndpointData{IPv4: "1.2.3.4:51820"}
 	ipv6Only := ctrl.EndpointData{IPv6: "[2001:db8::1]:51820"}
 	empty := ctrl.EndpointData{}
+	mapped := ctrl.EndpointData{IPv4: "1.2.3.4:51820", MappedIPv4: "1.2.3.4:40000", IPv6: "[2001:db8::1]:51820"}
 
 	tests := []struct {
 		name     string
@@ -46,6 +47,9 @@ func TestSelectEndpoint(t *testing.T) {
 		{"prefer_ipv6 falls back to ipv4", ipv4Only, "prefer_ipv6", "1.2.3.4:51820", false},
 		{"prefer_ipv6 errors when both absent", empty, "prefer_ipv6", "", true},
 
+		{"ipv4 prefers the mapped endpoint", mapped, "ipv4", "1.2.3.4:40000", false},
+		{"prefer_ipv6 keeps ipv6 over a mapping", mapped, "prefer_ipv6", "[2001:db8::1]:51820", false},
+
 		{"unknown protocol errors", both, "carrier-pigeon", "", true},
 	}
 
@@ -0,0 +1,107 @@
+package pluginapi
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors a store returns, wrapped or not, to say why a call failed, so the
+// daemon can react to it: a record that does not exist yet is expected, a
+// rejected token needs the operator, and a rate limit needs the daemon to
+// slow down. A store that cannot tell returns an error that is none of
+// these.
+var (
+	// ErrNotFound is returned by Get when the key has no record, such as
+	// when the peer has not published one yet.
+	ErrNotFound = errors.New("record not found")
+	// ErrUnauthorized is returned when the backend rejected the store's
+	// credentials or does not let them touch the record.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited is returned when the backend refused the call because
+	// it was made too often.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrTransient is returned when the backend failed in a way a later
+	// call may not, such as a server error.
+	ErrTransient = errors.New("transient error")
+)
+
+// Codes for the errors above, in an exec plugin's ExecResponse.Code and an
+// rpc plugin's errors.
+const (
+	CodeNotFound     = "not_found"
+	CodeUnauthorized = "unauthorized"
+	CodeRateLimited  = "rate_limited"
+	CodeTransient    = "transient"
+)
+
+// Exit codes a shell plugin exits with to report the errors above. Any
+// other non-zero exit is a failure that is none of them.
+const (
+	ExitNotFound     = 3
+	ExitUnauthorized = 4
+	ExitRateLimited  = 5
+	ExitTransient    = 6
+)
+
+var codes = []struct {
+	err  error
+	code string
+	exit int
+}{
+	{ErrNotFound, CodeNotFound, ExitNotFound},
+	{ErrUnauthorized, CodeUnauthorized, ExitUnauthorized},
+	{ErrRateLimited, CodeRateLimited, ExitRateLimited},
+	{ErrTransient, CodeTransient, ExitTransient},
+}
+
+// ErrorCode returns the code of the error err is, or wraps; empty when it
+// is none of them.
+func ErrorCode(err error) string {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// CodeError returns the error for code, nil when code is empty or unknown.
+func CodeError(code string) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// ExitCodeError returns the error for a shell plugin's exit code, nil when
+// the exit code is none of them.
+func ExitCodeError(exit int) error {
+	for _, c := range codes {
+		if c.exit == exit {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// ParseCodedMessage returns the error an rpc plugin's error message stands
+// for: Serve puts the code of an error above in front of its message, and
+// the error returned wraps that error again.
+func ParseCodedMessage(msg string) error {
+	if code, rest, ok := strings.Cut(msg, ": "); ok {
+		if sentinel := CodeError(code); sentinel != nil {
+			return &codedError{msg: rest, err: sentinel}
+		}
+	}
+	return errors.New(msg)
+}
+
+type codedError struct {
+	msg string
+	err error
+}
+
+func (e *codedError) Error() string { return e.msg }
+func (e *codedError) Unwrap() error { return e.err }
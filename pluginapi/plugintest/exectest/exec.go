@@ -0,0 +1,243 @@
+package exectest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// stopGrace is how long a persistent plugin has to exit once its stdin is
+// closed before it is killed.
+const stopGrace = 5 * time.Second
+
+// execStore starts the plugin for each request, writes the request to its
+// stdin and reads one response from its stdout.
+type execStore struct {
+	command string
+	args    []string
+}
+
+func (s *execStore) Get(ctx context.Context, key string) (string, error) {
+	response, err := s.call(ctx, pluginapi.ExecRequest{Action: pluginapi.OpGet, Key: key})
+	if err != nil {
+		return "", err
+	}
+	return response.Value, nil
+}
+
+func (s *execStore) Set(ctx context.Context, key string, value string) error {
+	_, err := s.call(ctx, pluginapi.ExecRequest{Action: pluginapi.OpSet, Key: key, Value: value})
+	return err
+}
+
+func (s *execStore) call(ctx context.Context, request pluginapi.ExecRequest) (*pluginapi.ExecResponse, error) {
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	err = json.NewEncoder(stdin).Encode(request)
+	if cerr := stdin.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var response pluginapi.ExecResponse
+	decodeErr := json.NewDecoder(stdout).Decode(&response)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("command execution failed: %w", err)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", decodeErr)
+	}
+	return checkResponse(&response)
+}
+
+// persistentStore keeps one plugin process running and sends it one
+// request per line, matching the response lines to requests by ID. Unlike
+// stunmesh's runner it does not restart a process that exits: in a test,
+// that is a failure to report.
+type persistentStore struct {
+	cmd *exec.Cmd
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *pluginapi.ExecResponse
+	// done is closed once the process has exited, with err set to why.
+	done chan struct{}
+	err  error
+}
+
+func startPersistent(command string, args []string) (*persistentStore, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	s := &persistentStore{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan *pluginapi.ExecResponse),
+		done:    make(chan struct{}),
+	}
+	go s.read(stdout)
+	return s, nil
+}
+
+func (s *persistentStore) Get(ctx context.Context, key string) (string, error) {
+	response, err := s.call(ctx, pluginapi.ExecRequest{Action: pluginapi.OpGet, Key: key})
+	if err != nil {
+		return "", err
+	}
+	return response.Value, nil
+}
+
+func (s *persistentStore) Set(ctx context.Context, key string, value string) error {
+	_, err := s.call(ctx, pluginapi.ExecRequest{Action: pluginapi.OpSet, Key: key, Value: value})
+	return err
+}
+
+// Close closes the plugin's stdin, asking it to exit, and kills it if it
+// has not within stopGrace.
+func (s *persistentStore) Close() error {
+	s.writeMu.Lock()
+	err := s.stdin.Close()
+	s.writeMu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(stopGrace):
+		_ = s.cmd.Process.Kill()
+		<-s.done
+	}
+	return err
+}
+
+func (s *persistentStore) call(ctx context.Context, request pluginapi.ExecRequest) (*pluginapi.ExecResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *pluginapi.ExecResponse, 1)
+	s.mu.Lock()
+	if s.pending == nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("plugin process exited: %w", s.err)
+	}
+	s.nextID++
+	request.ID = s.nextID
+	s.pending[request.ID] = ch
+	s.mu.Unlock()
+
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	s.writeMu.Lock()
+	_, err = s.stdin.Write(append(line, '\n'))
+	s.writeMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	select {
+	case response := <-ch:
+		return checkResponse(response)
+	case <-s.done:
+		select {
+		case response := <-ch:
+			return checkResponse(response)
+		default:
+		}
+		return nil, fmt.Errorf("plugin process exited: %w", s.err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// read delivers response lines until the plugin's stdout closes, then reaps
+// the process.
+func (s *persistentStore) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	var readErr error
+	for scanner.Scan() {
+		var response pluginapi.ExecResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			readErr = fmt.Errorf("failed to decode response: %w", err)
+			_ = s.cmd.Process.Kill()
+			break
+		}
+
+		s.mu.Lock()
+		ch, ok := s.pending[response.ID]
+		delete(s.pending, response.ID)
+		s.mu.Unlock()
+		if ok {
+			ch <- &response
+		}
+	}
+	if readErr == nil {
+		readErr = scanner.Err()
+	}
+	waitErr := s.cmd.Wait()
+
+	s.mu.Lock()
+	switch {
+	case readErr != nil:
+		s.err = readErr
+	case waitErr != nil:
+		s.err = waitErr
+	default:
+		s.err = io.EOF
+	}
+	s.pending = nil
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// checkResponse turns a response that is not a success into its error,
+// wrapping the pluginapi error its code names.
+func checkResponse(response *pluginapi.ExecResponse) (*pluginapi.ExecResponse, error) {
+	if response.Success {
+		return response, nil
+	}
+	if sentinel := pluginapi.CodeError(response.Code); sentinel != nil {
+		return nil, fmt.Errorf("exec plugin error: %s (%w)", response.Error, sentinel)
+	}
+	return nil, errors.New("exec plugin error: " + response.Error)
+}
@@ -0,0 +1,51 @@
+// Package exectest runs the plugintest conformance suite against an exec or
+// shell plugin:
+//
+//	func TestMyPlugin(t *testing.T) {
+//		exectest.RunExec(t, "./my-plugin.sh")
+//	}
+//
+// It talks to the plugin over the protocols pluginapi documents, the way
+// stunmesh's own exec and shell runners do, so it needs nothing from
+// stunmesh beyond pluginapi. It is apart from plugintest because a Go
+// plugin that only needs the suite has no use for it.
+package exectest
+
+import (
+	"testing"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+	"github.com/tjjh89017/stunmesh-go/pluginapi/plugintest"
+)
+
+// RunExec runs the suite against the exec plugin command, started with args
+// for every request.
+func RunExec(t *testing.T, command string, args ...string) {
+	t.Helper()
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		return &execStore{command: command, args: args}
+	})
+}
+
+// RunPersistentExec runs the suite against the exec plugin command in
+// persistent mode, one process serving every request.
+func RunPersistentExec(t *testing.T, command string, args ...string) {
+	t.Helper()
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		t.Helper()
+		store, err := startPersistent(command, args)
+		if err != nil {
+			t.Fatalf("failed to start plugin: %v", err)
+		}
+		return store
+	})
+}
+
+// RunShell runs the suite against the shell plugin command, started with
+// args for every request.
+func RunShell(t *testing.T, command string, args ...string) {
+	t.Helper()
+	plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+		return &shellStore{command: command, args: args}
+	})
+}
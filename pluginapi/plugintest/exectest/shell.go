@@ -0,0 +1,74 @@
+package exectest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+// shellStore runs the plugin for each request with the request as shell
+// variable assignments on its stdin, reading a record from its stdout and
+// an error from its exit code.
+type shellStore struct {
+	command string
+	args    []string
+}
+
+func (s *shellStore) Get(ctx context.Context, key string) (string, error) {
+	return s.run(ctx, pluginapi.OpGet, key, "")
+}
+
+func (s *shellStore) Set(ctx context.Context, key string, value string) error {
+	_, err := s.run(ctx, pluginapi.OpSet, key, value)
+	return err
+}
+
+func (s *shellStore) run(ctx context.Context, action, key, value string) (string, error) {
+	// A script may source its input, so like stunmesh the harness only
+	// hands it what means the same when sourced.
+	if !shellSafe(key) || !shellSafe(value) {
+		return "", fmt.Errorf("key %q or its value is not safe to pass to a shell plugin", key)
+	}
+
+	var stdin bytes.Buffer
+	fmt.Fprintf(&stdin, "STUNMESH_ACTION=%s\n", action)
+	fmt.Fprintf(&stdin, "STUNMESH_KEY=%s\n", key)
+	if action == pluginapi.OpSet {
+		fmt.Fprintf(&stdin, "STUNMESH_VALUE=%s\n", value)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if sentinel := pluginapi.ExitCodeError(exitErr.ExitCode()); sentinel != nil {
+				return "", fmt.Errorf("command execution failed: %w (%w, stderr: %s)", err, sentinel, strings.TrimSpace(stderr.String()))
+			}
+		}
+		return "", fmt.Errorf("command execution failed: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// shellSafe reports whether s can be written as a shell variable assignment
+// that means the same when sourced: no quotes, expansions or line breaks.
+func shellSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
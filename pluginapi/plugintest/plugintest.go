@@ -0,0 +1,235 @@
+// Package plugintest is the conformance suite a storage plugin is expected
+// to pass. A Go plugin runs it against its Store from a test:
+//
+//	func TestConformance(t *testing.T) {
+//		plugintest.Run(t, func(t *testing.T) pluginapi.Store {
+//			return newStore(t.TempDir())
+//		})
+//	}
+//
+// and an exec or shell plugin, whatever it is written in, through the
+// harness in plugintest/exectest.
+//
+// The suite uses fresh random keys shaped like the ones stunmesh derives,
+// so it can run against a store that keeps records from earlier runs.
+package plugintest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pluginapi "github.com/tjjh89017/stunmesh-go/pluginapi"
+)
+
+const (
+	// ValueLen is the length of the records the suite stores unless it
+	// says otherwise: about the size of a real one.
+	ValueLen = 256
+
+	// LargeValueLen is the length of the large record the suite stores:
+	// well beyond the few hundred hex characters of a real one.
+	LargeValueLen = 1024
+
+	// Concurrency is how many calls the suite makes at once.
+	Concurrency = 8
+
+	// CancelTimeout is how soon a call with a cancelled context has to
+	// return.
+	CancelTimeout = 5 * time.Second
+)
+
+// Factory returns the store under test. The suite calls it once per test,
+// and closes stores that implement io.Closer when the test ends.
+type Factory func(t *testing.T) pluginapi.Store
+
+// Run runs the whole suite against the stores factory returns, each case
+// as a subtest.
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store pluginapi.Store)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"NotFound", testNotFound},
+		{"LargeValue", testLargeValue},
+		{"Concurrent", testConcurrent},
+		{"CancelledContext", testCancelledContext},
+		{"InvalidKeys", testInvalidKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := factory(t)
+			if closer, ok := store.(io.Closer); ok {
+				t.Cleanup(func() { _ = closer.Close() })
+			}
+			tt.fn(t, store)
+		})
+	}
+}
+
+// Key returns a fresh random key shaped like the ones stunmesh derives: 40
+// lowercase hex characters.
+func Key(t *testing.T) string {
+	t.Helper()
+	return randomHex(t, 20)
+}
+
+// Value returns a fresh random record value of n hex characters, shaped
+// like stunmesh's encrypted records.
+func Value(t *testing.T, n int) string {
+	t.Helper()
+	return randomHex(t, (n+1)/2)[:n]
+}
+
+func randomHex(t *testing.T, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func testRoundTrip(t *testing.T, store pluginapi.Store) {
+	ctx := context.Background()
+	key, value := Key(t), Value(t, ValueLen)
+
+	if err := store.Set(ctx, key, value); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := store.Get(ctx, key); err != nil || got != value {
+		t.Fatalf("Get() = (%q, %v), want (%q, nil)", got, err, value)
+	}
+}
+
+func testOverwrite(t *testing.T, store pluginapi.Store) {
+	ctx := context.Background()
+	key := Key(t)
+	first, second := Value(t, ValueLen), Value(t, ValueLen)
+
+	if err := store.Set(ctx, key, first); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := store.Set(ctx, key, second); err != nil {
+		t.Fatalf("second Set() error = %v", err)
+	}
+	if got, err := store.Get(ctx, key); err != nil || got != second {
+		t.Fatalf("Get() = (%q, %v), want the second value %q", got, err, second)
+	}
+
+	// Setting the same value again is a no-op, not an error.
+	if err := store.Set(ctx, key, second); err != nil {
+		t.Fatalf("Set() of the current value error = %v", err)
+	}
+	if got, err := store.Get(ctx, key); err != nil || got != second {
+		t.Fatalf("Get() = (%q, %v), want %q", got, err, second)
+	}
+}
+
+func testNotFound(t *testing.T, store pluginapi.Store) {
+	got, err := store.Get(context.Background(), Key(t))
+	if !errors.Is(err, pluginapi.ErrNotFound) {
+		t.Fatalf("Get() of a key never set = (%q, %v), want pluginapi.ErrNotFound", got, err)
+	}
+}
+
+func testLargeValue(t *testing.T, store pluginapi.Store) {
+	ctx := context.Background()
+	key, value := Key(t), Value(t, LargeValueLen)
+
+	if err := store.Set(ctx, key, value); err != nil {
+		t.Fatalf("Set() of %d characters error = %v", len(value), err)
+	}
+	got, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != value {
+		t.Fatalf("Get() returned %d characters, want the %d stored", len(got), len(value))
+	}
+}
+
+func testConcurrent(t *testing.T, store pluginapi.Store) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, Concurrency)
+	for i := 0; i < Concurrency; i++ {
+		key, value := Key(t), Value(t, ValueLen)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Set(ctx, key, value); err != nil {
+				errs <- fmt.Errorf("Set(%s) error = %w", key, err)
+				return
+			}
+			if got, err := store.Get(ctx, key); err != nil || got != value {
+				errs <- fmt.Errorf("Get(%s) = (%q, %v), want (%q, nil)", key, got, err, value)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// testCancelledContext only asks that calls return promptly: a store may
+// still complete a call that was cheap enough, but must not hang on one
+// the caller gave up on.
+func testCancelledContext(t *testing.T, store pluginapi.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = store.Set(ctx, Key(t), Value(t, ValueLen))
+		_, _ = store.Get(ctx, Key(t))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(CancelTimeout):
+		t.Fatalf("calls with a cancelled context did not return within %s", CancelTimeout)
+	}
+}
+
+// testInvalidKeys checks that keys stunmesh never produces cannot reach
+// another key's record: a store may reject them or store them apart, but
+// must not let one overwrite a valid key.
+func testInvalidKeys(t *testing.T, store pluginapi.Store) {
+	ctx := context.Background()
+	key, value := Key(t), Value(t, ValueLen)
+	if err := store.Set(ctx, key, value); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	invalid := []string{
+		"",
+		"../" + key,
+		"sub/" + key,
+		strings.ToUpper(key),
+		key + "\n",
+	}
+	for _, bad := range invalid {
+		_ = store.Set(ctx, bad, Value(t, ValueLen))
+	}
+
+	if got, err := store.Get(ctx, key); err != nil || got != value {
+		t.Fatalf("Get() after setting invalid keys = (%q, %v), want the untouched %q", got, err, value)
+	}
+}
@@ -0,0 +1,65 @@
+package pluginapi
+
+import "time"
+
+// RPC Plugin Protocol
+//
+// An rpc plugin is a program stunmesh starts once and keeps running, serving
+// the Store over net/rpc on its stdin and stdout. Go plugins implement Store,
+// and whichever optional interfaces they can, and call Serve; the types
+// below are the wire protocol Serve speaks.
+
+const (
+	// RPCProtocolVersion is the version of the rpc plugin protocol. stunmesh
+	// refuses a plugin that answers the handshake with another one.
+	RPCProtocolVersion = 1
+
+	// RPCServiceName is the net/rpc service a plugin registers.
+	RPCServiceName = "Plugin"
+
+	// RPCPluginEnv is set in an rpc plugin's environment to the protocol
+	// version stunmesh speaks. Serve refuses to run without it, so starting
+	// a plugin by hand explains itself instead of waiting on stdin.
+	RPCPluginEnv = "STUNMESH_RPC_PLUGIN"
+)
+
+// Capabilities an rpc plugin reports in its handshake: the optional
+// interfaces its Store implements.
+const (
+	CapabilityDelete = "delete"
+	CapabilityList   = "list"
+	CapabilityTTL    = "ttl"
+	CapabilityMany   = "many"
+)
+
+// RPCHandshakeArgs opens a session.
+type RPCHandshakeArgs struct {
+	Version int
+}
+
+// RPCHandshakeReply answers the handshake with the version the plugin speaks
+// and its capabilities.
+type RPCHandshakeReply struct {
+	Version      int
+	Capabilities []string
+}
+
+// RPCRequest is the arguments of every Store call. Deadline, when not zero,
+// is when the caller gives up; the plugin's context ends then too.
+type RPCRequest struct {
+	Deadline time.Time
+	Key      string
+	Value    string
+	TTL      time.Duration
+	Keys     []string
+	Records  map[string]string
+}
+
+// RPCReply is the result of every Store call. A call that failed with one
+// of the errors in errors.go, such as ErrNotFound, has its code and a colon
+// in front of the error message, which stunmesh turns back into that error.
+type RPCReply struct {
+	Value   string
+	Keys    []string
+	Records map[string]string
+}
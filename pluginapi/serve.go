@@ -0,0 +1,174 @@
+package pluginapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"strconv"
+)
+
+// Serve runs store as an rpc plugin on the process's stdin and stdout until
+// stunmesh closes them, and is what a Go plugin's main calls:
+//
+//	func main() {
+//		if err := pluginapi.Serve(newStore()); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(1)
+//		}
+//	}
+//
+// The plugin is configured with `type: rpc` and its `command`. Stdout
+// carries the protocol, so Serve points os.Stdout at stderr, where anything
+// the plugin prints ends up in stunmesh's log instead of corrupting it.
+func Serve(store Store) error {
+	version, err := strconv.Atoi(os.Getenv(RPCPluginEnv))
+	if err != nil {
+		return errors.New("this is a stunmesh plugin: configure it with type rpc, stunmesh starts it")
+	}
+	if version != RPCProtocolVersion {
+		return fmt.Errorf("stunmesh speaks plugin protocol %d, this plugin %d", version, RPCProtocolVersion)
+	}
+
+	conn := stdioConn{Reader: os.Stdin, Writer: os.Stdout}
+	os.Stdout = os.Stderr
+	return ServeConn(store, conn)
+}
+
+// ServeConn serves store over conn, as Serve does over stdio. It returns
+// once conn is closed.
+func ServeConn(store Store, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(RPCServiceName, &rpcService{store: store}); err != nil {
+		return err
+	}
+	server.ServeConn(conn)
+	return nil
+}
+
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c stdioConn) Close() error {
+	return os.Stdin.Close()
+}
+
+// rpcService is the net/rpc face of a Store.
+type rpcService struct {
+	store Store
+}
+
+func (s *rpcService) Handshake(args RPCHandshakeArgs, reply *RPCHandshakeReply) error {
+	reply.Version = RPCProtocolVersion
+	if args.Version != RPCProtocolVersion {
+		return fmt.Errorf("plugin speaks protocol %d, not %d", RPCProtocolVersion, args.Version)
+	}
+
+	if _, ok := s.store.(Deleter); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityDelete)
+	}
+	if _, ok := s.store.(Lister); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityList)
+	}
+	if _, ok := s.store.(TTLSetter); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityTTL)
+	}
+	if _, ok := s.store.(BatchStore); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityMany)
+	}
+	return nil
+}
+
+// Ping answers stunmesh's health checks.
+func (s *rpcService) Ping(args struct{}, reply *struct{}) error {
+	return nil
+}
+
+func (s *rpcService) Get(args RPCRequest, reply *RPCReply) error {
+	ctx, cancel := args.context()
+	defer cancel()
+	var err error
+	reply.Value, err = s.store.Get(ctx, args.Key)
+	return coded(err)
+}
+
+func (s *rpcService) Set(args RPCRequest, reply *RPCReply) error {
+	ctx, cancel := args.context()
+	defer cancel()
+	return coded(s.store.Set(ctx, args.Key, args.Value))
+}
+
+func (s *rpcService) SetWithTTL(args RPCRequest, reply *RPCReply) error {
+	setter, ok := s.store.(TTLSetter)
+	if !ok {
+		return errors.New("plugin cannot expire records")
+	}
+	ctx, cancel := args.context()
+	defer cancel()
+	return coded(setter.SetWithTTL(ctx, args.Key, args.Value, args.TTL))
+}
+
+func (s *rpcService) Delete(args RPCRequest, reply *RPCReply) error {
+	deleter, ok := s.store.(Deleter)
+	if !ok {
+		return errors.New("plugin cannot delete records")
+	}
+	ctx, cancel := args.context()
+	defer cancel()
+	return coded(deleter.Delete(ctx, args.Key))
+}
+
+func (s *rpcService) List(args RPCRequest, reply *RPCReply) error {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return errors.New("plugin cannot list keys")
+	}
+	ctx, cancel := args.context()
+	defer cancel()
+	var err error
+	reply.Keys, err = lister.List(ctx)
+	return coded(err)
+}
+
+func (s *rpcService) GetMany(args RPCRequest, reply *RPCReply) error {
+	many, ok := s.store.(BatchStore)
+	if !ok {
+		return errors.New("plugin cannot read records at once")
+	}
+	ctx, cancel := args.context()
+	defer cancel()
+	var err error
+	reply.Records, err = many.GetMany(ctx, args.Keys)
+	return coded(err)
+}
+
+func (s *rpcService) SetMany(args RPCRequest, reply *RPCReply) error {
+	many, ok := s.store.(BatchStore)
+	if !ok {
+		return errors.New("plugin cannot write records at once")
+	}
+	ctx, cancel := args.context()
+	defer cancel()
+	return coded(many.SetMany(ctx, args.Records))
+}
+
+// coded puts the code of err in front of its message, the only part of it
+// the connection carries; see ParseCodedMessage.
+func coded(err error) error {
+	code := ErrorCode(err)
+	if code == "" {
+		return err
+	}
+	return errors.New(code + ": " + err.Error())
+}
+
+func (r RPCRequest) context() (context.Context, context.CancelFunc) {
+	if r.Deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), r.Deadline)
+}
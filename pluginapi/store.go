@@ -1,8 +1,141 @@
 package pluginapi
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 type Store interface {
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, value string) error
 }
+
+// Batcher is implemented by stores that can write several records as one
+// operation, such as a single commit. The publish controller opens a batch
+// for each device's publish round, sets every peer's record through it and
+// flushes it once at the end, instead of calling Store.Set per peer.
+type Batcher interface {
+	NewBatch() Batch
+}
+
+// Batch holds Sets back until Flush writes them out together. Flush
+// reports the outcome for the whole batch: either every record was written
+// or none is to be considered written.
+type Batch interface {
+	Set(ctx context.Context, key string, value string) error
+	Flush(ctx context.Context) error
+}
+
+// BatchStore is implemented by stores that can read or write many records
+// in one round trip, such as a DNS API that lists every record under a
+// name at once. The establish controller reads all of a plugin instance's
+// peers with one GetMany per refresh, and the publish controller writes a
+// round's records with one SetMany, the way it flushes a Batch; a store that
+// is also a Batcher is written through its Batch instead.
+//
+// GetMany leaves keys that have no record out of its result. SetMany
+// reports the outcome for all records as Batch.Flush does.
+type BatchStore interface {
+	GetMany(ctx context.Context, keys []string) (map[string]string, error)
+	SetMany(ctx context.Context, records map[string]string) error
+}
+
+// Deleter is implemented by stores that can remove a record, so the daemon
+// can drop the record of a peer that is removed. Deleting a key that has no
+// record is not an error.
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// Lister is implemented by stores that can enumerate the keys they hold
+// records for, which is what `stunmesh gc` needs to find orphaned ones. A
+// store shared by several nodes lists every node's keys.
+type Lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// TTLSetter is implemented by stores whose records carry a lifetime. For a
+// DNS record that is how long resolvers may cache it; for a DHT, how long it
+// lives without being refreshed. Stores round ttl to what their backend
+// supports. When a record_ttl is configured, the publish controller writes
+// a TTLSetter record by record, even if it is also a Batcher or BatchStore.
+type TTLSetter interface {
+	SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error
+}
+
+// Watcher is implemented by stores that can tell when a record changes, so
+// the daemon can re-read a peer's endpoint as soon as it is republished
+// instead of at the next refresh. Watch subscribes to keys and calls changed
+// with a key whenever its record may have changed; changed may be called
+// concurrently, and a spurious call only costs a Get. Watch blocks until ctx
+// is done, returning ctx.Err(), or until the subscription breaks, returning
+// why; the caller subscribes again after a backoff.
+type Watcher interface {
+	Watch(ctx context.Context, keys []string, changed func(key string)) error
+}
+
+// ErrNotModified is returned by ConditionalGetter.GetIfModified when the
+// record is still the revision the caller has.
+var ErrNotModified = errors.New("record not modified")
+
+// Revision is what a store knows about the version of a record it read.
+type Revision struct {
+	// Tag tells versions of a record apart, such as an HTTP ETag or a
+	// modification time; empty when the backend offers nothing to compare.
+	Tag string
+	// Expires is until when the record may be used without asking the
+	// backend again, such as a DNS record's TTL from now; zero when every
+	// read has to ask.
+	Expires time.Time
+}
+
+// ConditionalGetter is implemented by stores that can tell whether a record
+// changed since an earlier read more cheaply than by reading it, which the
+// daemon's read cache uses to skip downloads of unchanged records. rev is
+// the Revision the caller got last time, zero on a first read. When the
+// record is still rev, GetIfModified returns ErrNotModified along with the
+// record's current Revision, e.g. with a later Expires.
+type ConditionalGetter interface {
+	GetIfModified(ctx context.Context, key string, rev Revision) (string, Revision, error)
+}
+
+type revalidateKey struct{}
+
+// Revalidate marks ctx so a cache between the caller and the store asks the
+// backend instead of answering from a record it has not revalidated yet, as
+// the daemon does when it suspects a record changed.
+func Revalidate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revalidateKey{}, true)
+}
+
+// MustRevalidate reports whether ctx was marked by Revalidate.
+func MustRevalidate(ctx context.Context) bool {
+	v, _ := ctx.Value(revalidateKey{}).(bool)
+	return v
+}
+
+// Wrapper is implemented by stores that decorate another store, such as the
+// daemon's retry and timeout layer. A wrapper may implement an optional
+// interface its inner store does not, so callers check for one with As
+// rather than a type assertion.
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// As reports whether store offers the optional interface T, such as
+// Deleter, and returns it. Through a Wrapper, T counts only if every layer
+// down to the innermost store implements it.
+func As[T any](store Store) (T, bool) {
+	outer, ok := store.(T)
+	for ok {
+		wrapper, isWrapper := store.(Wrapper)
+		if !isWrapper {
+			return outer, true
+		}
+		store = wrapper.Unwrap()
+		_, ok = store.(T)
+	}
+	var zero T
+	return zero, false
+}
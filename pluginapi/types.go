@@ -12,12 +12,20 @@ type PluginDefinition struct {
 // Exec Plugin Protocol
 
 const (
-	OpSet = "set"
-	OpGet = "get"
+	OpSet    = "set"
+	OpGet    = "get"
+	OpDelete = "delete"
+	OpList   = "list"
 )
 
 // ExecRequest is the JSON request format for exec plugins
+//
+// A one-shot exec plugin reads a single request from stdin. A persistent one
+// (persistent: true) reads one request per line for as long as stdin stays
+// open, and answers each with one ExecResponse line carrying the request's
+// ID. It may answer out of order, so it can work on several requests at once.
 type ExecRequest struct {
+	ID     uint64 `json:"id,omitempty"`
 	Action string `json:"action"`
 	Key    string `json:"key"`
 	Value  string `json:"value,omitempty"`
@@ -25,7 +33,13 @@ type ExecRequest struct {
 
 // ExecResponse is the JSON response format for exec plugins
 type ExecResponse struct {
+	ID      uint64 `json:"id,omitempty"`
 	Success bool   `json:"success"`
 	Value   string `json:"value,omitempty"`
-	Error   string `json:"error,omitempty"`
+	// Keys answers a list request.
+	Keys  []string `json:"keys,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// Code says which of the errors in errors.go a failure is, e.g.
+	// CodeNotFound for a get of a key with no record; empty for any other.
+	Code string `json:"code,omitempty"`
 }
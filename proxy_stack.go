@@ -2,21 +2,37 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/netip"
 	"runtime"
+	"slices"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/ctrl"
+	"github.com/tjjh89017/stunmesh-go/internal/entity"
+	"github.com/tjjh89017/stunmesh-go/internal/portmap"
+	"github.com/tjjh89017/stunmesh-go/internal/routeprobe"
 	"github.com/tjjh89017/stunmesh-go/internal/stun"
 	"github.com/tjjh89017/stunmesh-go/internal/wg"
 	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
 )
 
-// proxyStack bundles the two components whose construction depends on
-// whether proxy mode is on; newProxyStack builds it either way so
-// wire_gen.go stays a single, unconditional code path.
+// proxyStack bundles the components whose construction depends on whether
+// proxy mode is on, or any interface is embedded; newProxyStack builds it
+// either way so wire_gen.go stays a single, unconditional code path.
+// PortMapper is here because a proxied device's mapping is for its outer
+// port, not its listen port, Relays because relay allocations leave on the
+// proxy's outer socket, and PeerPorts because only a proxy pins peers to
+// one of several outer ports.
 type proxyStack struct {
-	Client   wg.Client
-	Resolver *stun.Resolver
+	Client      wg.Client
+	Resolver    *stun.Resolver
+	PortMapper  ctrl.PortMapper
+	Relays      ctrl.RelayAllocator
+	PeerPorts   ctrl.PeerPorts
+	Obfuscation ctrl.Obfuscation
 }
 
 // proxyModeEnabled reports whether the proxy fronts the wg client: on iff any
@@ -41,9 +57,10 @@ func proxyModeEnabledForGOOS(cfg *config.Config, deviceConfig *config.DeviceConf
 }
 
 // newProxyStack wires the proxy decorator and proxy-backed STUN path when
-// proxy mode is on. The per-device transport lookup is safe because bootstrap
-// creates each proxy before any Resolve runs; the trailing manager.Close is
-// idempotent and covers the idle manager in plain mode.
+// proxy mode is on, and the embedded interfaces' client and STUN path when
+// any is configured. The per-device transport lookup is safe because
+// bootstrap creates each proxy before any Resolve runs; the trailing
+// manager.Close is idempotent and covers the idle manager in plain mode.
 func newProxyStack(cfg *config.Config, deviceConfig *config.DeviceConfig, logger *zerolog.Logger) (*proxyStack, func(), error) {
 	// mode (the OR across every interface) only decides whether proxy
 	// infrastructure is built at all. It does not decide whether any given
@@ -53,15 +70,20 @@ func newProxyStack(cfg *config.Config, deviceConfig *config.DeviceConfig, logger
 	// path even while the decorator/factory is installed for the process.
 	mode := proxyModeEnabled(cfg, deviceConfig, logger)
 	manager := wgproxy.NewManager(logger)
+	mappings := portmap.NewManager(logger, routeprobe.NewTunnelInterfaces(deviceConfig.TunnelInterfaceNames()...))
 
-	client, err := wg.New()
+	client, devices, err := newWGClient(deviceConfig, runtime.GOOS, logger)
 	if err != nil {
 		return nil, nil, err
 	}
 	if mode {
 		client = wg.NewProxyClient(client, manager, deviceConfig, logger)
+		startCaptures(manager, deviceConfig, runtime.GOOS, logger)
 	}
 	cleanup := func() {
+		if err := mappings.Close(); err != nil {
+			logger.Warn().Err(err).Msg("failed to delete port mappings")
+		}
 		if err := client.Close(); err != nil {
 			logger.Warn().Err(err).Msg("failed to close WireGuard client")
 		}
@@ -70,20 +92,172 @@ func newProxyStack(cfg *config.Config, deviceConfig *config.DeviceConfig, logger
 		}
 	}
 
-	resolver := stun.NewResolver(cfg, deviceConfig, logger)
+	factory := stun.NewDefaultFactory()
 	if mode {
-		lookup := func(deviceName string) (stun.StunTransport, error) {
+		lookup := func(deviceName string, port uint16) (stun.StunTransport, error) {
 			proxy, err := manager.Get(deviceName)
 			if err != nil {
 				return nil, err
 			}
-			return proxy, nil
+			return proxy.Transport(port), nil
+		}
+		factory = newPerDeviceStunFactory(deviceConfig, runtime.GOOS, stun.NewProxyLookupFactory(lookup, logger), factory)
+	}
+	if devices != nil {
+		factory = newEmbeddedStunFactory(devices, factory, logger)
+	}
+	resolver := stun.NewResolverWithFactory(cfg, deviceConfig, logger, factory)
+
+	portMapper := &devicePortMapper{mappings: mappings, deviceConfig: deviceConfig, goos: runtime.GOOS}
+	// Allocations outlive a few missed refreshes; each publish renews them.
+	relays := &deviceRelayAllocator{deviceConfig: deviceConfig, goos: runtime.GOOS, lifetime: 3 * cfg.RefreshInterval}
+	peerPorts := &devicePeerPorts{deviceConfig: deviceConfig, goos: runtime.GOOS}
+	obfuscation := &deviceObfuscation{deviceConfig: deviceConfig, goos: runtime.GOOS}
+	if mode {
+		portMapper.proxies = manager
+		relays.proxies = manager
+		peerPorts.proxies = manager
+		obfuscation.proxies = manager
+	}
+
+	return &proxyStack{Client: client, Resolver: resolver, PortMapper: portMapper, Relays: relays, PeerPorts: peerPorts, Obfuscation: obfuscation}, cleanup, nil
+}
+
+// startCaptures asks the manager for each proxy-mode device's
+// proxy.capture; the manager starts it once the device's proxy exists. A
+// capture that cannot start is only worth a warning.
+func startCaptures(manager *wgproxy.Manager, deviceConfig *config.DeviceConfig, goos string, logger *zerolog.Logger) {
+	for _, deviceName := range deviceConfig.TunnelInterfaceNames() {
+		c := deviceConfig.GetProxyCapture(deviceName)
+		if c.Path == "" || !deviceConfig.GetProxyEnabled(deviceName, goos) {
+			continue
+		}
+		opts := wgproxy.CaptureOptions{Path: c.Path, MaxSize: c.MaxSize, MaxFiles: c.MaxFiles, SnapLen: c.SnapLen}
+		if err := manager.Capture(deviceName, &opts); err != nil {
+			logger.Warn().Err(err).Str("device", deviceName).Msg("failed to set up proxy debug capture")
+			continue
+		}
+		logger.Warn().Str("device", deviceName).Str("path", c.Path).Msg("proxy debug capture enabled; packet headers and payload prefixes are written to disk")
+	}
+}
+
+// devicePeerPorts reports the extra outer port a device's proxy pins each
+// peer to; devices not in proxy mode, or peers on the proxy's primary
+// sockets, get 0.
+type devicePeerPorts struct {
+	deviceConfig *config.DeviceConfig
+	// proxies is nil when proxy mode is off.
+	proxies *wgproxy.Manager
+	goos    string
+}
+
+func (p *devicePeerPorts) PeerPort(deviceName string, peer entity.PeerPublicKey) uint16 {
+	if p.proxies == nil || !p.deviceConfig.GetProxyEnabled(deviceName, p.goos) {
+		return 0
+	}
+	proxy, err := p.proxies.Get(deviceName)
+	if err != nil {
+		return 0
+	}
+	return proxy.PeerExtraPort(peer)
+}
+
+// deviceObfuscation reports a device's proxy.obfuscation and masks its
+// proxy's traffic to peers that obfuscate too; devices not in proxy mode
+// never obfuscate.
+type deviceObfuscation struct {
+	deviceConfig *config.DeviceConfig
+	// proxies is nil when proxy mode is off.
+	proxies *wgproxy.Manager
+	goos    string
+}
+
+func (o *deviceObfuscation) Enabled(deviceName string) bool {
+	if o.proxies == nil || !o.deviceConfig.GetProxyEnabled(deviceName, o.goos) {
+		return false
+	}
+	enabled, _ := o.deviceConfig.GetProxyObfuscation(deviceName)
+	return enabled
+}
+
+func (o *deviceObfuscation) SetPeer(deviceName string, peer entity.PeerPublicKey, enabled bool) error {
+	if o.proxies == nil || !o.deviceConfig.GetProxyEnabled(deviceName, o.goos) {
+		return nil
+	}
+	proxy, err := o.proxies.Get(deviceName)
+	if err != nil {
+		return err
+	}
+	proxy.SetPeerObfuscated(peer, enabled)
+	return nil
+}
+
+// deviceRelayAllocator allocates on the relay node a device's proxy.relay
+// config names, through the device's proxy; devices without a relay server
+// or not in proxy mode get none.
+type deviceRelayAllocator struct {
+	deviceConfig *config.DeviceConfig
+	// proxies is nil when proxy mode is off.
+	proxies  *wgproxy.Manager
+	goos     string
+	lifetime time.Duration
+}
+
+func (a *deviceRelayAllocator) Allocate(ctx context.Context, deviceName string, privateKey entity.PrivateKey, peer entity.PeerPublicKey) (netip.AddrPort, error) {
+	server, serverKey, ok := a.deviceConfig.GetRelayServer(deviceName)
+	if !ok {
+		return netip.AddrPort{}, nil
+	}
+	if a.proxies == nil || !a.deviceConfig.GetProxyEnabled(deviceName, a.goos) {
+		return netip.AddrPort{}, fmt.Errorf("relay server for %s needs proxy mode", deviceName)
+	}
+	proxy, err := a.proxies.Get(deviceName)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	relayed, _, err := proxy.AllocateRelay(ctx, server, serverKey, privateKey, peer, a.lifetime)
+	return relayed, err
+}
+
+// devicePortMapper maps the UDP port a device is reached on, per its
+// port_mapping config: when the proxy fronts the device, the requested port
+// if it is one of the proxy's extra outer ports and its primary IPv4 outer
+// port otherwise; its listen port when not.
+type devicePortMapper struct {
+	mappings     *portmap.Manager
+	deviceConfig *config.DeviceConfig
+	// proxies is nil when proxy mode is off.
+	proxies *wgproxy.Manager
+	goos    string
+}
+
+func (m *devicePortMapper) Map(ctx context.Context, deviceName string, port uint16) (netip.AddrPort, error) {
+	cfg := m.deviceConfig.GetPortMapping(deviceName)
+	if !cfg.Enabled {
+		return netip.AddrPort{}, nil
+	}
+	name := deviceName
+	if m.proxies != nil && m.deviceConfig.GetProxyEnabled(deviceName, m.goos) {
+		proxy, err := m.proxies.Get(deviceName)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		primary := proxy.OuterPort(wgproxy.FamilyIPv4)
+		if primary == 0 {
+			return netip.AddrPort{}, fmt.Errorf("proxy for %s has no IPv4 outer socket to map", deviceName)
+		}
+		if slices.Contains(proxy.ExtraPorts(), port) {
+			// Each extra port keeps a mapping of its own beside the primary's.
+			name = fmt.Sprintf("%s:%d", deviceName, port)
+		} else {
+			port = primary
 		}
-		factory := newPerDeviceStunFactory(deviceConfig, runtime.GOOS, stun.NewProxyLookupFactory(lookup, logger), stun.NewDefaultFactory())
-		resolver = stun.NewResolverWithFactory(cfg, deviceConfig, logger, factory)
 	}
 
-	return &proxyStack{Client: client, Resolver: resolver}, cleanup, nil
+	// The gateway was validated when the config loaded; empty parses to the
+	// zero address, which means the default route's next hop.
+	gateway, _ := netip.ParseAddr(cfg.Gateway)
+	return m.mappings.Map(ctx, name, port, portmap.Options{Gateway: gateway, Lifetime: cfg.Lifetime})
 }
 
 // newPerDeviceStunFactory routes each Resolve call to proxyFactory or
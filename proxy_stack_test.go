@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tjjh89017/stunmesh-go/internal/config"
+	"github.com/tjjh89017/stunmesh-go/internal/entity"
+	"github.com/tjjh89017/stunmesh-go/internal/portmap"
 	"github.com/tjjh89017/stunmesh-go/internal/stun"
+	"github.com/tjjh89017/stunmesh-go/internal/wgproxy"
 )
 
 func TestProxyModeEnabledForGOOS(t *testing.T) {
@@ -30,7 +37,7 @@ func TestProxyModeEnabledForGOOS(t *testing.T) {
 			want:       false,
 		},
 		"lone proxy.listen does not enable proxy mode on linux": {
-			interfaces: config.Interfaces{"wg0": {Proxy: config.Proxy{Listen: 51999}}},
+			interfaces: config.Interfaces{"wg0": {Proxy: config.Proxy{Listen: config.ListenPorts{51999}}}},
 			goos:       "linux",
 			want:       false,
 		},
@@ -158,3 +165,129 @@ func TestNewPerDeviceStunFactory_SingleInterface_MatchesPriorBehavior(t *testing
 		}
 	})
 }
+
+// A device without port_mapping gets no mapping, and no gateway is asked.
+func TestDevicePortMapper_Disabled(t *testing.T) {
+	cfg := &config.Config{Interfaces: config.Interfaces{"wg0": {}}}
+	logger := zerolog.Nop()
+	mappings := portmap.NewManager(&logger, nil)
+	defer mappings.Close()
+	m := &devicePortMapper{mappings: mappings, deviceConfig: config.NewDeviceConfig(cfg), goos: "linux"}
+
+	got, err := m.Map(context.Background(), "wg0", 51820)
+	if err != nil || got.IsValid() {
+		t.Errorf("Map(wg0) = %v, %v; want no mapping and no error", got, err)
+	}
+}
+
+func TestDeviceRelayAllocator(t *testing.T) {
+	cfg := &config.Config{Interfaces: config.Interfaces{
+		"wg0": {},
+		"wg1": {Proxy: config.Proxy{Relay: config.ProxyRelay{
+			Server:    "198.51.100.1:3479",
+			ServerKey: "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+		}}},
+	}}
+	a := &deviceRelayAllocator{deviceConfig: config.NewDeviceConfig(cfg), goos: "linux", lifetime: time.Hour}
+
+	got, err := a.Allocate(context.Background(), "wg0", entity.PrivateKey{}, entity.PeerPublicKey{})
+	if err != nil || got.IsValid() {
+		t.Errorf("Allocate(wg0) = %v, %v; want no relay and no error", got, err)
+	}
+	// A relay server without proxy mode is a misconfiguration worth a warning.
+	if _, err := a.Allocate(context.Background(), "wg1", entity.PrivateKey{}, entity.PeerPublicKey{}); err == nil {
+		t.Error("Allocate(wg1) without proxy mode succeeded, want an error")
+	}
+}
+
+func TestDevicePeerPorts(t *testing.T) {
+	enabled := true
+	cfg := &config.Config{Interfaces: config.Interfaces{
+		"wg0": {Proxy: config.Proxy{Enabled: &enabled}},
+		"wg1": {},
+	}}
+	logger := zerolog.Nop()
+	manager := wgproxy.NewManager(&logger)
+	defer manager.Close()
+
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	extra := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+	_ = probe.Close()
+	if _, err := manager.For("wg0", map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0}, wgproxy.WithExtraPorts(extra)); err != nil {
+		t.Fatalf("manager.For: %v", err)
+	}
+	p := &devicePeerPorts{deviceConfig: config.NewDeviceConfig(cfg), proxies: manager, goos: "linux"}
+
+	// Peers spread over the primary sockets (0) and the extra port.
+	seen := make(map[uint16]bool)
+	for i := range 16 {
+		var peer entity.PeerPublicKey
+		peer[0] = byte(i)
+		seen[p.PeerPort("wg0", peer)] = true
+		if got := p.PeerPort("wg1", peer); got != 0 {
+			t.Errorf("PeerPort(wg1) = %d, want 0 without proxy mode", got)
+		}
+	}
+	if len(seen) != 2 || !seen[0] || !seen[extra] {
+		t.Errorf("PeerPort(wg0) returned %v, want both 0 and %d", seen, extra)
+	}
+}
+
+// Only a proxy-mode device with a capture path gets a capture, started
+// with its proxy.
+func TestStartCaptures(t *testing.T) {
+	enabled := true
+	dir := t.TempDir()
+	cfg := &config.Config{Interfaces: config.Interfaces{
+		"wg0": {Proxy: config.Proxy{Enabled: &enabled, Capture: config.ProxyCapture{Path: filepath.Join(dir, "wg0.pcapng")}}},
+		"wg1": {Proxy: config.Proxy{Capture: config.ProxyCapture{Path: filepath.Join(dir, "wg1.pcapng")}}},
+		"wg2": {Proxy: config.Proxy{Enabled: &enabled}},
+	}}
+	logger := zerolog.Nop()
+	manager := wgproxy.NewManager(&logger)
+	defer manager.Close()
+
+	startCaptures(manager, config.NewDeviceConfig(cfg), "linux", &logger)
+	for _, name := range []string{"wg0", "wg1", "wg2"} {
+		if _, err := manager.For(name, map[wgproxy.Family]uint16{wgproxy.FamilyIPv4: 0}); err != nil {
+			t.Fatalf("manager.For(%s): %v", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "wg0.pcapng" {
+		t.Errorf("capture files = %v, want only wg0.pcapng", entries)
+	}
+}
+
+func TestDeviceObfuscation(t *testing.T) {
+	enabled := true
+	cfg := &config.Config{Interfaces: config.Interfaces{
+		"wg0": {Proxy: config.Proxy{Enabled: &enabled, Obfuscation: config.ProxyObfuscation{Enabled: true}}},
+		"wg1": {Proxy: config.Proxy{Obfuscation: config.ProxyObfuscation{Enabled: true}}},
+		"wg2": {Proxy: config.Proxy{Enabled: &enabled}},
+	}}
+	logger := zerolog.Nop()
+	manager := wgproxy.NewManager(&logger)
+	defer manager.Close()
+	o := &deviceObfuscation{deviceConfig: config.NewDeviceConfig(cfg), proxies: manager, goos: "linux"}
+
+	for name, want := range map[string]bool{"wg0": true, "wg1": false, "wg2": false} {
+		if got := o.Enabled(name); got != want {
+			t.Errorf("Enabled(%s) = %v, want %v", name, got, want)
+		}
+	}
+	// Without its proxy yet, a proxy-mode device cannot mark the peer.
+	if err := o.SetPeer("wg0", entity.PeerPublicKey{1}, true); err == nil {
+		t.Error("SetPeer(wg0) before the proxy exists succeeded, want an error")
+	}
+	if err := o.SetPeer("wg1", entity.PeerPublicKey{1}, true); err != nil {
+		t.Errorf("SetPeer(wg1) without proxy mode = %v, want nil", err)
+	}
+}
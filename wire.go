@@ -21,7 +21,7 @@ import (
 func setup(cfg *config.Config) (*daemon.Daemon, func(), error) {
 	wire.Build(
 		newProxyStack,
-		wire.FieldsOf(new(*proxyStack), "Client", "Resolver"),
+		wire.FieldsOf(new(*proxyStack), "Client", "Resolver", "PortMapper", "Relays", "PeerPorts", "Obfuscation"),
 		wire.Bind(new(ctrl.WireGuardClient), new(wg.Client)),
 		wire.Bind(new(repo.WireGuardClient), new(wg.Client)),
 		wire.Bind(new(entity.ConfigPeerProvider), new(*config.DeviceConfig)),
@@ -35,6 +35,7 @@ func setup(cfg *config.Config) (*daemon.Daemon, func(), error) {
 		wire.Bind(new(daemon.EstablishRunner), new(*ctrl.EstablishController)),
 		wire.Bind(new(daemon.PingMonitorExecutor), new(*ctrl.PingMonitorController)),
 		wire.Bind(new(ctrl.Publisher), new(*ctrl.PublishController)),
+		wire.Bind(new(ctrl.Unpublisher), new(*ctrl.PublishController)),
 		wire.Bind(new(ctrl.Establisher), new(*ctrl.EstablishController)),
 		config.DefaultSet,
 		logger.DefaultSet,
@@ -48,13 +49,13 @@ func setup(cfg *config.Config) (*daemon.Daemon, func(), error) {
 	return nil, nil, nil
 }
 
-func providePluginManager(config *config.Config) (*plugin.Manager, error) {
+func providePluginManager(config *config.Config) (*plugin.Manager, func(), error) {
 	manager := plugin.NewManager()
 	ctx := context.Background()
 
 	if err := manager.LoadPlugins(ctx, config.Plugins); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return manager, nil
+	return manager, func() { _ = manager.Close() }, nil
 }
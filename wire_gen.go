@@ -31,32 +31,37 @@ func setup(cfg *config.Config) (*daemon.Daemon, func(), error) {
 	devices := repo.NewDevices()
 	peers := repo.NewPeers(client)
 	filterPeerService := entity.NewFilterPeerService(peers, deviceConfig)
-	bootstrapController := ctrl.NewBootstrapController(client, cfg, deviceConfig, devices, peers, zerologLogger, filterPeerService)
-	manager, err := providePluginManager(cfg)
+	manager, cleanup2, err := providePluginManager(cfg)
 	if err != nil {
 		cleanup()
 		return nil, nil, err
 	}
 	resolver := mainProxyStack.Resolver
 	endpoint := crypto.NewEndpoint()
-	publishController := ctrl.NewPublishController(devices, peers, manager, resolver, endpoint, deviceConfig, zerologLogger)
-	establishController := ctrl.NewEstablishController(client, devices, peers, manager, endpoint, deviceConfig, zerologLogger)
+	portMapper := mainProxyStack.PortMapper
+	relayAllocator := mainProxyStack.Relays
+	peerPorts := mainProxyStack.PeerPorts
+	obfuscation := mainProxyStack.Obfuscation
+	publishController := ctrl.NewPublishController(devices, peers, manager, resolver, endpoint, deviceConfig, portMapper, relayAllocator, peerPorts, obfuscation, zerologLogger)
+	bootstrapController := ctrl.NewBootstrapController(client, cfg, deviceConfig, devices, peers, zerologLogger, filterPeerService, publishController)
+	establishController := ctrl.NewEstablishController(client, devices, peers, manager, endpoint, deviceConfig, obfuscation, zerologLogger)
 	pingMonitorController := ctrl.NewPingMonitorController(cfg, devices, peers, publishController, establishController, zerologLogger)
 	daemonDaemon := daemon.New(cfg, bootstrapController, publishController, establishController, pingMonitorController, zerologLogger)
 	return daemonDaemon, func() {
+		cleanup2()
 		cleanup()
 	}, nil
 }
 
 // wire.go:
 
-func providePluginManager(config2 *config.Config) (*plugin.Manager, error) {
+func providePluginManager(config2 *config.Config) (*plugin.Manager, func(), error) {
 	manager := plugin.NewManager()
 	ctx := context.Background()
 
 	if err := manager.LoadPlugins(ctx, config2.Plugins); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return manager, nil
+	return manager, func() { _ = manager.Close() }, nil
 }